@@ -62,6 +62,20 @@ func initializeAppSettings() {
 			AccessLevel: 0,
 			UpdatedBy:   0,
 		},
+		{
+			Key:         "device_registration_policy",
+			Val:         os.Getenv("DEVICE_REGISTRATION_POLICY"),
+			Description: "Defines how devices sending traffic for the first time are registered: 'auto' creates them straight away, 'approval' holds them until an admin approves them, and 'claim_code' holds them until they are claimed with the code on their label or approved.",
+			AccessLevel: 1,
+			UpdatedBy:   0,
+		},
+		{
+			Key:         "device_retention_days",
+			Val:         os.Getenv("DEVICE_RETENTION_DAYS"),
+			Description: "The number of days a deleted device can be restored before it is purged together with its logs.",
+			AccessLevel: 1,
+			UpdatedBy:   0,
+		},
 		{
 			Key:         "initial_parking_check_date",
 			Val:         "2014-12-21T15:35:24Z",
@@ -9,17 +9,23 @@ import (
 
 	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
 	"github.com/foxcodenine/iot-parking-gateway/internal/mq"
+	"github.com/foxcodenine/iot-parking-gateway/internal/realtime"
 
 	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/commands"
 	"github.com/foxcodenine/iot-parking-gateway/internal/core"
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
 	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
 	"github.com/foxcodenine/iot-parking-gateway/internal/httpserver"
+	"github.com/foxcodenine/iot-parking-gateway/internal/influx"
 	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+	"github.com/foxcodenine/iot-parking-gateway/internal/thingsboard"
 	"github.com/robfig/cron/v3"
 
 	"github.com/foxcodenine/iot-parking-gateway/internal/db"
 	"github.com/foxcodenine/iot-parking-gateway/internal/models"
 	"github.com/foxcodenine/iot-parking-gateway/internal/udp"
+	socketio "github.com/googollee/go-socket.io"
 	"github.com/joho/godotenv"
 )
 
@@ -43,6 +49,33 @@ func main() {
 	go app.MQProducer.Run()
 	defer app.MQProducer.Close() // Ensure to close the connection on application shutdown
 
+	// Start forwarding the thingsboard_event_logs queue to ThingsBoard, if enabled
+	if app.ThingsBoard != nil {
+		app.ThingsBoard.Connect()
+		defer app.ThingsBoard.Close()
+
+		go app.ThingsBoardConsumer.Run()
+		defer app.ThingsBoardConsumer.Close()
+	}
+
+	// Start batching the influxdb_event_logs queue into InfluxDB, if enabled
+	if app.InfluxWriter != nil {
+		go app.InfluxWriter.Run()
+		defer app.InfluxWriter.Close()
+
+		go app.InfluxConsumer.Run()
+		defer app.InfluxConsumer.Close()
+	}
+
+	// Start processing commands from other services
+	if app.CommandConsumer != nil {
+		go app.CommandConsumer.Run()
+		defer app.CommandConsumer.Close()
+	}
+
+	// Drain the event bus after the UDP server stops and before the producer closes
+	defer app.Bus.Close()
+
 	// Start the UDP server in a goroutine
 	go app.UdpServer.Start()
 	defer app.UdpServer.Stop()
@@ -51,6 +84,12 @@ func main() {
 	app.Cache.CreateBloomFilter("registered-devices", 0.00001, 100000)
 	app.Service.PopulateDeviceBloomFilter()
 	app.Service.PopulateDeviceCache()
+	app.Service.PopulateRegistrationStatuses()
+	app.Service.RebuildZoneCounts()
+	app.Service.RebuildSpatialIndex()
+
+	// Learn the forecast profiles now instead of waiting for the nightly run.
+	go app.Service.TrainForecastProfiles()
 
 	// Start cron
 	app.Cron.AddFunc("0,20,40 * * * * *", func() {
@@ -75,15 +114,62 @@ func main() {
 
 		app.Service.SyncAuditLogs()
 	})
+
+	// Keep the occupancy rollups of bays that stay occupied up to date
+	app.Cron.AddFunc("30 */5 * * * *", func() {
+		app.Service.RefreshOccupancyRollups()
+	})
+
+	// Relearn the occupancy profiles used by the availability forecasts
+	app.Cron.AddFunc("0 10 3 * * *", func() {
+		app.Service.TrainForecastProfiles()
+	})
+
+	// Purge the devices deleted for longer than their retention period
+	app.Cron.AddFunc("0 20 3 * * *", func() {
+		app.Service.PurgeExpiredDevices()
+	})
+
+	// Raise and clear overstay violations
+	app.Cron.AddFunc("*/15 * * * * *", func() {
+		app.Service.CheckStayRules()
+	})
+
+	// Recount the zones, correcting any drift of the live counts
+	app.Cron.AddFunc("50 */5 * * * *", func() {
+		app.Service.RebuildZoneCounts()
+	})
+
+	// Reindex the device locations, picking up devices registered from traffic and changes made by other instances
+	app.Cron.AddFunc("5 * * * * *", func() {
+		app.Service.RebuildSpatialIndex()
+	})
+
+	// Generate the scheduled reports, picking up schedule changes made by other instances
+	app.Service.StartReportSchedules(app.Cron)
+	app.Cron.AddFunc("45 * * * * *", func() {
+		app.Service.SyncReportSchedules()
+	})
+
 	app.Cron.Start()
 
 	// Create and start the the web server.
 	httpServer := httpserver.NewHttpServer(os.Getenv("HTTP_PORT"))
+	httpServer.Broadcaster = app.Broadcaster
+	httpServer.OnSocketRestart = func(server *socketio.Server) {
+		app.SocketIO = server
+	}
 	httpServer.Start()
-	defer httpServer.Shutdown()
 
-	app.UdpServer.SocketIO = httpServer.SocketServer
 	app.SocketIO = httpServer.SocketServer
+
+	// Relay Socket.IO broadcasts between gateway instances through Redis.
+	app.Broadcaster.SetServer(httpServer.SocketServer)
+	go app.Broadcaster.Run()
+
+	// Wait for a shutdown signal, then stop the relay once the server is down.
+	httpServer.Shutdown()
+	app.Broadcaster.Close()
 }
 
 // ---------------------------------------------------------------------
@@ -124,18 +210,60 @@ func initializeAppConfig() {
 		app.Cache,
 	)
 
-	// Setup RabbitMQ Producer
+	// Setup the message bus producer (RabbitMQ or Kafka, selected by MQ_DRIVER)
 	rabbitConfig := mq.SetupRabbitMQConfig()
-	app.MQProducer = mq.NewRabbitMQProducer(rabbitConfig)
+	app.MQProducer = mq.NewPublisher(rabbitConfig)
+
+	// Setup the ThingsBoard gateway and its queue consumer.
+	// The sinks consume RabbitMQ queues, so they are only available with the RabbitMQ driver.
+	thingsBoardConfig := thingsboard.SetupThingsBoardConfig()
+	if thingsBoardConfig.Enabled && mq.UsesRabbitMQ() {
+		app.ThingsBoard = thingsboard.NewGateway(thingsBoardConfig)
+		thingsBoardSink := thingsboard.NewSink(app.ThingsBoard, app.Cache)
+		app.ThingsBoardConsumer = mq.NewRabbitMQConsumer(rabbitConfig, "thingsboard_event_logs", thingsBoardSink.HandleDelivery)
+	}
+
+	// Setup the InfluxDB writer and its queue consumer
+	influxConfig := influx.SetupInfluxConfig()
+	if influxConfig.Enabled && mq.UsesRabbitMQ() {
+		app.InfluxWriter = influx.NewWriter(influxConfig)
+		influxSink := influx.NewSink(app.InfluxWriter)
+		influxQueue := mq.Queue{Name: "influxdb_event_logs", Durable: true}
+		app.InfluxConsumer = mq.NewRabbitMQConsumer(rabbitConfig.WithQueue(influxQueue, "event_logs"), influxQueue.Name, influxSink.HandleDelivery)
+	}
+
+	// Setup the consumer for commands sent by other services on the gateway_commands queue
+	if mq.UsesRabbitMQ() {
+		commandHandler := commands.NewHandler(app.Models, app.Cache)
+		app.CommandConsumer = mq.NewRabbitMQConsumer(rabbitConfig, "gateway_commands", commandHandler.HandleDelivery)
+		commandHandler.SetReplier(app.CommandConsumer)
+	}
+
+	// Setup the Socket.IO broadcaster shared by the UDP server and the HTTP handlers
+	app.Broadcaster = realtime.NewBroadcaster(app.Cache)
+
+	// Setup the event bus the ingest paths publish to, and its subscribers
+	app.Bus = events.NewBus()
+	// The log buffer feeds PostgreSQL and the device registrations, and the message bus feeds every
+	// downstream consumer, so neither may lose events.
+	app.Bus.SubscribeLossless("redis-logs", 10000, events.LogBufferSubscriber(app.Cache))
+	app.Bus.SubscribeLossless("mq", 10000, events.MQSubscriber(app.MQProducer, app.Cache))
+	app.Bus.Subscribe("socketio", 0, events.SocketSubscriber(app.Broadcaster))
+	app.Bus.Subscribe("zones", 0, events.ZoneSubscriber(app.Cache, app.Broadcaster))
+	app.Bus.Subscribe("battery", 0, events.BatterySubscriber(app.Cache))
+	// The replay subscribers release the traffic held for approved devices, which is not kept elsewhere.
+	app.Bus.SubscribeLossless("replay-http", 0, handlers.ReplaySubscriber())
+	app.Service.SetBus(app.Bus)
 
 	// Set up the UDP server
 	app.UdpServer = udp.NewUDPServer(
 		fmt.Sprintf(":%s", os.Getenv("UDP_PORT")),
-		app.MQProducer,
+		app.Bus,
 		app.Cache,
 		app.Service,
 		app.DeviceAccessMode,
 	)
+	app.Bus.SubscribeLossless("replay-udp", 0, app.UdpServer.ReplaySubscriber())
 
 	// Initialize and assign a cron scheduler instance to the app
 	app.Cron = cron.New(cron.WithSeconds())
@@ -0,0 +1,29 @@
+-- Parking sessions pair the arrival and departure events of activity_logs into stays.
+-- Rows are derived by the gateway and rebuilt whenever late (out-of-order) events arrive.
+CREATE TABLE IF NOT EXISTS parking.parking_sessions (
+    id BIGSERIAL PRIMARY KEY,
+    device_id VARCHAR(255) NOT NULL,       -- Device that detected the vehicle.
+    network_type VARCHAR(50) NOT NULL,     -- Network type (e.g., NB-IoT, LoRa).
+    started_at TIMESTAMP NOT NULL,         -- Arrival (first occupied event).
+    ended_at TIMESTAMP NULL,               -- Departure (vacant event), NULL while the vehicle is parked.
+    duration_seconds BIGINT NULL,          -- ended_at - started_at, NULL while open.
+    arrival_raw_id UUID NOT NULL,          -- Raw data log of the arrival event.
+    departure_raw_id UUID NULL,            -- Raw data log of the departure event.
+    events_amount INTEGER NOT NULL DEFAULT 0,     -- Activity events that belong to the session.
+    interruptions INTEGER NOT NULL DEFAULT 0,     -- Repeated arrivals or short vacant gaps, e.g. sensor reboots.
+    beacons_amount INTEGER NOT NULL DEFAULT 0,    -- Distinct beacons seen during the session.
+    beacons JSONB,                                -- Distinct beacons seen during the session.
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (device_id, started_at)
+);
+
+-- Attach a trigger to update the 'updated_at' field before any update operation on 'parking_sessions'.
+CREATE TRIGGER set_updated_at
+BEFORE UPDATE ON parking.parking_sessions
+FOR EACH ROW
+EXECUTE FUNCTION update_timestamp();
+
+-- Indexes for improved query performance.
+CREATE INDEX idx_parking_sessions_started_at ON parking.parking_sessions (started_at);
+CREATE INDEX idx_parking_sessions_open ON parking.parking_sessions (device_id) WHERE ended_at IS NULL;
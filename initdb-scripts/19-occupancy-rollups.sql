@@ -0,0 +1,29 @@
+-- Occupancy rollups aggregate the parking sessions (derived from activity_logs) per device and hour/day.
+-- Buckets are in UTC and are recomputed whenever sessions change, so late data is backfilled.
+CREATE TABLE IF NOT EXISTS parking.occupancy_hourly (
+    device_id VARCHAR(255) NOT NULL,
+    bucket TIMESTAMP NOT NULL,                  -- Start of the UTC hour.
+    occupied_seconds BIGINT NOT NULL DEFAULT 0, -- Seconds the bay was occupied within the bucket.
+    arrivals INTEGER NOT NULL DEFAULT 0,        -- Sessions started within the bucket.
+    departures INTEGER NOT NULL DEFAULT 0,      -- Sessions ended within the bucket.
+    dwell_seconds BIGINT NOT NULL DEFAULT 0,    -- Total duration of the sessions ended within the bucket.
+    dwell_count INTEGER NOT NULL DEFAULT 0,     -- Number of sessions ended within the bucket.
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (device_id, bucket)
+);
+
+CREATE INDEX idx_occupancy_hourly_bucket ON parking.occupancy_hourly (bucket);
+
+CREATE TABLE IF NOT EXISTS parking.occupancy_daily (
+    device_id VARCHAR(255) NOT NULL,
+    bucket TIMESTAMP NOT NULL,                  -- Start of the UTC day.
+    occupied_seconds BIGINT NOT NULL DEFAULT 0,
+    arrivals INTEGER NOT NULL DEFAULT 0,
+    departures INTEGER NOT NULL DEFAULT 0,
+    dwell_seconds BIGINT NOT NULL DEFAULT 0,
+    dwell_count INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (device_id, bucket)
+);
+
+CREATE INDEX idx_occupancy_daily_bucket ON parking.occupancy_daily (bucket);
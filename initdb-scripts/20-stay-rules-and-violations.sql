@@ -0,0 +1,38 @@
+-- Stay rules limit how long a vehicle may occupy a bay, optionally only at certain hours and days.
+CREATE TABLE IF NOT EXISTS parking.stay_rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    device_id VARCHAR(255) NULL,                 -- Device the rule applies to, NULL for every device without its own rules.
+    max_stay_minutes INTEGER NOT NULL,           -- Longest allowed stay.
+    active_from VARCHAR(5) NOT NULL DEFAULT '',  -- 'HH:MM' local time the rule starts applying, '' for all day.
+    active_to VARCHAR(5) NOT NULL DEFAULT '',    -- 'HH:MM' local time the rule stops applying, '' for all day.
+    days_of_week VARCHAR(20) NOT NULL DEFAULT '',-- ISO weekdays, e.g. '1,2,3,4,5' (1 = Monday), '' for every day.
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC', -- IANA timezone of active_from, active_to and days_of_week.
+    is_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Attach a trigger to update the 'updated_at' field before any update operation on 'stay_rules'.
+CREATE TRIGGER set_updated_at
+BEFORE UPDATE ON parking.stay_rules
+FOR EACH ROW
+EXECUTE FUNCTION update_timestamp();
+
+CREATE INDEX idx_stay_rules_device_id ON parking.stay_rules (device_id);
+
+-- Violations are raised when a vehicle exceeds a stay rule and cleared when it leaves.
+CREATE TABLE IF NOT EXISTS parking.violations (
+    id BIGSERIAL PRIMARY KEY,
+    rule_id INTEGER NOT NULL,
+    device_id VARCHAR(255) NOT NULL,
+    occupied_since TIMESTAMP NOT NULL,   -- Arrival of the vehicle.
+    violated_at TIMESTAMP NOT NULL,      -- When the limit was exceeded.
+    max_stay_minutes INTEGER NOT NULL,   -- Limit of the rule when the violation was raised.
+    cleared_at TIMESTAMP NULL,           -- Departure of the vehicle, NULL while the violation is active.
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_violations_device_id ON parking.violations (device_id);
+CREATE INDEX idx_violations_violated_at ON parking.violations (violated_at);
+CREATE UNIQUE INDEX idx_violations_active ON parking.violations (rule_id, device_id) WHERE cleared_at IS NULL;
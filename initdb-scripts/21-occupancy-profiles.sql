@@ -0,0 +1,11 @@
+-- Occupancy profiles hold the average occupancy of each device per weekday and hour,
+-- learned from the hourly rollups and used to forecast availability.
+CREATE TABLE IF NOT EXISTS parking.occupancy_profiles (
+    device_id VARCHAR(255) NOT NULL,
+    weekday SMALLINT NOT NULL,                  -- ISO weekday in the profile timezone, 1 = Monday ... 7 = Sunday.
+    hour SMALLINT NOT NULL,                     -- Hour of the day in the profile timezone, 0 - 23.
+    occupancy_rate DOUBLE PRECISION NOT NULL,   -- Average share of the hour the bay was occupied, 0 - 1.
+    samples INTEGER NOT NULL,                   -- Number of hours the average was taken over.
+    trained_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (device_id, weekday, hour)
+);
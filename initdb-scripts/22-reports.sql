@@ -0,0 +1,40 @@
+-- Reports generated on demand or by a schedule. The files are stored in app.report_files.
+CREATE TABLE IF NOT EXISTS app.reports (
+    id SERIAL PRIMARY KEY,
+    report_type VARCHAR(50) NOT NULL,           -- occupancy_summary, device_health, sessions or audit_trail.
+    format VARCHAR(10) NOT NULL,                -- csv, xlsx or pdf.
+    from_date TIMESTAMP NOT NULL,               -- Start of the reported period.
+    to_date TIMESTAMP NOT NULL,                 -- End of the reported period (exclusive).
+    device_ids TEXT NOT NULL DEFAULT '',        -- Comma separated device filter, '' for every device.
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, ready or failed.
+    file_name VARCHAR(255) NOT NULL DEFAULT '', -- Name the file is downloaded as.
+    file_size BIGINT NOT NULL DEFAULT 0,
+    row_count INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    user_id INTEGER NULL,                       -- User who requested the report, NULL for scheduled reports.
+    schedule_id INTEGER NULL,                   -- Schedule that generated the report.
+    created_at TIMESTAMP DEFAULT NOW(),
+    completed_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_reports_created_at ON app.reports (created_at);
+
+-- Schedules generate a report of the previous period on a cron expression.
+CREATE TABLE IF NOT EXISTS app.report_schedules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    report_type VARCHAR(50) NOT NULL,
+    format VARCHAR(10) NOT NULL,
+    cron_spec VARCHAR(100) NOT NULL,            -- Cron expression with seconds, e.g. '0 0 6 1 * *'.
+    period VARCHAR(20) NOT NULL,                -- previous_day, previous_week or previous_month.
+    device_ids TEXT NOT NULL DEFAULT '',
+    is_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Attach a trigger to update the 'updated_at' field before any update operation on 'report_schedules'.
+CREATE TRIGGER set_updated_at
+BEFORE UPDATE ON app.report_schedules
+FOR EACH ROW
+EXECUTE FUNCTION update_timestamp();
@@ -0,0 +1,23 @@
+-- Zones group bays into a hierarchy: a lot (car park) contains levels, and lots and levels contain zones.
+CREATE TABLE IF NOT EXISTS parking.zones (
+    id SERIAL PRIMARY KEY,
+    parent_id INTEGER NULL REFERENCES parking.zones (id) ON DELETE CASCADE, -- NULL for a top-level lot or zone.
+    kind VARCHAR(10) NOT NULL,              -- 'lot', 'level' or 'zone'.
+    name VARCHAR(255) NOT NULL,
+    capacity INTEGER NOT NULL DEFAULT 0,    -- Number of spaces, 0 to count the assigned bays.
+    polygon JSONB NULL,                     -- Outline as [[longitude, latitude], ...], NULL for a bounding box only.
+    min_latitude DECIMAL(9, 6) NULL,        -- Bounding box, derived from the polygon when there is one.
+    min_longitude DECIMAL(9, 6) NULL,
+    max_latitude DECIMAL(9, 6) NULL,
+    max_longitude DECIMAL(9, 6) NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Attach a trigger to update the 'updated_at' field before any update operation on 'zones'.
+CREATE TRIGGER set_updated_at
+BEFORE UPDATE ON parking.zones
+FOR EACH ROW
+EXECUTE FUNCTION update_timestamp();
+
+CREATE INDEX idx_zones_parent_id ON parking.zones (parent_id);
@@ -0,0 +1,37 @@
+-- Bays are the parking spaces themselves, kept when the sensor monitoring them is replaced.
+CREATE TABLE IF NOT EXISTS parking.bays (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(50) NOT NULL,                       -- Marking of the bay, e.g. 'L2-041'.
+    zone_id INTEGER NULL REFERENCES parking.zones (id) ON DELETE SET NULL, -- Lot, level or zone the bay is in.
+    bay_type VARCHAR(20) NOT NULL DEFAULT 'standard', -- standard, disabled, ev, loading or motorcycle.
+    latitude DECIMAL(9, 6) DEFAULT 0,
+    longitude DECIMAL(9, 6) DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Attach a trigger to update the 'updated_at' field before any update operation on 'bays'.
+CREATE TRIGGER set_updated_at
+BEFORE UPDATE ON parking.bays
+FOR EACH ROW
+EXECUTE FUNCTION update_timestamp();
+
+CREATE INDEX idx_bays_zone_id ON parking.bays (zone_id);
+
+-- Bay assignments record which device monitored a bay and when.
+CREATE TABLE IF NOT EXISTS parking.bay_assignments (
+    id BIGSERIAL PRIMARY KEY,
+    bay_id INTEGER NOT NULL REFERENCES parking.bays (id) ON DELETE CASCADE,
+    device_id VARCHAR(255) NOT NULL,
+    assigned_from TIMESTAMP NOT NULL,
+    assigned_to TIMESTAMP NULL,          -- NULL while the device monitors the bay.
+    created_at TIMESTAMP DEFAULT NOW(),
+    CHECK (assigned_to IS NULL OR assigned_to >= assigned_from)
+);
+
+CREATE INDEX idx_bay_assignments_bay_id ON parking.bay_assignments (bay_id, assigned_from);
+CREATE INDEX idx_bay_assignments_device_id ON parking.bay_assignments (device_id, assigned_from);
+
+-- A bay has at most one device, and a device monitors at most one bay, at a time.
+CREATE UNIQUE INDEX idx_bay_assignments_open_bay ON parking.bay_assignments (bay_id) WHERE assigned_to IS NULL;
+CREATE UNIQUE INDEX idx_bay_assignments_open_device ON parking.bay_assignments (device_id) WHERE assigned_to IS NULL;
@@ -0,0 +1,8 @@
+-- Trigram indexes let the device search match parts of names and device IDs (ILIKE '%...%').
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_devices_name_trgm ON parking.devices USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_devices_device_id_trgm ON parking.devices USING GIN (device_id gin_trgm_ops);
+
+-- Index for filtering devices on the age of their last keepalive.
+CREATE INDEX IF NOT EXISTS idx_devices_keepalive_at ON parking.devices (keepalive_at);
@@ -0,0 +1,19 @@
+-- Tags are the custom key/value metadata of devices, such as installer, installation date or asset number.
+ALTER TABLE parking.devices ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '{}';
+
+-- Tag definitions are the schema admins define for the device tags.
+CREATE TABLE IF NOT EXISTS parking.tag_definitions (
+    key VARCHAR(50) PRIMARY KEY,                   -- Tag key, e.g. 'installer'.
+    description TEXT NOT NULL DEFAULT '',
+    type VARCHAR(20) NOT NULL DEFAULT 'string',    -- string, number, boolean or date.
+    allowed_values JSONB NOT NULL DEFAULT '[]',    -- Empty for any value of the type.
+    required BOOLEAN NOT NULL DEFAULT FALSE,       -- Devices must have the tag when their tags are edited.
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Attach a trigger to update the 'updated_at' field before any update operation on 'tag_definitions'.
+CREATE TRIGGER set_updated_at
+BEFORE UPDATE ON parking.tag_definitions
+FOR EACH ROW
+EXECUTE FUNCTION update_timestamp();
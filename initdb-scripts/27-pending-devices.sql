@@ -0,0 +1,23 @@
+-- Pending devices are unknown devices waiting to be approved, or claimed, before they are registered.
+-- Their traffic is held in Redis until then. Rejected devices are kept so that their traffic is dropped.
+CREATE TABLE IF NOT EXISTS parking.pending_devices (
+    device_id VARCHAR(255) PRIMARY KEY,
+    network_type VARCHAR(50) NOT NULL,
+    firmware_version DECIMAL(5, 2) NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',          -- Name to register the device with, set by an admin.
+    latitude DECIMAL(9, 6) DEFAULT 0,               -- Location to register the device at, set by an admin.
+    longitude DECIMAL(9, 6) DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',  -- pending or rejected.
+    first_seen_at TIMESTAMP DEFAULT NOW(),
+    reviewed_by INTEGER NULL,                       -- User who last renamed, located or rejected the device.
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Attach a trigger to update the 'updated_at' field before any update operation on 'pending_devices'.
+CREATE TRIGGER set_updated_at
+BEFORE UPDATE ON parking.pending_devices
+FOR EACH ROW
+EXECUTE FUNCTION update_timestamp();
+
+CREATE INDEX idx_pending_devices_status ON parking.pending_devices (status);
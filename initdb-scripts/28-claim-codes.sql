@@ -0,0 +1,20 @@
+-- Claim codes are generated per batch of devices and printed on their labels. Installers redeem
+-- them on site to register a device at its location, without admin access.
+CREATE TABLE IF NOT EXISTS parking.claim_codes (
+    code VARCHAR(20) PRIMARY KEY,                   -- eg: K7QM-3HXD-W9TP
+    device_id VARCHAR(255) NOT NULL UNIQUE,         -- Each device has a single code.
+    network_type VARCHAR(50) NOT NULL,
+    batch VARCHAR(100) NOT NULL,                    -- Batch the labels were printed for.
+    claimed_by INTEGER NULL,                        -- User who redeemed the code, NULL while unclaimed.
+    claimed_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Attach a trigger to update the 'updated_at' field before any update operation on 'claim_codes'.
+CREATE TRIGGER set_updated_at
+BEFORE UPDATE ON parking.claim_codes
+FOR EACH ROW
+EXECUTE FUNCTION update_timestamp();
+
+CREATE INDEX idx_claim_codes_batch ON parking.claim_codes (batch);
@@ -0,0 +1,9 @@
+-- Lifecycle state of a device: provisioned, active, maintenance, decommissioned or deleted.
+-- Deleted devices keep deleted_at, and the state and blocking they are restored to in previous_state and previous_blocked.
+ALTER TABLE parking.devices ADD COLUMN IF NOT EXISTS lifecycle_state VARCHAR(20) NOT NULL DEFAULT 'active';
+ALTER TABLE parking.devices ADD COLUMN IF NOT EXISTS previous_state VARCHAR(20) NULL;
+ALTER TABLE parking.devices ADD COLUMN IF NOT EXISTS previous_blocked BOOLEAN NULL;
+
+UPDATE parking.devices SET lifecycle_state = 'deleted', previous_state = 'active' WHERE deleted_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_devices_lifecycle_state ON parking.devices (lifecycle_state);
@@ -0,0 +1,9 @@
+-- Purged devices leave a tombstone so that incremental clients, such as the GeoJSON feed,
+-- learn about the deletion once the device row is gone.
+CREATE TABLE IF NOT EXISTS parking.purged_devices (
+    device_id VARCHAR(255) PRIMARY KEY,
+    network_type VARCHAR(50) NOT NULL,
+    purged_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_purged_devices_purged_at ON parking.purged_devices (purged_at);
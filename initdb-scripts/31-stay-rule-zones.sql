@@ -0,0 +1,6 @@
+-- Stay rules can apply to the bays of a zone, and of the zones nested in it.
+-- A device takes its own rules, else those of its innermost zone that has rules, else the rules without a device or zone.
+ALTER TABLE parking.stay_rules ADD COLUMN IF NOT EXISTS zone_id INTEGER NULL REFERENCES parking.zones (id) ON DELETE CASCADE;
+ALTER TABLE parking.stay_rules ADD CONSTRAINT stay_rules_device_or_zone CHECK (device_id IS NULL OR zone_id IS NULL);
+
+CREATE INDEX IF NOT EXISTS idx_stay_rules_zone_id ON parking.stay_rules (zone_id);
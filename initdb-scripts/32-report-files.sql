@@ -0,0 +1,6 @@
+-- The report files are stored in the database rather than on the disk of the instance that
+-- generated them, so any gateway instance can serve the download.
+CREATE TABLE IF NOT EXISTS app.report_files (
+    report_id INTEGER PRIMARY KEY REFERENCES app.reports (id) ON DELETE CASCADE,
+    content BYTEA NOT NULL
+);
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+)
+
+// Horizons of the forecast endpoint.
+const (
+	defaultForecastHorizon = 15 * time.Minute
+	maxForecastHorizon     = 7 * 24 * time.Hour
+)
+
+// AnalyticsHandler serves occupancy statistics computed from the occupancy rollups.
+type AnalyticsHandler struct{}
+
+// parseRollupQuery parses the query parameters shared by the analytics endpoints:
+//
+//	from_date, to_date   Unix timestamps (required)
+//	interval             hour (default), day, week or month
+//	group_by             device (default), network_type, bay (default with bay_id) or all
+//	tz                   IANA timezone the periods are aligned to, default UTC
+//	device_id            Comma separated device IDs
+//	network_type         Network type filter
+//	bay_id               Comma separated bay IDs, following each bay across sensor replacements
+func parseRollupQuery(r *http.Request) (models.RollupQuery, error) {
+	query := r.URL.Query()
+
+	q := models.RollupQuery{
+		Interval:    query.Get("interval"),
+		GroupBy:     query.Get("group_by"),
+		Timezone:    query.Get("tz"),
+		NetworkType: query.Get("network_type"),
+	}
+
+	if q.Interval == "" {
+		q.Interval = "hour"
+	}
+	if !models.RollupIntervals[q.Interval] {
+		return q, errors.New("Invalid interval. Must be 'hour', 'day', 'week' or 'month'.")
+	}
+
+	if q.GroupBy == "" && query.Get("bay_id") != "" {
+		q.GroupBy = "bay"
+	}
+	if q.GroupBy == "" {
+		q.GroupBy = "device"
+	}
+	if !models.RollupGroups[q.GroupBy] {
+		return q, errors.New("Invalid group_by. Must be 'device', 'network_type', 'bay' or 'all'.")
+	}
+
+	if q.Timezone == "" {
+		q.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(q.Timezone); err != nil {
+		return q, fmt.Errorf("Invalid tz '%s'. Must be an IANA timezone, e.g. Europe/Malta.", q.Timezone)
+	}
+
+	for deviceID := range splitQueryList(query.Get("device_id")) {
+		q.DeviceIDs = append(q.DeviceIDs, deviceID)
+	}
+
+	for value := range splitQueryList(query.Get("bay_id")) {
+		bayID, err := strconv.Atoi(value)
+		if err != nil || bayID <= 0 {
+			return q, fmt.Errorf("Invalid bay_id '%s'.", value)
+		}
+		q.BayIDs = append(q.BayIDs, bayID)
+	}
+	if q.ByBay() && (q.GroupBy == "device" || q.GroupBy == "network_type" || len(q.DeviceIDs) > 0 || q.NetworkType != "") {
+		return q, errors.New("bay_id and group_by=bay cannot be combined with device or network_type grouping and filters.")
+	}
+
+	fromDate, err := parseOptionalInt(r, "from_date")
+	if err != nil || fromDate == 0 {
+		return q, errors.New("Invalid from_date. Must be a valid timestamp.")
+	}
+	toDate, err := parseOptionalInt(r, "to_date")
+	if err != nil || toDate == 0 {
+		return q, errors.New("Invalid to_date. Must be a valid timestamp.")
+	}
+	if fromDate >= toDate {
+		return q, errors.New("from_date must be less than to_date")
+	}
+
+	q.From = time.Unix(fromDate, 0).UTC()
+	q.To = time.Unix(toDate, 0).UTC()
+
+	return q, nil
+}
+
+// writeAnalyticsResponse encodes a report together with the parameters it was computed for.
+func writeAnalyticsResponse(w http.ResponseWriter, q models.RollupQuery, key string, points any) {
+	response := map[string]interface{}{
+		"from_date": q.From.Unix(),
+		"to_date":   q.To.Unix(),
+		"interval":  q.Interval,
+		"group_by":  q.GroupBy,
+		"tz":        q.Timezone,
+		key:         points,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// respondWithReportError maps report errors to a response.
+func respondWithReportError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrAnalyticsRangeTooLarge) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	helpers.RespondWithError(w, helpers.WrapError(err), "Error computing analytics", http.StatusInternalServerError)
+}
+
+// ---------------------------------------------------------------------
+
+// Occupancy returns the occupancy rate per group and period.
+// eg: GET /api/analytics/occupancy?from_date=1707782400&to_date=1707868800&interval=hour&group_by=all&tz=Europe/Malta
+func (h *AnalyticsHandler) Occupancy(w http.ResponseWriter, r *http.Request) {
+	q, err := parseRollupQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := app.Service.OccupancyReport(q)
+	if err != nil {
+		respondWithReportError(w, err)
+		return
+	}
+
+	writeAnalyticsResponse(w, q, "occupancy", points)
+}
+
+// Turnover returns the arrivals per bay and the average dwell time per group and period.
+// eg: GET /api/analytics/turnover?from_date=1707782400&to_date=1708387200&interval=day&group_by=device
+func (h *AnalyticsHandler) Turnover(w http.ResponseWriter, r *http.Request) {
+	q, err := parseRollupQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := app.Service.TurnoverReport(q)
+	if err != nil {
+		respondWithReportError(w, err)
+		return
+	}
+
+	writeAnalyticsResponse(w, q, "turnover", points)
+}
+
+// Recompute backfills the rollups of every device between from_date and to_date in the background.
+// eg: POST /api/analytics/recompute?from_date=1707782400&to_date=1708387200
+func (h *AnalyticsHandler) Recompute(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to recompute the rollups
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	fromDate, err := parseOptionalInt(r, "from_date")
+	if err != nil || fromDate == 0 {
+		http.Error(w, "Invalid from_date. Must be a valid timestamp.", http.StatusBadRequest)
+		return
+	}
+	toDate, err := parseOptionalInt(r, "to_date")
+	if err != nil || toDate == 0 {
+		http.Error(w, "Invalid to_date. Must be a valid timestamp.", http.StatusBadRequest)
+		return
+	}
+	if fromDate >= toDate {
+		http.Error(w, "from_date must be less than to_date", http.StatusBadRequest)
+		return
+	}
+
+	from := time.Unix(fromDate, 0).UTC()
+	to := time.Unix(toDate, 0).UTC()
+
+	go app.Service.BackfillOccupancyRollups(from, to)
+
+	app.PushAuditToCache(*userData, "UPDATE", "analytics", "occupancy_rollups", r, fmt.Sprintf("Recomputed occupancy rollups from %s to %s.", from.Format(time.RFC3339), to.Format(time.RFC3339)))
+
+	response := map[string]interface{}{
+		"message": "Recomputing occupancy rollups in the background.",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Forecast returns the forecast availability of the bays of a zone after the horizon (minutes, default 15).
+// eg: GET /api/analytics/forecast?zone=all&horizon=15
+func (h *AnalyticsHandler) Forecast(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := services.ForecastQuery{
+		Zone:    query.Get("zone"),
+		Horizon: defaultForecastHorizon,
+	}
+	if q.Zone == "" {
+		q.Zone = "all"
+	}
+
+	for deviceID := range splitQueryList(query.Get("device_id")) {
+		q.DeviceIDs = append(q.DeviceIDs, deviceID)
+	}
+
+	horizon, err := parseOptionalInt(r, "horizon")
+	if err != nil || time.Duration(horizon)*time.Minute > maxForecastHorizon {
+		http.Error(w, fmt.Sprintf("Invalid horizon. Must be a number of minutes up to %.0f.", maxForecastHorizon.Minutes()), http.StatusBadRequest)
+		return
+	}
+	if query.Get("horizon") != "" {
+		q.Horizon = time.Duration(horizon) * time.Minute
+	}
+
+	forecast, err := app.Service.Forecast(q)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownZone) {
+			http.Error(w, fmt.Sprintf("Zone '%s' not found.", q.Zone), http.StatusNotFound)
+			return
+		}
+		helpers.RespondWithError(w, helpers.WrapError(err), "Error computing forecast", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":  fmt.Sprintf("Forecast of %d bays computed successfully.", forecast.Capacity),
+		"forecast": forecast,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
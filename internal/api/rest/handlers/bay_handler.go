@@ -0,0 +1,508 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// BayHandler manages parking bays and the devices assigned to them.
+type BayHandler struct{}
+
+// bayPayload is the body of the create and update requests.
+type bayPayload struct {
+	Code      string  `json:"code"`
+	ZoneID    *int    `json:"zone_id"`
+	BayType   string  `json:"bay_type"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// toBay converts the payload into a validated bay.
+func (p bayPayload) toBay() (*models.Bay, error) {
+	bay := &models.Bay{
+		Code:      strings.TrimSpace(p.Code),
+		ZoneID:    p.ZoneID,
+		BayType:   strings.ToLower(strings.TrimSpace(p.BayType)),
+		Latitude:  p.Latitude,
+		Longitude: p.Longitude,
+	}
+	if bay.BayType == "" {
+		bay.BayType = "standard"
+	}
+
+	if err := bay.Validate(); err != nil {
+		return nil, err
+	}
+	if bay.ZoneID != nil {
+		if _, err := app.Models.Zone.GetByID(*bay.ZoneID); err != nil {
+			if err.Error() == "zone not found" {
+				return nil, fmt.Errorf("zone %d not found", *bay.ZoneID)
+			}
+			return nil, err
+		}
+	}
+
+	return bay, nil
+}
+
+// assignmentPayload is the body of the assign and replace-sensor requests.
+type assignmentPayload struct {
+	DeviceID string `json:"device_id"`
+	At       int64  `json:"at"` // Unix timestamp the change took effect, 0 for now
+}
+
+// time returns when the assignment change took effect.
+func (p assignmentPayload) time() (time.Time, error) {
+	now := time.Now().UTC()
+	if p.At == 0 {
+		return now, nil
+	}
+
+	at := time.Unix(p.At, 0).UTC()
+	if at.After(now) {
+		return at, errors.New("at cannot be in the future")
+	}
+	return at, nil
+}
+
+// bayFromRequest loads the bay with the ID in the URL.
+func bayFromRequest(w http.ResponseWriter, r *http.Request) (*models.Bay, bool) {
+	bayID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid bay ID.", http.StatusBadRequest)
+		return nil, false
+	}
+
+	bay, err := app.Models.Bay.GetByID(bayID)
+	if err != nil {
+		if err.Error() == "bay not found" {
+			http.Error(w, fmt.Sprintf("Bay with ID %d not found.", bayID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to retrieve bay.", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+
+	return bay, true
+}
+
+// respondWithAssignmentError maps assignment errors to a response.
+func respondWithAssignmentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrBayHasDevice), errors.Is(err, models.ErrBayHasNoDevice), errors.Is(err, models.ErrDeviceHasBay):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrAssignmentBefore), errors.Is(err, models.ErrAssignmentAfter):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		helpers.RespondWithError(w, err, "Failed to update bay assignment.", http.StatusInternalServerError)
+	}
+}
+
+// writeBayResponse encodes a response.
+func writeBayResponse(w http.ResponseWriter, status int, response map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// Index lists the bays with the devices currently assigned to them.
+// eg: GET /api/bays?zone_id=3,4&bay_type=ev
+func (h *BayHandler) Index(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := models.BayFilter{BayType: strings.ToLower(query.Get("bay_type"))}
+	if filter.BayType != "" && !models.BayTypes[filter.BayType] {
+		http.Error(w, fmt.Sprintf("Invalid bay_type '%s'.", filter.BayType), http.StatusBadRequest)
+		return
+	}
+	for value := range splitQueryList(query.Get("zone_id")) {
+		zoneID, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid zone_id '%s'.", value), http.StatusBadRequest)
+			return
+		}
+		filter.ZoneIDs = append(filter.ZoneIDs, zoneID)
+	}
+
+	bays, err := app.Models.Bay.GetAll(filter)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve bays.", http.StatusInternalServerError)
+		return
+	}
+
+	writeBayResponse(w, http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("%d bays retrieved successfully.", len(bays)),
+		"bays":    bays,
+	})
+}
+
+// Get returns a bay with its assignment history, newest first.
+func (h *BayHandler) Get(w http.ResponseWriter, r *http.Request) {
+	bay, ok := bayFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	assignments, err := app.Models.BayAssignment.GetByBay(bay.ID)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve bay assignments.", http.StatusInternalServerError)
+		return
+	}
+	if assignments == nil {
+		assignments = []*models.BayAssignment{}
+	}
+
+	writeBayResponse(w, http.StatusOK, map[string]interface{}{
+		"message":     "Bay retrieved successfully.",
+		"bay":         bay,
+		"assignments": assignments,
+	})
+}
+
+func (h *BayHandler) Store(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to create a bay
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload bayPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	bay, err := payload.toBay()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bay, err = app.Models.Bay.Create(bay)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to create bay.", http.StatusInternalServerError)
+		return
+	}
+
+	app.PushAuditToCache(*userData, "CREATE", "bay", fmt.Sprintf("%d", bay.ID), r, fmt.Sprintf("Created %s bay '%s'.", bay.BayType, bay.Code))
+
+	writeBayResponse(w, http.StatusCreated, map[string]interface{}{
+		"message": "Bay created successfully.",
+		"bay":     bay,
+	})
+}
+
+func (h *BayHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to update a bay
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	bayID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid bay ID.", http.StatusBadRequest)
+		return
+	}
+
+	var payload bayPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	bay, err := payload.toBay()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bay.ID = bayID
+
+	bay, err = app.Models.Bay.Update(bay)
+	if err != nil {
+		if err.Error() == "bay not found" {
+			http.Error(w, fmt.Sprintf("Bay with ID %d not found.", bayID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to update bay.", http.StatusInternalServerError)
+		}
+		return
+	}
+	app.Service.RebuildZoneCounts()
+	app.Service.RebuildSpatialIndex()
+
+	app.PushAuditToCache(*userData, "UPDATE", "bay", fmt.Sprintf("%d", bayID), r, fmt.Sprintf("Updated bay '%s'.", bay.Code))
+
+	writeBayResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "Bay updated successfully.",
+		"bay":     bay,
+	})
+}
+
+// Destroy deletes a bay with its assignment history.
+func (h *BayHandler) Destroy(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to delete a bay
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	bayID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid bay ID.", http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Bay.DeleteByID(bayID)
+	if err != nil {
+		if err.Error() == "bay not found" {
+			http.Error(w, fmt.Sprintf("Bay with ID %d not found.", bayID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to delete bay.", http.StatusInternalServerError)
+		}
+		return
+	}
+	app.Service.RebuildZoneCounts()
+	app.Service.RebuildSpatialIndex()
+
+	app.PushAuditToCache(*userData, "DELETE", "bay", fmt.Sprintf("%d", bayID), r, fmt.Sprintf("Deleted bay with ID %d.", bayID))
+
+	writeBayResponse(w, http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("Bay with ID %d successfully deleted.", bayID),
+	})
+}
+
+// -----------------------------------------------------------------------------
+
+// AssignDevice assigns a device to a bay that has none.
+// eg: PUT /api/bays/12/device {"device_id": "865123456789012"}
+func (h *BayHandler) AssignDevice(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to assign devices
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	bay, ok := bayFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	payload, at, ok := decodeAssignment(w, r)
+	if !ok {
+		return
+	}
+
+	assignment, err := app.Models.BayAssignment.Assign(bay.ID, payload.DeviceID, at)
+	if err != nil {
+		respondWithAssignmentError(w, err)
+		return
+	}
+	app.Service.RebuildZoneCounts()
+	app.Service.RebuildSpatialIndex()
+
+	app.PushAuditToCache(*userData, "UPDATE", "bay", fmt.Sprintf("%d", bay.ID), r, fmt.Sprintf("Assigned device %s to bay '%s'.", payload.DeviceID, bay.Code))
+
+	writeBayResponse(w, http.StatusOK, map[string]interface{}{
+		"message":    fmt.Sprintf("Device %s assigned to bay '%s'.", payload.DeviceID, bay.Code),
+		"assignment": assignment,
+	})
+}
+
+// UnassignDevice ends the assignment of the bay's device, leaving the bay without a device.
+// eg: DELETE /api/bays/12/device?at=1707803200
+func (h *BayHandler) UnassignDevice(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to unassign devices
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	bay, ok := bayFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	atUnix, err := parseOptionalInt(r, "at")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	at, err := assignmentPayload{At: atUnix}.time()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	assignment, err := app.Models.BayAssignment.Unassign(bay.ID, at)
+	if err != nil {
+		respondWithAssignmentError(w, err)
+		return
+	}
+	app.Service.RebuildZoneCounts()
+	app.Service.RebuildSpatialIndex()
+
+	app.PushAuditToCache(*userData, "UPDATE", "bay", fmt.Sprintf("%d", bay.ID), r, fmt.Sprintf("Unassigned device %s from bay '%s'.", assignment.DeviceID, bay.Code))
+
+	writeBayResponse(w, http.StatusOK, map[string]interface{}{
+		"message":    fmt.Sprintf("Device %s unassigned from bay '%s'.", assignment.DeviceID, bay.Code),
+		"assignment": assignment,
+	})
+}
+
+// ReplaceSensor ends the assignment of the bay's device and assigns the new device from the same time.
+// eg: POST /api/bays/12/replace-sensor {"device_id": "865123456789099", "at": 1707803200}
+func (h *BayHandler) ReplaceSensor(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to replace sensors
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	bay, ok := bayFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	payload, at, ok := decodeAssignment(w, r)
+	if !ok {
+		return
+	}
+	if bay.DeviceID != nil && *bay.DeviceID == payload.DeviceID {
+		http.Error(w, fmt.Sprintf("Device %s already monitors bay '%s'.", payload.DeviceID, bay.Code), http.StatusConflict)
+		return
+	}
+
+	closed, opened, err := app.Models.BayAssignment.Replace(bay.ID, payload.DeviceID, at)
+	if err != nil {
+		respondWithAssignmentError(w, err)
+		return
+	}
+	app.Service.RebuildZoneCounts()
+	app.Service.RebuildSpatialIndex()
+
+	app.PushAuditToCache(*userData, "UPDATE", "bay", fmt.Sprintf("%d", bay.ID), r,
+		fmt.Sprintf("Replaced device %s with %s in bay '%s'.", closed.DeviceID, opened.DeviceID, bay.Code))
+
+	writeBayResponse(w, http.StatusOK, map[string]interface{}{
+		"message":  fmt.Sprintf("Device %s replaced with %s in bay '%s'.", closed.DeviceID, opened.DeviceID, bay.Code),
+		"previous": closed,
+		"current":  opened,
+	})
+}
+
+// decodeAssignment decodes an assignment payload and checks that its device exists.
+func decodeAssignment(w http.ResponseWriter, r *http.Request) (assignmentPayload, time.Time, bool) {
+	var payload assignmentPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return payload, time.Time{}, false
+	}
+	payload.DeviceID = strings.TrimSpace(payload.DeviceID)
+
+	at, err := payload.time()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return payload, at, false
+	}
+
+	if _, err := app.Models.Device.GetByID(payload.DeviceID); err != nil {
+		if err.Error() == "device not found or has been deleted" {
+			http.Error(w, fmt.Sprintf("Device with ID %s not found.", payload.DeviceID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to retrieve device.", http.StatusInternalServerError)
+		}
+		return payload, at, false
+	}
+
+	return payload, at, true
+}
+
+// -----------------------------------------------------------------------------
+
+// History returns the activity logs of a bay across the devices that monitored it.
+// eg: GET /api/bays/12/history?from_date=1707782400&to_date=1707868800
+func (h *BayHandler) History(w http.ResponseWriter, r *http.Request) {
+	bay, ok := bayFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	fromDate, err := parseOptionalInt(r, "from_date")
+	if err != nil || fromDate == 0 {
+		http.Error(w, "Invalid from_date. Must be a valid timestamp.", http.StatusBadRequest)
+		return
+	}
+	toDate, err := parseOptionalInt(r, "to_date")
+	if err != nil || toDate == 0 {
+		http.Error(w, "Invalid to_date. Must be a valid timestamp.", http.StatusBadRequest)
+		return
+	}
+	if fromDate > toDate {
+		http.Error(w, "from_date cannot be greater than to_date", http.StatusBadRequest)
+		return
+	}
+
+	activityLogs, err := app.Models.ActivityLog.GetBayActivityLogs(bay.ID, fromDate, toDate)
+	if err != nil {
+		helpers.RespondWithError(w, helpers.WrapError(err), "Error fetching activity logs", http.StatusInternalServerError)
+		return
+	}
+	if activityLogs == nil {
+		activityLogs = []*models.ActivityLog{}
+	}
+
+	writeBayResponse(w, http.StatusOK, map[string]interface{}{
+		"message":       fmt.Sprintf("%d activity logs retrieved successfully.", len(activityLogs)),
+		"bay":           bay,
+		"activity_logs": activityLogs,
+	})
+}
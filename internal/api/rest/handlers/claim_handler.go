@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// ClaimHandler manages the claim codes printed on device labels, and their redemption by installers.
+type ClaimHandler struct{}
+
+// Index lists the claim codes of a batch, as JSON or as a CSV file for printing the labels.
+// eg: GET /api/claims?batch=2024-06-lot-b&format=csv
+func (h *ClaimHandler) Index(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to manage claim codes
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	batch := r.URL.Query().Get("batch")
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, fmt.Sprintf("Invalid format '%s'. Must be json or csv.", format), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := app.Models.ClaimCode.GetAll(batch)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve claim codes.", http.StatusInternalServerError)
+		return
+	}
+
+	if codes == nil {
+		codes = []*models.ClaimCode{}
+	}
+
+	if format == "csv" {
+		filename := "claim-codes.csv"
+		if batch != "" {
+			filename = fmt.Sprintf("claim-codes-%s.csv", batch)
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"code", "device_id", "network_type", "batch", "claimed_at"})
+		for _, c := range codes {
+			claimedAt := ""
+			if c.ClaimedAt != nil {
+				claimedAt = c.ClaimedAt.Format(time.RFC3339)
+			}
+			writer.Write([]string{c.Code, c.DeviceID, c.NetworkType, c.Batch, claimedAt})
+		}
+		writer.Flush()
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":     fmt.Sprintf("%d claim codes retrieved successfully.", len(codes)),
+		"claim_codes": codes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// StoreBatch generates the claim codes of a batch of devices.
+// eg: POST /api/claims/batches {"batch": "2024-06-lot-b", "network_type": "LoRa", "device_ids": ["02DF9902", "02DF9903"]}
+func (h *ClaimHandler) StoreBatch(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to manage claim codes
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		Batch       string   `json:"batch"`
+		NetworkType string   `json:"network_type"`
+		DeviceIDs   []string `json:"device_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	payload.Batch = strings.TrimSpace(payload.Batch)
+	if payload.Batch == "" || len(payload.Batch) > 100 {
+		http.Error(w, "batch is required and must be at most 100 characters.", http.StatusBadRequest)
+		return
+	}
+
+	deviceIDs := []string{}
+	for _, deviceID := range payload.DeviceIDs {
+		if deviceID = strings.ToUpper(strings.TrimSpace(deviceID)); deviceID != "" {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+	if len(deviceIDs) == 0 {
+		http.Error(w, "device_ids must list at least one device.", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := app.Service.GenerateClaimCodes(payload.Batch, payload.NetworkType, deviceIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidNetworkType):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case strings.HasSuffix(err.Error(), "already has a claim code"):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			helpers.RespondWithError(w, err, "Failed to generate claim codes.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "CREATE", "claim_code", payload.Batch, r,
+		fmt.Sprintf("Generated %d claim codes for batch '%s'.", len(codes), payload.Batch))
+
+	response := map[string]interface{}{
+		"message":     fmt.Sprintf("%d claim codes generated successfully.", len(codes)),
+		"claim_codes": codes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Redeem registers the device of a claim code at the installer's location. Any user can redeem a code,
+// the code on the label being the proof that they installed the device.
+// eg: POST /api/claims {"code": "K7QM-3HXD-W9TP", "latitude": 35.8989, "longitude": 14.5146, "bay_id": 12}
+func (h *ClaimHandler) Redeem(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Code      string   `json:"code"`
+		Name      string   `json:"name"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+		BayID     *int     `json:"bay_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(payload.Code) == "" {
+		http.Error(w, "code is required.", http.StatusBadRequest)
+		return
+	}
+	if payload.Latitude == nil || payload.Longitude == nil {
+		http.Error(w, "latitude and longitude are required.", http.StatusBadRequest)
+		return
+	}
+	if *payload.Latitude < -90 || *payload.Latitude > 90 || *payload.Longitude < -180 || *payload.Longitude > 180 {
+		http.Error(w, "latitude or longitude is out of range.", http.StatusBadRequest)
+		return
+	}
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name != "" && len(payload.Name) < 3 {
+		http.Error(w, "name must be at least 3 characters long.", http.StatusBadRequest)
+		return
+	}
+
+	result, err := app.Service.RedeemClaim(services.Claim{
+		Code:      payload.Code,
+		Name:      payload.Name,
+		Latitude:  *payload.Latitude,
+		Longitude: *payload.Longitude,
+		BayID:     payload.BayID,
+	}, userData.UserID)
+	if err != nil && result == nil {
+		switch {
+		case errors.Is(err, services.ErrClaimNotFound):
+			http.Error(w, "Unknown claim code.", http.StatusNotFound)
+		case errors.Is(err, services.ErrBayNotFound):
+			http.Error(w, fmt.Sprintf("Bay with ID %d not found.", *payload.BayID), http.StatusNotFound)
+		case errors.Is(err, services.ErrClaimRedeemed), errors.Is(err, models.ErrBayHasDevice):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			helpers.RespondWithError(w, err, "Failed to redeem claim code.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	deviceID := result.Device.DeviceID
+	details := fmt.Sprintf("Claimed device %s (%s) of batch '%s' with code %s at %v,%v.",
+		deviceID, result.Device.NetworkType, result.Claim.Batch, result.Claim.Code, result.Device.Latitude, result.Device.Longitude)
+	if result.Assignment != nil {
+		details += fmt.Sprintf(" Assigned to bay %d.", result.Assignment.BayID)
+	}
+	app.PushAuditToCache(*userData, "CLAIM", "device", deviceID, r, details)
+
+	message := fmt.Sprintf("Device %s claimed successfully.", deviceID)
+	if err != nil {
+		// The device was registered, only its bay assignment failed.
+		helpers.LogError(err, fmt.Sprintf("Failed to assign claimed device %s to bay %d", deviceID, *payload.BayID))
+		message = fmt.Sprintf("Device %s claimed successfully, but it could not be assigned to the bay: %s.", deviceID, err)
+	}
+
+	response := map[string]interface{}{
+		"message":    message,
+		"claim":      result.Claim,
+		"device":     result.Device,
+		"assignment": result.Assignment,
+		"registered": result.Registered,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Destroy revokes a claim code that has not been redeemed.
+// eg: DELETE /api/claims/K7QM-3HXD-W9TP
+func (h *ClaimHandler) Destroy(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to manage claim codes
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	code := models.NormalizeClaimCode(chi.URLParam(r, "code"))
+
+	err = app.Models.ClaimCode.DeleteByCode(code)
+	if err != nil {
+		switch err.Error() {
+		case "claim code not found":
+			http.Error(w, "Unknown claim code.", http.StatusNotFound)
+		case "claim code already redeemed":
+			http.Error(w, "The claim code was already redeemed and cannot be revoked.", http.StatusConflict)
+		default:
+			helpers.RespondWithError(w, err, "Failed to revoke claim code.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "DELETE", "claim_code", code, r, fmt.Sprintf("Revoked claim code %s.", code))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Claim code %s successfully revoked.", code),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
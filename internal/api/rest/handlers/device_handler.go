@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
 	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
@@ -25,10 +28,163 @@ func CreateDeviceMap(devices []*models.Device) map[string]*models.Device {
 	return deviceMap
 }
 
+// maxDevicesLimit is the largest page of the devices endpoint.
+const maxDevicesLimit = 1000
+
+// deviceFilterFromRequest parses the search, filter, sort and page parameters of the devices endpoint.
+func deviceFilterFromRequest(r *http.Request) (models.DeviceFilter, error) {
+	query := r.URL.Query()
+	filter := models.DeviceFilter{
+		Search: strings.TrimSpace(query.Get("q")),
+		SortBy: query.Get("sort"),
+	}
+	var err error
+
+	// Tags are filtered with tag.<key>=<value>[,<value>...] and has_tag=<key>[,<key>...]
+	for name, values := range query {
+		key, ok := strings.CutPrefix(name, "tag.")
+		if !ok {
+			continue
+		}
+		if !models.TagKeyPattern.MatchString(key) {
+			return filter, fmt.Errorf("Invalid tag key '%s'.", key)
+		}
+		if filter.Tags == nil {
+			filter.Tags = map[string][]string{}
+		}
+		for value := range splitQueryList(strings.Join(values, ",")) {
+			filter.Tags[key] = append(filter.Tags[key], value)
+		}
+		if len(filter.Tags[key]) == 0 {
+			return filter, fmt.Errorf("Missing value of tag.%s.", key)
+		}
+	}
+	for key := range splitQueryList(query.Get("has_tag")) {
+		if !models.TagKeyPattern.MatchString(key) {
+			return filter, fmt.Errorf("Invalid tag key '%s'.", key)
+		}
+		filter.HasTags = append(filter.HasTags, key)
+	}
+
+	for networkType := range splitQueryList(query.Get("network_type")) {
+		filter.NetworkTypes = append(filter.NetworkTypes, networkType)
+	}
+	for state := range splitQueryList(query.Get("state")) {
+		if _, ok := models.LifecycleStates[state]; !ok {
+			return filter, fmt.Errorf("Invalid state '%s'.", state)
+		}
+		filter.States = append(filter.States, state)
+	}
+	for value := range splitQueryList(query.Get("firmware_version")) {
+		version, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid firmware_version '%s'.", value)
+		}
+		filter.FirmwareVersions = append(filter.FirmwareVersions, version)
+	}
+
+	if filter.Occupied, err = parseOptionalBool(r, "occupied"); err != nil {
+		return filter, err
+	}
+	if filter.Hidden, err = parseOptionalBool(r, "hidden"); err != nil {
+		return filter, err
+	}
+	if filter.Blocked, err = parseOptionalBool(r, "blocked"); err != nil {
+		return filter, err
+	}
+	if filter.Allowed, err = parseOptionalBool(r, "allowed"); err != nil {
+		return filter, err
+	}
+
+	// Keepalive ages are in seconds
+	now := time.Now().UTC()
+	olderThan, err := parseOptionalInt(r, "keepalive_older_than")
+	if err != nil {
+		return filter, err
+	}
+	if olderThan > 0 {
+		filter.KeepaliveBefore = now.Add(-time.Duration(olderThan) * time.Second)
+	}
+	within, err := parseOptionalInt(r, "keepalive_within")
+	if err != nil {
+		return filter, err
+	}
+	if within > 0 {
+		filter.KeepaliveAfter = now.Add(-time.Duration(within) * time.Second)
+	}
+
+	if filter.SortBy != "" {
+		if _, ok := models.DeviceSortColumns[filter.SortBy]; !ok {
+			return filter, fmt.Errorf("Invalid sort '%s'. Must be created_at, updated_at, happened_at, keepalive_at or settings_at.", filter.SortBy)
+		}
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, errors.New("Invalid order. Must be 'asc' or 'desc'.")
+	}
+
+	limit, err := parseOptionalInt(r, "limit")
+	if err != nil {
+		return filter, err
+	}
+	filter.Limit = int(min(limit, maxDevicesLimit))
+
+	if value := query.Get("cursor"); value != "" {
+		if filter.After, err = models.DecodeDeviceCursor(value); err != nil {
+			return filter, errors.New("Invalid cursor.")
+		}
+		if filter.SortBy == "" {
+			filter.SortBy = "created_at"
+		}
+		if filter.After.SortBy != filter.SortBy || filter.After.Descending != filter.Descending {
+			return filter, errors.New("The cursor belongs to a differently sorted search; pass the same sort and order.")
+		}
+	}
+
+	return filter, nil
+}
+
+// Index returns the devices that match the query, sorted and optionally paged.
+// Without limit every matching device is returned. When a page is full, next_cursor is set; pass it
+// as cursor, with the same filters and sort, to fetch the next page.
+// With map=true the devices are returned keyed by device ID.
+// eg: GET /api/device?q=level 2&network_type=lora,nb-iot&occupied=false&keepalive_older_than=3600&sort=keepalive_at&order=desc&limit=50
+// eg: GET /api/device?zone=3&blocked=false&limit=50&cursor=eyJzIjoiY3JlYXRlZF9hdCIs...
+// eg: GET /api/device?tag.installer=acme&tag.mounting_type=surface,flush&has_tag=asset_number
 func (h *DeviceHandler) Index(w http.ResponseWriter, r *http.Request) {
 
+	filter, err := deviceFilterFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Zones include the devices of the zones nested in them
+	if zone := r.URL.Query().Get("zone"); zone != "" {
+		zoneID, err := strconv.Atoi(zone)
+		if err != nil || zoneID <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid zone '%s'.", zone), http.StatusBadRequest)
+			return
+		}
+		if _, err := app.Models.Zone.GetByID(zoneID); err != nil {
+			if err.Error() == "zone not found" {
+				http.Error(w, fmt.Sprintf("Zone '%s' not found.", zone), http.StatusNotFound)
+				return
+			}
+			helpers.RespondWithError(w, err, "Failed to retrieve zone", http.StatusInternalServerError)
+			return
+		}
+		if filter.DeviceIDs, err = app.Models.Zone.DeviceIDs(zoneID); err != nil {
+			helpers.RespondWithError(w, err, "Failed to retrieve the devices of the zone", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Retrieve devices from the database
-	devices, err := app.Models.Device.GetAll()
+	devices, next, err := app.Models.Device.Search(filter)
 	if err != nil {
 		helpers.RespondWithError(w, err, "Failed to retrieve devices", http.StatusInternalServerError)
 		return
@@ -57,6 +213,15 @@ func (h *DeviceHandler) Index(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Paging is only reported when asked for, leaving the unpaged response as it was
+	if filter.Limit > 0 {
+		response["limit"] = filter.Limit
+		response["next_cursor"] = nil
+		if next != nil {
+			response["next_cursor"] = next.Encode()
+		}
+	}
+
 	// Set the response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
@@ -82,15 +247,16 @@ func (h *DeviceHandler) Store(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var payload struct {
-		DeviceID        string  `json:"device_id"`
-		Name            string  `json:"name"`
-		NetworkType     string  `json:"network_type"`
-		FirmwareVersion float64 `json:"firmware_version"`
-		Latitude        float64 `json:"latitude"`
-		Longitude       float64 `json:"longitude"`
-		IsAllowed       bool    `json:"is_allowed"`
-		IsBlocked       bool    `json:"is_blocked"`
-		IsHidden        bool    `json:"is_hidden"`
+		DeviceID        string            `json:"device_id"`
+		Name            string            `json:"name"`
+		NetworkType     string            `json:"network_type"`
+		FirmwareVersion float64           `json:"firmware_version"`
+		Latitude        float64           `json:"latitude"`
+		Longitude       float64           `json:"longitude"`
+		IsAllowed       bool              `json:"is_allowed"`
+		IsBlocked       bool              `json:"is_blocked"`
+		IsHidden        bool              `json:"is_hidden"`
+		Tags            models.DeviceTags `json:"tags"`
 	}
 
 	// Decode the JSON body into the payload struct
@@ -112,6 +278,11 @@ func (h *DeviceHandler) Store(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if problems, err := app.Service.ValidateTags(payload.Tags); err != nil {
+		respondWithTagProblems(w, problems, err)
+		return
+	}
+
 	newDevice := models.Device{
 		DeviceID:        strings.ToUpper(payload.DeviceID),
 		NetworkType:     payload.NetworkType,
@@ -122,6 +293,7 @@ func (h *DeviceHandler) Store(w http.ResponseWriter, r *http.Request) {
 		IsAllowed:       payload.IsAllowed,
 		IsBlocked:       payload.IsBlocked,
 		IsHidden:        payload.IsHidden,
+		Tags:            payload.Tags,
 	}
 
 	// Call the Create method on the Device model (example uses hardcoded device ID)
@@ -144,6 +316,8 @@ func (h *DeviceHandler) Store(w http.ResponseWriter, r *http.Request) {
 		helpers.LogError(err, "Failed to add device ID to the Bloom Filter")
 	}
 
+	app.Service.RebuildSpatialIndex()
+
 	// Set the response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
@@ -204,6 +378,28 @@ func (h *DeviceHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The lifecycle state only changes through its own endpoints, which enforce the transitions
+	for _, field := range []string{"lifecycle_state", "previous_state", "deleted_at"} {
+		if _, ok := updatedFields[field]; ok {
+			http.Error(w, fmt.Sprintf("%s cannot be updated. Use PUT /api/device/%s/state instead.", field, id), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Tags are replaced as a whole and must match the tag definitions
+	if value, ok := updatedFields["tags"]; ok {
+		tags, ok := value.(map[string]interface{})
+		if !ok {
+			http.Error(w, "Tags must be an object.", http.StatusBadRequest)
+			return
+		}
+		if problems, err := app.Service.ValidateTags(tags); err != nil {
+			respondWithTagProblems(w, problems, err)
+			return
+		}
+		updatedFields["tags"] = models.DeviceTags(tags)
+	}
+
 	// Attempt to update the device
 	device, err := app.Models.Device.UpdateByID(id, updatedFields)
 	if err != nil {
@@ -211,6 +407,8 @@ func (h *DeviceHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.Service.RebuildSpatialIndex()
+
 	app.PushAuditToCache(*userData, "UPDATE", "device", id, r, fmt.Sprintf("Updated device with ID %s.", id))
 
 	// Response structure with a success message and user data
@@ -251,7 +449,7 @@ func (h *DeviceHandler) Destroy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Attempt to soft delete the device
-	err = app.Models.Device.SoftDeleteByID(id)
+	_, err = app.Service.ChangeDeviceState(id, models.StateDeleted)
 	if err != nil {
 		helpers.LogError(err, fmt.Sprintf("Failed to soft delete device %s", id))
 		if err.Error() == "device not found" {
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/devicefile"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/realtime"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+)
+
+// maxImportSize is the largest device file accepted by an import.
+const maxImportSize = 10 << 20
+
+// importFormat returns the format of an uploaded file, from the format parameter or the file extension.
+func importFormat(r *http.Request, filename string) (string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+		if format == "json" {
+			format = "geojson"
+		}
+	}
+	if _, ok := devicefile.Formats[format]; !ok {
+		return "", fmt.Errorf("Invalid format '%s'. Must be csv, xlsx or geojson.", format)
+	}
+	return format, nil
+}
+
+// Import validates a CSV, XLSX or GeoJSON file of devices and, unless dry_run is set, upserts its devices.
+// The file is sent as the `file` field of a multipart form. Nothing is imported while the file has issues.
+// eg: POST /api/device/import?dry_run=true
+func (h *DeviceHandler) Import(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to create devices
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid dry_run. Must be true or false.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("A device file of up to %d MB is required in the 'file' field.", maxImportSize>>20), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	format, err := importFormat(r, header.Filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := devicefile.Read(format, file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read '%s': %s.", header.Filename, err), http.StatusBadRequest)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, fmt.Sprintf("'%s' has no devices.", header.Filename), http.StatusBadRequest)
+		return
+	}
+
+	report, err := app.Service.ImportDevices(records, dryRun)
+	if err != nil && !errors.Is(err, services.ErrImportInvalid) {
+		helpers.RespondWithError(w, err, "Failed to import devices.", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	message := fmt.Sprintf("%d devices validated, %d issues found.", report.Rows, len(report.Issues))
+	switch {
+	case err != nil:
+		status = http.StatusUnprocessableEntity
+		message = fmt.Sprintf("No devices were imported, '%s' has %d issues.", header.Filename, len(report.Issues))
+	case report.Applied:
+		message = fmt.Sprintf("%d devices imported successfully.", report.Rows)
+
+		app.PushAuditToCache(*userData, "IMPORT", "device", "", r,
+			fmt.Sprintf("Imported %d devices from '%s': %d created, %d updated, %d restored.",
+				report.Rows, header.Filename, report.Created, report.Updated, report.Restored))
+	}
+
+	response := map[string]interface{}{
+		"message": message,
+		"report":  report,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Export downloads the devices as a file that can be edited and imported again.
+// eg: GET /api/device/export?format=xlsx
+func (h *DeviceHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "csv"
+	}
+	if _, ok := devicefile.Formats[format]; !ok {
+		http.Error(w, fmt.Sprintf("Invalid format '%s'. Must be csv, xlsx or geojson.", format), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := app.Models.Device.GetAll()
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve devices", http.StatusInternalServerError)
+		return
+	}
+
+	session := realtime.Session{Claims: userData}
+	if !session.CanSeeHiddenDevices() {
+		visible := []*models.Device{}
+		for _, device := range devices {
+			if !device.IsHidden {
+				visible = append(visible, device)
+			}
+		}
+		devices = visible
+	}
+
+	// Render before writing, so that a failure can still be reported with a status.
+	var body bytes.Buffer
+	if err := devicefile.Write(format, &body, devices); err != nil {
+		helpers.RespondWithError(w, err, "Failed to export devices.", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("devices-%s%s", time.Now().UTC().Format("20060102"), devicefile.Formats[format])
+	w.Header().Set("Content-Type", devicefile.ContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// State moves a device to another lifecycle state: provisioned, active, maintenance, decommissioned or deleted.
+// eg: PUT /api/device/02DF9902/state {"state": "maintenance"}
+func (h *DeviceHandler) State(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to update a device
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	if _, ok := models.LifecycleStates[payload.State]; !ok {
+		http.Error(w, fmt.Sprintf("Invalid state '%s'.", payload.State), http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	device, err := app.Service.ChangeDeviceState(id, payload.State)
+	if err != nil {
+		switch {
+		case err.Error() == "device not found":
+			http.Error(w, fmt.Sprintf("Device with ID %s not found.", id), http.StatusNotFound)
+		case errors.Is(err, models.ErrInvalidTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			helpers.RespondWithError(w, err, "Failed to change device state.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "UPDATE", "device", id, r, fmt.Sprintf("Moved device with ID %s to the %s state.", id, payload.State))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Device %s is now %s.", id, payload.State),
+		"device":  device,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Restore brings back a soft-deleted device in the state it was deleted from.
+// eg: POST /api/device/02DF9902/restore
+func (h *DeviceHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has sufficient permission to restore a device
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	device, err := app.Service.RestoreDevice(id)
+	if err != nil {
+		switch {
+		case err.Error() == "device not found":
+			http.Error(w, fmt.Sprintf("Device with ID %s not found.", id), http.StatusNotFound)
+		case errors.Is(err, models.ErrInvalidTransition):
+			http.Error(w, fmt.Sprintf("Device %s is not deleted.", id), http.StatusConflict)
+		default:
+			helpers.RespondWithError(w, err, "Failed to restore device.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "RESTORE", "device", id, r,
+		fmt.Sprintf("Restored device with ID %s in the %s state.", id, device.LifecycleState))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Device %s restored successfully.", id),
+		"device":  device,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Purge permanently deletes a soft-deleted device together with its logs, once its retention period
+// has elapsed. Root users can purge it straight away with force=true.
+// eg: DELETE /api/device/02DF9902/purge?force=true
+func (h *DeviceHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has sufficient permission to purge a device
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	if force && userData.AccessLevel > 0 {
+		http.Error(w, "Only root users can purge a device before its retention period has elapsed.", http.StatusForbidden)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	err = app.Service.PurgeDevice(id, force)
+	if err != nil {
+		switch {
+		case err.Error() == "device not found":
+			http.Error(w, fmt.Sprintf("Device with ID %s not found.", id), http.StatusNotFound)
+		case errors.Is(err, services.ErrDeviceNotDeleted):
+			http.Error(w, fmt.Sprintf("Device %s must be deleted before it is purged.", id), http.StatusConflict)
+		case errors.Is(err, services.ErrRetentionNotElapsed):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			helpers.RespondWithError(w, err, "Failed to purge device.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "PURGE", "device", id, r, fmt.Sprintf("Purged device with ID %s and its logs.", id))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Device %s purged successfully.", id),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Deleted lists the soft-deleted devices with the time each one is purged at.
+// eg: GET /api/device/deleted
+func (h *DeviceHandler) Deleted(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to review deleted devices
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	devices, err := app.Service.DeletedDevices()
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve deleted devices.", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":        fmt.Sprintf("%d deleted devices retrieved successfully.", len(devices)),
+		"retention_days": int(app.Service.DeviceRetention() / (24 * time.Hour)),
+		"devices":        devices,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
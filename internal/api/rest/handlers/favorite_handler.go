@@ -45,6 +45,11 @@ func (h *FavoriteHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Live device events are matched against the favorites cached by the broadcaster
+	if app.Broadcaster != nil {
+		app.Broadcaster.FavoritesChanged(userData.UserID)
+	}
+
 	// Respond with success
 	response := map[string]interface{}{
 		"message":    "Favorites updated successfully.",
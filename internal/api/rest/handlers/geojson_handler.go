@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/realtime"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// GeoJSONHandler serves the devices as GeoJSON feeds that GIS tools such as QGIS and ArcGIS load directly.
+type GeoJSONHandler struct{}
+
+// etagMatches reports whether the If-None-Match header lists the ETag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveDeviceFeatures writes the FeatureCollection of a zone's devices, or 304 Not Modified when
+// the client already has it.
+func serveDeviceFeatures(w http.ResponseWriter, r *http.Request, zone string) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+	session := realtime.Session{Claims: userData}
+
+	q := services.GeoJSONQuery{Zone: zone, ShowHidden: session.CanSeeHiddenDevices()}
+
+	since, err := parseOptionalInt(r, "since")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if since > 0 {
+		q.Since = time.Unix(since, 0).UTC()
+	}
+
+	collection, err := app.Service.DeviceFeatures(q)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownZone) {
+			http.Error(w, fmt.Sprintf("Zone '%s' not found.", zone), http.StatusNotFound)
+			return
+		}
+		helpers.RespondWithError(w, err, "Failed to build the device feed.", http.StatusInternalServerError)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(collection); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+
+	// The ETag is the hash of the feed, so it changes with any feature and differs between
+	// users who may and may not see hidden devices.
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Write(body.Bytes())
+}
+
+// Devices returns the devices as a FeatureCollection, all of them or those of a zone.
+// With since, only the devices updated since then are returned, with the IDs of the deleted ones.
+// eg: GET /api/device.geojson?zone=3&since=1707803200&api_key=...
+func (h *GeoJSONHandler) Devices(w http.ResponseWriter, r *http.Request) {
+	zone := r.URL.Query().Get("zone")
+	if zone == "" {
+		zone = "all"
+	}
+	serveDeviceFeatures(w, r, zone)
+}
+
+// ZoneDevices returns the devices of a zone, including those of the zones nested in it, as a FeatureCollection.
+// eg: GET /api/zones/3/device.geojson
+func (h *GeoJSONHandler) ZoneDevices(w http.ResponseWriter, r *http.Request) {
+	serveDeviceFeatures(w, r, chi.URLParam(r, "id"))
+}
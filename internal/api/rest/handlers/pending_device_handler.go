@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// PendingDeviceHandler manages the devices waiting for registration.
+type PendingDeviceHandler struct{}
+
+// pendingDevicePayload is the body of the update and approve requests, every field being optional.
+type pendingDevicePayload struct {
+	Name      *string  `json:"name"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+// validate trims the name and checks the name and location.
+func (p *pendingDevicePayload) validate() error {
+	if p.Name != nil {
+		name := strings.TrimSpace(*p.Name)
+		if len(name) < 3 {
+			return errors.New("name must be at least 3 characters long")
+		}
+		p.Name = &name
+	}
+	if p.Latitude != nil && (*p.Latitude < -90 || *p.Latitude > 90) {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if p.Longitude != nil && (*p.Longitude < -180 || *p.Longitude > 180) {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// holdUnregistered holds the message of a device that is waiting for registration, or ignores it when
+// the device was rejected, and answers the request. It returns false when the message should be processed.
+func holdUnregistered(w http.ResponseWriter, r *http.Request, networkType, deviceID string, firmwareVersion float64, body any) bool {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		helpers.LogError(helpers.WrapError(err), fmt.Sprintf("Failed to encode message of device %s (%s)", deviceID, networkType))
+		return false
+	}
+
+	msg := events.HeldMessage{ReceivedAt: time.Now().UTC(), Payload: string(payload), URL: r.URL.RequestURI()}
+	response := map[string]interface{}{}
+	switch app.Service.HoldTraffic(networkType, deviceID, firmwareVersion, msg) {
+	case models.PendingStatusPending:
+		response["status"] = "held"
+		response["message"] = fmt.Sprintf("Device %s is waiting for registration. Message held.", deviceID)
+	case models.PendingStatusRejected:
+		response["status"] = "ignored"
+		response["message"] = fmt.Sprintf("Device %s was rejected. Request ignored.", deviceID)
+	default:
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+	return true
+}
+
+// replayResponse records the status of a replayed request, discarding its body.
+type replayResponse struct {
+	header http.Header
+	status int
+}
+
+func (rr *replayResponse) Header() http.Header         { return rr.header }
+func (rr *replayResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (rr *replayResponse) WriteHeader(status int)      { rr.status = status }
+
+// ReplaySubscriber processes the LoRa and SigFox messages held for a device once it is registered,
+// posting them again to the handler they were received by. Messages overtaken by newer traffic of
+// the device are skipped.
+func ReplaySubscriber() events.Handler {
+	loraHandler := &LoraHandler{}
+	sigfoxHandler := &SigfoxHandler{}
+
+	return func(event events.Event) {
+		e, ok := event.(events.TrafficReleased)
+		if !ok {
+			return
+		}
+
+		var handle http.HandlerFunc
+		switch e.NetworkType {
+		case "LoRa":
+			handle = loraHandler.UpChirpstack
+		case "SigFox":
+			handle = sigfoxHandler.Up
+		default:
+			return
+		}
+		defer app.Service.HeldTrafficReplayed(e)
+
+		for _, msg := range e.Messages {
+			if app.Service.StaleHeldMessage(e.DeviceID, msg) {
+				helpers.LogInfo("Skipped held message of device %s received at %s, newer traffic was processed", e.DeviceID, msg.ReceivedAt.Format(time.RFC3339))
+				continue
+			}
+			req, err := http.NewRequest(http.MethodPost, msg.URL, strings.NewReader(msg.Payload))
+			if err != nil {
+				helpers.LogError(err, fmt.Sprintf("Failed to replay held message of device %s (%s)", e.DeviceID, e.NetworkType))
+				continue
+			}
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := &replayResponse{header: http.Header{}, status: http.StatusOK}
+			handle(rr, req)
+			if rr.status >= http.StatusBadRequest {
+				helpers.LogInfo("Held message of device %s (%s) was not processed, status %d", e.DeviceID, e.NetworkType, rr.status)
+			}
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// Index lists the devices waiting for registration.
+// eg: GET /api/pending-devices?status=pending
+func (h *PendingDeviceHandler) Index(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to review devices
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != models.PendingStatusPending && status != models.PendingStatusRejected {
+		http.Error(w, fmt.Sprintf("Invalid status '%s'. Must be pending or rejected.", status), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := app.Service.PendingDevices(status)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve pending devices.", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":         fmt.Sprintf("%d pending devices retrieved successfully.", len(devices)),
+		"policy":          app.Service.RegistrationPolicy(),
+		"pending_devices": devices,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Update sets the name and location the device will be registered with.
+// eg: PUT /api/pending-devices/02DF9902 {"name": "Bay 12", "latitude": 35.89, "longitude": 14.51}
+func (h *PendingDeviceHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to review devices
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload pendingDevicePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	if err := payload.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deviceID := chi.URLParam(r, "id")
+	device, err := app.Models.PendingDevice.GetByID(deviceID)
+	if err != nil {
+		if err.Error() == "pending device not found" {
+			http.Error(w, fmt.Sprintf("Device %s is not waiting for registration.", deviceID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to retrieve pending device.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if payload.Name != nil {
+		device.Name = *payload.Name
+	}
+	if payload.Latitude != nil {
+		device.Latitude = *payload.Latitude
+	}
+	if payload.Longitude != nil {
+		device.Longitude = *payload.Longitude
+	}
+	device.ReviewedBy = &userData.UserID
+
+	device, err = app.Models.PendingDevice.Update(device)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to update pending device.", http.StatusInternalServerError)
+		return
+	}
+
+	app.PushAuditToCache(*userData, "UPDATE", "pending_device", deviceID, r,
+		fmt.Sprintf("Set pending device %s to register as '%s' at %v,%v.", deviceID, device.Name, device.Latitude, device.Longitude))
+
+	response := map[string]interface{}{
+		"message":        "Pending device updated successfully.",
+		"pending_device": device,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Approve registers a pending or rejected device and releases the messages held for it.
+// The name and location are optional, falling back to those set on the pending device.
+// eg: POST /api/pending-devices/02DF9902/approve {"name": "Bay 12"}
+func (h *PendingDeviceHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to review devices
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload pendingDevicePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	if err := payload.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := ""
+	if payload.Name != nil {
+		name = *payload.Name
+	}
+
+	deviceID := chi.URLParam(r, "id")
+	device, err := app.Service.ApproveDevice(deviceID, name, payload.Latitude, payload.Longitude)
+	if err != nil {
+		if errors.Is(err, services.ErrDeviceNotPending) {
+			http.Error(w, fmt.Sprintf("Device %s is not waiting for registration.", deviceID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to approve device.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "APPROVE", "pending_device", deviceID, r,
+		fmt.Sprintf("Approved device %s (%s) as '%s'.", deviceID, device.NetworkType, device.Name))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Device %s approved successfully.", deviceID),
+		"device":  device,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Reject refuses a pending device. Its held messages are dropped and its further traffic ignored,
+// until it is approved after all.
+// eg: POST /api/pending-devices/02DF9902/reject
+func (h *PendingDeviceHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to review devices
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	deviceID := chi.URLParam(r, "id")
+	device, err := app.Service.RejectDevice(deviceID, userData.UserID)
+	if err != nil {
+		if errors.Is(err, services.ErrDeviceNotPending) {
+			http.Error(w, fmt.Sprintf("Device %s is not waiting for registration.", deviceID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to reject device.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "REJECT", "pending_device", deviceID, r,
+		fmt.Sprintf("Rejected device %s (%s).", deviceID, device.NetworkType))
+
+	response := map[string]interface{}{
+		"message":        fmt.Sprintf("Device %s rejected successfully.", deviceID),
+		"pending_device": device,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
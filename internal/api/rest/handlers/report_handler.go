@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/reports"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// Page sizes of the reports list.
+const (
+	defaultReportsLimit = 50
+	maxReportsLimit     = 500
+)
+
+// reportAccessLevel returns the highest access level allowed to generate and download a report type.
+// The audit trail holds user activity and is restricted to admins.
+func reportAccessLevel(reportType string) int {
+	if reportType == "audit_trail" {
+		return 1
+	}
+	return 2
+}
+
+// ReportHandler generates report files and serves them for download.
+type ReportHandler struct{}
+
+// reportFromRequest loads the report with the ID in the URL.
+func reportFromRequest(w http.ResponseWriter, r *http.Request) (*models.Report, bool) {
+	reportID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid report ID.", http.StatusBadRequest)
+		return nil, false
+	}
+
+	report, err := app.Models.Report.GetByID(reportID)
+	if err != nil {
+		if err.Error() == "report not found" {
+			http.Error(w, fmt.Sprintf("Report with ID %d not found.", reportID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to retrieve report.", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+
+	return report, true
+}
+
+// Types lists the report types and formats.
+func (h *ReportHandler) Types(w http.ResponseWriter, r *http.Request) {
+	formats := make([]string, 0, len(reports.Formats))
+	for format := range reports.Formats {
+		formats = append(formats, format)
+	}
+
+	response := map[string]interface{}{
+		"message": "Report types retrieved successfully.",
+		"types":   services.ReportTypes,
+		"formats": formats,
+		"periods": models.ReportPeriods,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Index lists the latest reports, newest first.
+// eg: GET /api/reports?limit=50&offset=0
+func (h *ReportHandler) Index(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseOptionalInt(r, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseOptionalInt(r, "offset")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if limit == 0 {
+		limit = defaultReportsLimit
+	}
+	if limit > maxReportsLimit {
+		limit = maxReportsLimit
+	}
+
+	reportList, err := app.Models.Report.GetRecent(int(limit), int(offset))
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve reports.", http.StatusInternalServerError)
+		return
+	}
+	if reportList == nil {
+		reportList = []*models.Report{}
+	}
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("%d reports retrieved successfully.", len(reportList)),
+		"reports": reportList,
+		"limit":   limit,
+		"offset":  offset,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Store generates a report in the background; poll the report until its status is ready.
+// eg: POST /api/reports {"report_type": "occupancy_summary", "format": "xlsx", "from_date": 1704067200, "to_date": 1706745600}
+func (h *ReportHandler) Store(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		ReportType string   `json:"report_type"`
+		Format     string   `json:"format"`
+		FromDate   int64    `json:"from_date"`
+		ToDate     int64    `json:"to_date"`
+		DeviceIDs  []string `json:"device_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	// Check if the user has permission to generate this report type
+	if userData.AccessLevel > reportAccessLevel(payload.ReportType) {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	userID := userData.UserID
+	report := &models.Report{
+		ReportType: payload.ReportType,
+		Format:     strings.ToLower(payload.Format),
+		FromDate:   time.Unix(payload.FromDate, 0).UTC(),
+		ToDate:     time.Unix(payload.ToDate, 0).UTC(),
+		DeviceIDs:  strings.Join(payload.DeviceIDs, ","),
+		UserID:     &userID,
+	}
+
+	if err := services.ValidateReport(report); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err = app.Service.RequestReport(report)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to create report.", http.StatusInternalServerError)
+		return
+	}
+
+	app.PushAuditToCache(*userData, "CREATE", "report", fmt.Sprintf("%d", report.ID), r,
+		fmt.Sprintf("Requested %s report in %s from %s to %s.", report.ReportType, report.Format, report.FromDate.Format(time.RFC3339), report.ToDate.Format(time.RFC3339)))
+
+	response := map[string]interface{}{
+		"message": "Report is being generated.",
+		"report":  report,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Get returns a single report, e.g. to poll its status.
+func (h *ReportHandler) Get(w http.ResponseWriter, r *http.Request) {
+	report, ok := reportFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Report retrieved successfully.",
+		"report":  report,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Download serves the file of a ready report.
+func (h *ReportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	report, ok := reportFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Check if the user has permission to download this report type
+	if userData.AccessLevel > reportAccessLevel(report.ReportType) {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	if report.Status != "ready" {
+		http.Error(w, fmt.Sprintf("Report with ID %d is %s.", report.ID, report.Status), http.StatusConflict)
+		return
+	}
+
+	content, err := app.Models.Report.GetFile(report.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrReportFileNotFound) {
+			http.Error(w, fmt.Sprintf("The file of report %d no longer exists.", report.ID), http.StatusGone)
+			return
+		}
+		helpers.RespondWithError(w, err, "Failed to retrieve report file.", http.StatusInternalServerError)
+		return
+	}
+
+	var modTime time.Time
+	if report.CompletedAt != nil {
+		modTime = *report.CompletedAt
+	}
+
+	w.Header().Set("Content-Type", reports.ContentTypes[report.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", report.FileName))
+	http.ServeContent(w, r, report.FileName, modTime, bytes.NewReader(content))
+}
+
+// Destroy deletes a report and its file.
+func (h *ReportHandler) Destroy(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to delete a report
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	report, ok := reportFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.Service.DeleteReport(report); err != nil {
+		helpers.RespondWithError(w, err, "Failed to delete report.", http.StatusInternalServerError)
+		return
+	}
+
+	app.PushAuditToCache(*userData, "DELETE", "report", fmt.Sprintf("%d", report.ID), r, fmt.Sprintf("Deleted %s report with ID %d.", report.ReportType, report.ID))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Report with ID %d successfully deleted.", report.ID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// ReportScheduleHandler manages the schedules that generate reports with the cron scheduler.
+type ReportScheduleHandler struct{}
+
+// reportSchedulePayload is the body of the create and update requests.
+type reportSchedulePayload struct {
+	Name       string   `json:"name"`
+	ReportType string   `json:"report_type"`
+	Format     string   `json:"format"`
+	CronSpec   string   `json:"cron_spec"` // With seconds, e.g. "0 0 6 1 * *"
+	Period     string   `json:"period"`
+	DeviceIDs  []string `json:"device_ids"`
+	IsEnabled  *bool    `json:"is_enabled"`
+}
+
+// toReportSchedule converts the payload into a validated schedule.
+func (p reportSchedulePayload) toReportSchedule() (*models.ReportSchedule, error) {
+	schedule := &models.ReportSchedule{
+		Name:       strings.TrimSpace(p.Name),
+		ReportType: p.ReportType,
+		Format:     strings.ToLower(p.Format),
+		CronSpec:   strings.TrimSpace(p.CronSpec),
+		Period:     p.Period,
+		DeviceIDs:  strings.Join(p.DeviceIDs, ","),
+		IsEnabled:  true,
+	}
+	if p.IsEnabled != nil {
+		schedule.IsEnabled = *p.IsEnabled
+	}
+
+	return schedule, services.ValidateReportSchedule(schedule)
+}
+
+func (h *ReportScheduleHandler) Index(w http.ResponseWriter, r *http.Request) {
+	schedules, err := app.Models.ReportSchedule.GetAll()
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve report schedules.", http.StatusInternalServerError)
+		return
+	}
+	if schedules == nil {
+		schedules = []*models.ReportSchedule{}
+	}
+
+	response := map[string]interface{}{
+		"message":          fmt.Sprintf("%d report schedules retrieved successfully.", len(schedules)),
+		"report_schedules": schedules,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func (h *ReportScheduleHandler) Store(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to create a report schedule
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload reportSchedulePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := payload.toReportSchedule()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule, err = app.Models.ReportSchedule.Create(schedule)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to create report schedule.", http.StatusInternalServerError)
+		return
+	}
+	app.Service.SyncReportSchedules()
+
+	app.PushAuditToCache(*userData, "CREATE", "report_schedule", fmt.Sprintf("%d", schedule.ID), r,
+		fmt.Sprintf("Created report schedule '%s' for %s reports at '%s'.", schedule.Name, schedule.ReportType, schedule.CronSpec))
+
+	response := map[string]interface{}{
+		"message":         "Report schedule created successfully.",
+		"report_schedule": schedule,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func (h *ReportScheduleHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to update a report schedule
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	scheduleID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid report schedule ID.", http.StatusBadRequest)
+		return
+	}
+
+	var payload reportSchedulePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := payload.toReportSchedule()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	schedule.ID = scheduleID
+
+	schedule, err = app.Models.ReportSchedule.Update(schedule)
+	if err != nil {
+		if err.Error() == "report schedule not found" {
+			http.Error(w, fmt.Sprintf("Report schedule with ID %d not found.", scheduleID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to update report schedule.", http.StatusInternalServerError)
+		}
+		return
+	}
+	app.Service.SyncReportSchedules()
+
+	app.PushAuditToCache(*userData, "UPDATE", "report_schedule", fmt.Sprintf("%d", scheduleID), r, fmt.Sprintf("Updated report schedule '%s'.", schedule.Name))
+
+	response := map[string]interface{}{
+		"message":         "Report schedule updated successfully.",
+		"report_schedule": schedule,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func (h *ReportScheduleHandler) Destroy(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to delete a report schedule
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	scheduleID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid report schedule ID.", http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.ReportSchedule.DeleteByID(scheduleID)
+	if err != nil {
+		if err.Error() == "report schedule not found" {
+			http.Error(w, fmt.Sprintf("Report schedule with ID %d not found.", scheduleID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to delete report schedule.", http.StatusInternalServerError)
+		}
+		return
+	}
+	app.Service.SyncReportSchedules()
+
+	app.PushAuditToCache(*userData, "DELETE", "report_schedule", fmt.Sprintf("%d", scheduleID), r, fmt.Sprintf("Deleted report schedule with ID %d.", scheduleID))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Report schedule with ID %d successfully deleted.", scheduleID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// Page sizes of the sessions endpoint.
+const (
+	defaultSessionsLimit = 100
+	maxSessionsLimit     = 1000
+)
+
+// SessionHandler handles parking session requests.
+type SessionHandler struct{}
+
+// parseOptionalInt parses an optional non-negative integer query parameter.
+func parseOptionalInt(r *http.Request, name string) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid %s. Must be a non-negative integer.", name)
+	}
+	return n, nil
+}
+
+// Get returns the parking sessions that match the query filters.
+// eg: GET /api/sessions?device_id=02DF9902,02DF9903&from_date=1707803200&to_date=1707806000&status=closed&min_duration=600
+// eg: GET /api/sessions?bay_id=12&from_date=1707803200
+func (h *SessionHandler) Get(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := models.ParkingSessionFilter{
+		NetworkType: query.Get("network_type"),
+		Status:      query.Get("status"),
+		NewestFirst: query.Get("order") == "desc",
+	}
+
+	for deviceID := range splitQueryList(query.Get("device_id")) {
+		filter.DeviceIDs = append(filter.DeviceIDs, deviceID)
+	}
+
+	// Sessions of bays follow them across sensor replacements
+	for value := range splitQueryList(query.Get("bay_id")) {
+		bayID, err := strconv.Atoi(value)
+		if err != nil || bayID <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid bay_id '%s'.", value), http.StatusBadRequest)
+			return
+		}
+		filter.BayIDs = append(filter.BayIDs, bayID)
+	}
+
+	if filter.Status != "" && filter.Status != "open" && filter.Status != "closed" {
+		http.Error(w, "Invalid status. Must be 'open' or 'closed'.", http.StatusBadRequest)
+		return
+	}
+
+	// Parse the numeric filters
+	numbers := map[string]int64{}
+	for _, name := range []string{"from_date", "to_date", "min_duration", "max_duration", "limit", "offset"} {
+		n, err := parseOptionalInt(r, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		numbers[name] = n
+	}
+
+	if numbers["from_date"] > 0 {
+		filter.From = time.Unix(numbers["from_date"], 0).UTC()
+	}
+	if numbers["to_date"] > 0 {
+		filter.To = time.Unix(numbers["to_date"], 0).UTC()
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.From.After(filter.To) {
+		http.Error(w, "from_date cannot be greater than to_date", http.StatusBadRequest)
+		return
+	}
+
+	filter.MinDuration = numbers["min_duration"]
+	filter.MaxDuration = numbers["max_duration"]
+
+	filter.Limit = int(numbers["limit"])
+	if filter.Limit == 0 {
+		filter.Limit = defaultSessionsLimit
+	}
+	if filter.Limit > maxSessionsLimit {
+		filter.Limit = maxSessionsLimit
+	}
+	filter.Offset = int(numbers["offset"])
+
+	// Fetch parking sessions from storage
+	sessions, err := app.Models.ParkingSession.GetParkingSessions(filter)
+	if err != nil {
+		helpers.RespondWithError(w, helpers.WrapError(err), "Error fetching parking sessions", http.StatusInternalServerError)
+		return
+	}
+
+	// Determine the response message based on the number of sessions retrieved
+	var message string
+	if len(sessions) > 0 {
+		message = fmt.Sprintf("%d parking sessions retrieved successfully.", len(sessions))
+	} else {
+		message = "No parking sessions found for the given filters."
+		sessions = []*models.ParkingSession{}
+	}
+
+	// Response structure
+	response := map[string]interface{}{
+		"message":          message,
+		"parking_sessions": sessions,
+		"limit":            filter.Limit,
+		"offset":           filter.Offset,
+	}
+
+	// Encode and send the response
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
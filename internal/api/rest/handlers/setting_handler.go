@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/foxcodenine/iot-parking-gateway/internal/core"
 	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
 	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
 )
 
 type SettingHandler struct {
@@ -356,6 +358,89 @@ func (h *SettingHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	// -----------------------------------------------------------------
 
+	registrationPolicy, ok := updatedFields["device_registration_policy"]
+	if ok {
+		if userData.AccessLevel > 1 {
+			http.Error(w, "Permission denied.", http.StatusForbidden)
+			return
+		}
+
+		// Fetch the cached value
+		cachedVal, err := cache.AppCache.HGet("app:settings", "device_registration_policy")
+		if err != nil {
+			helpers.RespondWithError(w, err, "Failed to fetch cached setting value.", http.StatusInternalServerError)
+			return
+		}
+
+		// Check if the value is the same
+		if cachedPolicy, _ := cachedVal.(string); cachedPolicy != registrationPolicy {
+
+			// Validate that the string is one of the registration policies
+			if !slices.Contains(services.RegistrationPolicies, registrationPolicy) {
+				http.Error(w, "Invalid value for device_registration_policy. It must be 'auto', 'approval' or 'claim_code'.", http.StatusBadRequest)
+				return
+			}
+
+			val := map[string]interface{}{"val": registrationPolicy}
+
+			// Proceed to update the setting
+			_, err = app.Models.Setting.UpdateByKey("device_registration_policy", val)
+			if err != nil {
+				helpers.RespondWithError(w, err, "Failed to update settings.", http.StatusInternalServerError)
+				return
+			}
+
+			err = auditLogSettingUpdate(userData, r, "device_registration_policy", registrationPolicy)
+			if err != nil {
+				helpers.LogError(err, "Failed to create an audit log entry for updating the 'device_registration_policy' setting.")
+			}
+		}
+	}
+
+	// -----------------------------------------------------------------
+
+	retentionDays, ok := updatedFields["device_retention_days"]
+	if ok {
+		if userData.AccessLevel > 1 {
+			http.Error(w, "Permission denied.", http.StatusForbidden)
+			return
+		}
+
+		// Fetch the cached value
+		cachedVal, err := cache.AppCache.HGet("app:settings", "device_retention_days")
+		if err != nil {
+			helpers.RespondWithError(w, err, "Failed to fetch cached setting value.", http.StatusInternalServerError)
+			return
+		}
+
+		// Check if the value is the same
+		if cachedDays, _ := cachedVal.(string); cachedDays != retentionDays {
+
+			// Validate that the string represents a positive integer
+			intVal, err := strconv.Atoi(retentionDays)
+			if err != nil || intVal <= 0 {
+				http.Error(w, "Invalid value for device_retention_days. It must be a positive integer.", http.StatusBadRequest)
+				return
+			}
+
+			val := map[string]interface{}{"val": retentionDays}
+
+			// Proceed to update the setting
+			_, err = app.Models.Setting.UpdateByKey("device_retention_days", val)
+			if err != nil {
+				helpers.RespondWithError(w, err, "Failed to update settings.", http.StatusInternalServerError)
+				return
+			}
+
+			err = auditLogSettingUpdate(userData, r, "device_retention_days", retentionDays)
+			if err != nil {
+				helpers.LogError(err, "Failed to create an audit log entry for updating the 'device_retention_days' setting.")
+			}
+		}
+	}
+
+	// -----------------------------------------------------------------
+
 	google_map_id, ok := updatedFields["google_map_id"]
 	if ok {
 		if userData.AccessLevel > 0 {
@@ -9,10 +9,10 @@ import (
 	"time"
 
 	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
 	sigfoxfw "github.com/foxcodenine/iot-parking-gateway/internal/firmware/sigfox_fw"
 	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
 	"github.com/foxcodenine/iot-parking-gateway/internal/models"
-	"github.com/foxcodenine/iot-parking-gateway/internal/mq"
 
 	"github.com/google/uuid"
 )
@@ -98,16 +98,22 @@ func (h *SigfoxHandler) Up(w http.ResponseWriter, r *http.Request) {
 
 	// If the device ID is not registered, track it for registration and prevent duplicates.
 	if !isDeviceRegistered {
-		// Add the device to a Redis set for tracking devices that need registration.
-		deviceDataKey := fmt.Sprintf("%s %f", deviceIdentifierKey, firmwareVersion)
-		if err := cache.AppCache.SAdd("to-register-devices", deviceDataKey); err != nil {
-			helpers.LogError(helpers.WrapError(err), "Failed to add device ID to the 'to-register-devices' set (SigFox)")
-		}
+		// Announce the device so it is tracked for registration.
+		app.Bus.Publish(events.DeviceRegistered{
+			DeviceID:        deviceID,
+			NetworkType:     "SigFox",
+			FirmwareVersion: firmwareVersion,
+		})
 
 		// Add the device ID to the Bloom Filter to prevent duplicate registrations in the future.
 		if _, err := cache.AppCache.AddItemToBloomFilter("registered-devices", deviceIdentifierKey); err != nil {
 			helpers.LogError(helpers.WrapError(err), "Failed to add device ID to the 'registered-devices' Bloom Filter (SigFox)")
 		}
+
+		// Hold the message when the device has to be approved or claimed first.
+		if holdUnregistered(w, r, "SigFox", deviceID, firmwareVersion, req) {
+			return
+		}
 	}
 
 	// If the device ID is registered, check if it is soft delete, white listed or black listed.
@@ -120,6 +126,11 @@ func (h *SigfoxHandler) Up(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// A device that is not registered yet may be waiting for approval.
+		if deviceData == nil && holdUnregistered(w, r, "SigFox", deviceID, firmwareVersion, req) {
+			return
+		}
+
 		// Check if the device is soft deleted
 		if deletedAt, exists := deviceData["deleted_at"]; exists && deletedAt != nil && deletedAt != "0001-01-01T00:00:00Z" {
 			response := map[string]interface{}{
@@ -132,6 +143,18 @@ func (h *SigfoxHandler) Up(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Check if the lifecycle state of the device lets its traffic in
+		if state, ok := app.Service.AdmitTraffic("SigFox", deviceID, firmwareVersion, deviceData); !ok {
+			response := map[string]interface{}{
+				"status":  "ignored",
+				"message": fmt.Sprintf("Device %s is %s. Request ignored.", deviceID, state),
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusSeeOther)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
 		// Retrieve application settings for device access mode
 		deviceAccessMode, err := cache.AppCache.HGet("app:settings", "device_access_mode")
 		if err != nil {
@@ -220,104 +243,75 @@ func (h *SigfoxHandler) Up(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.updateDeviceCacheAndBroadcast(parsedData, deviceID)
+	// Attempt to update the device state in the cache.
+	occupancyUpdate, err := h.updateDeviceCache(parsedData, deviceID)
 
 	// Check for errors in the update process.
 	if err != nil {
 		// Log the error with additional context for better troubleshooting.
-		helpers.LogError(helpers.WrapError(err), "Failed to update device cache and broadcast changes (SigFox)")
+		helpers.LogError(helpers.WrapError(err), "Failed to update device cache (SigFox)")
 	}
 
-	// Attempt to update device keepalive_at in cache and broadcast the changes.
-	err = h.updateDeviceKeepaliveInCacheAndBroadcast(parsedData, deviceID)
+	// Attempt to update device keepalive_at in cache.
+	keepaliveUpdate, err := h.updateDeviceKeepaliveInCache(parsedData, deviceID)
 
 	// Check for errors in the update process.
 	if err != nil {
 		// Log the error with additional context for better troubleshooting.
-		helpers.LogError(helpers.WrapError(err), "Failed to update device keepalive_at in cache and broadcast changes (SigFox)")
+		helpers.LogError(helpers.WrapError(err), "Failed to update device keepalive_at in cache (SigFox)")
 	}
 
-	// Attempt to update device settings_at in cache, check if device_settings should be updated and broadcast settings_at.
-	updateDeviceSettings, err := h.updateDeviceSettingsInCacheAndBroadcast(parsedData, deviceID)
+	// Attempt to update device settings_at in cache and check if device_settings should be updated.
+	settingsUpdate, err := h.updateDeviceSettingsInCache(parsedData, deviceID)
 
 	// Check for errors in the update process.
 	if err != nil {
 		// Log the error with additional context for better troubleshooting.
-		helpers.LogError(helpers.WrapError(err), "Failed to update device settings_at in cache and broadcast it (SigFox)")
+		helpers.LogError(helpers.WrapError(err), "Failed to update device settings_at in cache (SigFox)")
 	}
+	updateDeviceSettings := settingsUpdate != nil
 
-	// Push parsed parking data packages to Redis.
-	for _, i := range parsedData["parking_packages"].([]map[string]any) {
-
+	// Add common fields to the parsed parking data packages.
+	parkingPackages := parsedData["parking_packages"].([]map[string]any)
+	for _, i := range parkingPackages {
 		i["firmware_version"] = parsedData["firmware_version"]
 		i["device_id"] = deviceID
 		i["raw_id"] = rawUUID
 		i["event_id"] = 26
 		i["network_type"] = "SigFox"
-
-		err := cache.AppCache.RPush("logs:activity-logs", i)
-		if err != nil {
-			helpers.LogError(err, "Failed to push parking package data log to Redis")
-		}
-
-		messageData, err := json.Marshal(i)
-		if err != nil {
-			helpers.LogError(err, "Failed to serialize parsedData to JSON")
-			continue
-		}
-		mq.AppRabbitMQProducer.SendMessageToExchange("event_logs", string(messageData))
 	}
 
-	// Push parsed keepalive data to Redis.
-	for _, i := range parsedData["keep_alive_packages"].([]map[string]any) {
+	// Add common fields to the parsed keepalive packages.
+	keepalivePackages := parsedData["keep_alive_packages"].([]map[string]any)
+	for _, i := range keepalivePackages {
 		i["firmware_version"] = parsedData["firmware_version"]
 		i["device_id"] = deviceID
 		i["raw_id"] = rawUUID
 		i["event_id"] = 6
 		i["network_type"] = "SigFox"
-
-		err := cache.AppCache.RPush("logs:sigfox-keepalive-logs", i)
-		if err != nil {
-			helpers.LogError(err, "Failed to push keepalive package data log to Redis")
-		}
-
-		messageData, err := json.Marshal(i)
-		if err != nil {
-			helpers.LogError(err, "Failed to serialize parsedData to JSON")
-			continue
-		}
-		mq.AppRabbitMQProducer.SendMessageToExchange("event_logs", string(messageData))
 	}
 
-	// Push parsed settings data to Redis.
-	for n, i := range parsedData["settings_packages"].([]map[string]any) {
+	// Add common fields to the parsed settings packages.
+	settingsPackages := parsedData["settings_packages"].([]map[string]any)
+	for n, i := range settingsPackages {
 		if n == 0 && updateDeviceSettings {
 			i["update_device_settings"] = true
 		} else {
 			i["update_device_settings"] = false
 		}
 
-		// Add common fields to each individual package
 		i["firmware_version"] = parsedData["firmware_version"]
 		i["device_id"] = deviceID
 		i["raw_id"] = rawUUID
 		i["event_id"] = 25 // Assuming 25 is the event ID for setting logs
 		i["network_type"] = "SigFox"
-
-		// Push the package to Redis
-		err := cache.AppCache.RPush("logs:sigfox-setting-logs", i)
-		if err != nil {
-			helpers.LogError(helpers.WrapError(err), "Failed to push setting package data log to Redis (SigFox)")
-		}
-
-		messageData, err := json.Marshal(i)
-		if err != nil {
-			helpers.LogError(helpers.WrapError(err), "Failed to serialize parsedData to JSON (SigFox)")
-			continue
-		}
-		mq.AppRabbitMQProducer.SendMessageToExchange("event_logs", string(messageData))
 	}
 
+	// Hand the packages to the subscribers (Redis logs, RabbitMQ, Socket.IO, ...).
+	app.Bus.Publish(events.OccupancyChanged{DeviceID: deviceID, NetworkType: "SigFox", Packages: parkingPackages, Update: occupancyUpdate})
+	app.Bus.Publish(events.KeepaliveReceived{DeviceID: deviceID, NetworkType: "SigFox", Packages: keepalivePackages, Update: keepaliveUpdate})
+	app.Bus.Publish(events.SettingsReported{DeviceID: deviceID, NetworkType: "SigFox", Packages: settingsPackages, Update: settingsUpdate})
+
 	// After all the updates and checks, send a success response to the client.
 	response := map[string]interface{}{
 		"status":  "success",
@@ -327,25 +321,25 @@ func (h *SigfoxHandler) Up(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// updateDeviceKeepaliveInCacheAndBroadcast updates the keepalive timestamp for a device in the cache and broadcasts changes.
-// If the new keepalive timestamp is more recent than the cached one, the cache and relevant logs are updated.
-func (h *SigfoxHandler) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[string]any, deviceID string) error {
+// updateDeviceKeepaliveInCache updates the keepalive timestamp for a device in the cache.
+// If the new keepalive timestamp is more recent than the cached one, the cache is updated and the change is returned.
+func (h *SigfoxHandler) updateDeviceKeepaliveInCache(parsedData map[string]any, deviceID string) (map[string]any, error) {
 
 	// Extract the list of keepalive packages from the parsed data.
 	keepalivePackages, ok := parsedData["keep_alive_packages"].([]map[string]any)
 	if !ok {
-		return errors.New("invalid or missing keep_alive_packages data")
+		return nil, errors.New("invalid or missing keep_alive_packages data")
 	}
 
 	// Return early if there are no keepalive packages.
 	if len(keepalivePackages) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Retrieve the timestamp from the first keepalive package.
 	timestamp, ok := keepalivePackages[0]["timestamp"].(int)
 	if !ok {
-		return errors.New("timestamp missing or not an integer in keepalive package")
+		return nil, errors.New("timestamp missing or not an integer in keepalive package")
 	}
 
 	// Convert the timestamp to a UTC time string.
@@ -356,7 +350,7 @@ func (h *SigfoxHandler) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[
 	cachedDevice, err := cache.AppCache.GetDevice(deviceID)
 	if err != nil {
 		helpers.LogError(err, "Error retrieving device from cache")
-		return err
+		return nil, err
 	}
 
 	var happenedAt string
@@ -371,27 +365,27 @@ func (h *SigfoxHandler) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[
 		}
 		happenedAt, ok = cachedDevice["happened_at"].(string)
 		if !ok {
-			return errors.New("cached happened_at is not a string")
+			return nil, errors.New("cached happened_at is not a string")
 		}
 		settingsAt, ok = cachedDevice["settings_at"].(string)
 		if !ok {
-			return errors.New("cached settings_at is not a string")
+			return nil, errors.New("cached settings_at is not a string")
 		}
 
 		cachedKeepaliveAt, err := time.Parse("2006-01-02T15:04:05Z", cachedKeepaliveAtStr)
 		if err != nil {
-			return fmt.Errorf("error parsing cached keepalive_at time: %v", err)
+			return nil, fmt.Errorf("error parsing cached keepalive_at time: %v", err)
 		}
 
 		newKeepaliveAt, err := time.Parse("2006-01-02T15:04:05Z", keepaliveAt)
 		if err != nil {
-			return fmt.Errorf("error parsing new keepalive_at time: %v", err)
+			return nil, fmt.Errorf("error parsing new keepalive_at time: %v", err)
 		}
 
 		// Update only if the new keepalive timestamp is more recent.
 		if !newKeepaliveAt.After(cachedKeepaliveAt) {
 			helpers.LogInfo("No update needed. Cached keepalive_at is newer or equal.")
-			return nil
+			return nil, nil
 		}
 
 	} else {
@@ -403,7 +397,7 @@ func (h *SigfoxHandler) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[
 	err = cache.AppCache.UpdateKeepaliveAt(deviceID, keepaliveAt, happenedAt, settingsAt)
 	if err != nil {
 		helpers.LogError(err, "Failed to update device keepalive timestamp in cache")
-		return err
+		return nil, err
 	}
 
 	// --- Log updates for PostgreSQL synchronization (e.g., logs:device-keepalive-at)
@@ -412,39 +406,29 @@ func (h *SigfoxHandler) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[
 		"keepalive_at": keepaliveAt,
 	}
 
-	// Push the log entry to Redis for PostgreSQL update processing.
-	err = cache.AppCache.RPush("logs:device-keepalive-at", logPayload)
-	if err != nil {
-		helpers.LogError(err, "Failed to push keepalive update log to Redis")
-	}
-
-	// Broadcast the update to clients using Socket.IO.
-	app.SocketIO.BroadcastToNamespace("/", "keepalive-event", logPayload)
-	helpers.LogInfo("Broadcasted keepalive event for device %s", deviceID)
-
-	return nil
+	return logPayload, nil
 }
 
-// updateDeviceSettingsInCacheAndBroadcast updates the settings timestamp for a device in the cache and broadcasts changes.
-// If the new settings timestamp is more recent than the cached one, the cache and relevant logs are updated.
-func (h *SigfoxHandler) updateDeviceSettingsInCacheAndBroadcast(parsedData map[string]any, deviceID string) (bool, error) {
+// updateDeviceSettingsInCache updates the settings timestamp for a device in the cache.
+// If the new settings timestamp is more recent than the cached one, the cache is updated and the change is returned.
+func (h *SigfoxHandler) updateDeviceSettingsInCache(parsedData map[string]any, deviceID string) (map[string]any, error) {
 	// Extract the list of settings packages from the parsed data.
 	settingsPackages, ok := parsedData["settings_packages"].([]map[string]any)
 
 	if !ok {
-		return false, errors.New("invalid or missing settings_packages data")
+		return nil, errors.New("invalid or missing settings_packages data")
 	}
 
 	// Return early if there are no settings packages.
 	if len(settingsPackages) == 0 {
-		return false, nil
+		return nil, nil
 	}
 
 	// Retrieve the timestamp from the first settings package.
 	firstSettingsPakage := settingsPackages[0]
 	timestamp, ok := firstSettingsPakage["timestamp"].(int)
 	if !ok {
-		return false, errors.New("timestamp missing or not an integer in settings package")
+		return nil, errors.New("timestamp missing or not an integer in settings package")
 	}
 
 	// Convert the timestamp to a UTC time string.
@@ -455,7 +439,7 @@ func (h *SigfoxHandler) updateDeviceSettingsInCacheAndBroadcast(parsedData map[s
 	cachedDevice, err := cache.AppCache.GetDevice(deviceID)
 	if err != nil {
 		helpers.LogError(err, "Error retrieving device from cache")
-		return false, err
+		return nil, err
 	}
 
 	var happenedAt string
@@ -471,27 +455,27 @@ func (h *SigfoxHandler) updateDeviceSettingsInCacheAndBroadcast(parsedData map[s
 		}
 		happenedAt, ok = cachedDevice["happened_at"].(string)
 		if !ok {
-			return false, errors.New("cached happened_at is not a string")
+			return nil, errors.New("cached happened_at is not a string")
 		}
 		keepaliveAt, ok = cachedDevice["keepalive_at"].(string)
 		if !ok {
-			return false, errors.New("cached keepalive_at is not a string")
+			return nil, errors.New("cached keepalive_at is not a string")
 		}
 
 		cachedSettingsAt, err := time.Parse("2006-01-02T15:04:05Z", cachedSettingsAtStr)
 		if err != nil {
-			return false, fmt.Errorf("error parsing cached settings_at time: %v", err)
+			return nil, fmt.Errorf("error parsing cached settings_at time: %v", err)
 		}
 
 		newSettingsAt, err := time.Parse("2006-01-02T15:04:05Z", settingsAt)
 		if err != nil {
-			return false, fmt.Errorf("error parsing new settings_at time: %v", err)
+			return nil, fmt.Errorf("error parsing new settings_at time: %v", err)
 		}
 
 		// Update only if the new settings timestamp is more recent.
 		if !newSettingsAt.After(cachedSettingsAt) {
 			helpers.LogInfo("No update needed. Cached settings_at is newer or equal.")
-			return false, nil
+			return nil, nil
 		}
 
 	} else {
@@ -503,7 +487,7 @@ func (h *SigfoxHandler) updateDeviceSettingsInCacheAndBroadcast(parsedData map[s
 	err = cache.AppCache.UpdateSettingsAt(deviceID, settingsAt, happenedAt, keepaliveAt)
 	if err != nil {
 		helpers.LogError(err, "Failed to update device settings timestamp in cache")
-		return false, err
+		return nil, err
 	}
 
 	// --- Log updates for PostgreSQL synchronization (e.g., logs:device-settings-at)
@@ -512,35 +496,26 @@ func (h *SigfoxHandler) updateDeviceSettingsInCacheAndBroadcast(parsedData map[s
 		"settings_at": settingsAt,
 	}
 
-	err = cache.AppCache.RPush("logs:device-settings-at", logPayload)
-	if err != nil {
-		helpers.LogError(err, "Failed to push device settings_at to Redis")
-	}
-
-	// Broadcast the update to clients using Socket.IO.
-	app.SocketIO.BroadcastToNamespace("/", "settings-event", logPayload)
-	helpers.LogInfo("Broadcasted settings event for device %s", deviceID)
-
-	return true, nil
+	return logPayload, nil
 }
 
-// updateDeviceCacheAndBroadcast updates the device data cache and broadcasts changes if the incoming data is newer than what's in the cache.
-func (h *SigfoxHandler) updateDeviceCacheAndBroadcast(parsedData map[string]any, deviceId string) error {
+// updateDeviceCache updates the device data cache if the incoming data is newer than what's in the cache and returns the change.
+func (h *SigfoxHandler) updateDeviceCache(parsedData map[string]any, deviceId string) (map[string]any, error) {
 	// Extract the list of parking packages from the parsed data
 	latestParkingPackage, ok := parsedData["parking_packages"].([]map[string]any)
 	if !ok {
-		return errors.New("invalid or missing parking_packages data")
+		return nil, errors.New("invalid or missing parking_packages data")
 	}
 
 	// Return early if there are no parking packages
 	if len(latestParkingPackage) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Retrieve the timestamp from the first parking package
 	timestamp, ok := latestParkingPackage[0]["timestamp"].(int)
 	if !ok {
-		return errors.New("timestamp missing or not an integer")
+		return nil, errors.New("timestamp missing or not an integer")
 	}
 
 	// Convert the timestamp to a UTC time string
@@ -551,24 +526,24 @@ func (h *SigfoxHandler) updateDeviceCacheAndBroadcast(parsedData map[string]any,
 	cachedDevice, err := cache.AppCache.GetDevice(deviceId)
 	if err != nil {
 		helpers.LogError(err, "Error retrieving device from cache")
-		return err
+		return nil, err
 	}
 
 	// Check if there is cached data and the new data is more recent
 	if cachedDevice != nil {
 		cachedHappenedAtStr, ok := cachedDevice["happened_at"].(string)
 		if !ok {
-			return errors.New("cached happened_at is not a string")
+			return nil, errors.New("cached happened_at is not a string")
 		}
 
 		cachedHappenedAt, err := time.Parse("2006-01-02T15:04:05Z", cachedHappenedAtStr)
 		if err != nil {
-			return fmt.Errorf("error parsing cached happened_at time: %v", err)
+			return nil, fmt.Errorf("error parsing cached happened_at time: %v", err)
 		}
 
 		newHappenedAt, err := time.Parse("2006-01-02T15:04:05Z", happenedAt)
 		if err != nil {
-			return fmt.Errorf("error parsing new happened_at time: %v", err)
+			return nil, fmt.Errorf("error parsing new happened_at time: %v", err)
 		}
 
 		// Proceed with update if the new data is more recent
@@ -577,7 +552,7 @@ func (h *SigfoxHandler) updateDeviceCacheAndBroadcast(parsedData map[string]any,
 		}
 
 		helpers.LogInfo("No update needed. Cached happened_at is newer or equal.")
-		return nil
+		return nil, nil
 	}
 
 	// If no cached data exists, process the event as a new entry
@@ -589,11 +564,11 @@ func (h *SigfoxHandler) processParkingEvent(
 	deviceId string,
 	happenedAt string,
 	latestParkingPackage []map[string]any,
-) error {
+) (map[string]any, error) {
 	// Extract the firmware version as a float64
 	firmwareVersionFloat, ok := parsedData["firmware_version"].(float64)
 	if !ok {
-		return errors.New("firmware_version missing or not a float64")
+		return nil, errors.New("firmware_version missing or not a float64")
 	}
 
 	// Format the firmware version as a string
@@ -602,7 +577,7 @@ func (h *SigfoxHandler) processParkingEvent(
 	// Extract the beacons data from the parking package
 	beacons, ok := latestParkingPackage[0]["beacons"].([]map[string]any)
 	if !ok {
-		return errors.New("beacons missing or not in the expected format")
+		return nil, errors.New("beacons missing or not in the expected format")
 	}
 
 	// Determine if the parking spot is occupied
@@ -612,7 +587,7 @@ func (h *SigfoxHandler) processParkingEvent(
 	err := cache.AppCache.ProcessParkingEventData(deviceId, firmwareVersion, beacons, happenedAt, isOccupied)
 	if err != nil {
 		helpers.LogError(err, "Failed to update device cache")
-		return err
+		return nil, err
 	}
 
 	// --- Log updates for PostgreSQL synchronization (logs:device-update)
@@ -625,15 +600,5 @@ func (h *SigfoxHandler) processParkingEvent(
 		"beacons":          beacons,
 	}
 
-	// Push the log entry to Redis for PostgreSQL update processing
-	err = cache.AppCache.RPush("logs:device-update", payload)
-	if err != nil {
-		helpers.LogError(err, "Failed to push to Redis logs:device-update")
-		return err
-	}
-
-	// Broadcast the update to clients using socket.io
-	app.SocketIO.BroadcastToNamespace("/", "parking-event", payload)
-
-	return nil
+	return payload, nil
 }
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/apptypes"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/realtime"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+)
+
+// Limits of the playback endpoint.
+const (
+	defaultPlaybackSpeed = 60
+	maxPlaybackSpeed     = 3600
+	maxPlaybackWindow    = 24 * time.Hour
+)
+
+// Names of the playback events; state changes use the names of the live events.
+var playbackEventNames = map[string]string{
+	"parking":   "parking-event",
+	"keepalive": "keepalive-event",
+	"settings":  "settings-event",
+}
+
+// SnapshotHandler serves the historical state of the devices for map time-travel.
+type SnapshotHandler struct{}
+
+// parseSnapshotQuery parses the `zone` and `device_id` query parameters shared by the snapshot endpoints.
+func parseSnapshotQuery(r *http.Request) services.SnapshotQuery {
+	query := r.URL.Query()
+
+	q := services.SnapshotQuery{Zone: query.Get("zone")}
+	if q.Zone == "" {
+		q.Zone = "all"
+	}
+	for deviceID := range splitQueryList(query.Get("device_id")) {
+		q.DeviceIDs = append(q.DeviceIDs, deviceID)
+	}
+	return q
+}
+
+// parseTimestamp parses a required Unix timestamp query parameter.
+func parseTimestamp(r *http.Request, name string) (time.Time, error) {
+	n, err := parseOptionalInt(r, name)
+	if err != nil || n == 0 {
+		return time.Time{}, fmt.Errorf("Invalid %s. Must be a valid timestamp.", name)
+	}
+	return time.Unix(n, 0).UTC(), nil
+}
+
+// visibleSnapshots drops the hidden devices the user may not see.
+func visibleSnapshots(snapshots []*models.DeviceSnapshot, userData *apptypes.UserClaims) []*models.DeviceSnapshot {
+	session := realtime.Session{Claims: userData}
+	if session.CanSeeHiddenDevices() {
+		return snapshots
+	}
+
+	visible := []*models.DeviceSnapshot{}
+	for _, snapshot := range snapshots {
+		if !snapshot.IsHidden {
+			visible = append(visible, snapshot)
+		}
+	}
+	return visible
+}
+
+// respondWithSnapshotError maps snapshot errors to a response.
+func respondWithSnapshotError(w http.ResponseWriter, q services.SnapshotQuery, err error) {
+	if errors.Is(err, services.ErrUnknownZone) {
+		http.Error(w, fmt.Sprintf("Zone '%s' not found.", q.Zone), http.StatusNotFound)
+		return
+	}
+	helpers.RespondWithError(w, helpers.WrapError(err), "Error rebuilding device snapshot", http.StatusInternalServerError)
+}
+
+// ---------------------------------------------------------------------
+
+// Get returns the state of the devices at the given time.
+// eg: GET /api/snapshot?at=1707815520&zone=all&device_id=02DF9902,02DF9903
+func (h *SnapshotHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	q := parseSnapshotQuery(r)
+
+	q.From, err = parseTimestamp(r, "at")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	snapshots, err := app.Service.Snapshot(q)
+	if err != nil {
+		respondWithSnapshotError(w, q, err)
+		return
+	}
+	snapshots = visibleSnapshots(snapshots, userData)
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("State of %d devices rebuilt successfully.", len(snapshots)),
+		"at":      q.From.Unix(),
+		"zone":    q.Zone,
+		"devices": snapshots,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Playback streams the history of the devices between from and to as Server-Sent Events, `speed`
+// times faster than real time. It starts with a `snapshot` event holding the state at `from`,
+// followed by `parking-event`, `keepalive-event` and `settings-event` events as they happened,
+// and ends with a `playback-end` event.
+// eg: GET /api/snapshot/playback?from=1707811200&to=1707825600&speed=120&zone=all
+func (h *SnapshotHandler) Playback(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	q := parseSnapshotQuery(r)
+
+	if q.From, err = parseTimestamp(r, "from"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseTimestamp(r, "to"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !q.From.Before(q.To) {
+		http.Error(w, "from must be less than to", http.StatusBadRequest)
+		return
+	}
+	if q.To.Sub(q.From) > maxPlaybackWindow {
+		http.Error(w, fmt.Sprintf("The playback window cannot exceed %.0f hours.", maxPlaybackWindow.Hours()), http.StatusBadRequest)
+		return
+	}
+
+	q.Speed = defaultPlaybackSpeed
+	if value := r.URL.Query().Get("speed"); value != "" {
+		q.Speed, err = strconv.ParseFloat(value, 64)
+		if err != nil || q.Speed <= 0 || q.Speed > maxPlaybackSpeed {
+			http.Error(w, fmt.Sprintf("Invalid speed. Must be greater than 0 and at most %d.", maxPlaybackSpeed), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The stream starts with the first event, so errors before it are still plain responses.
+	started := false
+
+	// Hidden devices are taken from the starting state.
+	hidden := make(map[string]bool)
+
+	writeEvent := func(name string, payload any) error {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	sink := services.PlaybackSink{
+		Snapshot: func(snapshots []*models.DeviceSnapshot) error {
+			for _, snapshot := range snapshots {
+				hidden[snapshot.DeviceID] = snapshot.IsHidden
+			}
+			return writeEvent("snapshot", map[string]interface{}{
+				"at":      q.From.Unix(),
+				"devices": visibleSnapshots(snapshots, userData),
+			})
+		},
+		Change: func(change models.SnapshotChange) error {
+			session := realtime.Session{Claims: userData}
+			if hidden[change.DeviceID] && !session.CanSeeHiddenDevices() {
+				return nil
+			}
+			return writeEvent(playbackEventNames[change.Type], change)
+		},
+		Heartbeat: func() error {
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		},
+	}
+
+	err = app.Service.Playback(r.Context(), q, sink)
+	if err != nil {
+		if !started {
+			respondWithSnapshotError(w, q, err)
+			return
+		}
+		if !errors.Is(err, services.ErrPlaybackStopped) {
+			helpers.LogError(err, "Playback failed")
+			writeEvent("playback-error", map[string]interface{}{"message": "Error playing back device history"})
+		}
+		return
+	}
+
+	writeEvent("playback-end", map[string]interface{}{"to": q.To.Unix()})
+}
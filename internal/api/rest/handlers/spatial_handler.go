@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/geo"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/realtime"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+)
+
+const (
+	defaultNearestRadius = 500  // Meters
+	maxNearestRadius     = 5000 // Meters
+	defaultNearestLimit  = 10
+	maxNearestLimit      = 100
+)
+
+// SpatialHandler serves the geospatial queries over the device and bay locations.
+type SpatialHandler struct{}
+
+// parseFloat parses a float query parameter, returning def when it is missing.
+func parseFloat(r *http.Request, name string, def float64) (float64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("Invalid %s. Must be a number.", name)
+	}
+	return f, nil
+}
+
+// parseOptionalBool parses a true/false query parameter, returning nil when it is missing.
+func parseOptionalBool(r *http.Request, name string) (*bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s. Must be true or false.", name)
+	}
+	return &b, nil
+}
+
+// spatialFilterFromRequest parses the occupied, bay_type, stale and stale_after filters.
+func spatialFilterFromRequest(r *http.Request) (services.SpatialFilter, error) {
+	var filter services.SpatialFilter
+	var err error
+
+	if filter.Occupied, err = parseOptionalBool(r, "occupied"); err != nil {
+		return filter, err
+	}
+	if filter.Stale, err = parseOptionalBool(r, "stale"); err != nil {
+		return filter, err
+	}
+
+	staleMinutes, err := parseOptionalInt(r, "stale_after")
+	if err != nil {
+		return filter, err
+	}
+	filter.StaleAfter = time.Duration(staleMinutes) * time.Minute
+
+	filter.BayTypes = splitQueryList(strings.ToLower(r.URL.Query().Get("bay_type")))
+	for bayType := range filter.BayTypes {
+		if !models.BayTypes[bayType] {
+			return filter, fmt.Errorf("Invalid bay_type '%s'.", bayType)
+		}
+	}
+
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		return filter, err
+	}
+	session := realtime.Session{Claims: userData}
+	filter.ShowHidden = session.CanSeeHiddenDevices()
+
+	return filter, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// Nearest returns the nearest bays to a point within a radius, free and recently heard from unless
+// the occupied and stale filters say otherwise.
+// eg: GET /api/spatial/nearest?lat=35.8989&lng=14.5146&radius=300&limit=5&bay_type=ev,disabled
+func (h *SpatialHandler) Nearest(w http.ResponseWriter, r *http.Request) {
+	lat, err := parseFloat(r, "lat", math.NaN())
+	if err != nil || math.IsNaN(lat) {
+		http.Error(w, "Invalid lat. Must be a number.", http.StatusBadRequest)
+		return
+	}
+	lng, err := parseFloat(r, "lng", math.NaN())
+	if err != nil || math.IsNaN(lng) {
+		http.Error(w, "Invalid lng. Must be a number.", http.StatusBadRequest)
+		return
+	}
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		http.Error(w, "lat or lng is out of range.", http.StatusBadRequest)
+		return
+	}
+
+	radius, err := parseFloat(r, "radius", defaultNearestRadius)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if radius <= 0 || radius > maxNearestRadius {
+		http.Error(w, fmt.Sprintf("radius must be between 0 and %d meters.", maxNearestRadius), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseOptionalInt(r, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit == 0 {
+		limit = defaultNearestLimit
+	}
+	limit = min(limit, maxNearestLimit)
+
+	filter, err := spatialFilterFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Occupied == nil {
+		free := false
+		filter.Occupied = &free
+	}
+	if filter.Stale == nil {
+		stale := false
+		filter.Stale = &stale
+	}
+
+	devices, err := app.Service.NearestDevices(lat, lng, radius, int(limit), filter)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to find the nearest bays.", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("%d bays found within %.0f meters.", len(devices), radius),
+		"devices": devices,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Within returns the devices inside a bounding box, given in GeoJSON order.
+// eg: GET /api/spatial/within?bbox=14.50,35.89,14.52,35.90&occupied=false&stale=false
+func (h *SpatialHandler) Within(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Query().Get("bbox"), ",")
+	if len(parts) != 4 {
+		http.Error(w, "bbox is required as min_lng,min_lat,max_lng,max_lat.", http.StatusBadRequest)
+		return
+	}
+	var coords [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			http.Error(w, "Invalid bbox. Coordinates must be numbers.", http.StatusBadRequest)
+			return
+		}
+		coords[i] = f
+	}
+
+	box := geo.Rect{MinLongitude: coords[0], MinLatitude: coords[1], MaxLongitude: coords[2], MaxLatitude: coords[3]}
+	if box.MinLatitude > box.MaxLatitude || box.MinLongitude > box.MaxLongitude {
+		http.Error(w, "Invalid bbox. The minimum must not exceed the maximum.", http.StatusBadRequest)
+		return
+	}
+	if box.MinLatitude < -90 || box.MaxLatitude > 90 || box.MinLongitude < -180 || box.MaxLongitude > 180 {
+		http.Error(w, "Invalid bbox. Coordinates are out of range.", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := spatialFilterFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := app.Service.DevicesInBox(box, filter)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to search the bounding box.", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("%d devices found in the bounding box.", len(devices)),
+		"devices": devices,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
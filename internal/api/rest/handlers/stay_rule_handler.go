@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// StayRuleHandler manages the rules that limit how long a vehicle may stay in a bay.
+type StayRuleHandler struct{}
+
+// stayRulePayload is the body of the create and update requests.
+type stayRulePayload struct {
+	Name           string `json:"name"`
+	DeviceID       string `json:"device_id"` // Empty for a zone rule or a rule that applies to every device
+	ZoneID         *int   `json:"zone_id"`   // Nil for a device rule or a rule that applies to every device
+	MaxStayMinutes int    `json:"max_stay_minutes"`
+	ActiveFrom     string `json:"active_from"`
+	ActiveTo       string `json:"active_to"`
+	DaysOfWeek     string `json:"days_of_week"`
+	Timezone       string `json:"timezone"`
+	IsEnabled      *bool  `json:"is_enabled"`
+}
+
+// toStayRule converts the payload into a validated stay rule.
+func (p stayRulePayload) toStayRule() (*models.StayRule, error) {
+	rule := &models.StayRule{
+		Name:           strings.TrimSpace(p.Name),
+		MaxStayMinutes: p.MaxStayMinutes,
+		ActiveFrom:     p.ActiveFrom,
+		ActiveTo:       p.ActiveTo,
+		DaysOfWeek:     strings.ReplaceAll(p.DaysOfWeek, " ", ""),
+		Timezone:       p.Timezone,
+		ZoneID:         p.ZoneID,
+		IsEnabled:      true,
+	}
+
+	if deviceID := strings.ToUpper(strings.TrimSpace(p.DeviceID)); deviceID != "" {
+		rule.DeviceID = &deviceID
+	}
+	if rule.Timezone == "" {
+		rule.Timezone = "UTC"
+	}
+	if p.IsEnabled != nil {
+		rule.IsEnabled = *p.IsEnabled
+	}
+
+	if err := rule.Validate(); err != nil {
+		return rule, err
+	}
+	if rule.ZoneID != nil {
+		if _, err := app.Models.Zone.GetByID(*rule.ZoneID); err != nil {
+			return rule, fmt.Errorf("zone %d not found", *rule.ZoneID)
+		}
+	}
+	return rule, nil
+}
+
+func (h *StayRuleHandler) Index(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.Models.StayRule.GetAll()
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve stay rules.", http.StatusInternalServerError)
+		return
+	}
+
+	if rules == nil {
+		rules = []*models.StayRule{}
+	}
+
+	response := map[string]interface{}{
+		"message":    fmt.Sprintf("%d stay rules retrieved successfully.", len(rules)),
+		"stay_rules": rules,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *StayRuleHandler) Store(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to create a stay rule
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload stayRulePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := payload.toStayRule()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule, err = app.Models.StayRule.Create(rule)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to create stay rule.", http.StatusInternalServerError)
+		return
+	}
+
+	app.PushAuditToCache(*userData, "CREATE", "stay_rule", fmt.Sprintf("%d", rule.ID), r, fmt.Sprintf("Created stay rule '%s' of %d minutes.", rule.Name, rule.MaxStayMinutes))
+
+	response := map[string]interface{}{
+		"message":   "Stay rule created successfully.",
+		"stay_rule": rule,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *StayRuleHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to update a stay rule
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	ruleID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid stay rule ID.", http.StatusBadRequest)
+		return
+	}
+
+	var payload stayRulePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := payload.toStayRule()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.ID = ruleID
+
+	rule, err = app.Models.StayRule.Update(rule)
+	if err != nil {
+		if err.Error() == "stay rule not found" {
+			http.Error(w, fmt.Sprintf("Stay rule with ID %d not found.", ruleID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to update stay rule.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "UPDATE", "stay_rule", fmt.Sprintf("%d", ruleID), r, fmt.Sprintf("Updated stay rule '%s'.", rule.Name))
+
+	response := map[string]interface{}{
+		"message":   "Stay rule updated successfully.",
+		"stay_rule": rule,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *StayRuleHandler) Destroy(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to delete a stay rule
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	ruleID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid stay rule ID.", http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.StayRule.DeleteByID(ruleID)
+	if err != nil {
+		if err.Error() == "stay rule not found" {
+			http.Error(w, fmt.Sprintf("Stay rule with ID %d not found.", ruleID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to delete stay rule.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "DELETE", "stay_rule", fmt.Sprintf("%d", ruleID), r, fmt.Sprintf("Deleted stay rule with ID %d.", ruleID))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Stay rule with ID %d successfully deleted.", ruleID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
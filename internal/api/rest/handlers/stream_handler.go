@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/apptypes"
+	"github.com/foxcodenine/iot-parking-gateway/internal/realtime"
+)
+
+// streamHeartbeatInterval is how often a comment line is sent to keep idle connections open.
+const streamHeartbeatInterval = 15 * time.Second
+
+type StreamHandler struct {
+}
+
+// streamFilter holds the query filters of a stream request. Empty filters match everything.
+type streamFilter struct {
+	deviceIDs    map[string]bool
+	networkTypes map[string]bool
+	events       map[string]bool
+	networkCache map[string]string // Network type per device, loaded on first use
+}
+
+// newStreamFilter parses the `device_id`, `network_type` and `event` query parameters.
+// Each accepts a comma separated list, e.g. ?event=parking-event,keepalive-event
+func newStreamFilter(r *http.Request) *streamFilter {
+	query := r.URL.Query()
+	return &streamFilter{
+		deviceIDs:    splitQueryList(query.Get("device_id")),
+		networkTypes: splitQueryList(strings.ToLower(query.Get("network_type"))),
+		events:       splitQueryList(query.Get("event")),
+		networkCache: make(map[string]string),
+	}
+}
+
+func splitQueryList(value string) map[string]bool {
+	items := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items[item] = true
+		}
+	}
+	return items
+}
+
+// matches reports whether the user may see the event and it passes the filters.
+func (f *streamFilter) matches(event *realtime.Event, userData *apptypes.UserClaims) bool {
+	// Room broadcasts are addressed to Socket.IO subscriptions only.
+	if event.Room != "" {
+		return false
+	}
+
+	if len(f.events) > 0 && !f.events[event.Name] {
+		return false
+	}
+
+	if event.DeviceID == "" {
+		return len(f.deviceIDs) == 0 && len(f.networkTypes) == 0
+	}
+
+	session := realtime.Session{Claims: userData}
+	if event.Hidden && !session.CanSeeHiddenDevices() {
+		return false
+	}
+
+	if len(f.deviceIDs) > 0 && !f.deviceIDs[event.DeviceID] {
+		return false
+	}
+
+	if len(f.networkTypes) > 0 && !f.networkTypes[f.networkType(event.DeviceID)] {
+		return false
+	}
+
+	return true
+}
+
+// networkType returns the device's network type from the device cache.
+func (f *streamFilter) networkType(deviceID string) string {
+	if networkType, ok := f.networkCache[deviceID]; ok {
+		return networkType
+	}
+
+	networkType := ""
+	if device, err := app.Cache.GetDevice(deviceID); err == nil && device != nil {
+		networkType, _ = device["network_type"].(string)
+		networkType = strings.ToLower(networkType)
+	}
+
+	f.networkCache[deviceID] = networkType
+	return networkType
+}
+
+// ---------------------------------------------------------------------
+
+// Stream serves the real-time device events as Server-Sent Events.
+// Events use the same names and payloads as the Socket.IO events, and their sequence number as
+// the SSE id, so clients resume after a disconnect by sending `Last-Event-ID`.
+func (h *StreamHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	filter := newStreamFilter(r)
+
+	// Start listening before reading the replay ring so no event falls in between.
+	events, cancel := app.Broadcaster.Listen()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+
+	// Resume from the last event the client received, if any.
+	var lastSeq int64
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	if lastEventID != "" {
+		lastSeq, _ = strconv.ParseInt(lastEventID, 10, 64)
+
+		missed, current, ok := app.Broadcaster.Since(lastSeq)
+		if !ok {
+			fmt.Fprintf(w, "event: %s\ndata: {\"current_seq\":%d}\n\n", realtime.EventResyncRequired, current)
+			lastSeq = current
+		}
+		for i := range missed {
+			if filter.matches(&missed[i], userData) {
+				writeStreamEvent(w, &missed[i])
+			}
+			lastSeq = missed[i].Seq
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+
+		case event, ok := <-events:
+			if !ok {
+				// The listener fell too far behind and was disconnected. Ending the stream makes
+				// the client reconnect with Last-Event-ID and catch up from the replay ring.
+				return
+			}
+			// Skip events already sent from the replay ring.
+			if event.Seq != 0 && event.Seq <= lastSeq {
+				continue
+			}
+			if filter.matches(&event, userData) {
+				writeStreamEvent(w, &event)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// writeStreamEvent writes a single SSE frame.
+func writeStreamEvent(w http.ResponseWriter, event *realtime.Event) {
+	if event.Seq != 0 {
+		fmt.Fprintf(w, "id: %d\n", event.Seq)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, event.Payload)
+}
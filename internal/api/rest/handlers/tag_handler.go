@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+	"github.com/go-chi/chi/v5"
+)
+
+// TagDefinitionHandler manages the schema of the device tags.
+type TagDefinitionHandler struct{}
+
+// tagDefinitionPayload is the body of the create and update requests.
+type tagDefinitionPayload struct {
+	Key           string   `json:"key"` // Only read on create
+	Description   string   `json:"description"`
+	Type          string   `json:"type"`
+	AllowedValues []string `json:"allowed_values"`
+	Required      bool     `json:"required"`
+}
+
+// toTagDefinition converts the payload into a validated tag definition.
+func (p tagDefinitionPayload) toTagDefinition() (*models.TagDefinition, error) {
+	def := &models.TagDefinition{
+		Key:           strings.TrimSpace(p.Key),
+		Description:   strings.TrimSpace(p.Description),
+		Type:          p.Type,
+		AllowedValues: models.StringList{},
+		Required:      p.Required,
+	}
+	if def.Type == "" {
+		def.Type = "string"
+	}
+	for _, value := range p.AllowedValues {
+		if value = strings.TrimSpace(value); value != "" {
+			def.AllowedValues = append(def.AllowedValues, value)
+		}
+	}
+
+	return def, def.Validate()
+}
+
+// respondWithTagProblems reports tags that do not match the tag definitions.
+func respondWithTagProblems(w http.ResponseWriter, problems []string, err error) {
+	if errors.Is(err, services.ErrTagsInvalid) {
+		http.Error(w, fmt.Sprintf("Invalid tags: %s.", strings.Join(problems, "; ")), http.StatusBadRequest)
+		return
+	}
+	helpers.RespondWithError(w, err, "Failed to validate tags.", http.StatusInternalServerError)
+}
+
+func (h *TagDefinitionHandler) Index(w http.ResponseWriter, r *http.Request) {
+	defs, err := app.Models.TagDefinition.GetAll()
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve tag definitions.", http.StatusInternalServerError)
+		return
+	}
+
+	if defs == nil {
+		defs = []*models.TagDefinition{}
+	}
+
+	response := map[string]interface{}{
+		"message":         fmt.Sprintf("%d tag definitions retrieved successfully.", len(defs)),
+		"tag_definitions": defs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *TagDefinitionHandler) Store(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to define tags
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload tagDefinitionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	def, err := payload.toTagDefinition()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	def, err = app.Models.TagDefinition.Create(def)
+	if err != nil {
+		if strings.HasSuffix(err.Error(), "is already defined") {
+			http.Error(w, fmt.Sprintf("Tag '%s' is already defined.", payload.Key), http.StatusConflict)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to create tag definition.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "CREATE", "tag_definition", def.Key, r, fmt.Sprintf("Defined %s tag '%s'.", def.Type, def.Key))
+
+	response := map[string]interface{}{
+		"message":        "Tag definition created successfully.",
+		"tag_definition": def,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *TagDefinitionHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to define tags
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload tagDefinitionPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+	payload.Key = chi.URLParam(r, "key")
+
+	def, err := payload.toTagDefinition()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	def, err = app.Models.TagDefinition.Update(def)
+	if err != nil {
+		if err.Error() == "tag definition not found" {
+			http.Error(w, fmt.Sprintf("Tag '%s' is not defined.", payload.Key), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to update tag definition.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "UPDATE", "tag_definition", def.Key, r, fmt.Sprintf("Updated the definition of tag '%s'.", def.Key))
+
+	response := map[string]interface{}{
+		"message":        "Tag definition updated successfully.",
+		"tag_definition": def,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *TagDefinitionHandler) Destroy(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to define tags
+	if userData.AccessLevel > 1 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	key := chi.URLParam(r, "key")
+
+	err = app.Models.TagDefinition.DeleteByKey(key)
+	if err != nil {
+		if err.Error() == "tag definition not found" {
+			http.Error(w, fmt.Sprintf("Tag '%s' is not defined.", key), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to delete tag definition.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	app.PushAuditToCache(*userData, "DELETE", "tag_definition", key, r, fmt.Sprintf("Deleted the definition of tag '%s'.", key))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Definition of tag '%s' successfully deleted. Devices keep their '%s' tags.", key, key),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// BulkUpdateTags sets and removes tags on several devices at once. Nothing is saved when the
+// resulting tags of any device do not match the tag definitions.
+// eg: PATCH /api/device/tags {"device_ids": ["02DF9902", "02DF9903"], "set": {"installer": "acme"}, "remove": ["old_asset"]}
+func (h *DeviceHandler) BulkUpdateTags(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to update devices
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		DeviceIDs []string          `json:"device_ids"`
+		Set       models.DeviceTags `json:"set"`
+		Remove    []string          `json:"remove"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	deviceIDs := []string{}
+	seen := map[string]bool{}
+	for _, deviceID := range payload.DeviceIDs {
+		deviceID = strings.ToUpper(strings.TrimSpace(deviceID))
+		if deviceID != "" && !seen[deviceID] {
+			seen[deviceID] = true
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+	if len(deviceIDs) == 0 {
+		http.Error(w, "device_ids must list at least one device.", http.StatusBadRequest)
+		return
+	}
+	if len(payload.Set) == 0 && len(payload.Remove) == 0 {
+		http.Error(w, "Nothing to do, set or remove at least one tag.", http.StatusBadRequest)
+		return
+	}
+
+	updated, issues, err := app.Service.BulkUpdateTags(deviceIDs, payload.Set, payload.Remove)
+	if err != nil && !errors.Is(err, services.ErrTagsInvalid) {
+		helpers.RespondWithError(w, err, "Failed to update tags.", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	message := fmt.Sprintf("Tags of %d devices updated successfully.", updated)
+	if err != nil {
+		status = http.StatusUnprocessableEntity
+		message = fmt.Sprintf("No tags were updated, %d issues found.", len(issues))
+	} else {
+		app.PushAuditToCache(*userData, "UPDATE", "device", "", r,
+			fmt.Sprintf("Bulk edited the tags of %d devices: set %d, removed %d.", updated, len(payload.Set), len(payload.Remove)))
+	}
+
+	response := map[string]interface{}{
+		"message": message,
+		"updated": updated,
+		"issues":  issues,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
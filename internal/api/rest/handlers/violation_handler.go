@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// ViolationHandler handles overstay violation requests.
+type ViolationHandler struct{}
+
+// Get returns the violations that match the query filters.
+// eg: GET /api/violations?device_id=02DF9902&rule_id=1&status=active&from_date=1707803200&to_date=1707806000
+func (h *ViolationHandler) Get(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := models.ViolationFilter{
+		Status: query.Get("status"),
+	}
+
+	for deviceID := range splitQueryList(query.Get("device_id")) {
+		filter.DeviceIDs = append(filter.DeviceIDs, deviceID)
+	}
+
+	if filter.Status != "" && filter.Status != "active" && filter.Status != "cleared" {
+		http.Error(w, "Invalid status. Must be 'active' or 'cleared'.", http.StatusBadRequest)
+		return
+	}
+
+	// Parse the numeric filters
+	numbers := map[string]int64{}
+	for _, name := range []string{"rule_id", "from_date", "to_date", "limit", "offset"} {
+		n, err := parseOptionalInt(r, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		numbers[name] = n
+	}
+
+	filter.RuleID = int(numbers["rule_id"])
+
+	if numbers["from_date"] > 0 {
+		filter.From = time.Unix(numbers["from_date"], 0).UTC()
+	}
+	if numbers["to_date"] > 0 {
+		filter.To = time.Unix(numbers["to_date"], 0).UTC()
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.From.After(filter.To) {
+		http.Error(w, "from_date cannot be greater than to_date", http.StatusBadRequest)
+		return
+	}
+
+	filter.Limit = int(numbers["limit"])
+	if filter.Limit == 0 {
+		filter.Limit = defaultSessionsLimit
+	}
+	if filter.Limit > maxSessionsLimit {
+		filter.Limit = maxSessionsLimit
+	}
+	filter.Offset = int(numbers["offset"])
+
+	violations, err := app.Models.Violation.GetViolations(filter)
+	if err != nil {
+		helpers.RespondWithError(w, helpers.WrapError(err), "Error fetching violations", http.StatusInternalServerError)
+		return
+	}
+
+	var message string
+	if len(violations) > 0 {
+		message = fmt.Sprintf("%d violations retrieved successfully.", len(violations))
+	} else {
+		message = "No violations found for the given filters."
+		violations = []*models.Violation{}
+	}
+
+	response := map[string]interface{}{
+		"message":    message,
+		"violations": violations,
+		"limit":      filter.Limit,
+		"offset":     filter.Offset,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// ZoneHandler manages parking lots, levels and zones.
+type ZoneHandler struct{}
+
+// zonePayload is the body of the create and update requests.
+type zonePayload struct {
+	ParentID     *int         `json:"parent_id"`
+	Kind         string       `json:"kind"` // Ignored on update
+	Name         string       `json:"name"`
+	Capacity     int          `json:"capacity"`
+	Polygon      [][2]float64 `json:"polygon"` // [[longitude, latitude], ...]
+	MinLatitude  *float64     `json:"min_latitude"`
+	MinLongitude *float64     `json:"min_longitude"`
+	MaxLatitude  *float64     `json:"max_latitude"`
+	MaxLongitude *float64     `json:"max_longitude"`
+}
+
+// toZone converts the payload into a zone.
+func (p zonePayload) toZone() *models.Zone {
+	return &models.Zone{
+		ParentID:     p.ParentID,
+		Kind:         strings.ToLower(strings.TrimSpace(p.Kind)),
+		Name:         strings.TrimSpace(p.Name),
+		Capacity:     p.Capacity,
+		Polygon:      p.Polygon,
+		MinLatitude:  p.MinLatitude,
+		MinLongitude: p.MinLongitude,
+		MaxLatitude:  p.MaxLatitude,
+		MaxLongitude: p.MaxLongitude,
+	}
+}
+
+// zoneFromRequest loads the zone with the ID in the URL.
+func zoneFromRequest(w http.ResponseWriter, r *http.Request) (*models.Zone, bool) {
+	zoneID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid zone ID.", http.StatusBadRequest)
+		return nil, false
+	}
+
+	zone, err := app.Models.Zone.GetByID(zoneID)
+	if err != nil {
+		if err.Error() == "zone not found" {
+			http.Error(w, fmt.Sprintf("Zone with ID %d not found.", zoneID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to retrieve zone.", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+
+	return zone, true
+}
+
+// Index lists the zones with their live counts.
+// eg: GET /api/zones?kind=level&parent_id=1
+func (h *ZoneHandler) Index(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	parentID, err := parseOptionalInt(r, "parent_id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kinds := splitQueryList(query.Get("kind"))
+
+	zones, err := app.Models.Zone.GetAll()
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve zones.", http.StatusInternalServerError)
+		return
+	}
+
+	filtered := []*models.Zone{}
+	for _, zone := range zones {
+		if len(kinds) > 0 && !kinds[zone.Kind] {
+			continue
+		}
+		if query.Get("parent_id") != "" && (zone.ParentID == nil || int64(*zone.ParentID) != parentID) {
+			continue
+		}
+		filtered = append(filtered, zone)
+	}
+
+	occupancies, err := app.Service.ZoneOccupancies(filtered)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve zone counts.", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("%d zones retrieved successfully.", len(occupancies)),
+		"zones":   occupancies,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Get returns a zone with its live counts and the zones nested directly in it.
+func (h *ZoneHandler) Get(w http.ResponseWriter, r *http.Request) {
+	zone, ok := zoneFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	zones, err := app.Models.Zone.GetAll()
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve zones.", http.StatusInternalServerError)
+		return
+	}
+	children := []*models.Zone{}
+	for _, child := range zones {
+		if child.ParentID != nil && *child.ParentID == zone.ID {
+			children = append(children, child)
+		}
+	}
+
+	occupancies, err := app.Service.ZoneOccupancies(append([]*models.Zone{zone}, children...))
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to retrieve zone counts.", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":  "Zone retrieved successfully.",
+		"zone":     occupancies[0],
+		"children": occupancies[1:],
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func (h *ZoneHandler) Store(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to create a zone
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	var payload zonePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	zone := payload.toZone()
+	if err := app.Service.ValidateZone(zone); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	zone, err = app.Models.Zone.Create(zone)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to create zone.", http.StatusInternalServerError)
+		return
+	}
+	app.Service.RebuildZoneCounts()
+
+	app.PushAuditToCache(*userData, "CREATE", "zone", fmt.Sprintf("%d", zone.ID), r, fmt.Sprintf("Created %s '%s'.", zone.Kind, zone.Name))
+
+	response := map[string]interface{}{
+		"message": "Zone created successfully.",
+		"zone":    zone,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func (h *ZoneHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to update a zone
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	existing, ok := zoneFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var payload zonePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body.", http.StatusBadRequest)
+		return
+	}
+
+	zone := payload.toZone()
+	zone.ID = existing.ID
+	zone.Kind = existing.Kind
+	if err := app.Service.ValidateZone(zone); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	zone, err = app.Models.Zone.Update(zone)
+	if err != nil {
+		helpers.RespondWithError(w, err, "Failed to update zone.", http.StatusInternalServerError)
+		return
+	}
+	app.Service.RebuildZoneCounts()
+
+	app.PushAuditToCache(*userData, "UPDATE", "zone", fmt.Sprintf("%d", zone.ID), r, fmt.Sprintf("Updated %s '%s'.", zone.Kind, zone.Name))
+
+	response := map[string]interface{}{
+		"message": "Zone updated successfully.",
+		"zone":    zone,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// Destroy deletes a zone with the zones nested in it. Their bays are kept without a zone.
+func (h *ZoneHandler) Destroy(w http.ResponseWriter, r *http.Request) {
+	userData, err := app.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authentication error.", http.StatusUnauthorized)
+		return
+	}
+
+	// Check if the user has permission to delete a zone
+	if userData.AccessLevel > 2 {
+		http.Error(w, "You do not have the necessary permissions to perform this action.", http.StatusForbidden)
+		return
+	}
+
+	zoneID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid zone ID.", http.StatusBadRequest)
+		return
+	}
+
+	err = app.Models.Zone.DeleteByID(zoneID)
+	if err != nil {
+		if err.Error() == "zone not found" {
+			http.Error(w, fmt.Sprintf("Zone with ID %d not found.", zoneID), http.StatusNotFound)
+		} else {
+			helpers.RespondWithError(w, err, "Failed to delete zone.", http.StatusInternalServerError)
+		}
+		return
+	}
+	app.Service.RebuildZoneCounts()
+
+	app.PushAuditToCache(*userData, "DELETE", "zone", fmt.Sprintf("%d", zoneID), r, fmt.Sprintf("Deleted zone with ID %d.", zoneID))
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Zone with ID %d successfully deleted.", zoneID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		helpers.RespondWithError(w, err, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/apptypes"
+)
+
+// apiKeyAccessLevel is the access level granted to integrators authenticating with an API key.
+// It only gives read access to devices that are not hidden.
+const apiKeyAccessLevel = 3
+
+// JWTOrAPIKeyAuthMiddleware authenticates the request with either a JWT or an API key.
+// The JWT can be sent as a bearer token or, for clients such as EventSource that cannot set
+// headers, as the `token` query parameter. API keys are listed in the API_KEYS environment
+// variable and sent in the `X-API-Key` header or the `api_key` query parameter.
+func JWTOrAPIKeyAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var claims *apptypes.UserClaims
+
+		if apiKey := requestAPIKey(r); apiKey != "" {
+			if !validAPIKey(apiKey) {
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			claims = &apptypes.UserClaims{
+				Email:       "api-key",
+				AccessLevel: apiKeyAccessLevel,
+			}
+		} else {
+			tokenStr := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if tokenStr == "" {
+				tokenStr = r.URL.Query().Get("token")
+			}
+			if tokenStr == "" {
+				http.Error(w, "Authorization header, token or API key required", http.StatusUnauthorized)
+				return
+			}
+
+			var status int
+			var err error
+			claims, status, err = ValidateToken(tokenStr)
+			if err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+		}
+
+		// Add user data to context for access in subsequent handlers
+		ctx := context.WithValue(r.Context(), apptypes.UserContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestAPIKey returns the API key sent with the request, if any.
+func requestAPIKey(r *http.Request) string {
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		return apiKey
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// validAPIKey reports whether the key is one of the configured API keys.
+func validAPIKey(apiKey string) bool {
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		key = strings.TrimSpace(key)
+		if key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -29,54 +30,60 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Retrieve the JWT secret key from environment variables
-		secret := os.Getenv("JWT_SECRET_KEY")
-		tokenStr := parts[1] // The JWT token itself
-		claims := &apptypes.UserClaims{}
-
-		// Parse the token with claims
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
-			// This callback function returns the secret key for token verification
-			return []byte(secret), nil
-		})
-		// Check for parsing errors or if the token is not valid
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		claims, status, err := ValidateToken(parts[1])
+		if err != nil {
+			http.Error(w, err.Error(), status)
 			return
 		}
 
-		// Check if the user has been logged out
-		logoutTimestampInterface, err := cache.AppCache.Get(fmt.Sprintf("app:user:logout:%d", claims.UserID))
-		if err != nil {
-			// Handle errors during retrieval from Redis
-			http.Error(w, "Failed to retrieve logout timestamp from cache.", http.StatusInternalServerError)
+		// Add user data to context for access in subsequent handlers
+		ctx := context.WithValue(r.Context(), apptypes.UserContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-			return
-		}
+// ValidateToken parses the JWT and checks it against the user's logout timestamp.
+// On failure it returns the HTTP status and an error whose message is safe to send to the client.
+func ValidateToken(tokenStr string) (*apptypes.UserClaims, int, error) {
+	// Retrieve the JWT secret key from environment variables
+	secret := os.Getenv("JWT_SECRET_KEY")
+	claims := &apptypes.UserClaims{}
 
-		// Check if there's a logout timestamp for the user.
-		if logoutTimestampInterface != nil {
+	// Parse the token with claims
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		// This callback function returns the secret key for token verification
+		return []byte(secret), nil
+	})
+	// Check for parsing errors or if the token is not valid
+	if err != nil || !token.Valid {
+		return nil, http.StatusUnauthorized, errors.New("Invalid or expired token")
+	}
 
-			// Convert the retrieved value to int64. If conversion fails, report an error.
-			logoutTimestampFloat64, ok := logoutTimestampInterface.(float64)
-			if !ok {
-				http.Error(w, "Invalid timestamp format", http.StatusInternalServerError)
-				return
-			}
+	// Check if the user has been logged out
+	logoutTimestampInterface, err := cache.AppCache.Get(fmt.Sprintf("app:user:logout:%d", claims.UserID))
+	if err != nil {
+		// Handle errors during retrieval from Redis
+		return nil, http.StatusInternalServerError, errors.New("Failed to retrieve logout timestamp from cache.")
+	}
 
-			logoutTimestamp := int64(logoutTimestampFloat64)
+	// Check if there's a logout timestamp for the user.
+	if logoutTimestampInterface != nil {
 
-			// Invalidate the token if it was issued before the logout timestamp.
-			// This is triggered when an admin changes critical user account details like email or access level.
-			// It ensures that users must re-authenticate to reflect these changes immediately.
-			if claims.Timestamp < logoutTimestamp {
-				http.Error(w, "Token is no longer valid", http.StatusUnauthorized)
-				return
-			}
+		// Convert the retrieved value to int64. If conversion fails, report an error.
+		logoutTimestampFloat64, ok := logoutTimestampInterface.(float64)
+		if !ok {
+			return nil, http.StatusInternalServerError, errors.New("Invalid timestamp format")
 		}
 
-		// Add user data to context for access in subsequent handlers
-		ctx := context.WithValue(r.Context(), apptypes.UserContextKey, claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+		logoutTimestamp := int64(logoutTimestampFloat64)
+
+		// Invalidate the token if it was issued before the logout timestamp.
+		// This is triggered when an admin changes critical user account details like email or access level.
+		// It ensures that users must re-authenticate to reflect these changes immediately.
+		if claims.Timestamp < logoutTimestamp {
+			return nil, http.StatusUnauthorized, errors.New("Token is no longer valid")
+		}
+	}
+
+	return claims, http.StatusOK, nil
 }
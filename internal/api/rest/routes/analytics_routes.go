@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func AnalyticsRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	analyticsHandler := &handlers.AnalyticsHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	// eg: GET /api/analytics/occupancy?from_date=1707782400&to_date=1707868800&interval=hour&group_by=all&tz=Europe/Malta
+	r.Get("/occupancy", analyticsHandler.Occupancy)
+	r.Get("/turnover", analyticsHandler.Turnover)
+
+	// eg: GET /api/analytics/forecast?zone=all&horizon=15
+	r.Get("/forecast", analyticsHandler.Forecast)
+
+	// eg: POST /api/analytics/recompute?from_date=1707782400&to_date=1708387200
+	r.Post("/recompute", analyticsHandler.Recompute)
+
+	return r
+}
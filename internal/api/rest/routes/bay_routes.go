@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func BayRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	bayHandler := &handlers.BayHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	// eg: POST /api/bays {"code": "L2-041", "zone_id": 3, "bay_type": "ev", "latitude": 35.8989, "longitude": 14.5146}
+	r.Get("/", bayHandler.Index)
+	r.Post("/", bayHandler.Store)
+	r.Get("/{id}", bayHandler.Get)
+	r.Put("/{id}", bayHandler.Update)
+	r.Delete("/{id}", bayHandler.Destroy)
+
+	r.Put("/{id}/device", bayHandler.AssignDevice)
+	r.Delete("/{id}/device", bayHandler.UnassignDevice)
+	r.Post("/{id}/replace-sensor", bayHandler.ReplaceSensor)
+	r.Get("/{id}/history", bayHandler.History)
+
+	return r
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func ClaimRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	claimHandler := &handlers.ClaimHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	r.Get("/", claimHandler.Index)
+	r.Post("/", claimHandler.Redeem)
+	r.Post("/batches", claimHandler.StoreBatch)
+	r.Delete("/{code}", claimHandler.Destroy)
+
+	return r
+}
@@ -14,10 +14,17 @@ func DeviceRoutes() chi.Router {
 	r.Use(middleware.JWTAuthMiddleware)
 
 	r.Get("/", deviceHandler.Index)
+	r.Get("/export", deviceHandler.Export)
+	r.Post("/import", deviceHandler.Import)
+	r.Patch("/tags", deviceHandler.BulkUpdateTags)
+	r.Get("/deleted", deviceHandler.Deleted)
 	r.Get("/{id}", deviceHandler.Get)
 	r.Post("/", deviceHandler.Store)
 	r.Put("/{id}", deviceHandler.Update)
 	r.Delete("/{id}", deviceHandler.Destroy)
+	r.Put("/{id}/state", deviceHandler.State)
+	r.Post("/{id}/restore", deviceHandler.Restore)
+	r.Delete("/{id}/purge", deviceHandler.Purge)
 
 	return r
 }
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+// GeoJSONRoutes serves the device feed. It also accepts API keys, so that GIS tools can load it by URL.
+func GeoJSONRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	geoJSONHandler := &handlers.GeoJSONHandler{}
+
+	r.Use(middleware.JWTOrAPIKeyAuthMiddleware)
+
+	// eg: GET /api/device.geojson?zone=3&since=1707803200&api_key=...
+	r.Get("/", geoJSONHandler.Devices)
+
+	return r
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func PendingDeviceRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	pendingDeviceHandler := &handlers.PendingDeviceHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	r.Get("/", pendingDeviceHandler.Index)
+	r.Put("/{id}", pendingDeviceHandler.Update)
+	r.Post("/{id}/approve", pendingDeviceHandler.Approve)
+	r.Post("/{id}/reject", pendingDeviceHandler.Reject)
+
+	return r
+}
@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func ReportRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	reportHandler := &handlers.ReportHandler{}
+	reportScheduleHandler := &handlers.ReportScheduleHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	r.Get("/types", reportHandler.Types)
+
+	r.Get("/schedules", reportScheduleHandler.Index)
+	r.Post("/schedules", reportScheduleHandler.Store)
+	r.Put("/schedules/{id}", reportScheduleHandler.Update)
+	r.Delete("/schedules/{id}", reportScheduleHandler.Destroy)
+
+	// eg: POST /api/reports {"report_type": "device_health", "format": "pdf", "from_date": 1704067200, "to_date": 1706745600}
+	r.Get("/", reportHandler.Index)
+	r.Post("/", reportHandler.Store)
+	r.Get("/{id}", reportHandler.Get)
+	r.Get("/{id}/download", reportHandler.Download)
+	r.Delete("/{id}", reportHandler.Destroy)
+
+	return r
+}
@@ -20,8 +20,8 @@ func Routes() http.Handler {
 	mux.Use(middleware.Recoverer)
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key", "Last-Event-ID"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: false,
 		MaxAge:           300,
@@ -43,6 +43,7 @@ func Routes() http.Handler {
 	// API routes
 	mux.Route("/api", func(r chi.Router) {
 		r.Mount("/device", DeviceRoutes())
+		r.Mount("/device.geojson", GeoJSONRoutes())
 		r.Mount("/user", UserRoutes())
 		r.Mount("/auth", AuthRoutes())
 		r.Mount("/favorite", FavoriteRoutes())
@@ -51,8 +52,24 @@ func Routes() http.Handler {
 		r.Mount("/lora", LoraRoutes())
 		r.Mount("/activity-logs", ActivityLogRouter())
 		r.Mount("/keepalive-logs", KeepaliveLogRouter())
+		r.Mount("/stream", StreamRoutes())
+		r.Mount("/sessions", SessionRoutes())
+		r.Mount("/analytics", AnalyticsRoutes())
+		r.Mount("/stay-rules", StayRuleRoutes())
+		r.Mount("/violations", ViolationRoutes())
+		r.Mount("/snapshot", SnapshotRoutes())
+		r.Mount("/reports", ReportRoutes())
+		r.Mount("/zones", ZoneRoutes())
+		r.Mount("/bays", BayRoutes())
+		r.Mount("/spatial", SpatialRoutes())
+		r.Mount("/tag-definitions", TagDefinitionRoutes())
+		r.Mount("/pending-devices", PendingDeviceRoutes())
+		r.Mount("/claims", ClaimRoutes())
 	})
 
+	// Report files are stored under dist but only downloaded through /api/reports/{id}/download
+	mux.Get("/reports/*", http.NotFound)
+
 	// Serve all static files under the dist directory
 	workDir, _ := filepath.Abs(".")
 	filesDir := filepath.Join(workDir, "dist")
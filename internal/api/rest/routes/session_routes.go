@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func SessionRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	sessionHandler := &handlers.SessionHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	// eg: GET /api/sessions?device_id=02DF9902&from_date=1707803200&to_date=1707806000&status=closed
+	r.Get("/", sessionHandler.Get)
+
+	return r
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func SnapshotRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	snapshotHandler := &handlers.SnapshotHandler{}
+
+	r.Use(middleware.JWTOrAPIKeyAuthMiddleware)
+
+	// eg: GET /api/snapshot?at=1707815520&zone=all
+	r.Get("/", snapshotHandler.Get)
+
+	// eg: GET /api/snapshot/playback?from=1707811200&to=1707825600&speed=120
+	r.Get("/playback", snapshotHandler.Playback)
+
+	return r
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func SpatialRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	spatialHandler := &handlers.SpatialHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	r.Get("/nearest", spatialHandler.Nearest)
+	r.Get("/within", spatialHandler.Within)
+
+	return r
+}
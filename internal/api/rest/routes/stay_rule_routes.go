@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func StayRuleRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	stayRuleHandler := &handlers.StayRuleHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	r.Get("/", stayRuleHandler.Index)
+	r.Post("/", stayRuleHandler.Store)
+	r.Put("/{id}", stayRuleHandler.Update)
+	r.Delete("/{id}", stayRuleHandler.Destroy)
+
+	return r
+}
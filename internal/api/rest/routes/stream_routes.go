@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func StreamRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	streamHandler := &handlers.StreamHandler{}
+
+	r.Use(middleware.JWTOrAPIKeyAuthMiddleware)
+
+	// eg: GET /api/stream?device_id=02DF9902,02DF9903&network_type=lora&event=parking-event
+	r.Get("/", streamHandler.Stream)
+
+	return r
+}
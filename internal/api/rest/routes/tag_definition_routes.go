@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func TagDefinitionRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	tagDefinitionHandler := &handlers.TagDefinitionHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	r.Get("/", tagDefinitionHandler.Index)
+	r.Post("/", tagDefinitionHandler.Store)
+	r.Put("/{key}", tagDefinitionHandler.Update)
+	r.Delete("/{key}", tagDefinitionHandler.Destroy)
+
+	return r
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func ViolationRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	violationHandler := &handlers.ViolationHandler{}
+
+	r.Use(middleware.JWTAuthMiddleware)
+
+	// eg: GET /api/violations?device_id=02DF9902&status=active
+	r.Get("/", violationHandler.Get)
+
+	return r
+}
@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/handlers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func ZoneRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	zoneHandler := &handlers.ZoneHandler{}
+	geoJSONHandler := &handlers.GeoJSONHandler{}
+
+	// The GeoJSON feed also accepts API keys, so that GIS tools can load it by URL
+	r.With(middleware.JWTOrAPIKeyAuthMiddleware).Get("/{id}/device.geojson", geoJSONHandler.ZoneDevices)
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.JWTAuthMiddleware)
+
+		// eg: POST /api/zones {"kind": "level", "parent_id": 1, "name": "Level 2", "capacity": 120, "polygon": [[14.51, 35.89], ...]}
+		r.Get("/", zoneHandler.Index)
+		r.Post("/", zoneHandler.Store)
+		r.Get("/{id}", zoneHandler.Get)
+		r.Put("/{id}", zoneHandler.Update)
+		r.Delete("/{id}", zoneHandler.Destroy)
+	})
+
+	return r
+}
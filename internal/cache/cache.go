@@ -118,6 +118,40 @@ func (rc *RedisCache) Set(key string, value any, ttlSeconds int) error {
 	return nil
 }
 
+// SetNX stores a key-value pair with a TTL only if the key does not already exist.
+// It returns true when the key was set.
+func (rc *RedisCache) SetNX(key string, value any, ttlSeconds int) (bool, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	// Marshal the value to JSON for storage
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal value: %v", err)
+	}
+
+	reply, err := conn.Do("SET", rc.Prefix+key, jsonData, "EX", ttlSeconds, "NX")
+	if err != nil {
+		return false, fmt.Errorf("failed to set key in Redis: %w", err)
+	}
+
+	// Redis replies nil when the key already exists
+	return reply != nil, nil
+}
+
+// Incr increments the integer value of a key by one and returns the new value.
+func (rc *RedisCache) Incr(key string) (int64, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	value, err := redis.Int64(conn.Do("INCR", rc.Prefix+key))
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment key in Redis: %w", err)
+	}
+
+	return value, nil
+}
+
 // Exists checks if a key exists in Redis.
 func (rc *RedisCache) Exists(key string) (bool, error) {
 	conn := rc.Conn.Get()
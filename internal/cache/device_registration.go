@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Devices waiting for registration are tracked in Redis so the ingest paths can hold their traffic:
+//
+//	registration:devices         hash of device ID to "pending" or "rejected"
+//	registration:held:<id>       list of the JSON messages held for a pending device, oldest first
+const (
+	registrationKey    = "registration:devices"
+	heldMessagesPrefix = "registration:held:"
+)
+
+// GetRegistrationStatus returns "pending" or "rejected" for a device waiting for registration, "" otherwise.
+func (rc *RedisCache) GetRegistrationStatus(deviceID string) (string, error) {
+	value, err := rc.HGet(registrationKey, deviceID)
+	if err != nil || value == nil {
+		return "", err
+	}
+	status, _ := value.(string)
+	return status, nil
+}
+
+// SetRegistrationStatus records the status of a device waiting for registration; "" forgets the device.
+func (rc *RedisCache) SetRegistrationStatus(deviceID, status string) error {
+	if status != "" {
+		return rc.HSet(registrationKey, deviceID, status)
+	}
+
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("HDEL", rc.Prefix+registrationKey, deviceID); err != nil {
+		return fmt.Errorf("failed to forget registration status of device %s: %w", deviceID, err)
+	}
+	return nil
+}
+
+// ReplaceRegistrationStatuses replaces the statuses of the devices waiting for registration.
+func (rc *RedisCache) ReplaceRegistrationStatuses(statuses map[string]string) error {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("DEL", rc.Prefix+registrationKey)
+	for deviceID, status := range statuses {
+		jsonData, _ := json.Marshal(status)
+		conn.Send("HSET", rc.Prefix+registrationKey, deviceID, jsonData)
+	}
+
+	if _, err := conn.Do("EXEC"); err != nil {
+		return fmt.Errorf("failed to replace registration statuses: %w", err)
+	}
+	return nil
+}
+
+// HoldMessage appends a message to those held for a pending device, keeping only the newest max messages.
+func (rc *RedisCache) HoldMessage(deviceID string, message any, max int) error {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal held message: %v", err)
+	}
+
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	key := rc.Prefix + heldMessagesPrefix + deviceID
+	conn.Send("MULTI")
+	conn.Send("RPUSH", key, jsonData)
+	conn.Send("LTRIM", key, -max, -1)
+	if _, err := conn.Do("EXEC"); err != nil {
+		return fmt.Errorf("failed to hold message of device %s: %w", deviceID, err)
+	}
+	return nil
+}
+
+// CountHeldMessages returns how many messages are held for each of the devices.
+func (rc *RedisCache) CountHeldMessages(deviceIDs []string) (map[string]int, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	for _, deviceID := range deviceIDs {
+		conn.Send("LLEN", rc.Prefix+heldMessagesPrefix+deviceID)
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to count held messages: %w", err)
+	}
+
+	counts := make(map[string]int, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		count, err := redis.Int(conn.Receive())
+		if err != nil {
+			return nil, fmt.Errorf("failed to count held messages of device %s: %w", deviceID, err)
+		}
+		counts[deviceID] = count
+	}
+	return counts, nil
+}
+
+// GetHeldMessages returns the messages held for a device, oldest first, leaving them held.
+func (rc *RedisCache) GetHeldMessages(deviceID string) ([][]byte, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("LRANGE", rc.Prefix+heldMessagesPrefix+deviceID, 0, -1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve held messages of device %s: %w", deviceID, err)
+	}
+	return values, nil
+}
+
+// RemoveHeldMessages discards the count oldest messages held for a device, once they were processed.
+func (rc *RedisCache) RemoveHeldMessages(deviceID string, count int) error {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("LTRIM", rc.Prefix+heldMessagesPrefix+deviceID, count, -1); err != nil {
+		return fmt.Errorf("failed to remove held messages of device %s: %w", deviceID, err)
+	}
+	return nil
+}
+
+// DropHeldMessages discards the messages held for a device.
+func (rc *RedisCache) DropHeldMessages(deviceID string) error {
+	return rc.Delete(heldMessagesPrefix + deviceID)
+}
@@ -3,6 +3,7 @@ package cache
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -40,6 +41,28 @@ func (rc *RedisCache) GetDevice(deviceID string) (map[string]any, error) {
 	return deviceData, nil
 }
 
+// GetDeviceTags retrieves the tags of a cached device, nil when the device or its tags are not cached.
+func (rc *RedisCache) GetDeviceTags(deviceID string) (map[string]any, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	hashKey := fmt.Sprintf("%s%s:%s", rc.Prefix, "parking:device", deviceID)
+
+	raw, err := redis.Bytes(conn.Do("HGET", hashKey, "tags"))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve tags of device %s: %w", deviceID, err)
+	}
+
+	var tags map[string]any
+	if err := json.Unmarshal(raw, &tags); err != nil {
+		return nil, fmt.Errorf("failed to decode tags of device %s: %w", deviceID, err)
+	}
+	return tags, nil
+}
+
 // DeleteDevice removes a device from Redis by its ID.
 // It returns an error if the deletion fails.
 func (rc *RedisCache) DeleteDevice(deviceID string) error {
@@ -125,6 +148,19 @@ func (rc *RedisCache) ProcessParkingEventData(deviceID string, firmwareVersion s
 	args = append(args, "is_occupied", isOccupied)
 	args = append(args, "updated_at", updatedAt)
 
+	// Track when the current vehicle arrived: set on the first occupied event, cleared on departure.
+	previous, err := redis.Strings(conn.Do("HMGET", hashKey, "is_occupied", "occupied_since"))
+	if err != nil {
+		return fmt.Errorf("failed to read occupancy of device %s: %w", deviceID, err)
+	}
+	wasOccupied := IsOccupiedValue(previous[0])
+	switch {
+	case isOccupied && (!wasOccupied || previous[1] == ""):
+		args = append(args, "occupied_since", happenedAt)
+	case !isOccupied:
+		args = append(args, "occupied_since", "")
+	}
+
 	redisKey := fmt.Sprintf("parking:device:%s", deviceID)
 
 	inCache, _ := rc.Exists(redisKey)
@@ -290,6 +326,118 @@ func (rc *RedisCache) UpdateDeviceFields(deviceID string, fields map[string]any)
 	return nil
 }
 
+// GetAllDevices returns every cached device keyed by device ID.
+func (rc *RedisCache) GetAllDevices() (map[string]map[string]any, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	keyPrefix := fmt.Sprintf("%s%s:", rc.Prefix, "parking:device")
+	devices := make(map[string]map[string]any)
+
+	var cursor int64
+	for {
+		// Use SCAN to find matching keys.
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", keyPrefix+"*", "COUNT", 100))
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan Redis keys: %w", err)
+		}
+
+		cursor, _ = redis.Int64(values[0], nil)
+		keys, _ := redis.Strings(values[1], nil)
+
+		// Pipeline the HGETALL of the keys found.
+		for _, key := range keys {
+			conn.Send("HGETALL", key)
+		}
+		if err := conn.Flush(); err != nil {
+			return nil, fmt.Errorf("failed to retrieve devices: %w", err)
+		}
+
+		for _, key := range keys {
+			data, err := redis.StringMap(conn.Receive())
+			if err != nil {
+				return nil, fmt.Errorf("failed to retrieve device data for %s: %w", key, err)
+			}
+
+			// Deserialize JSON-encoded fields, as GetDevice does.
+			deviceData := make(map[string]any, len(data))
+			for field, rawValue := range data {
+				var parsedValue any
+				if err := json.Unmarshal([]byte(rawValue), &parsedValue); err != nil {
+					parsedValue = rawValue
+				}
+				deviceData[field] = parsedValue
+			}
+			devices[strings.TrimPrefix(key, keyPrefix)] = deviceData
+		}
+
+		// If cursor is 0, the scan is complete.
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return devices, nil
+}
+
+// GetDevices returns the cached devices with the given IDs, keyed by device ID.
+// Devices that are not cached are left out.
+func (rc *RedisCache) GetDevices(deviceIDs []string) (map[string]map[string]any, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	devices := make(map[string]map[string]any, len(deviceIDs))
+	if len(deviceIDs) == 0 {
+		return devices, nil
+	}
+
+	// Pipeline the HGETALL of the devices.
+	for _, deviceID := range deviceIDs {
+		conn.Send("HGETALL", fmt.Sprintf("%s%s:%s", rc.Prefix, "parking:device", deviceID))
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve devices: %w", err)
+	}
+
+	for _, deviceID := range deviceIDs {
+		data, err := redis.StringMap(conn.Receive())
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve device data for %s: %w", deviceID, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		// Deserialize JSON-encoded fields, as GetDevice does.
+		deviceData := make(map[string]any, len(data))
+		for field, rawValue := range data {
+			var parsedValue any
+			if err := json.Unmarshal([]byte(rawValue), &parsedValue); err != nil {
+				parsedValue = rawValue
+			}
+			deviceData[field] = parsedValue
+		}
+		devices[deviceID] = deviceData
+	}
+
+	return devices, nil
+}
+
+// IsOccupiedValue interprets a cached is_occupied field. The field is stored as "1"/"0" by
+// ProcessParkingEventData and as JSON true/false when the device is loaded from PostgreSQL.
+func IsOccupiedValue(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v == "1" || v == "true"
+	default:
+		return false
+	}
+}
+
 // Helper function to format specific date-time fields in the data map.
 // This is used to ensure all timestamps are consistent and follow the specified format.
 func formatDateTimeFields(data map[string]any, format string) {
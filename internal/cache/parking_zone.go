@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Zone counts are kept in Redis sets so that repeated occupancy events cannot skew them:
+//
+//	parking:zones                hash of zone ID to the zone's JSON summary
+//	parking:device-zones         hash of device ID to the JSON list of the zones its bay counts towards
+//	parking:zone:<id>:bays       set of the devices counting towards the zone
+//	parking:zone:<id>:occupied   set of those devices that are occupied
+const (
+	zonesKey       = "parking:zones"
+	deviceZonesKey = "parking:device-zones"
+	zoneKeyPrefix  = "parking:zone:"
+)
+
+func zoneBaysKey(zoneID int) string {
+	return fmt.Sprintf("%s%d:bays", zoneKeyPrefix, zoneID)
+}
+
+func zoneOccupiedKey(zoneID int) string {
+	return fmt.Sprintf("%s%d:occupied", zoneKeyPrefix, zoneID)
+}
+
+// ZoneCounts is the number of bays of a zone and how many of them are occupied.
+type ZoneCounts struct {
+	Bays     int
+	Occupied int
+}
+
+// ReplaceZones atomically replaces the cached zones and their counts.
+// zones maps zone IDs to their summaries, bays and occupied map zone IDs to device IDs,
+// and deviceZones maps device IDs to the zones they count towards.
+func (rc *RedisCache) ReplaceZones(zones map[int]any, bays, occupied map[int][]string, deviceZones map[string][]int) error {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	// Collect the sets of the previous zones, including zones that were deleted since.
+	var staleKeys []any
+	var cursor int64
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", rc.Prefix+zoneKeyPrefix+"*", "COUNT", 100))
+		if err != nil {
+			return fmt.Errorf("failed to scan Redis keys: %w", err)
+		}
+
+		cursor, _ = redis.Int64(values[0], nil)
+		keys, _ := redis.Strings(values[1], nil)
+		for _, key := range keys {
+			staleKeys = append(staleKeys, key)
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	conn.Send("MULTI")
+	conn.Send("DEL", append(staleKeys, rc.Prefix+zonesKey, rc.Prefix+deviceZonesKey)...)
+
+	for zoneID, zone := range zones {
+		jsonData, err := json.Marshal(zone)
+		if err != nil {
+			conn.Do("DISCARD")
+			return fmt.Errorf("failed to marshal zone %d: %v", zoneID, err)
+		}
+		conn.Send("HSET", rc.Prefix+zonesKey, zoneID, jsonData)
+	}
+	for zoneID, deviceIDs := range bays {
+		if len(deviceIDs) > 0 {
+			conn.Send("SADD", redis.Args{rc.Prefix + zoneBaysKey(zoneID)}.AddFlat(deviceIDs)...)
+		}
+	}
+	for zoneID, deviceIDs := range occupied {
+		if len(deviceIDs) > 0 {
+			conn.Send("SADD", redis.Args{rc.Prefix + zoneOccupiedKey(zoneID)}.AddFlat(deviceIDs)...)
+		}
+	}
+	for deviceID, zoneIDs := range deviceZones {
+		jsonData, err := json.Marshal(zoneIDs)
+		if err != nil {
+			conn.Do("DISCARD")
+			return fmt.Errorf("failed to marshal zones of device %s: %v", deviceID, err)
+		}
+		conn.Send("HSET", rc.Prefix+deviceZonesKey, deviceID, jsonData)
+	}
+
+	if _, err := conn.Do("EXEC"); err != nil {
+		return fmt.Errorf("failed to replace zones: %w", err)
+	}
+
+	return nil
+}
+
+// GetDeviceZones returns the zones the device's bay counts towards, or nil if it has no bay.
+func (rc *RedisCache) GetDeviceZones(deviceID string) ([]int, error) {
+	value, err := rc.HGet(deviceZonesKey, deviceID)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	list, _ := value.([]any)
+	zoneIDs := make([]int, 0, len(list))
+	for _, v := range list {
+		if id, ok := v.(float64); ok {
+			zoneIDs = append(zoneIDs, int(id))
+		}
+	}
+
+	return zoneIDs, nil
+}
+
+// GetAllDeviceZones returns the zones the bay of each device counts towards, innermost first.
+// Devices without a bay are left out.
+func (rc *RedisCache) GetAllDeviceZones() (map[string][]int, error) {
+	values, err := rc.HGetAll(deviceZonesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceZones := make(map[string][]int, len(values))
+	for deviceID, value := range values {
+		list, _ := value.([]any)
+		for _, v := range list {
+			if id, ok := v.(float64); ok {
+				deviceZones[deviceID] = append(deviceZones[deviceID], int(id))
+			}
+		}
+	}
+
+	return deviceZones, nil
+}
+
+// GetZone returns the cached summary of a zone, or nil if the zone is not cached.
+func (rc *RedisCache) GetZone(zoneID int) (map[string]any, error) {
+	value, err := rc.HGet(zonesKey, strconv.Itoa(zoneID))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	zone, _ := value.(map[string]any)
+	return zone, nil
+}
+
+// SetZoneOccupancy adds the device to or removes it from the occupied set of a zone.
+// It reports whether the set changed.
+func (rc *RedisCache) SetZoneOccupancy(zoneID int, deviceID string, isOccupied bool) (bool, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	command := "SREM"
+	if isOccupied {
+		command = "SADD"
+	}
+
+	changed, err := redis.Int(conn.Do(command, rc.Prefix+zoneOccupiedKey(zoneID), deviceID))
+	if err != nil {
+		return false, fmt.Errorf("failed to update occupancy of zone %d: %w", zoneID, err)
+	}
+
+	return changed > 0, nil
+}
+
+// GetZoneCounts returns the counts of the given zones.
+func (rc *RedisCache) GetZoneCounts(zoneIDs []int) (map[int]ZoneCounts, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	// Pipeline the SCARD of both sets of every zone.
+	for _, zoneID := range zoneIDs {
+		conn.Send("SCARD", rc.Prefix+zoneBaysKey(zoneID))
+		conn.Send("SCARD", rc.Prefix+zoneOccupiedKey(zoneID))
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve zone counts: %w", err)
+	}
+
+	counts := make(map[int]ZoneCounts, len(zoneIDs))
+	for _, zoneID := range zoneIDs {
+		bays, err := redis.Int(conn.Receive())
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve bays of zone %d: %w", zoneID, err)
+		}
+		occupied, err := redis.Int(conn.Receive())
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve occupancy of zone %d: %w", zoneID, err)
+		}
+		counts[zoneID] = ZoneCounts{Bays: bays, Occupied: occupied}
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Publish sends a message to the Redis pub/sub channel specified by channel.
+// The value is serialized to JSON before being published.
+func (rc *RedisCache) Publish(channel string, value any) error {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value: %v", err)
+	}
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("PUBLISH", rc.Prefix+channel, jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to publish to Redis channel: %v", err)
+	}
+
+	return nil
+}
+
+// Subscribe listens on the Redis pub/sub channel specified by channel and calls handler
+// for every message received. It blocks until the subscription fails or stop is closed.
+func (rc *RedisCache) Subscribe(channel string, stop <-chan struct{}, handler func(data []byte)) error {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(rc.Prefix + channel); err != nil {
+		return fmt.Errorf("failed to subscribe to Redis channel: %v", err)
+	}
+
+	// Unsubscribe when asked to stop, which unblocks the receive loop below.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			psc.Unsubscribe()
+		case <-done:
+		}
+	}()
+
+	for {
+		switch msg := psc.ReceiveWithTimeout(0).(type) {
+		case redis.Message:
+			handler(msg.Data)
+		case redis.Subscription:
+			if msg.Count == 0 {
+				return nil // Unsubscribed
+			}
+		case error:
+			return fmt.Errorf("failed to receive from Redis channel: %v", msg)
+		}
+	}
+}
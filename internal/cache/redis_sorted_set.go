@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// SequencedItem is an item of a sorted set written by ZAppend, with the sequence number it was given.
+type SequencedItem struct {
+	Seq  int64
+	Data []byte
+}
+
+// zAppendScript increments the sequence counter, adds the item to the sorted set scored by the new
+// sequence number and trims the set to its newest items, all at once, so no reader sees a sequence
+// number whose item is not in the set yet. The member is "<seq>:<item>", which keeps equal items apart.
+var zAppendScript = redis.NewScript(2, `
+local seq = redis.call('INCR', KEYS[1])
+redis.call('ZADD', KEYS[2], seq, string.format('%d:', seq) .. ARGV[1])
+redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -tonumber(ARGV[2]) - 1)
+return seq
+`)
+
+// ZAppend assigns the next sequence number of seqKey to the value and adds it to the Redis sorted
+// set specified by key, keeping only the `size` newest items. The value is serialized to JSON
+// before being added. It returns the sequence number.
+func (rc *RedisCache) ZAppend(seqKey, key string, value any, size int) (int64, error) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal value: %v", err)
+	}
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	seq, err := redis.Int64(zAppendScript.Do(conn, rc.Prefix+seqKey, rc.Prefix+key, jsonData, size))
+	if err != nil {
+		return 0, fmt.Errorf("failed to append to Redis sorted set: %v", err)
+	}
+
+	return seq, nil
+}
+
+// ZRangeSequenced retrieves the items written by ZAppend to the Redis sorted set specified by key
+// whose sequence number is between min and max (inclusive), in order.
+func (rc *RedisCache) ZRangeSequenced(key string, min, max int64) ([]SequencedItem, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	members, err := redis.ByteSlices(conn.Do("ZRANGEBYSCORE", rc.Prefix+key, min, max))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve range from Redis sorted set: %v", err)
+	}
+
+	items := make([]SequencedItem, 0, len(members))
+	for _, member := range members {
+		prefix, data, ok := bytes.Cut(member, []byte(":"))
+		if !ok {
+			return nil, fmt.Errorf("malformed item in Redis sorted set: %q", member)
+		}
+		seq, err := strconv.ParseInt(string(prefix), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed sequence number in Redis sorted set: %v", err)
+		}
+		items = append(items, SequencedItem{Seq: seq, Data: data})
+	}
+
+	return items, nil
+}
+
+// ZMinScore returns the lowest score in the Redis sorted set specified by key,
+// or false if the set is empty.
+func (rc *RedisCache) ZMinScore(key string) (int64, bool, error) {
+	conn := rc.Conn.Get()
+	defer conn.Close()
+
+	// The reply is [member, score]; only the score is needed.
+	values, err := redis.Values(conn.Do("ZRANGE", rc.Prefix+key, 0, 0, "WITHSCORES"))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to retrieve lowest score from Redis sorted set: %v", err)
+	}
+
+	if len(values) < 2 {
+		return 0, false, nil
+	}
+
+	score, err := redis.Int64(values[1], nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to parse score from Redis sorted set: %v", err)
+	}
+
+	return score, true, nil
+}
@@ -0,0 +1,102 @@
+// Package commands consumes the `gateway_commands` RabbitMQ queue, through which other
+// services (billing, enforcement, ...) ask the gateway to act on devices.
+//
+// Each message body is a JSON command:
+//
+//	{
+//	  "id":        "5f0c...",          // Idempotency key, required. Re-sending the same id returns the original response.
+//	  "type":      "device.hide",      // Command type, required (see below).
+//	  "service":   "enforcement",      // Calling service, required. Recorded in the audit log.
+//	  "device_id": "860123456789012",  // Target device, required.
+//	  "payload":   { "hidden": true }  // Command specific arguments.
+//	}
+//
+// Supported command types and their payloads:
+//
+//	device.hide      {"hidden": bool}              Show or hide the device on the dashboard.
+//	device.block     {"blocked": bool}             Block or unblock traffic from the device.
+//	device.settings  {"settings": {...}}           Queue a settings change for the device's next downlink.
+//
+// If the message has a reply-to property, a JSON response is published to that queue with
+// the same correlation ID:
+//
+//	{
+//	  "command_id":   "5f0c...",
+//	  "type":         "device.hide",
+//	  "status":       "ok",                 // "ok" or "error"
+//	  "error":        "",                   // Set when status is "error"
+//	  "retryable":    false,                // True when the error is transient, e.g. a database outage; the same id can be resent
+//	  "result":       { ... },              // Command specific result
+//	  "duplicate":    false,                // True when the id was already processed
+//	  "processed_at": "2024-01-01T00:00:00Z"
+//	}
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Command types accepted on the queue.
+const (
+	TypeDeviceHide     = "device.hide"
+	TypeDeviceBlock    = "device.block"
+	TypeDeviceSettings = "device.settings"
+)
+
+// Response statuses.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Command is a single request received on the gateway_commands queue.
+type Command struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Service  string          `json:"service"`
+	DeviceID string          `json:"device_id"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// Response is published to the reply-to queue once a command has been processed.
+type Response struct {
+	CommandID   string    `json:"command_id"`
+	Type        string    `json:"type"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Result      any       `json:"result,omitempty"`
+	Duplicate   bool      `json:"duplicate"`
+	Retryable   bool      `json:"retryable,omitempty"` // The command failed for a passing reason and can be resent with the same id
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+type hidePayload struct {
+	Hidden *bool `json:"hidden"`
+}
+
+type blockPayload struct {
+	Blocked *bool `json:"blocked"`
+}
+
+type settingsPayload struct {
+	Settings map[string]any `json:"settings"`
+}
+
+// Validate checks that the command has all required fields.
+func (c *Command) Validate() error {
+	if c.ID == "" {
+		return errors.New("missing required field: id")
+	}
+	if c.Type == "" {
+		return errors.New("missing required field: type")
+	}
+	if c.Service == "" {
+		return errors.New("missing required field: service")
+	}
+	if c.DeviceID == "" {
+		return errors.New("missing required field: device_id")
+	}
+	return nil
+}
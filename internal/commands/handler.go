@@ -0,0 +1,263 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/streadway/amqp"
+)
+
+// idempotencyTTL is how long processed command IDs are remembered.
+const idempotencyTTL = 24 * 60 * 60
+
+// claimTTL is how long a command ID is claimed while the command runs. It is short so that a
+// command interrupted by a crash can be redelivered and run again.
+const claimTTL = 5 * 60
+
+// permanentError is a failure caused by the command itself, which fails again when retried.
+// Other failures, e.g. an unreachable database, are not remembered so that the command can be resent.
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// permanent marks err as caused by the command; a missing device is, other errors are not.
+func permanent(err error) error {
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		return permanentError{err}
+	}
+	return err
+}
+
+// Replier publishes a response to the reply-to queue of a delivery.
+type Replier interface {
+	Reply(delivery amqp.Delivery, body []byte) error
+}
+
+// Handler executes commands consumed from the gateway_commands queue.
+type Handler struct {
+	models  models.Models
+	cache   *cache.RedisCache
+	replier Replier
+}
+
+// NewHandler creates a new command handler.
+func NewHandler(m models.Models, c *cache.RedisCache) *Handler {
+	return &Handler{
+		models: m,
+		cache:  c,
+	}
+}
+
+// SetReplier sets where responses are published, normally the consumer feeding this handler.
+func (h *Handler) SetReplier(r Replier) {
+	h.replier = r
+}
+
+// ---------------------------------------------------------------------
+
+// HandleDelivery processes a single message from the gateway_commands queue.
+// Commands are always acknowledged; failures are reported to the caller through the reply.
+func (h *Handler) HandleDelivery(delivery amqp.Delivery) error {
+	var cmd Command
+	if err := json.Unmarshal(delivery.Body, &cmd); err != nil {
+		h.reply(delivery, &Response{Status: StatusError, Error: "invalid JSON command", ProcessedAt: time.Now().UTC()})
+		return nil
+	}
+
+	if err := cmd.Validate(); err != nil {
+		h.reply(delivery, &Response{CommandID: cmd.ID, Type: cmd.Type, Status: StatusError, Error: err.Error(), ProcessedAt: time.Now().UTC()})
+		return nil
+	}
+
+	idempotencyKey := fmt.Sprintf("gateway:commands:%s", cmd.ID)
+
+	// Claim the idempotency key; if it already exists the command was seen before.
+	claimed, err := h.cache.SetNX(idempotencyKey, Response{CommandID: cmd.ID, Type: cmd.Type, Status: "processing"}, claimTTL)
+	if err != nil {
+		return fmt.Errorf("failed to claim idempotency key for command %s: %w", cmd.ID, err)
+	}
+
+	if !claimed {
+		h.replyDuplicate(delivery, idempotencyKey, &cmd)
+		return nil
+	}
+
+	response := h.execute(&cmd)
+
+	// Remember the outcome, unless the command may succeed when it is resent.
+	if response.Retryable {
+		if err := h.cache.Delete(idempotencyKey); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to release idempotency key for command %s", cmd.ID))
+		}
+	} else if err := h.cache.Set(idempotencyKey, response, idempotencyTTL); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to store response for command %s", cmd.ID))
+	}
+
+	h.reply(delivery, response)
+	return nil
+}
+
+// execute runs the command and builds its response.
+func (h *Handler) execute(cmd *Command) *Response {
+	response := &Response{
+		CommandID: cmd.ID,
+		Type:      cmd.Type,
+		Status:    StatusOK,
+	}
+
+	var result any
+	var err error
+
+	switch cmd.Type {
+	case TypeDeviceHide:
+		result, err = h.hideDevice(cmd)
+	case TypeDeviceBlock:
+		result, err = h.blockDevice(cmd)
+	case TypeDeviceSettings:
+		result, err = h.queueSettings(cmd)
+	default:
+		err = permanentError{fmt.Errorf("unknown command type '%s'", cmd.Type)}
+	}
+
+	if err != nil {
+		var pe permanentError
+		response.Status = StatusError
+		response.Error = err.Error()
+		response.Retryable = !errors.As(err, &pe)
+		helpers.LogError(err, fmt.Sprintf("Command %s (%s) from '%s' failed", cmd.ID, cmd.Type, cmd.Service))
+	} else {
+		response.Result = result
+	}
+
+	response.ProcessedAt = time.Now().UTC()
+	return response
+}
+
+// ---------------------------------------------------------------------
+
+func (h *Handler) hideDevice(cmd *Command) (any, error) {
+	var payload hidePayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil || payload.Hidden == nil {
+		return nil, permanentError{errors.New("payload must contain boolean field 'hidden'")}
+	}
+
+	device, err := h.models.Device.UpdateByID(cmd.DeviceID, map[string]any{"is_hidden": *payload.Hidden})
+	if err != nil {
+		return nil, permanent(err)
+	}
+
+	// Ingest reads the flag from the device cache, so it must be up to date before replying.
+	if err := h.cache.UpdateDeviceFields(cmd.DeviceID, map[string]any{"is_hidden": *payload.Hidden}); err != nil {
+		return nil, fmt.Errorf("failed to update device %s in cache: %w", cmd.DeviceID, err)
+	}
+
+	h.audit(cmd, "UPDATE", fmt.Sprintf("Set is_hidden=%t on device with ID %s.", *payload.Hidden, cmd.DeviceID))
+	return device, nil
+}
+
+func (h *Handler) blockDevice(cmd *Command) (any, error) {
+	var payload blockPayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil || payload.Blocked == nil {
+		return nil, permanentError{errors.New("payload must contain boolean field 'blocked'")}
+	}
+
+	device, err := h.models.Device.UpdateByID(cmd.DeviceID, map[string]any{"is_blocked": *payload.Blocked})
+	if err != nil {
+		return nil, permanent(err)
+	}
+
+	// Ingest reads the flag from the device cache, so it must be up to date before replying.
+	if err := h.cache.UpdateDeviceFields(cmd.DeviceID, map[string]any{"is_blocked": *payload.Blocked}); err != nil {
+		return nil, fmt.Errorf("failed to update device %s in cache: %w", cmd.DeviceID, err)
+	}
+
+	h.audit(cmd, "UPDATE", fmt.Sprintf("Set is_blocked=%t on device with ID %s.", *payload.Blocked, cmd.DeviceID))
+	return device, nil
+}
+
+// queueSettings stores a settings change in Redis until it can be sent to the device.
+func (h *Handler) queueSettings(cmd *Command) (any, error) {
+	var payload settingsPayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil || len(payload.Settings) == 0 {
+		return nil, permanentError{errors.New("payload must contain a non-empty object 'settings'")}
+	}
+
+	if _, err := h.models.Device.GetByID(cmd.DeviceID); err != nil {
+		return nil, permanent(err)
+	}
+
+	queued := map[string]any{
+		"command_id":   cmd.ID,
+		"device_id":    cmd.DeviceID,
+		"settings":     payload.Settings,
+		"requested_by": cmd.Service,
+		"queued_at":    time.Now().UTC(),
+	}
+
+	if err := h.cache.RPush(fmt.Sprintf("commands:settings:%s", cmd.DeviceID), queued); err != nil {
+		return nil, fmt.Errorf("failed to queue settings change: %w", err)
+	}
+
+	h.audit(cmd, "QUEUE_SETTINGS", fmt.Sprintf("Queued settings change for device with ID %s.", cmd.DeviceID))
+	return queued, nil
+}
+
+// ---------------------------------------------------------------------
+
+// audit pushes an audit log entry attributed to the calling service.
+func (h *Handler) audit(cmd *Command, action, details string) {
+	auditLogEntry := models.AuditLog{
+		UserID:      0,
+		Email:       fmt.Sprintf("service:%s", cmd.Service),
+		AccessLevel: 1,
+		HappenedAt:  time.Now().UTC(),
+		Action:      action,
+		Entity:      "device",
+		EntityID:    cmd.DeviceID,
+		URL:         fmt.Sprintf("amqp://gateway_commands/%s", cmd.Type),
+		Details:     fmt.Sprintf("%s Command ID %s.", details, cmd.ID),
+	}
+
+	if err := h.cache.RPush("logs:audit-logs", auditLogEntry); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to push audit log for command %s", cmd.ID))
+	}
+}
+
+// replyDuplicate answers a repeated command with the stored response of the original.
+func (h *Handler) replyDuplicate(delivery amqp.Delivery, idempotencyKey string, cmd *Command) {
+	response := &Response{CommandID: cmd.ID, Type: cmd.Type, Status: "processing"}
+
+	stored, err := h.cache.Get(idempotencyKey)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to load stored response for command %s", cmd.ID))
+	} else if stored != nil {
+		data, _ := json.Marshal(stored)
+		json.Unmarshal(data, response)
+	}
+
+	response.Duplicate = true
+	h.reply(delivery, response)
+}
+
+// reply publishes the response if the delivery asked for one.
+func (h *Handler) reply(delivery amqp.Delivery, response *Response) {
+	if h.replier == nil || delivery.ReplyTo == "" {
+		return
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		helpers.LogError(err, "Failed to serialize command response")
+		return
+	}
+
+	if err := h.replier.Reply(delivery, body); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to reply to command %s", response.CommandID))
+	}
+}
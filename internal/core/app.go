@@ -9,9 +9,13 @@ import (
 
 	"github.com/foxcodenine/iot-parking-gateway/internal/apptypes"
 	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
+	"github.com/foxcodenine/iot-parking-gateway/internal/influx"
 	"github.com/foxcodenine/iot-parking-gateway/internal/models"
 	"github.com/foxcodenine/iot-parking-gateway/internal/mq"
+	"github.com/foxcodenine/iot-parking-gateway/internal/realtime"
 	"github.com/foxcodenine/iot-parking-gateway/internal/services"
+	"github.com/foxcodenine/iot-parking-gateway/internal/thingsboard"
 	"github.com/foxcodenine/iot-parking-gateway/internal/udp"
 	socketio "github.com/googollee/go-socket.io"
 
@@ -24,12 +28,26 @@ type App struct {
 	HttpPort   string
 	DB         *pgxpool.Pool
 	Models     models.Models
-	MQProducer *mq.RabbitMQProducer
+	MQProducer mq.Publisher
 	Cache      *cache.RedisCache
 	Cron       *cron.Cron
 	UdpServer  *udp.UDPServer
 	SocketIO   *socketio.Server
 
+	// Broadcaster emits Socket.IO events on every gateway instance through Redis pub/sub.
+	Broadcaster *realtime.Broadcaster
+
+	// Bus delivers ingest events to the Redis log buffer, the message queue and Socket.IO.
+	Bus *events.Bus
+
+	ThingsBoard         *thingsboard.Gateway
+	ThingsBoardConsumer *mq.RabbitMQConsumer
+
+	InfluxWriter   *influx.Writer
+	InfluxConsumer *mq.RabbitMQConsumer
+
+	CommandConsumer *mq.RabbitMQConsumer
+
 	Service          *services.Service
 	DeviceAccessMode *string
 }
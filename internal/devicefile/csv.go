@@ -0,0 +1,49 @@
+package devicefile
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+func readCSV(r io.Reader) ([]*Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+
+	return readRows(rows)
+}
+
+func writeCSV(w io.Writer, devices []*models.Device) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(Columns); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	record := make([]string, len(Columns))
+	for _, d := range devices {
+		for i, value := range deviceCells(d) {
+			switch v := value.(type) {
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
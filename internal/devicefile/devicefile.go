@@ -0,0 +1,192 @@
+// Package devicefile reads and writes the CSV, XLSX and GeoJSON device lists used for bulk
+// imports and exports. An exported file can be edited and imported again.
+package devicefile
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// Columns are the columns of the CSV and XLSX files and the properties of the GeoJSON features.
+// The GeoJSON files carry the coordinates in the geometry instead of the latitude and longitude.
+var Columns = []string{"device_id", "name", "network_type", "firmware_version", "latitude", "longitude", "is_allowed", "is_blocked", "is_hidden"}
+
+// requiredColumns must be present in the header of an imported CSV or XLSX file.
+var requiredColumns = []string{"device_id", "name", "network_type", "latitude", "longitude"}
+
+// Formats lists the supported file formats with their file extensions.
+var Formats = map[string]string{
+	"csv":     ".csv",
+	"xlsx":    ".xlsx",
+	"geojson": ".geojson",
+}
+
+// ContentTypes lists the MIME type of each format.
+var ContentTypes = map[string]string{
+	"csv":     "text/csv",
+	"xlsx":    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"geojson": "application/geo+json",
+}
+
+// Record is a device read from a file.
+type Record struct {
+	Row     int // Row of the CSV or XLSX file, or position of the GeoJSON feature, from 1
+	Device  models.Device
+	Columns []string          // Columns given a value, in the order of Columns; the others keep their current value
+	Errors  map[string]string // Column to the error of a value that could not be parsed
+}
+
+// Read reads the devices of a file in the given format.
+// Values that cannot be parsed are recorded on their record; an error is only returned when
+// the file itself cannot be read.
+func Read(format string, r io.Reader) ([]*Record, error) {
+	switch format {
+	case "csv":
+		return readCSV(r)
+	case "xlsx":
+		return readXLSX(r)
+	case "geojson":
+		return readGeoJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported file format '%s'", format)
+	}
+}
+
+// Write writes the devices to w in the given format.
+func Write(format string, w io.Writer, devices []*models.Device) error {
+	switch format {
+	case "csv":
+		return writeCSV(w, devices)
+	case "xlsx":
+		return writeXLSX(w, devices)
+	case "geojson":
+		return writeGeoJSON(w, devices)
+	default:
+		return fmt.Errorf("unsupported file format '%s'", format)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// readRows converts the rows of a tabular file, the first non-empty row being the header.
+func readRows(rows [][]string) ([]*Record, error) {
+	header := -1
+	for i, row := range rows {
+		if !isBlank(row) {
+			header = i
+			break
+		}
+	}
+	if header < 0 {
+		return []*Record{}, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[header] {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := columns[name]; !ok && name != "" {
+			columns[name] = i
+		}
+	}
+	var missing []string
+	for _, name := range requiredColumns {
+		if _, ok := columns[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing columns: %s", strings.Join(missing, ", "))
+	}
+
+	records := []*Record{}
+	for i := header + 1; i < len(rows); i++ {
+		row := rows[i]
+		if isBlank(row) {
+			continue
+		}
+
+		values := make(map[string]string, len(columns))
+		for name, index := range columns {
+			if index < len(row) {
+				values[name] = strings.TrimSpace(row[index])
+			}
+		}
+		records = append(records, newRecord(i+1, values))
+	}
+
+	return records, nil
+}
+
+// newRecord parses the values of a device.
+func newRecord(row int, values map[string]string) *Record {
+	record := &Record{Row: row, Errors: map[string]string{}}
+	d := &record.Device
+
+	d.DeviceID = strings.ToUpper(values["device_id"])
+	d.Name = values["name"]
+	d.NetworkType = values["network_type"]
+	d.FirmwareVersion = record.parseFloat(values, "firmware_version")
+	d.Latitude = record.parseFloat(values, "latitude")
+	d.Longitude = record.parseFloat(values, "longitude")
+	d.IsAllowed = record.parseBool(values, "is_allowed")
+	d.IsBlocked = record.parseBool(values, "is_blocked")
+	d.IsHidden = record.parseBool(values, "is_hidden")
+
+	for _, column := range requiredColumns {
+		if values[column] == "" {
+			record.Errors[column] = column + " is required"
+		}
+	}
+	for _, column := range Columns {
+		if values[column] != "" {
+			record.Columns = append(record.Columns, column)
+		}
+	}
+
+	return record
+}
+
+// parseFloat parses a number, recording an error when it is not one. A missing value is 0.
+func (record *Record) parseFloat(values map[string]string, column string) float64 {
+	value := values[column]
+	if value == "" {
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		record.Errors[column] = fmt.Sprintf("'%s' is not a number", value)
+	}
+	return f
+}
+
+// parseBool parses true/false, yes/no or 1/0, recording an error otherwise. A missing value is false.
+func (record *Record) parseBool(values map[string]string, column string) bool {
+	switch strings.ToLower(values[column]) {
+	case "", "false", "no", "0":
+		return false
+	case "true", "yes", "1":
+		return true
+	default:
+		record.Errors[column] = fmt.Sprintf("'%s' is not true or false", values[column])
+		return false
+	}
+}
+
+func isBlank(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// deviceCells returns the cells of a device in the order of Columns.
+func deviceCells(d *models.Device) []any {
+	return []any{d.DeviceID, d.Name, d.NetworkType, d.FirmwareVersion, d.Latitude, d.Longitude, d.IsAllowed, d.IsBlocked, d.IsHidden}
+}
@@ -0,0 +1,96 @@
+package devicefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id,omitempty"`
+	Geometry   *point         `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type point struct {
+	Type        string        `json:"type"`
+	Coordinates []json.Number `json:"coordinates"` // [longitude, latitude]
+}
+
+func readGeoJSON(r io.Reader) ([]*Record, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber() // Keeps numeric device IDs as written
+
+	var collection featureCollection
+	if err := decoder.Decode(&collection); err != nil {
+		return nil, fmt.Errorf("failed to read GeoJSON: %w", err)
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("GeoJSON must be a FeatureCollection")
+	}
+
+	records := make([]*Record, 0, len(collection.Features))
+	for i, f := range collection.Features {
+		values := make(map[string]string, len(f.Properties)+2)
+		for name, value := range f.Properties {
+			if value != nil {
+				values[name] = fmt.Sprint(value)
+			}
+		}
+
+		geometryError := ""
+		if f.Geometry == nil || f.Geometry.Type != "Point" || len(f.Geometry.Coordinates) < 2 {
+			geometryError = "geometry must be a Point"
+		} else {
+			values["longitude"] = f.Geometry.Coordinates[0].String()
+			values["latitude"] = f.Geometry.Coordinates[1].String()
+		}
+
+		record := newRecord(i+1, values)
+		if geometryError != "" {
+			delete(record.Errors, "latitude")
+			delete(record.Errors, "longitude")
+			record.Errors["geometry"] = geometryError
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func writeGeoJSON(w io.Writer, devices []*models.Device) error {
+	collection := featureCollection{Type: "FeatureCollection", Features: make([]feature, 0, len(devices))}
+
+	for _, d := range devices {
+		properties := make(map[string]any, len(Columns))
+		for i, value := range deviceCells(d) {
+			if name := Columns[i]; name != "latitude" && name != "longitude" {
+				properties[name] = value
+			}
+		}
+
+		collection.Features = append(collection.Features, feature{
+			Type: "Feature",
+			ID:   d.DeviceID,
+			Geometry: &point{Type: "Point", Coordinates: []json.Number{
+				json.Number(strconv.FormatFloat(d.Longitude, 'f', -1, 64)),
+				json.Number(strconv.FormatFloat(d.Latitude, 'f', -1, 64)),
+			}},
+			Properties: properties,
+		})
+	}
+
+	if err := json.NewEncoder(w).Encode(collection); err != nil {
+		return fmt.Errorf("failed to write GeoJSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package devicefile
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxSheet is the name of the worksheet the devices are exported to.
+// Imports read the first worksheet, whatever its name.
+const xlsxSheet = "Devices"
+
+func readXLSX(r io.Reader) ([]*Record, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook: %w", err)
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if len(sheets) == 0 {
+		return []*Record{}, nil
+	}
+
+	rows, err := f.GetRows(sheets[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read worksheet '%s': %w", sheets[0], err)
+	}
+
+	return readRows(rows)
+}
+
+func writeXLSX(w io.Writer, devices []*models.Device) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	if err := f.SetSheetName("Sheet1", xlsxSheet); err != nil {
+		return fmt.Errorf("failed to name worksheet: %w", err)
+	}
+
+	sw, err := f.NewStreamWriter(xlsxSheet)
+	if err != nil {
+		return fmt.Errorf("failed to create worksheet writer: %w", err)
+	}
+
+	header := make([]interface{}, len(Columns))
+	for i, column := range Columns {
+		header[i] = column
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	for i, d := range devices {
+		if err := sw.SetRow(fmt.Sprintf("A%d", i+2), deviceCells(d)); err != nil {
+			return fmt.Errorf("failed to write row %d: %w", i+1, err)
+		}
+	}
+
+	if err := sw.Flush(); err != nil {
+		return fmt.Errorf("failed to flush worksheet: %w", err)
+	}
+
+	return f.Write(w)
+}
@@ -0,0 +1,140 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+)
+
+// DefaultQueueSize is the queue length used when a subscriber does not specify one.
+const DefaultQueueSize = 1000
+
+// Handler processes events delivered to a subscriber.
+type Handler func(event Event)
+
+// Bus is an in-process publish/subscribe event bus.
+//
+// Every subscriber has its own queue and goroutine, so a slow subscriber only delays itself.
+// When a lossy subscriber's queue is full, new events for it are dropped instead of blocking ingest.
+// Lossless subscribers, which persist the events, block the publisher until there is room instead.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []*subscriber
+	wg          sync.WaitGroup
+	closed      bool
+}
+
+type subscriber struct {
+	name     string
+	queue    chan Event
+	handler  Handler
+	lossless bool // Publish waits for room in the queue instead of dropping the event
+	dropped  atomic.Int64
+}
+
+// AppBus is the bus shared by the ingest handlers.
+var AppBus *Bus
+
+// NewBus creates a new event bus.
+func NewBus() *Bus {
+	AppBus = &Bus{}
+	return AppBus
+}
+
+// ---------------------------------------------------------------------
+
+// Subscribe registers a best-effort handler that receives every published event in order.
+// queueSize bounds how many events may wait for the handler; 0 uses DefaultQueueSize.
+// Events are dropped while its queue is full.
+func (b *Bus) Subscribe(name string, queueSize int, handler Handler) {
+	b.subscribe(name, queueSize, handler, false)
+}
+
+// SubscribeLossless registers a handler that receives every published event in order, without
+// dropping any: publishers wait while its queue is full.
+func (b *Bus) SubscribeLossless(name string, queueSize int, handler Handler) {
+	b.subscribe(name, queueSize, handler, true)
+}
+
+func (b *Bus) subscribe(name string, queueSize int, handler Handler, lossless bool) {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	sub := &subscriber{
+		name:     name,
+		queue:    make(chan Event, queueSize),
+		handler:  handler,
+		lossless: lossless,
+	}
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, sub)
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go b.run(sub)
+}
+
+// run delivers queued events to the subscriber until the bus is closed.
+func (b *Bus) run(sub *subscriber) {
+	defer b.wg.Done()
+
+	for event := range sub.queue {
+		b.deliver(sub, event)
+	}
+}
+
+// deliver calls the handler, recovering from panics so one bad event cannot stop the subscriber.
+func (b *Bus) deliver(sub *subscriber, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			helpers.LogError(fmt.Errorf("panic: %v", r), fmt.Sprintf("Subscriber '%s' failed to handle '%s'", sub.name, event.Name()))
+		}
+	}()
+
+	sub.handler(event)
+}
+
+// Publish queues the event for every subscriber. It only blocks while the queue of a lossless
+// subscriber is full.
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return
+	}
+
+	for _, sub := range b.subscribers {
+		if sub.lossless {
+			sub.queue <- event
+			continue
+		}
+
+		select {
+		case sub.queue <- event:
+		default:
+			dropped := sub.dropped.Add(1)
+			helpers.LogInfo("Subscriber '%s' queue is full, dropped '%s' for device %s (%d dropped)", sub.name, event.Name(), event.Device(), dropped)
+		}
+	}
+}
+
+// Close stops accepting events and waits for subscribers to drain their queues.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	for _, sub := range b.subscribers {
+		close(sub.queue)
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+}
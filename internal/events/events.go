@@ -0,0 +1,114 @@
+package events
+
+import (
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// Event is a typed message published on the bus by the ingest paths (NB-IoT, LoRa, Sigfox)
+// and the stay rule scheduler.
+type Event interface {
+	// Name identifies the event type, e.g. "occupancy_changed".
+	Name() string
+	// Device returns the ID of the device the event belongs to.
+	Device() string
+}
+
+// Event names.
+const (
+	NameOccupancyChanged  = "occupancy_changed"
+	NameKeepaliveReceived = "keepalive_received"
+	NameSettingsReported  = "settings_reported"
+	NameDeviceRegistered  = "device_registered"
+	NameTrafficReleased   = "traffic_released"
+	NameViolationRaised   = "violation_raised"
+	NameViolationCleared  = "violation_cleared"
+)
+
+// OccupancyChanged carries the parking packages (event_id 26) of one uplink.
+// Update is set when the newest package changed the device state in the cache; it is the
+// payload of the `logs:device-update` entry and the `parking-event` broadcast.
+type OccupancyChanged struct {
+	DeviceID    string
+	NetworkType string
+	Packages    []map[string]any
+	Update      map[string]any
+}
+
+func (e OccupancyChanged) Name() string   { return NameOccupancyChanged }
+func (e OccupancyChanged) Device() string { return e.DeviceID }
+
+// KeepaliveReceived carries the keepalive packages (event_id 6) of one uplink.
+// Update is set when the device's keepalive_at moved forward in the cache.
+type KeepaliveReceived struct {
+	DeviceID    string
+	NetworkType string
+	Packages    []map[string]any
+	Update      map[string]any
+}
+
+func (e KeepaliveReceived) Name() string   { return NameKeepaliveReceived }
+func (e KeepaliveReceived) Device() string { return e.DeviceID }
+
+// SettingsReported carries the settings packages (event_id 25) of one uplink.
+// Update is set when the device's settings_at moved forward in the cache.
+type SettingsReported struct {
+	DeviceID    string
+	NetworkType string
+	Packages    []map[string]any
+	Update      map[string]any
+}
+
+func (e SettingsReported) Name() string   { return NameSettingsReported }
+func (e SettingsReported) Device() string { return e.DeviceID }
+
+// DeviceRegistered is published the first time traffic is received from an unknown device.
+type DeviceRegistered struct {
+	DeviceID        string
+	NetworkType     string
+	FirmwareVersion float64
+}
+
+func (e DeviceRegistered) Name() string   { return NameDeviceRegistered }
+func (e DeviceRegistered) Device() string { return e.DeviceID }
+
+// HeldMessage is an uplink of a device waiting for registration, held until the device is approved.
+// Payload is the hex string received over UDP (NB-IoT) or the JSON body of the request (LoRa, SigFox),
+// which was posted to URL.
+type HeldMessage struct {
+	ReceivedAt time.Time `json:"received_at"`
+	Payload    string    `json:"payload"`
+	URL        string    `json:"url,omitempty"`
+}
+
+// TrafficReleased is published when a pending device is approved, with the messages held for it,
+// oldest first. The ingest path of the device's network processes them again, then discards the
+// Held messages they were decoded from.
+type TrafficReleased struct {
+	DeviceID    string
+	NetworkType string
+	Messages    []HeldMessage
+	Held        int
+}
+
+func (e TrafficReleased) Name() string   { return NameTrafficReleased }
+func (e TrafficReleased) Device() string { return e.DeviceID }
+
+// ViolationRaised is published when a vehicle exceeds a stay rule.
+type ViolationRaised struct {
+	Violation models.Violation
+	RuleName  string
+}
+
+func (e ViolationRaised) Name() string   { return NameViolationRaised }
+func (e ViolationRaised) Device() string { return e.Violation.DeviceID }
+
+// ViolationCleared is published when the vehicle of an active violation leaves.
+type ViolationCleared struct {
+	Violation models.Violation
+	RuleName  string
+}
+
+func (e ViolationCleared) Name() string   { return NameViolationCleared }
+func (e ViolationCleared) Device() string { return e.Violation.DeviceID }
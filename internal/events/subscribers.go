@@ -0,0 +1,242 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/mq"
+	"github.com/foxcodenine/iot-parking-gateway/internal/realtime"
+)
+
+// networkLogPrefix returns the prefix of the per-network Redis log lists, e.g. "nb" for logs:nb-keepalive-logs.
+func networkLogPrefix(networkType string) string {
+	switch networkType {
+	case "NB-IoT":
+		return "nb"
+	default:
+		return strings.ToLower(networkType)
+	}
+}
+
+// LogBufferSubscriber buffers events in the Redis log lists that the cron services sync to PostgreSQL.
+func LogBufferSubscriber(c *cache.RedisCache) Handler {
+	rpush := func(key string, value any, event Event) {
+		if err := c.RPush(key, value); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to push %s log of device %s to Redis", event.Name(), event.Device()))
+		}
+	}
+
+	return func(event Event) {
+		switch e := event.(type) {
+		case OccupancyChanged:
+			for _, pkg := range e.Packages {
+				rpush("logs:activity-logs", pkg, e)
+			}
+			if e.Update != nil {
+				rpush("logs:device-update", e.Update, e)
+			}
+
+		case KeepaliveReceived:
+			for _, pkg := range e.Packages {
+				rpush(fmt.Sprintf("logs:%s-keepalive-logs", networkLogPrefix(e.NetworkType)), pkg, e)
+			}
+			if e.Update != nil {
+				rpush("logs:device-keepalive-at", e.Update, e)
+			}
+
+		case SettingsReported:
+			for _, pkg := range e.Packages {
+				rpush(fmt.Sprintf("logs:%s-setting-logs", networkLogPrefix(e.NetworkType)), pkg, e)
+			}
+			if e.Update != nil {
+				rpush("logs:device-settings-at", e.Update, e)
+			}
+
+		case DeviceRegistered:
+			// Track the device for registration by the RegisterNewDevices cron job.
+			deviceDataKey := fmt.Sprintf("%s %s %f", e.NetworkType, e.DeviceID, e.FirmwareVersion)
+			if err := c.SAdd("to-register-devices", deviceDataKey); err != nil {
+				helpers.LogError(err, "Failed to add device ID to the 'to-register-devices' set")
+			}
+		}
+	}
+}
+
+// violationPayload is the message published for violation events.
+func violationPayload(status string, v models.Violation, ruleName string) map[string]any {
+	return map[string]any{
+		"status":           status,
+		"id":               v.ID,
+		"rule_id":          v.RuleID,
+		"rule_name":        ruleName,
+		"device_id":        v.DeviceID,
+		"occupied_since":   v.OccupiedSince.UTC().Format("2006-01-02T15:04:05Z"),
+		"violated_at":      v.ViolatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		"max_stay_minutes": v.MaxStayMinutes,
+		"cleared_at":       v.ClearedAt,
+	}
+}
+
+// MQSubscriber publishes every package to the event_logs exchange, keyed by device ID,
+// and violations to the violations exchange. Messages carry the device's tags, so that
+// consumers can route them on installer, asset number and the like.
+func MQSubscriber(p mq.Publisher, c *cache.RedisCache) Handler {
+	deviceTags := func(deviceID string) map[string]any {
+		tags, err := c.GetDeviceTags(deviceID)
+		if err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to retrieve the tags of device %s", deviceID))
+		}
+		if tags == nil {
+			tags = map[string]any{}
+		}
+		return tags
+	}
+
+	publish := func(deviceID string, packages []map[string]any) {
+		tags := deviceTags(deviceID)
+		for _, pkg := range packages {
+			// Packages are shared with the other subscribers, so the tags go on a copy.
+			message := make(map[string]any, len(pkg)+1)
+			for key, value := range pkg {
+				message[key] = value
+			}
+			message["tags"] = tags
+
+			messageData, err := json.Marshal(message)
+			if err != nil {
+				helpers.LogError(err, "Failed to serialize package to JSON")
+				continue
+			}
+			p.Publish("event_logs", deviceID, string(messageData))
+		}
+	}
+
+	publishViolation := func(deviceID string, payload map[string]any) {
+		payload["tags"] = deviceTags(deviceID)
+		messageData, err := json.Marshal(payload)
+		if err != nil {
+			helpers.LogError(err, "Failed to serialize violation to JSON")
+			return
+		}
+		p.Publish("violations", deviceID, string(messageData))
+	}
+
+	return func(event Event) {
+		switch e := event.(type) {
+		case OccupancyChanged:
+			publish(e.DeviceID, e.Packages)
+		case KeepaliveReceived:
+			publish(e.DeviceID, e.Packages)
+		case SettingsReported:
+			publish(e.DeviceID, e.Packages)
+		case ViolationRaised:
+			publishViolation(e.Violation.DeviceID, violationPayload("raised", e.Violation, e.RuleName))
+		case ViolationCleared:
+			publishViolation(e.Violation.DeviceID, violationPayload("cleared", e.Violation, e.RuleName))
+		}
+	}
+}
+
+// SocketSubscriber broadcasts device state changes to real-time clients.
+func SocketSubscriber(b *realtime.Broadcaster) Handler {
+	return func(event Event) {
+		switch e := event.(type) {
+		case OccupancyChanged:
+			if e.Update != nil {
+				b.BroadcastDeviceEvent(e.DeviceID, "parking-event", e.Update)
+			}
+		case KeepaliveReceived:
+			if e.Update != nil {
+				b.BroadcastDeviceEvent(e.DeviceID, "keepalive-event", e.Update)
+				helpers.LogInfo("Broadcasted keepalive event for device %s", e.DeviceID)
+			}
+		case SettingsReported:
+			if e.Update != nil {
+				b.BroadcastDeviceEvent(e.DeviceID, "settings-event", e.Update)
+				helpers.LogInfo("Broadcasted settings event for device %s", e.DeviceID)
+			}
+		case ViolationRaised:
+			b.BroadcastDeviceEvent(e.Violation.DeviceID, "violation-event", violationPayload("raised", e.Violation, e.RuleName))
+		case ViolationCleared:
+			b.BroadcastDeviceEvent(e.Violation.DeviceID, "violation-event", violationPayload("cleared", e.Violation, e.RuleName))
+		}
+	}
+}
+
+// ZoneSubscriber keeps the zone counts in Redis live and broadcasts `zone-occupancy-event`
+// for every zone whose occupancy changed.
+func ZoneSubscriber(c *cache.RedisCache, b *realtime.Broadcaster) Handler {
+	return func(event Event) {
+		e, ok := event.(OccupancyChanged)
+		if !ok || e.Update == nil {
+			return
+		}
+
+		zoneIDs, err := c.GetDeviceZones(e.DeviceID)
+		if err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to load zones of device %s from cache", e.DeviceID))
+			return
+		}
+
+		isOccupied := cache.IsOccupiedValue(e.Update["is_occupied"])
+		var changed []int
+		for _, zoneID := range zoneIDs {
+			ok, err := c.SetZoneOccupancy(zoneID, e.DeviceID, isOccupied)
+			if err != nil {
+				helpers.LogError(err, fmt.Sprintf("Failed to update zone %d for device %s", zoneID, e.DeviceID))
+				continue
+			}
+			if ok {
+				changed = append(changed, zoneID)
+			}
+		}
+		if len(changed) == 0 {
+			return
+		}
+
+		counts, err := c.GetZoneCounts(changed)
+		if err != nil {
+			helpers.LogError(err, "Failed to load zone counts from cache")
+			return
+		}
+
+		for _, zoneID := range changed {
+			zone, err := c.GetZone(zoneID)
+			if err != nil || zone == nil {
+				helpers.LogError(err, fmt.Sprintf("Failed to load zone %d from cache", zoneID))
+				continue
+			}
+
+			zone["bays"] = counts[zoneID].Bays
+			zone["occupied"] = counts[zoneID].Occupied
+			zone["free"] = max(counts[zoneID].Bays-counts[zoneID].Occupied, 0)
+			zone["device_id"] = e.DeviceID
+			zone["happened_at"] = e.Update["happened_at"]
+			b.BroadcastToNamespace("/", "zone-occupancy-event", zone)
+		}
+	}
+}
+
+// BatterySubscriber caches the battery level of a device's newest keepalive with the device, for the device feeds.
+func BatterySubscriber(c *cache.RedisCache) Handler {
+	return func(event Event) {
+		e, ok := event.(KeepaliveReceived)
+		if !ok || e.Update == nil || len(e.Packages) == 0 {
+			return
+		}
+
+		// The first package is the newest, the one keepalive_at was taken from.
+		battery, ok := e.Packages[0]["battery_percentage"]
+		if !ok || battery == nil {
+			return
+		}
+
+		if err := c.UpdateDeviceFields(e.DeviceID, map[string]any{"battery_percentage": battery}); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to cache the battery level of device %s", e.DeviceID))
+		}
+	}
+}
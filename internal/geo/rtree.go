@@ -0,0 +1,251 @@
+// Package geo provides an in-memory spatial index for the device and bay locations.
+package geo
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// nodeCapacity is the maximum number of entries of a tree node.
+const nodeCapacity = 16
+
+// earthRadius is the mean radius of the earth in meters.
+const earthRadius = 6371008.8
+
+// Item is a point stored in the tree.
+type Item struct {
+	ID        string
+	Latitude  float64
+	Longitude float64
+}
+
+// Rect is a latitude/longitude bounding box.
+type Rect struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+// Contains reports whether the point is inside the box, edges included.
+func (r Rect) Contains(lat, lng float64) bool {
+	return lat >= r.MinLatitude && lat <= r.MaxLatitude && lng >= r.MinLongitude && lng <= r.MaxLongitude
+}
+
+func (r Rect) intersects(o Rect) bool {
+	return r.MinLatitude <= o.MaxLatitude && o.MinLatitude <= r.MaxLatitude &&
+		r.MinLongitude <= o.MaxLongitude && o.MinLongitude <= r.MaxLongitude
+}
+
+func (r Rect) extend(o Rect) Rect {
+	return Rect{
+		MinLatitude:  math.Min(r.MinLatitude, o.MinLatitude),
+		MinLongitude: math.Min(r.MinLongitude, o.MinLongitude),
+		MaxLatitude:  math.Max(r.MaxLatitude, o.MaxLatitude),
+		MaxLongitude: math.Max(r.MaxLongitude, o.MaxLongitude),
+	}
+}
+
+// distance returns the distance in meters from the point to the nearest point of the box.
+// Clamping the point into the box is exact for points and close enough at city scale for boxes.
+func (r Rect) distance(lat, lng float64) float64 {
+	return Distance(lat, lng,
+		math.Max(r.MinLatitude, math.Min(lat, r.MaxLatitude)),
+		math.Max(r.MinLongitude, math.Min(lng, r.MaxLongitude)))
+}
+
+// Distance returns the great-circle distance in meters between two points.
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	rLat1, rLat2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dLat, dLng := rLat2-rLat1, (lng2-lng1)*math.Pi/180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rLat1)*math.Cos(rLat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// -----------------------------------------------------------------------------
+
+type node struct {
+	rect     Rect
+	children []*node // Nil for leaves
+	items    []Item
+}
+
+// RTree is a static R-tree of points, bulk loaded with Sort-Tile-Recursive packing.
+// It is rebuilt rather than updated, so it may be read concurrently.
+type RTree struct {
+	root *node
+	size int
+}
+
+// NewRTree builds a tree of the items.
+func NewRTree(items []Item) *RTree {
+	t := &RTree{size: len(items)}
+	if len(items) == 0 {
+		return t
+	}
+
+	sorted := make([]Item, len(items))
+	copy(sorted, items)
+
+	// Pack the items into leaves.
+	var level []*node
+	tile(len(sorted),
+		func(i, j int) bool { return sorted[i].Longitude < sorted[j].Longitude },
+		func(i, j int) bool { return sorted[i].Latitude < sorted[j].Latitude },
+		func(i, j int) { sorted[i], sorted[j] = sorted[j], sorted[i] },
+		func(from, to int) {
+			leaf := &node{items: sorted[from:to]}
+			leaf.rect = pointRect(leaf.items[0])
+			for _, item := range leaf.items[1:] {
+				leaf.rect = leaf.rect.extend(pointRect(item))
+			}
+			level = append(level, leaf)
+		})
+
+	// Pack each level into the next until a single root remains.
+	for len(level) > 1 {
+		children := level
+		level = nil
+		tile(len(children),
+			func(i, j int) bool { return centerLongitude(children[i].rect) < centerLongitude(children[j].rect) },
+			func(i, j int) bool { return centerLatitude(children[i].rect) < centerLatitude(children[j].rect) },
+			func(i, j int) { children[i], children[j] = children[j], children[i] },
+			func(from, to int) {
+				parent := &node{children: children[from:to], rect: children[from].rect}
+				for _, child := range parent.children[1:] {
+					parent.rect = parent.rect.extend(child.rect)
+				}
+				level = append(level, parent)
+			})
+	}
+	t.root = level[0]
+
+	return t
+}
+
+// tile sorts n entries into vertical slices by longitude, each slice by latitude,
+// and hands runs of up to nodeCapacity entries to pack.
+func tile(n int, lessLng, lessLat func(i, j int) bool, swap func(i, j int), pack func(from, to int)) {
+	nodes := (n + nodeCapacity - 1) / nodeCapacity
+	sliceSize := int(math.Ceil(math.Sqrt(float64(nodes)))) * nodeCapacity
+
+	sort.Sort(sorter{n: n, less: lessLng, swap: swap})
+	for start := 0; start < n; start += sliceSize {
+		end := min(start+sliceSize, n)
+		sort.Sort(sorter{
+			n:    end - start,
+			less: func(i, j int) bool { return lessLat(start+i, start+j) },
+			swap: func(i, j int) { swap(start+i, start+j) },
+		})
+		for from := start; from < end; from += nodeCapacity {
+			pack(from, min(from+nodeCapacity, end))
+		}
+	}
+}
+
+type sorter struct {
+	n    int
+	less func(i, j int) bool
+	swap func(i, j int)
+}
+
+func (s sorter) Len() int           { return s.n }
+func (s sorter) Less(i, j int) bool { return s.less(i, j) }
+func (s sorter) Swap(i, j int)      { s.swap(i, j) }
+
+func pointRect(item Item) Rect {
+	return Rect{item.Latitude, item.Longitude, item.Latitude, item.Longitude}
+}
+
+func centerLatitude(r Rect) float64  { return (r.MinLatitude + r.MaxLatitude) / 2 }
+func centerLongitude(r Rect) float64 { return (r.MinLongitude + r.MaxLongitude) / 2 }
+
+// Len returns the number of items in the tree.
+func (t *RTree) Len() int {
+	return t.size
+}
+
+// Search calls fn for each item inside the box until fn returns false.
+func (t *RTree) Search(box Rect, fn func(item Item) bool) {
+	if t.root != nil {
+		search(t.root, box, fn)
+	}
+}
+
+func search(n *node, box Rect, fn func(item Item) bool) bool {
+	if !n.rect.intersects(box) {
+		return true
+	}
+	if n.children == nil {
+		for _, item := range n.items {
+			if box.Contains(item.Latitude, item.Longitude) && !fn(item) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, child := range n.children {
+		if !search(child, box, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// Nearest calls fn for the items within maxMeters of the point, nearest first,
+// until fn returns false. A maxMeters of 0 does not limit the distance.
+func (t *RTree) Nearest(lat, lng, maxMeters float64, fn func(item Item, meters float64) bool) {
+	if t.root == nil {
+		return
+	}
+	if maxMeters <= 0 {
+		maxMeters = math.Inf(1)
+	}
+
+	// Best-first search: nodes are expanded in order of their distance, so items
+	// leave the queue in order of theirs.
+	queue := &entryQueue{{node: t.root, meters: t.root.rect.distance(lat, lng)}}
+	for queue.Len() > 0 {
+		e := heap.Pop(queue).(entry)
+		if e.meters > maxMeters {
+			return
+		}
+
+		switch {
+		case e.node == nil:
+			if !fn(e.item, e.meters) {
+				return
+			}
+		case e.node.children == nil:
+			for _, item := range e.node.items {
+				heap.Push(queue, entry{item: item, meters: Distance(lat, lng, item.Latitude, item.Longitude)})
+			}
+		default:
+			for _, child := range e.node.children {
+				heap.Push(queue, entry{node: child, meters: child.rect.distance(lat, lng)})
+			}
+		}
+	}
+}
+
+// entry is a node or, when node is nil, an item queued by Nearest.
+type entry struct {
+	node   *node
+	item   Item
+	meters float64
+}
+
+type entryQueue []entry
+
+func (q entryQueue) Len() int           { return len(q) }
+func (q entryQueue) Less(i, j int) bool { return q[i].meters < q[j].meters }
+func (q entryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *entryQueue) Push(x any)        { *q = append(*q, x.(entry)) }
+func (q *entryQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
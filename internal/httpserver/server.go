@@ -8,12 +8,15 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/middleware"
 	"github.com/foxcodenine/iot-parking-gateway/internal/api/rest/routes"
 	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
 	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/realtime"
 
 	// "github.com/go-chi/chi/v5"
 	socketio "github.com/googollee/go-socket.io"
@@ -28,7 +31,13 @@ import (
 type Server struct {
 	HTTPServer   *http.Server
 	SocketServer *socketio.Server
+	Broadcaster  *realtime.Broadcaster
 	Port         string
+
+	// OnSocketRestart is called with the new Socket.IO server once it was restarted.
+	OnSocketRestart func(*socketio.Server)
+
+	mu sync.RWMutex // Guards SocketServer, which is replaced on restart
 }
 
 // allowOriginFunc checks if the request's origin is allowed based on cached settings.
@@ -59,23 +68,28 @@ func allowOriginFunc(r *http.Request) bool {
 // NewHttpServer initializes a new HTTP server on the specified port with routes configured.
 func NewHttpServer(port string) *Server {
 
-	socketServer := createSocketServer()
+	s := &Server{
+		SocketServer: createSocketServer(),
+		Port:         port,
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/socket.io/", socketServer)
+	// Serve through the current Socket.IO server, so that requests reach it after a restart.
+	mux.HandleFunc("/socket.io/", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		socketServer := s.SocketServer
+		s.mu.RUnlock()
+		socketServer.ServeHTTP(w, r)
+	})
 	mux.Handle("/", routes.Routes())
 
 	// ------------------------------------------
-	srv := &http.Server{
+	s.HTTPServer = &http.Server{
 		Addr:    fmt.Sprintf(":%s", port),
 		Handler: mux,
 	}
 
-	return &Server{
-		HTTPServer:   srv,
-		SocketServer: socketServer,
-		Port:         port,
-	}
+	return s
 }
 
 // Start begins running the HTTP server in a separate goroutine to allow it to listen for incoming requests without blocking the main thread.
@@ -106,16 +120,27 @@ func (s *Server) RestartSocketServer() {
 	}
 
 	// Reinitialize the Socket.IO server
+	s.mu.Lock()
 	s.SocketServer = createSocketServer()
+	s.mu.Unlock()
 
 	s.registerSocketHandlers()
 
 	// Restart Socket.IO server serve
+	socketServer := s.SocketServer
 	go func() {
-		if err := s.SocketServer.Serve(); err != nil {
+		if err := socketServer.Serve(); err != nil {
 			helpers.LogError(err, "Error starting Socket.IO server after restart")
 		}
 	}()
+
+	// Point the broadcasts to the new server.
+	if s.Broadcaster != nil {
+		s.Broadcaster.SetServer(socketServer)
+	}
+	if s.OnSocketRestart != nil {
+		s.OnSocketRestart(socketServer)
+	}
 	helpers.LogInfo("Socket.IO server restarted successfully.")
 }
 
@@ -130,14 +155,70 @@ func (s *Server) registerSocketHandlers() {
 	}()
 
 	s.SocketServer.OnConnect("/", func(s socketio.Conn) error {
-		helpers.LogInfo("Connected ID: %s", s.ID())
+		// Require a valid JWT, validated the same way as the REST API.
+		claims, _, err := middleware.ValidateToken(socketToken(s))
+		if err != nil {
+			helpers.LogInfo("Rejected socket connection ID: %s, Reason: %s", s.ID(), err.Error())
+			return err
+		}
+
+		session := &realtime.Session{Claims: claims}
+		s.SetContext(session)
+
+		// Receive all events of the devices the user may see until the client narrows its subscription.
+		s.Join(session.GroupRoom())
+
+		helpers.LogInfo("Connected ID: %s, User ID: %d", s.ID(), claims.UserID)
 		return nil
 	})
 
+	// Replaces the connection's subscriptions with the requested group, devices and favorites.
+	// Devices the user is not entitled to are skipped; the joined rooms are returned as the ack.
+	s.SocketServer.OnEvent("/", "subscribe", func(s socketio.Conn, req subscribeRequest) []string {
+		session := realtime.SessionFromConn(s)
+		if session == nil {
+			return nil
+		}
+
+		for _, room := range s.Rooms() {
+			if room != s.ID() {
+				s.Leave(room)
+			}
+		}
+
+		if req.Group {
+			s.Join(session.GroupRoom())
+		}
+		if req.Favorites {
+			s.Join(session.FavoritesRoom())
+		}
+		for _, deviceID := range req.Devices {
+			if realtime.CanAccessDevice(cache.AppCache, session, deviceID) {
+				s.Join(realtime.DeviceRoom(deviceID))
+			}
+		}
+
+		rooms := []string{}
+		for _, room := range s.Rooms() {
+			if room != s.ID() {
+				rooms = append(rooms, room)
+			}
+		}
+		return rooms
+	})
+
 	s.SocketServer.OnEvent("/", "update", func(s socketio.Conn, msg string) {
 		helpers.LogInfo("Received update: %s", msg)
 	})
 
+	// Replays the events broadcast after the client's last seen sequence number.
+	s.SocketServer.OnEvent("/", "replay", func(conn socketio.Conn, req replayRequest) realtime.ReplayResult {
+		if realtime.SessionFromConn(conn) == nil || s.Broadcaster == nil {
+			return realtime.ReplayResult{Status: realtime.ReplayResyncRequired}
+		}
+		return s.Broadcaster.Replay(conn, req.LastSeq)
+	})
+
 	s.SocketServer.OnDisconnect("/", func(s socketio.Conn, reason string) {
 		helpers.LogInfo("Disconnected ID: %s, Reason: %s", s.ID(), reason)
 	})
@@ -155,6 +236,28 @@ func (s *Server) registerSocketHandlers() {
 	})
 }
 
+// subscribeRequest is the payload of the "subscribe" socket event.
+type subscribeRequest struct {
+	Group     bool     `json:"group"`
+	Favorites bool     `json:"favorites"`
+	Devices   []string `json:"devices"`
+}
+
+// replayRequest is the payload of the "replay" socket event.
+type replayRequest struct {
+	LastSeq int64 `json:"last_seq"`
+}
+
+// socketToken returns the JWT sent with the handshake, either as the `token` query
+// parameter or as a bearer token in the Authorization header.
+func socketToken(s socketio.Conn) string {
+	u := s.URL()
+	if token := u.Query().Get("token"); token != "" {
+		return token
+	}
+	return strings.TrimPrefix(s.RemoteHeader().Get("Authorization"), "Bearer ")
+}
+
 func createSocketServer() *socketio.Server {
 	return socketio.NewServer(&engineio.Options{
 		Transports: []transport.Transport{
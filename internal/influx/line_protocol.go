@@ -0,0 +1,96 @@
+package influx
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point is a single InfluxDB data point.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]any
+	Time        time.Time
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringFieldEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// LineProtocol encodes the point in InfluxDB line protocol with second precision.
+// Tags and fields are sorted by key, as recommended by InfluxDB for write performance.
+func (p Point) LineProtocol() (string, error) {
+	if len(p.Fields) == 0 {
+		return "", fmt.Errorf("point %s has no fields", p.Measurement)
+	}
+
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+
+	tagKeys := make([]string, 0, len(p.Tags))
+	for k, v := range p.Tags {
+		if v == "" {
+			continue // Empty tag values are not allowed in line protocol
+		}
+		tagKeys = append(tagKeys, k)
+	}
+	sort.Strings(tagKeys)
+	for _, k := range tagKeys {
+		b.WriteString(",")
+		b.WriteString(tagEscaper.Replace(k))
+		b.WriteString("=")
+		b.WriteString(tagEscaper.Replace(p.Tags[k]))
+	}
+
+	fieldKeys := make([]string, 0, len(p.Fields))
+	for k := range p.Fields {
+		fieldKeys = append(fieldKeys, k)
+	}
+	sort.Strings(fieldKeys)
+
+	b.WriteString(" ")
+	for i, k := range fieldKeys {
+		value, err := formatField(p.Fields[k])
+		if err != nil {
+			return "", fmt.Errorf("field %s: %w", k, err)
+		}
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(tagEscaper.Replace(k))
+		b.WriteString("=")
+		b.WriteString(value)
+	}
+
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(p.Time.Unix(), 10))
+
+	return b.String(), nil
+}
+
+// formatField formats a field value according to its line protocol type.
+func formatField(value any) (string, error) {
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v) + "i", nil
+	case int64:
+		return strconv.FormatInt(v, 10) + "i", nil
+	case float64:
+		// JSON numbers decode as float64; whole numbers are written as integers to keep field types stable.
+		if v == float64(int64(v)) {
+			return strconv.FormatInt(int64(v), 10) + "i", nil
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		return `"` + stringFieldEscaper.Replace(v) + `"`, nil
+	default:
+		return "", fmt.Errorf("unsupported field type %T", value)
+	}
+}
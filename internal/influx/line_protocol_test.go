@@ -0,0 +1,121 @@
+package influx
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+)
+
+func TestMain(m *testing.M) {
+	helpers.ConfigLogger()
+	os.Exit(m.Run())
+}
+
+func TestFormatField(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"bool true", true, "true"},
+		{"bool false", false, "false"},
+		{"int", 42, "42i"},
+		{"int64", int64(-7), "-7i"},
+		{"whole float", 3.0, "3i"},
+		{"fractional float", 3.25, "3.25"},
+		{"string", "hello", `"hello"`},
+		{"string with quote", `say "hi"`, `"say \"hi\""`},
+		{"string with backslash", `C:\temp`, `"C:\\temp"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatField(tt.value)
+			if err != nil {
+				t.Fatalf("formatField(%v) returned error: %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("formatField(%v) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatFieldUnsupported(t *testing.T) {
+	if _, err := formatField([]int{1}); err == nil {
+		t.Error("formatField([]int) returned no error")
+	}
+}
+
+func TestLineProtocol(t *testing.T) {
+	at := time.Unix(1700000000, 0).UTC()
+
+	tests := []struct {
+		name  string
+		point Point
+		want  string
+	}{
+		{
+			name: "sorted tags and fields",
+			point: Point{
+				Measurement: "occupancy",
+				Tags:        map[string]string{"network_type": "LoRa", "device_id": "02DF9902"},
+				Fields:      map[string]any{"is_occupied": true, "beacons_amount": 2.0},
+				Time:        at,
+			},
+			want: "occupancy,device_id=02DF9902,network_type=LoRa beacons_amount=2i,is_occupied=true 1700000000",
+		},
+		{
+			name: "escaped measurement, tags and field keys",
+			point: Point{
+				Measurement: "car park,level 1",
+				Tags:        map[string]string{"zone name": "a=b,c"},
+				Fields:      map[string]any{"free bays": 3.5},
+				Time:        at,
+			},
+			want: `car\ park\,level\ 1,zone\ name=a\=b\,c free\ bays=3.5 1700000000`,
+		},
+		{
+			name: "empty tag values are left out",
+			point: Point{
+				Measurement: "keepalive",
+				Tags:        map[string]string{"device_id": "1", "network_type": ""},
+				Fields:      map[string]any{"rssi_average": -80.0},
+				Time:        at,
+			},
+			want: "keepalive,device_id=1 rssi_average=-80i 1700000000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.point.LineProtocol()
+			if err != nil {
+				t.Fatalf("LineProtocol() returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("LineProtocol() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		point Point
+	}{
+		{"no fields", Point{Measurement: "occupancy", Fields: map[string]any{}}},
+		{"unsupported field", Point{Measurement: "occupancy", Fields: map[string]any{"beacons": []any{}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.point.LineProtocol(); err == nil {
+				t.Error("LineProtocol() returned no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package influx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/streadway/amqp"
+)
+
+// Event IDs used in the event_logs messages.
+const (
+	keepaliveEventID = 6
+	parkingEventID   = 26
+)
+
+// keepaliveFields lists the keepalive metrics written to the keepalive measurement.
+var keepaliveFields = []string{"battery_percentage", "idle_voltage", "rssi_average", "temperature_min", "temperature_max"}
+
+// Sink converts decoded packages from the influxdb_event_logs queue into InfluxDB points.
+type Sink struct {
+	writer *Writer
+}
+
+// NewSink creates a new InfluxDB sink.
+func NewSink(w *Writer) *Sink {
+	return &Sink{writer: w}
+}
+
+// HandleDelivery buffers a single event_logs message for the next InfluxDB batch.
+func (s *Sink) HandleDelivery(delivery amqp.Delivery) error {
+	var pkg map[string]any
+	if err := json.Unmarshal(delivery.Body, &pkg); err != nil {
+		// A malformed message will never succeed, so log it and drop it instead of redelivering.
+		helpers.LogError(err, "Invalid message on influxdb_event_logs queue")
+		return nil
+	}
+
+	point, ok, err := PackageToPoint(pkg)
+	if err != nil {
+		helpers.LogError(err, "Failed to convert package to InfluxDB point")
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	return s.writer.Write(point)
+}
+
+// PackageToPoint maps a parking or keepalive package to an InfluxDB point.
+// It returns false for package types that are not written to InfluxDB.
+func PackageToPoint(pkg map[string]any) (Point, bool, error) {
+	deviceID, ok := pkg["device_id"].(string)
+	if !ok || deviceID == "" {
+		return Point{}, false, errors.New("missing or invalid device_id in package")
+	}
+
+	timestamp, ok := pkg["timestamp"].(float64)
+	if !ok {
+		return Point{}, false, fmt.Errorf("missing or invalid timestamp for device %s", deviceID)
+	}
+
+	networkType, _ := pkg["network_type"].(string)
+	firmwareVersion, _ := pkg["firmware_version"].(float64)
+
+	point := Point{
+		Tags: map[string]string{
+			"device_id":        deviceID,
+			"network_type":     networkType,
+			"firmware_version": fmt.Sprintf("%.2f", firmwareVersion),
+		},
+		Fields: map[string]any{},
+		Time:   time.Unix(int64(timestamp), 0).UTC(),
+	}
+
+	eventID, _ := pkg["event_id"].(float64)
+
+	switch int(eventID) {
+	case parkingEventID:
+		point.Measurement = "occupancy"
+		if isOccupied, ok := pkg["is_occupied"].(float64); ok {
+			point.Fields["is_occupied"] = isOccupied != 0
+		}
+		if beaconsAmount, ok := pkg["beacons_amount"].(float64); ok {
+			point.Fields["beacons_amount"] = beaconsAmount
+		}
+
+	case keepaliveEventID:
+		point.Measurement = "keepalive"
+		for _, key := range keepaliveFields {
+			if value, ok := pkg[key].(float64); ok {
+				point.Fields[key] = value
+			}
+		}
+
+	default:
+		return Point{}, false, nil
+	}
+
+	if len(point.Fields) == 0 {
+		return Point{}, false, nil
+	}
+
+	return point, true, nil
+}
@@ -0,0 +1,81 @@
+package influx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPackageToPoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		pkg     map[string]any
+		want    string
+		ok      bool
+		wantErr bool
+	}{
+		{
+			name: "parking event",
+			pkg: map[string]any{
+				"device_id": "02DF9902", "network_type": "LoRa", "firmware_version": 5.8,
+				"timestamp": 1700000000.0, "event_id": 26.0, "is_occupied": 1.0, "beacons_amount": 2.0,
+			},
+			want: "occupancy,device_id=02DF9902,firmware_version=5.80,network_type=LoRa beacons_amount=2i,is_occupied=true 1700000000",
+			ok:   true,
+		},
+		{
+			name: "keepalive event",
+			pkg: map[string]any{
+				"device_id": "860123", "network_type": "NB-IoT", "firmware_version": 5.3,
+				"timestamp": 1700000000.0, "event_id": 6.0, "battery_percentage": 87.0, "idle_voltage": 3.61,
+				"rssi_average": -91.0, "temperature_min": 12.0, "temperature_max": 24.5,
+			},
+			want: "keepalive,device_id=860123,firmware_version=5.30,network_type=NB-IoT " +
+				"battery_percentage=87i,idle_voltage=3.61,rssi_average=-91i,temperature_max=24.5,temperature_min=12i 1700000000",
+			ok: true,
+		},
+		{
+			name: "other event",
+			pkg:  map[string]any{"device_id": "02DF9902", "timestamp": 1700000000.0, "event_id": 1.0},
+		},
+		{
+			name: "parking event without fields",
+			pkg:  map[string]any{"device_id": "02DF9902", "timestamp": 1700000000.0, "event_id": 26.0},
+		},
+		{
+			name:    "missing device",
+			pkg:     map[string]any{"timestamp": 1700000000.0, "event_id": 26.0, "is_occupied": 1.0},
+			wantErr: true,
+		},
+		{
+			name:    "missing timestamp",
+			pkg:     map[string]any{"device_id": "02DF9902", "event_id": 26.0, "is_occupied": 1.0},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			point, ok, err := PackageToPoint(tt.pkg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PackageToPoint() error = %v, want error %t", err, tt.wantErr)
+			}
+			if ok != tt.ok {
+				t.Fatalf("PackageToPoint() ok = %t, want %t", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+
+			if !point.Time.Equal(time.Unix(1700000000, 0)) {
+				t.Errorf("PackageToPoint() time = %s", point.Time)
+			}
+			got, err := point.LineProtocol()
+			if err != nil {
+				t.Fatalf("LineProtocol() returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("PackageToPoint() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
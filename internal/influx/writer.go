@@ -0,0 +1,236 @@
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+)
+
+// Config holds the InfluxDB connection and batching settings.
+type Config struct {
+	Enabled       bool
+	URL           string        // Base URL of the InfluxDB HTTP API, e.g. http://influxdb:8086
+	Org           string        // InfluxDB 2.x organisation
+	Bucket        string        // InfluxDB 2.x bucket
+	Database      string        // InfluxDB 1.x database, used instead of Org/Bucket when set
+	Token         string        // API token (2.x) or "user:password" (1.x)
+	BatchSize     int           // Number of lines that triggers an immediate flush
+	FlushInterval time.Duration // Maximum time a line waits in the buffer
+	MaxBuffer     int           // Maximum number of lines kept while InfluxDB is unreachable
+}
+
+// SetupInfluxConfig builds the InfluxDB configuration from environment variables.
+func SetupInfluxConfig() Config {
+	batchSize, err := strconv.Atoi(os.Getenv("INFLUXDB_BATCH_SIZE"))
+	if err != nil || batchSize <= 0 {
+		batchSize = 500
+	}
+
+	flushSeconds, err := strconv.Atoi(os.Getenv("INFLUXDB_FLUSH_INTERVAL"))
+	if err != nil || flushSeconds <= 0 {
+		flushSeconds = 10
+	}
+
+	maxBuffer, err := strconv.Atoi(os.Getenv("INFLUXDB_MAX_BUFFER"))
+	if err != nil || maxBuffer < batchSize {
+		maxBuffer = batchSize * 100
+	}
+
+	return Config{
+		Enabled:       os.Getenv("INFLUXDB_ENABLED") == "true",
+		URL:           strings.TrimRight(os.Getenv("INFLUXDB_URL"), "/"),
+		Org:           os.Getenv("INFLUXDB_ORG"),
+		Bucket:        os.Getenv("INFLUXDB_BUCKET"),
+		Database:      os.Getenv("INFLUXDB_DATABASE"),
+		Token:         os.Getenv("INFLUXDB_TOKEN"),
+		BatchSize:     batchSize,
+		FlushInterval: time.Duration(flushSeconds) * time.Second,
+		MaxBuffer:     maxBuffer,
+	}
+}
+
+// Writer buffers line protocol entries and writes them to InfluxDB in batches.
+// Lines that fail to be written because of a network error or a server error stay in the buffer and
+// are retried on the next flush; batches that InfluxDB rejects are dropped.
+type Writer struct {
+	config     Config
+	httpClient *http.Client
+	buffer     []string
+	dropped    int // Total lines dropped from the head of the buffer
+	mu         sync.Mutex
+	flushMu    sync.Mutex
+	flushCh    chan struct{}
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+}
+
+// NewWriter creates a new InfluxDB writer.
+func NewWriter(config Config) *Writer {
+	return &Writer{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		flushCh:    make(chan struct{}, 1),
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+}
+
+// Run flushes the buffer on every interval tick or when a full batch is waiting.
+func (w *Writer) Run() {
+	defer close(w.doneCh)
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Flush()
+		case <-w.flushCh:
+			w.Flush()
+		case <-w.shutdownCh:
+			w.Flush() // Final flush on shutdown
+			return
+		}
+	}
+}
+
+// Close stops the flush loop after a final flush.
+func (w *Writer) Close() {
+	close(w.shutdownCh)
+	<-w.doneCh
+}
+
+// Write adds a point to the buffer.
+func (w *Writer) Write(p Point) error {
+	line, err := p.LineProtocol()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.buffer = append(w.buffer, line)
+
+	// Drop the oldest lines when InfluxDB has been unreachable for too long.
+	if overflow := len(w.buffer) - w.config.MaxBuffer; overflow > 0 {
+		w.buffer = w.buffer[overflow:]
+		w.dropped += overflow
+		helpers.LogError(nil, fmt.Sprintf("InfluxDB buffer full, dropped %d oldest points", overflow))
+	}
+	full := len(w.buffer) >= w.config.BatchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.flushCh <- struct{}{}:
+		default: // A flush is already pending
+		}
+	}
+
+	return nil
+}
+
+// Flush writes the buffered lines in batches. It stops at the first batch that fails to be written,
+// keeping that batch and everything after it for the next attempt. A rejected batch is dropped, so
+// that a bad line cannot hold back the lines after it.
+func (w *Writer) Flush() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	for {
+		w.mu.Lock()
+		n := min(len(w.buffer), w.config.BatchSize)
+		batch := make([]string, n)
+		copy(batch, w.buffer[:n])
+		droppedBefore := w.dropped
+		w.mu.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		var rejected *rejectedError
+		if err := w.send(batch); errors.As(err, &rejected) {
+			helpers.LogError(err, fmt.Sprintf("InfluxDB rejected %d points, dropping them", n))
+		} else if err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to write %d points to InfluxDB, keeping them buffered", n))
+			return
+		}
+
+		// Lines from this batch may already have been dropped by an overflow while sending.
+		w.mu.Lock()
+		if sent := n - (w.dropped - droppedBefore); sent > 0 {
+			w.buffer = w.buffer[sent:]
+		}
+		w.mu.Unlock()
+	}
+}
+
+// rejectedError is returned when InfluxDB refuses a batch with a client error, e.g. a malformed line or a
+// field type conflict, which retrying the same batch cannot fix.
+type rejectedError struct {
+	status string
+	body   string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("InfluxDB responded with %s: %s", e.status, e.body)
+}
+
+// send posts a batch of lines to the InfluxDB write endpoint.
+func (w *Writer) send(lines []string) error {
+	body := strings.Join(lines, "\n")
+
+	req, err := http.NewRequest(http.MethodPost, w.writeURL(), bytes.NewBufferString(body))
+	if err != nil {
+		return fmt.Errorf("failed to create InfluxDB request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.config.Token)
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		body := strings.TrimSpace(string(msg))
+
+		// Client errors are final, except for rate limiting which clears up by itself.
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return &rejectedError{status: resp.Status, body: body}
+		}
+		return fmt.Errorf("InfluxDB responded with %s: %s", resp.Status, body)
+	}
+
+	return nil
+}
+
+// writeURL returns the 1.x or 2.x write endpoint depending on the configuration.
+func (w *Writer) writeURL() string {
+	params := url.Values{}
+	params.Set("precision", "s")
+
+	if w.config.Database != "" {
+		params.Set("db", w.config.Database)
+		return fmt.Sprintf("%s/write?%s", w.config.URL, params.Encode())
+	}
+
+	params.Set("org", w.config.Org)
+	params.Set("bucket", w.config.Bucket)
+	return fmt.Sprintf("%s/api/v2/write?%s", w.config.URL, params.Encode())
+}
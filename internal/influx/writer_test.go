@@ -0,0 +1,240 @@
+package influx
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubInflux is an InfluxDB write endpoint that records the batches it receives and answers with
+// the status returned by respond, 204 when respond is nil.
+type stubInflux struct {
+	mu      sync.Mutex
+	batches [][]string
+	queries []string
+	auth    []string
+	respond func(batch int) int
+	server  *httptest.Server
+}
+
+func newStubInflux(t *testing.T, respond func(batch int) int) *stubInflux {
+	stub := &stubInflux{respond: respond}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		stub.mu.Lock()
+		batch := len(stub.batches)
+		stub.batches = append(stub.batches, strings.Split(string(body), "\n"))
+		stub.queries = append(stub.queries, r.URL.Path+"?"+r.URL.RawQuery)
+		stub.auth = append(stub.auth, r.Header.Get("Authorization"))
+		stub.mu.Unlock()
+
+		status := http.StatusNoContent
+		if stub.respond != nil {
+			status = stub.respond(batch)
+		}
+		w.WriteHeader(status)
+		if status != http.StatusNoContent {
+			fmt.Fprint(w, `{"code":"invalid","message":"stub error"}`)
+		}
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (s *stubInflux) received() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.batches...)
+}
+
+func newTestWriter(url string, batchSize int) *Writer {
+	return NewWriter(Config{
+		Enabled:       true,
+		URL:           url,
+		Org:           "parking",
+		Bucket:        "events",
+		Token:         "secret",
+		BatchSize:     batchSize,
+		FlushInterval: time.Hour,
+		MaxBuffer:     batchSize * 10,
+	})
+}
+
+func writePoints(t *testing.T, w *Writer, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		p := Point{
+			Measurement: "occupancy",
+			Tags:        map[string]string{"device_id": fmt.Sprintf("D%d", i)},
+			Fields:      map[string]any{"is_occupied": true},
+			Time:        time.Unix(1700000000, 0),
+		}
+		if err := w.Write(p); err != nil {
+			t.Fatalf("Write() returned error: %v", err)
+		}
+	}
+}
+
+func TestWriterFlushBatches(t *testing.T) {
+	stub := newStubInflux(t, nil)
+	w := newTestWriter(stub.server.URL, 2)
+
+	writePoints(t, w, 5)
+	w.Flush()
+
+	batches := stub.received()
+	if len(batches) != 3 {
+		t.Fatalf("got %d batches, want 3", len(batches))
+	}
+	for i, want := range []int{2, 2, 1} {
+		if len(batches[i]) != want {
+			t.Errorf("batch %d has %d lines, want %d", i, len(batches[i]), want)
+		}
+	}
+	if batches[0][0] != "occupancy,device_id=D0 is_occupied=true 1700000000" {
+		t.Errorf("unexpected first line %q", batches[0][0])
+	}
+	if len(w.buffer) != 0 {
+		t.Errorf("buffer has %d lines after a successful flush, want 0", len(w.buffer))
+	}
+
+	if stub.queries[0] != "/api/v2/write?bucket=events&org=parking&precision=s" {
+		t.Errorf("unexpected write endpoint %s", stub.queries[0])
+	}
+	if stub.auth[0] != "Token secret" {
+		t.Errorf("unexpected Authorization header %q", stub.auth[0])
+	}
+}
+
+func TestWriterV1Endpoint(t *testing.T) {
+	stub := newStubInflux(t, nil)
+	w := newTestWriter(stub.server.URL, 2)
+	w.config.Database = "parking"
+
+	writePoints(t, w, 1)
+	w.Flush()
+
+	if stub.queries[0] != "/write?db=parking&precision=s" {
+		t.Errorf("unexpected write endpoint %s", stub.queries[0])
+	}
+}
+
+func TestWriterKeepsBatchesOnServerError(t *testing.T) {
+	failing := true
+	var mu sync.Mutex
+	stub := newStubInflux(t, func(int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusNoContent
+	})
+	w := newTestWriter(stub.server.URL, 2)
+
+	writePoints(t, w, 3)
+	w.Flush()
+
+	if got := len(stub.received()); got != 1 {
+		t.Fatalf("got %d requests while InfluxDB is down, want 1: the flush stops at the first failure", got)
+	}
+	if len(w.buffer) != 3 {
+		t.Fatalf("buffer has %d lines after a failed flush, want 3", len(w.buffer))
+	}
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	w.Flush()
+
+	batches := stub.received()
+	if len(batches) != 3 {
+		t.Fatalf("got %d requests, want 3", len(batches))
+	}
+	if len(batches[1]) != 2 || len(batches[2]) != 1 {
+		t.Errorf("retried batches have %d and %d lines, want 2 and 1", len(batches[1]), len(batches[2]))
+	}
+	if len(w.buffer) != 0 {
+		t.Errorf("buffer has %d lines after the retry, want 0", len(w.buffer))
+	}
+}
+
+func TestWriterKeepsBatchesWhenRateLimited(t *testing.T) {
+	stub := newStubInflux(t, func(int) int { return http.StatusTooManyRequests })
+	w := newTestWriter(stub.server.URL, 2)
+
+	writePoints(t, w, 2)
+	w.Flush()
+
+	if len(w.buffer) != 2 {
+		t.Errorf("buffer has %d lines after being rate limited, want 2", len(w.buffer))
+	}
+}
+
+func TestWriterDropsRejectedBatches(t *testing.T) {
+	// The first batch is rejected, the others are accepted.
+	stub := newStubInflux(t, func(batch int) int {
+		if batch == 0 {
+			return http.StatusBadRequest
+		}
+		return http.StatusNoContent
+	})
+	w := newTestWriter(stub.server.URL, 2)
+
+	writePoints(t, w, 3)
+	w.Flush()
+
+	batches := stub.received()
+	if len(batches) != 2 {
+		t.Fatalf("got %d requests, want 2: the rejected batch must not hold back the next one", len(batches))
+	}
+	if batches[1][0] != "occupancy,device_id=D2 is_occupied=true 1700000000" {
+		t.Errorf("unexpected line after the rejected batch %q", batches[1][0])
+	}
+	if len(w.buffer) != 0 {
+		t.Errorf("buffer has %d lines, want 0: rejected lines are dropped", len(w.buffer))
+	}
+}
+
+func TestWriterDropsOldestLinesWhenFull(t *testing.T) {
+	w := newTestWriter("http://127.0.0.1:0", 2)
+	w.config.MaxBuffer = 3
+
+	writePoints(t, w, 5)
+
+	if len(w.buffer) != 3 || w.dropped != 2 {
+		t.Fatalf("buffer has %d lines with %d dropped, want 3 and 2", len(w.buffer), w.dropped)
+	}
+	if !strings.HasPrefix(w.buffer[0], "occupancy,device_id=D2 ") {
+		t.Errorf("oldest kept line is %q, want D2", w.buffer[0])
+	}
+}
+
+func TestWriterRunFlushesFullBatch(t *testing.T) {
+	stub := newStubInflux(t, nil)
+	w := newTestWriter(stub.server.URL, 2)
+
+	go w.Run()
+	writePoints(t, w, 2)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(stub.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(stub.received()) != 1 {
+		t.Fatal("a full batch was not flushed before the interval")
+	}
+
+	// Close flushes what is left.
+	writePoints(t, w, 1)
+	w.Close()
+	if got := len(stub.received()); got != 2 {
+		t.Errorf("got %d requests after Close, want 2", got)
+	}
+}
@@ -220,3 +220,37 @@ func (a *ActivityLog) GetActivityLogs(deviceID string, fromDate, toDate int64) (
 
 	return logs, nil
 }
+
+// GetBayActivityLogs returns the activity logs of a bay in [fromDate, toDate], taken from each device
+// while it was assigned to the bay, so the history continues across sensor replacements.
+func (a *ActivityLog) GetBayActivityLogs(bayID int, fromDate, toDate int64) ([]*ActivityLog, error) {
+	if fromDate <= 0 || toDate <= 0 {
+		return nil, helpers.WrapError(fmt.Errorf("invalid timestamps: both fromDate and toDate must be greater than zero"))
+	}
+	if fromDate > toDate {
+		return nil, helpers.WrapError(fmt.Errorf("fromDate cannot be greater than toDate"))
+	}
+
+	fromTime := time.Unix(fromDate, 0).UTC()
+	toTime := time.Unix(toDate, 0).UTC()
+
+	var logs []*ActivityLog
+
+	err := dbSession.Collection(a.TableName()).
+		Find(up.Raw(`happened_at BETWEEN ? AND ? AND EXISTS (
+			SELECT 1 FROM parking.bay_assignments ba
+			WHERE ba.bay_id = ? AND ba.device_id = activity_logs.device_id
+				AND activity_logs.happened_at >= ba.assigned_from
+				AND (ba.assigned_to IS NULL OR activity_logs.happened_at < ba.assigned_to)
+		)`, fromTime, toTime, bayID)).
+		OrderBy("happened_at ASC").
+		All(&logs)
+	if err != nil {
+		if err == up.ErrNoMoreRows {
+			return nil, nil
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to fetch activity logs of bay %d: %w", bayID, err))
+	}
+
+	return logs, nil
+}
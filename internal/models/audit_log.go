@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
 )
 
 // AuditLog represents a single entry in the audit_logs table.
@@ -141,3 +142,19 @@ func (a *AuditLog) BulkInsert(auditLog []AuditLog) error {
 
 	return nil
 }
+
+// GetBetween returns the audit log entries in [from, to), oldest first, up to limit entries.
+func (a *AuditLog) GetBetween(from, to time.Time, limit int) ([]*AuditLog, error) {
+	var logs []*AuditLog
+
+	err := dbSession.Collection(a.TableName()).
+		Find(up.Cond{"happened_at >=": from.UTC(), "happened_at <": to.UTC()}).
+		OrderBy("happened_at", "id").
+		Limit(limit).
+		All(&logs)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve audit logs: %w", err))
+	}
+
+	return logs, nil
+}
@@ -0,0 +1,342 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// BayTypes lists the supported bay types.
+var BayTypes = map[string]bool{"standard": true, "disabled": true, "ev": true, "loading": true, "motorcycle": true}
+
+// Assignment errors.
+var (
+	ErrBayHasDevice     = errors.New("bay already has a device")
+	ErrBayHasNoDevice   = errors.New("bay has no device")
+	ErrDeviceHasBay     = errors.New("device is already assigned to a bay")
+	ErrAssignmentBefore = errors.New("the time is before the current assignment started")
+	ErrAssignmentAfter  = errors.New("the time is before an earlier assignment of the bay or device ended")
+)
+
+// Bay is a parking space. It keeps its history when the device monitoring it is replaced.
+type Bay struct {
+	ID        int        `db:"id,omitempty" json:"id"`
+	Code      string     `db:"code" json:"code"`
+	ZoneID    *int       `db:"zone_id" json:"zone_id"`   // Lot, level or zone the bay is in
+	BayType   string     `db:"bay_type" json:"bay_type"` // standard, disabled, ev, loading or motorcycle
+	Latitude  float64    `db:"latitude" json:"latitude"`
+	Longitude float64    `db:"longitude" json:"longitude"`
+	DeviceID  *string    `db:"-" json:"device_id"`   // Device monitoring the bay, nil when unassigned
+	DeviceAt  *time.Time `db:"-" json:"assigned_at"` // Start of the current assignment
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// BayAssignment records that a device monitored a bay during [AssignedFrom, AssignedTo).
+type BayAssignment struct {
+	ID           int64      `db:"id,omitempty" json:"id"`
+	BayID        int        `db:"bay_id" json:"bay_id"`
+	DeviceID     string     `db:"device_id" json:"device_id"`
+	AssignedFrom time.Time  `db:"assigned_from" json:"assigned_from"`
+	AssignedTo   *time.Time `db:"assigned_to" json:"assigned_to"` // Nil while the device monitors the bay
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
+
+// BayFilter holds the optional filters of a bay query.
+type BayFilter struct {
+	ZoneIDs []int
+	BayType string
+}
+
+// TableName returns the full table name for the Bay model in PostgreSQL.
+func (b *Bay) TableName() string {
+	return "parking.bays"
+}
+
+// TableName returns the full table name for the BayAssignment model in PostgreSQL.
+func (a *BayAssignment) TableName() string {
+	return "parking.bay_assignments"
+}
+
+// -----------------------------------------------------------------------------
+
+// Validate checks the bay's code, type and location.
+func (b *Bay) Validate() error {
+	if b.Code == "" {
+		return errors.New("code cannot be empty")
+	}
+	if len(b.Code) > 50 {
+		return errors.New("code must be at most 50 characters")
+	}
+	if !BayTypes[b.BayType] {
+		return fmt.Errorf("invalid bay_type '%s', must be standard, disabled, ev, loading or motorcycle", b.BayType)
+	}
+	if !validCoordinates(b.Latitude, b.Longitude) {
+		return errors.New("latitude or longitude is out of range")
+	}
+	return nil
+}
+
+// baySelectSQL selects bays with the device currently assigned to them.
+const baySelectSQL = `
+	SELECT b.id, b.code, b.zone_id, b.bay_type, COALESCE(b.latitude, 0)::DOUBLE PRECISION, COALESCE(b.longitude, 0)::DOUBLE PRECISION,
+		a.device_id, a.assigned_from, b.created_at, b.updated_at
+	FROM parking.bays b
+	LEFT JOIN parking.bay_assignments a ON a.bay_id = b.id AND a.assigned_to IS NULL
+`
+
+// queryBays runs baySelectSQL with the conditions and scans the bays.
+func queryBays(conditions []string, args ...interface{}) ([]*Bay, error) {
+	query := baySelectSQL
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY b.code, b.id"
+
+	rows, err := dbSession.SQL().Query(query, args...)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve bays: %w", err))
+	}
+	defer rows.Close()
+
+	bays := []*Bay{}
+	for rows.Next() {
+		var b Bay
+		err := rows.Scan(&b.ID, &b.Code, &b.ZoneID, &b.BayType, &b.Latitude, &b.Longitude, &b.DeviceID, &b.DeviceAt, &b.CreatedAt, &b.UpdatedAt)
+		if err != nil {
+			return nil, helpers.WrapError(fmt.Errorf("failed to scan bay: %w", err))
+		}
+		bays = append(bays, &b)
+	}
+
+	return bays, rows.Err()
+}
+
+// GetAll retrieves the bays that match the filter with their current devices.
+func (b *Bay) GetAll(filter BayFilter) ([]*Bay, error) {
+	var conditions []string
+	var args []interface{}
+
+	if len(filter.ZoneIDs) > 0 {
+		args = append(args, filter.ZoneIDs)
+		conditions = append(conditions, fmt.Sprintf("b.zone_id = ANY($%d)", len(args)))
+	}
+	if filter.BayType != "" {
+		args = append(args, filter.BayType)
+		conditions = append(conditions, fmt.Sprintf("b.bay_type = $%d", len(args)))
+	}
+
+	return queryBays(conditions, args...)
+}
+
+// GetByID retrieves a single bay with its current device.
+func (b *Bay) GetByID(id int) (*Bay, error) {
+	bays, err := queryBays([]string{"b.id = $1"}, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(bays) == 0 {
+		return nil, errors.New("bay not found")
+	}
+
+	return bays[0], nil
+}
+
+// Create inserts a new bay into the database and returns it.
+func (b *Bay) Create(bay *Bay) (*Bay, error) {
+	now := time.Now().UTC()
+	bay.CreatedAt = now
+	bay.UpdatedAt = now
+
+	err := dbSession.Collection(b.TableName()).InsertReturning(bay)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to create bay: %w", err))
+	}
+
+	return bay, nil
+}
+
+// Update saves the code, zone, type and location of an existing bay.
+func (b *Bay) Update(bay *Bay) (*Bay, error) {
+	res := dbSession.Collection(b.TableName()).Find(up.Cond{"id": bay.ID})
+	count, err := res.Count()
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking bay existence: %w", err))
+	}
+	if count == 0 {
+		return nil, errors.New("bay not found")
+	}
+
+	err = res.Update(map[string]interface{}{
+		"code":       bay.Code,
+		"zone_id":    bay.ZoneID,
+		"bay_type":   bay.BayType,
+		"latitude":   bay.Latitude,
+		"longitude":  bay.Longitude,
+		"updated_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error updating bay: %w", err))
+	}
+
+	return b.GetByID(bay.ID)
+}
+
+// DeleteByID deletes a bay with its assignment history.
+func (b *Bay) DeleteByID(id int) error {
+	res := dbSession.Collection(b.TableName()).Find(up.Cond{"id": id})
+	count, err := res.Count()
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("error checking bay existence: %w", err))
+	}
+	if count == 0 {
+		return errors.New("bay not found")
+	}
+
+	if err := res.Delete(); err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to delete bay: %w", err))
+	}
+
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// GetByBay retrieves the assignment history of a bay, newest first.
+func (a *BayAssignment) GetByBay(bayID int) ([]*BayAssignment, error) {
+	var assignments []*BayAssignment
+
+	err := dbSession.Collection(a.TableName()).Find(up.Cond{"bay_id": bayID}).OrderBy("-assigned_from", "-id").All(&assignments)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve assignments of bay %d: %w", bayID, err))
+	}
+
+	return assignments, nil
+}
+
+// Assign opens an assignment of the device to the bay from at.
+func (a *BayAssignment) Assign(bayID int, deviceID string, at time.Time) (*BayAssignment, error) {
+	var assignment *BayAssignment
+	err := dbSession.Tx(func(sess up.Session) error {
+		var err error
+		assignment, err = openAssignment(sess, bayID, deviceID, at)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return assignment, nil
+}
+
+// Unassign closes the open assignment of the bay at at.
+func (a *BayAssignment) Unassign(bayID int, at time.Time) (*BayAssignment, error) {
+	var closed *BayAssignment
+	err := dbSession.Tx(func(sess up.Session) error {
+		var err error
+		closed, err = closeAssignment(sess, bayID, at)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return closed, nil
+}
+
+// Replace closes the open assignment of the bay and assigns the new device from the same time,
+// so the history of the bay continues with the new device.
+func (a *BayAssignment) Replace(bayID int, deviceID string, at time.Time) (closed, opened *BayAssignment, err error) {
+	err = dbSession.Tx(func(sess up.Session) error {
+		var err error
+		if closed, err = closeAssignment(sess, bayID, at); err != nil {
+			return err
+		}
+		opened, err = openAssignment(sess, bayID, deviceID, at)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return closed, opened, nil
+}
+
+// openAssignment inserts an open assignment after checking that neither the bay nor the device has one,
+// and that it does not start before an earlier assignment of either ended.
+func openAssignment(sess up.Session, bayID int, deviceID string, at time.Time) (*BayAssignment, error) {
+	collection := sess.Collection("parking.bay_assignments")
+
+	count, err := collection.Find(up.Cond{"bay_id": bayID, "assigned_to IS": nil}).Count()
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking assignment of bay %d: %w", bayID, err))
+	}
+	if count > 0 {
+		return nil, ErrBayHasDevice
+	}
+
+	count, err = collection.Find(up.Cond{"device_id": deviceID, "assigned_to IS": nil}).Count()
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking assignment of device %s: %w", deviceID, err))
+	}
+	if count > 0 {
+		return nil, ErrDeviceHasBay
+	}
+
+	// A backdated assignment must not overlap the closed assignments of the bay or the device,
+	// or their history would be counted twice.
+	var lastEnded sql.NullTime
+	row, err := sess.SQL().QueryRow(`
+		SELECT MAX(assigned_to) FROM parking.bay_assignments WHERE bay_id = $1 OR device_id = $2
+	`, bayID, deviceID)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking assignment history of bay %d: %w", bayID, err))
+	}
+	if err := row.Scan(&lastEnded); err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking assignment history of bay %d: %w", bayID, err))
+	}
+	if lastEnded.Valid && at.Before(lastEnded.Time) {
+		return nil, ErrAssignmentAfter
+	}
+
+	assignment := &BayAssignment{
+		BayID:        bayID,
+		DeviceID:     deviceID,
+		AssignedFrom: at.UTC(),
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := collection.InsertReturning(assignment); err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to assign device %s to bay %d: %w", deviceID, bayID, err))
+	}
+
+	return assignment, nil
+}
+
+// closeAssignment ends the open assignment of the bay at at.
+func closeAssignment(sess up.Session, bayID int, at time.Time) (*BayAssignment, error) {
+	res := sess.Collection("parking.bay_assignments").Find(up.Cond{"bay_id": bayID, "assigned_to IS": nil})
+
+	var assignment BayAssignment
+	if err := res.One(&assignment); err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, ErrBayHasNoDevice
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve assignment of bay %d: %w", bayID, err))
+	}
+	if at.Before(assignment.AssignedFrom) {
+		return nil, ErrAssignmentBefore
+	}
+
+	assignedTo := at.UTC()
+	if err := res.Update(map[string]interface{}{"assigned_to": assignedTo}); err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to close assignment of bay %d: %w", bayID, err))
+	}
+	assignment.AssignedTo = &assignedTo
+
+	return &assignment, nil
+}
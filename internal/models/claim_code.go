@@ -0,0 +1,175 @@
+package models
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// claimCodeAlphabet leaves out 0, 1, I and O, which are easily misread on a label.
+const claimCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// ClaimCode is the code printed on the label of a device. An installer redeems it on site to
+// register the device at its location.
+type ClaimCode struct {
+	Code        string     `db:"code" json:"code"` // eg: K7QM-3HXD-W9TP
+	DeviceID    string     `db:"device_id" json:"device_id"`
+	NetworkType string     `db:"network_type" json:"network_type"`
+	Batch       string     `db:"batch" json:"batch"`
+	ClaimedBy   *int       `db:"claimed_by" json:"claimed_by"` // Nil while unclaimed
+	ClaimedAt   *time.Time `db:"claimed_at" json:"claimed_at"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// TableName returns the full table name for the ClaimCode model in PostgreSQL.
+func (c *ClaimCode) TableName() string {
+	return "parking.claim_codes"
+}
+
+// NewClaimCode generates a random code of three groups of four characters.
+func NewClaimCode() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate claim code: %w", err)
+	}
+
+	var code strings.Builder
+	for i, v := range b {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(claimCodeAlphabet[int(v)%len(claimCodeAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// NormalizeClaimCode formats a code as typed by an installer, ignoring case, spaces and dashes.
+func NormalizeClaimCode(code string) string {
+	var chars []rune
+	for _, r := range strings.ToUpper(code) {
+		if r != ' ' && r != '-' {
+			chars = append(chars, r)
+		}
+	}
+
+	var normalized strings.Builder
+	for i, r := range chars {
+		if i > 0 && i%4 == 0 {
+			normalized.WriteByte('-')
+		}
+		normalized.WriteRune(r)
+	}
+	return normalized.String()
+}
+
+// -----------------------------------------------------------------------------
+
+// GetAll retrieves the claim codes of a batch, or all of them when batch is empty.
+func (c *ClaimCode) GetAll(batch string) ([]*ClaimCode, error) {
+	var codes []*ClaimCode
+
+	cond := up.Cond{}
+	if batch != "" {
+		cond["batch"] = batch
+	}
+
+	collection := dbSession.Collection(c.TableName())
+	err := collection.Find(cond).OrderBy("batch", "device_id").All(&codes)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve claim codes from database: %w", err))
+	}
+
+	return codes, nil
+}
+
+// GetByCode retrieves a single claim code.
+func (c *ClaimCode) GetByCode(code string) (*ClaimCode, error) {
+	collection := dbSession.Collection(c.TableName())
+
+	var claim ClaimCode
+	err := collection.Find(up.Cond{"code": code}).One(&claim)
+	if err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, errors.New("claim code not found")
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve claim code: %w", err))
+	}
+
+	return &claim, nil
+}
+
+// CreateBatch inserts the claim codes of a batch in a single transaction.
+// A device can only have one code, so nothing is inserted when any of the devices already has one.
+func (c *ClaimCode) CreateBatch(codes []*ClaimCode) error {
+	now := time.Now().UTC()
+
+	return dbSession.Tx(func(sess up.Session) error {
+		collection := sess.Collection(c.TableName())
+
+		for _, code := range codes {
+			code.CreatedAt = now
+			code.UpdatedAt = now
+
+			if _, err := collection.Insert(code); err != nil {
+				if strings.Contains(err.Error(), "SQLSTATE 23505") {
+					return fmt.Errorf("device %s already has a claim code", code.DeviceID)
+				}
+				return helpers.WrapError(fmt.Errorf("failed to create claim code for device %s: %w", code.DeviceID, err))
+			}
+		}
+		return nil
+	})
+}
+
+// Claim marks a code as redeemed by the user. It fails when the code was redeemed in the meantime.
+func (c *ClaimCode) Claim(code string, userID int) (*ClaimCode, error) {
+	res, err := dbSession.SQL().Exec(`
+		UPDATE parking.claim_codes SET claimed_by = $1, claimed_at = $2
+		WHERE code = $3 AND claimed_at IS NULL
+	`, userID, time.Now().UTC(), code)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to claim code: %w", err))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errors.New("claim code already redeemed")
+	}
+
+	return c.GetByCode(code)
+}
+
+// Release makes a claimed code redeemable again, when the registration it was claimed for failed.
+func (c *ClaimCode) Release(code string) error {
+	collection := dbSession.Collection(c.TableName())
+
+	err := collection.Find(up.Cond{"code": code}).Update(map[string]interface{}{
+		"claimed_by": nil,
+		"claimed_at": nil,
+	})
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to release claim code: %w", err))
+	}
+	return nil
+}
+
+// DeleteByCode revokes a claim code that has not been redeemed.
+func (c *ClaimCode) DeleteByCode(code string) error {
+	claim, err := c.GetByCode(code)
+	if err != nil {
+		return err
+	}
+	if claim.ClaimedAt != nil {
+		return errors.New("claim code already redeemed")
+	}
+
+	collection := dbSession.Collection(c.TableName())
+	if err := collection.Find(up.Cond{"code": code}).Delete(); err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to delete claim code: %w", err))
+	}
+	return nil
+}
@@ -2,9 +2,11 @@ package models
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -29,9 +31,13 @@ type Device struct {
 	KeepaliveAt     time.Time      `db:"keepalive_at" json:"keepalive_at"`
 	SettingsAt      time.Time      `db:"settings_at" json:"settings_at"`
 	IsOccupied      bool           `db:"is_occupied" json:"is_occupied"`
-	IsAllowed       bool           `db:"is_allowed" json:"is_allowed"` // Indicates if the device is allowed
-	IsBlocked       bool           `db:"is_blocked" json:"is_blocked"` // Indicates if the device is blocked
-	IsHidden        bool           `db:"is_hidden" json:"is_hidden"`   // Indicates if the device is hidden
+	IsAllowed       bool           `db:"is_allowed" json:"is_allowed"`             // Indicates if the device is allowed
+	IsBlocked       bool           `db:"is_blocked" json:"is_blocked"`             // Indicates if the device is blocked
+	IsHidden        bool           `db:"is_hidden" json:"is_hidden"`               // Indicates if the device is hidden
+	Tags            DeviceTags     `db:"tags" json:"tags"`                         // Custom metadata, see TagDefinition
+	LifecycleState  string         `db:"lifecycle_state" json:"lifecycle_state"`   // See LifecycleStates
+	PreviousState   *string        `db:"previous_state" json:"previous_state"`     // State a deleted device is restored to
+	PreviousBlocked *bool          `db:"previous_blocked" json:"previous_blocked"` // Blocking a deleted device is restored with
 	CreatedAt       time.Time      `db:"created_at" json:"created_at"`
 	UpdatedAt       time.Time      `db:"updated_at" json:"updated_at"`
 	DeletedAt       time.Time      `db:"deleted_at,omitempty" json:"deleted_at,omitempty"`
@@ -145,6 +151,11 @@ func (d *Device) Create(newDevice *Device) (*Device, error) {
 	newDevice.CreatedAt = now
 	newDevice.UpdatedAt = now
 
+	// Devices created from their traffic are in service straight away.
+	if newDevice.LifecycleState == "" {
+		newDevice.LifecycleState = StateActive
+	}
+
 	_, err := collection.Insert(newDevice)
 	if err != nil {
 		// Check if the error is a duplicate key violation (PostgreSQL SQL state 23505)
@@ -185,83 +196,145 @@ func (d *Device) Create(newDevice *Device) (*Device, error) {
 	return newDevice, nil
 }
 
-// Upsert inserts a new device or updates specific fields if the device already exists.
+// deviceUpsertSQL inserts a device or, if it already exists, applies the given SET assignments and
+// restores it in its previous state when it was soft deleted.
+const deviceUpsertSQL = `
+	INSERT INTO parking.devices (
+		device_id, name, network_type, firmware_version, latitude, longitude, beacons, 
+		happened_at, is_occupied, is_allowed, is_blocked, is_hidden, created_at, updated_at, deleted_at, tags, lifecycle_state
+	) VALUES (
+		$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,  $14, NULL, $15::jsonb, $16
+	)
+	ON CONFLICT (device_id) DO UPDATE SET
+		%s
+		tags = parking.devices.tags || EXCLUDED.tags, -- Upserts add tags, they never remove them
+		lifecycle_state = CASE WHEN parking.devices.lifecycle_state = 'deleted'
+			THEN COALESCE(parking.devices.previous_state, 'active') ELSE parking.devices.lifecycle_state END,
+		previous_state = NULL,
+		previous_blocked = NULL,
+		deleted_at = NULL,
+		updated_at = EXCLUDED.updated_at;
+`
+
+// restoreBlockedSQL keeps the blocking of an existing device, restoring the one it had before it was deleted.
+const restoreBlockedSQL = `is_blocked = CASE WHEN parking.devices.lifecycle_state = 'deleted'
+			THEN COALESCE(parking.devices.previous_blocked, false) ELSE parking.devices.is_blocked END`
+
+// UpsertColumns are the columns an upsert can take from the new details of an existing device.
+var UpsertColumns = []string{"name", "network_type", "firmware_version", "latitude", "longitude", "is_allowed", "is_blocked", "is_hidden"}
+
+// Upsert inserts a new device or, if the device already exists, clears its allowed, blocked and hidden flags.
 func (d *Device) Upsert(device *Device) (*Device, error) {
-	collection := dbSession.Collection(d.TableName())
+	set := []string{"is_allowed = false", "is_blocked = false", "is_hidden = false"}
 
-	// Prepare the upsert query with positional placeholders
-	sqlQuery := `
-		INSERT INTO parking.devices (
-			device_id, name, network_type, firmware_version, latitude, longitude, beacons, 
-			happened_at, is_occupied, is_allowed, is_blocked, is_hidden, created_at, updated_at, deleted_at
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13,  $14, NULL
-		)
-		ON CONFLICT (device_id) DO UPDATE SET
-			is_allowed = false,
-			is_blocked = false,
-			is_hidden = false,
-			deleted_at = NULL,
-			updated_at = EXCLUDED.updated_at;
-	`
-
-	// Prepare the parameter values
-	params := []interface{}{
-		device.DeviceID,
-		device.Name,
-		device.NetworkType,
-		device.FirmwareVersion,
-		device.Latitude,
-		device.Longitude,
-		device.BeaconsJSON,
-		device.HappenedAt,
-		device.IsOccupied,
-		device.IsAllowed,
-		device.IsBlocked,
-		device.IsHidden,
-		time.Now().UTC(),
-		time.Now().UTC(),
-	}
-
-	// Execute the query
-	_, err := collection.Session().SQL().Exec(sqlQuery, params...)
+	devices, err := d.upsertMany([]*Device{device}, [][]string{set})
 	if err != nil {
-		return nil, fmt.Errorf("failed to upsert device: %w", err)
+		return nil, err
 	}
 
-	// Fetch the updated device from the database
-	upsertDevice, err := d.GetByID(device.DeviceID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch updated device: %w", err)
+	return devices[0], nil
+}
+
+// UpsertMany upserts the devices in a single transaction, so that either all or none are saved,
+// and caches them once the transaction commits. An existing device only takes the columns listed
+// for it, out of UpsertColumns; its other details are kept.
+func (d *Device) UpsertMany(devices []*Device, columns [][]string) ([]*Device, error) {
+	sets := make([][]string, len(devices))
+	for i := range devices {
+		for _, column := range columns[i] {
+			if !slices.Contains(UpsertColumns, column) {
+				return nil, fmt.Errorf("column '%s' cannot be upserted", column)
+			}
+			sets[i] = append(sets[i], fmt.Sprintf("%s = EXCLUDED.%s", column, column))
+		}
 	}
+	return d.upsertMany(devices, sets)
+}
 
-	// Convert the updated device struct to a map
-	deviceData, err := helpers.StructToMap(upsertDevice)
+// upsertMany upserts the devices, applying the SET assignments of each device when it already exists.
+func (d *Device) upsertMany(devices []*Device, sets [][]string) ([]*Device, error) {
+	err := dbSession.Tx(func(sess up.Session) error {
+		now := time.Now().UTC()
+		for i, device := range devices {
+			// Devices created ahead of their installation become active with their first message.
+			state := device.LifecycleState
+			if state == "" {
+				state = StateProvisioned
+			}
+
+			set := sets[i]
+			if !slices.ContainsFunc(set, func(s string) bool { return strings.HasPrefix(s, "is_blocked ") }) {
+				set = append(slices.Clone(set), restoreBlockedSQL)
+			}
+			assignments := strings.Join(set, ",\n\t\t") + ","
+
+			// Prepare the parameter values
+			params := []interface{}{
+				device.DeviceID,
+				device.Name,
+				device.NetworkType,
+				device.FirmwareVersion,
+				device.Latitude,
+				device.Longitude,
+				device.BeaconsJSON,
+				device.HappenedAt,
+				device.IsOccupied,
+				device.IsAllowed,
+				device.IsBlocked,
+				device.IsHidden,
+				now,
+				now,
+				device.Tags,
+				state,
+			}
+
+			if _, err := sess.SQL().Exec(fmt.Sprintf(deviceUpsertSQL, assignments), params...); err != nil {
+				return fmt.Errorf("failed to upsert device %s: %w", device.DeviceID, err)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert device data: %w", err)
+		return nil, err
 	}
 
-	if _, ok := deviceData["keepalive_at"]; !ok {
+	upserted := make([]*Device, 0, len(devices))
+	for _, device := range devices {
+		// Fetch the updated device from the database
+		upsertDevice, err := d.GetByID(device.DeviceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch updated device: %w", err)
+		}
 
-		deviceData["keepalive_at"] = "0001-01-01T00:00:00Z"
+		if err := cacheDevice(upsertDevice); err != nil {
+			return nil, err
+		}
+
+		upserted = append(upserted, upsertDevice)
 	}
 
-	if _, ok := deviceData["settings_at"]; !ok {
+	return upserted, nil
+}
 
-		deviceData["settings_at"] = "0001-01-01T00:00:00Z"
+// cacheDevice saves the whole device to the cache, with unset timestamps as the zero time.
+func cacheDevice(device *Device) error {
+	// Convert the device struct to a map
+	deviceData, err := helpers.StructToMap(device)
+	if err != nil {
+		return fmt.Errorf("failed to convert device data: %w", err)
 	}
-	if _, ok := deviceData["happened_at"]; !ok {
 
-		deviceData["happened_at"] = "0001-01-01T00:00:00Z"
+	for _, field := range []string{"keepalive_at", "settings_at", "happened_at"} {
+		if _, ok := deviceData[field]; !ok {
+			deviceData[field] = "0001-01-01T00:00:00Z"
+		}
 	}
 
 	// Save the device data to the cache
-	if err := cache.AppCache.SaveDeviceData(upsertDevice.DeviceID, deviceData); err != nil {
-		return nil, fmt.Errorf("failed to cache device data: %w", err)
+	if err := cache.AppCache.SaveDeviceData(device.DeviceID, deviceData); err != nil {
+		return fmt.Errorf("failed to cache device data: %w", err)
 	}
-
-	return upsertDevice, nil
-
+	return nil
 }
 
 // -----------------------------------------------------------------------------
@@ -330,39 +403,10 @@ func (d *Device) DeleteByID(id string) error {
 }
 
 // SoftDeleteByID marks a device as deleted by setting the `deleted_at` field to the current timestamp.
+// It moves the device to the deleted lifecycle state, see SetState.
 func (d *Device) SoftDeleteByID(id string) error {
-	collection := dbSession.Collection(d.TableName())
-
-	// Check if the device exists
-	res := collection.Find(up.Cond{"device_id": id})
-	count, err := res.Count()
-	if err != nil {
-		return fmt.Errorf("error checking device existence: %w", err)
-	}
-
-	// If no matching device is found, return a custom error
-	if count == 0 {
-		return fmt.Errorf("device with ID %s not found", id)
-	}
-
-	// Prepare the updated fields for soft deletion
-	updatedFields := map[string]interface{}{
-		"deleted_at": time.Now().UTC(),
-		"updated_at": time.Now().UTC(),
-		"is_blocked": true,
-	}
-
-	// Perform the soft deletion
-	err = res.Update(updatedFields)
-	if err != nil {
-		return fmt.Errorf("error soft-deleting device: %w", err)
-	}
-
-	if err := cache.AppCache.UpdateDeviceFields(id, updatedFields); err != nil {
-		return fmt.Errorf("failed to soft delete device data in cache: %w", err)
-	}
-
-	return nil // Successful soft deletion
+	_, err := d.SetState(id, StateDeleted)
+	return err
 }
 
 // -----------------------------------------------------------------------------
@@ -390,7 +434,6 @@ func (d *Device) BulkUpdateDevices(deviceData []Device) error {
 			firmware_version = v.firmware_version,
 			beacons = v.beacons,
 			happened_at = v.happened_at,
-			deleted_at = NULL,
 			is_occupied = v.is_occupied,
 			updated_at = NOW()
 		FROM (VALUES
@@ -486,3 +529,217 @@ func (d *Device) BulkUpdateDevicesSettings(deviceData []Device) error {
 
 	return nil // Return nil if the operation was successful.
 }
+
+// -----------------------------------------------------------------------------
+
+// DeviceSortColumns are the timestamp columns devices can be sorted by.
+var DeviceSortColumns = map[string]func(*Device) time.Time{
+	"created_at":   func(d *Device) time.Time { return d.CreatedAt },
+	"updated_at":   func(d *Device) time.Time { return d.UpdatedAt },
+	"happened_at":  func(d *Device) time.Time { return d.HappenedAt },
+	"keepalive_at": func(d *Device) time.Time { return d.KeepaliveAt },
+	"settings_at":  func(d *Device) time.Time { return d.SettingsAt },
+}
+
+// DeviceFilter holds the optional filters, the order and the page of a device search.
+type DeviceFilter struct {
+	Search           string   // Words that must each appear in the name or device ID
+	DeviceIDs        []string // Restricts the search to these devices when not nil, e.g. those of a zone
+	NetworkTypes     []string // Case-insensitive
+	States           []string // Lifecycle states, see LifecycleStates
+	FirmwareVersions []float64
+	Occupied         *bool
+	Hidden           *bool
+	Blocked          *bool
+	Allowed          *bool
+	KeepaliveBefore  time.Time           // Devices last seen before this time, or never seen
+	KeepaliveAfter   time.Time           // Devices last seen at or after this time
+	Tags             map[string][]string // Devices whose tag has one of the values, compared as text
+	HasTags          []string            // Devices that have all of these tags
+	SortBy           string              // One of DeviceSortColumns, created_at by default
+	Descending       bool
+	After            *DeviceCursor // Continues after the last device of the previous page
+	Limit            int           // 0 for every device
+}
+
+// DeviceCursor is the position of a device in a sorted search, handed to clients as an opaque string.
+// It carries the sort so that it cannot be used to continue a differently sorted search.
+type DeviceCursor struct {
+	SortBy     string    `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	Value      time.Time `json:"v"`
+	DeviceID   string    `json:"id"`
+}
+
+// Encode returns the cursor as a URL-safe string.
+func (c *DeviceCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeDeviceCursor parses a cursor returned by Search.
+func DecodeDeviceCursor(value string) (*DeviceCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var cursor DeviceCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.DeviceID == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	if _, ok := DeviceSortColumns[cursor.SortBy]; !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+var escapeLike = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Search returns a page of the devices that match the filter, excluding soft-deleted ones, and the
+// cursor of the next page, which is nil on the last page.
+// Pages are keyed on the sort column and the device ID, so devices added or removed between requests
+// neither shift nor repeat the following pages. Devices never seen sort as the oldest.
+func (d *Device) Search(filter DeviceFilter) ([]*Device, *DeviceCursor, error) {
+	if filter.SortBy == "" {
+		filter.SortBy = "created_at"
+	}
+	sortValue, ok := DeviceSortColumns[filter.SortBy]
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid sort column '%s'", filter.SortBy)
+	}
+	if filter.After != nil && (filter.After.SortBy != filter.SortBy || filter.After.Descending != filter.Descending) {
+		return nil, nil, errors.New("the cursor belongs to a differently sorted search")
+	}
+	if filter.DeviceIDs != nil && len(filter.DeviceIDs) == 0 {
+		return []*Device{}, nil, nil
+	}
+
+	conditions := []up.LogicalExpr{up.Cond{"deleted_at": nil}}
+
+	for _, word := range strings.Fields(filter.Search) {
+		pattern := "%" + escapeLike.Replace(word) + "%"
+		conditions = append(conditions, up.Raw("(name ILIKE ? OR device_id ILIKE ?)", pattern, pattern))
+	}
+	if filter.DeviceIDs != nil {
+		conditions = append(conditions, up.Cond{"device_id IN": filter.DeviceIDs})
+	}
+	if len(filter.NetworkTypes) > 0 {
+		placeholders := make([]string, len(filter.NetworkTypes))
+		args := make([]interface{}, len(filter.NetworkTypes))
+		for i, networkType := range filter.NetworkTypes {
+			placeholders[i] = "?"
+			args[i] = strings.ToLower(networkType)
+		}
+		conditions = append(conditions, up.Raw(fmt.Sprintf("LOWER(network_type) IN (%s)", strings.Join(placeholders, ", ")), args...))
+	}
+	if len(filter.States) > 0 {
+		conditions = append(conditions, up.Cond{"lifecycle_state IN": filter.States})
+	}
+	if len(filter.FirmwareVersions) > 0 {
+		conditions = append(conditions, up.Cond{"firmware_version IN": filter.FirmwareVersions})
+	}
+	if filter.Occupied != nil {
+		conditions = append(conditions, up.Raw("COALESCE(is_occupied, FALSE) = ?", *filter.Occupied))
+	}
+	if filter.Hidden != nil {
+		conditions = append(conditions, up.Cond{"is_hidden": *filter.Hidden})
+	}
+	if filter.Blocked != nil {
+		conditions = append(conditions, up.Cond{"is_blocked": *filter.Blocked})
+	}
+	if filter.Allowed != nil {
+		conditions = append(conditions, up.Cond{"is_allowed": *filter.Allowed})
+	}
+	if !filter.KeepaliveBefore.IsZero() {
+		conditions = append(conditions, up.Or(up.Cond{"keepalive_at IS": nil}, up.Cond{"keepalive_at <": filter.KeepaliveBefore}))
+	}
+	if !filter.KeepaliveAfter.IsZero() {
+		conditions = append(conditions, up.Cond{"keepalive_at >=": filter.KeepaliveAfter})
+	}
+
+	for key, values := range filter.Tags {
+		placeholders := make([]string, len(values))
+		args := []interface{}{key}
+		for i, value := range values {
+			placeholders[i] = "?"
+			args = append(args, value)
+		}
+		conditions = append(conditions, up.Raw(fmt.Sprintf("tags->>? IN (%s)", strings.Join(placeholders, ", ")), args...))
+	}
+	for _, key := range filter.HasTags {
+		conditions = append(conditions, up.Raw("jsonb_exists(tags, ?)", key))
+	}
+
+	// NULL timestamps are compared as the zero time, which is also what they are scanned as.
+	sortExpr := fmt.Sprintf("COALESCE(%s, '0001-01-01 00:00:00'::timestamp)", filter.SortBy)
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if filter.After != nil {
+		conditions = append(conditions, up.Raw(fmt.Sprintf("(%s, device_id) %s (?::timestamp, ?)", sortExpr, comparison),
+			filter.After.Value, filter.After.DeviceID))
+	}
+
+	res := dbSession.Collection(d.TableName()).
+		Find(up.And(conditions...)).
+		OrderBy(up.Raw(fmt.Sprintf("%s %s, device_id %s", sortExpr, direction, direction)))
+	if filter.Limit > 0 {
+		// One more than the page tells whether there is a next page.
+		res = res.Limit(filter.Limit + 1)
+	}
+
+	devices := []*Device{}
+	if err := res.All(&devices); err != nil {
+		return nil, nil, helpers.WrapError(fmt.Errorf("failed to search devices: %w", err))
+	}
+
+	var next *DeviceCursor
+	if filter.Limit > 0 && len(devices) > filter.Limit {
+		devices = devices[:filter.Limit]
+		last := devices[len(devices)-1]
+		next = &DeviceCursor{SortBy: filter.SortBy, Descending: filter.Descending, Value: sortValue(last), DeviceID: last.DeviceID}
+	}
+
+	for _, device := range devices {
+		if err := device.ParseBeaconsJSON(); err != nil {
+			return nil, nil, fmt.Errorf("error parsing BeaconsJSON for device ID %s: %w", device.DeviceID, err)
+		}
+	}
+
+	return devices, next, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// SetTags replaces the tags of several devices in a single transaction, then updates them in the cache.
+func (d *Device) SetTags(tagsByDevice map[string]DeviceTags) error {
+	now := time.Now().UTC()
+
+	err := dbSession.Tx(func(sess up.Session) error {
+		for deviceID, tags := range tagsByDevice {
+			_, err := sess.SQL().Exec(`
+				UPDATE parking.devices SET tags = $1::jsonb, updated_at = $2
+				WHERE device_id = $3 AND deleted_at IS NULL
+			`, tags, now, deviceID)
+			if err != nil {
+				return fmt.Errorf("failed to set the tags of device %s: %w", deviceID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return helpers.WrapError(err)
+	}
+
+	for deviceID, tags := range tagsByDevice {
+		fields := map[string]any{"tags": tags, "updated_at": now}
+		if err := cache.AppCache.UpdateDeviceFields(deviceID, fields); err != nil {
+			return fmt.Errorf("failed to update the tags of device %s in cache: %w", deviceID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+)
+
+// DeviceHealth summarises how a device reported during a period.
+type DeviceHealth struct {
+	DeviceID          string
+	Name              string
+	NetworkType       string
+	FirmwareVersion   float64
+	HappenedAt        *time.Time // Last parking event
+	KeepaliveAt       *time.Time // Last keepalive
+	SettingsAt        *time.Time // Last settings report
+	ParkingEvents     int        // Parking events in the period
+	Keepalives        int        // Keepalives in the period
+	DaysReporting     int        // Days in the period with a parking event or keepalive
+	BatteryPercentage *int       // Last battery level in the period
+	MinBattery        *int       // Lowest battery level in the period
+	IdleVoltage       *int       // Last idle voltage in the period
+}
+
+// GetDeviceHealth summarises the parking events and keepalives of the devices in [from, to).
+// An empty deviceIDs returns every device that is not deleted.
+func (d *DeviceHealth) GetDeviceHealth(from, to time.Time, deviceIDs []string) ([]*DeviceHealth, error) {
+	args := []interface{}{from.UTC(), to.UTC()}
+	deviceFilter := ""
+	if len(deviceIDs) > 0 {
+		args = append(args, deviceIDs)
+		deviceFilter = "AND d.device_id = ANY($3)"
+	}
+
+	keepalives := make([]string, len(keepaliveLogTables))
+	for i, table := range keepaliveLogTables {
+		keepalives[i] = fmt.Sprintf(`SELECT device_id, happened_at, battery_percentage, idle_voltage FROM %s WHERE happened_at >= $1 AND happened_at < $2`, table)
+	}
+
+	query := fmt.Sprintf(`
+		WITH k AS (%s),
+		a AS (
+			SELECT device_id, happened_at FROM parking.activity_logs WHERE happened_at >= $1 AND happened_at < $2
+		),
+		k_stats AS (
+			SELECT device_id, COUNT(*) AS keepalives, MIN(battery_percentage) AS min_battery FROM k GROUP BY device_id
+		),
+		k_last AS (
+			SELECT DISTINCT ON (device_id) device_id, battery_percentage, idle_voltage FROM k ORDER BY device_id, happened_at DESC
+		),
+		a_stats AS (
+			SELECT device_id, COUNT(*) AS events FROM a GROUP BY device_id
+		),
+		reporting AS (
+			SELECT device_id, COUNT(DISTINCT date_trunc('day', happened_at)) AS days
+			FROM (SELECT device_id, happened_at FROM k UNION ALL SELECT device_id, happened_at FROM a) x
+			GROUP BY device_id
+		)
+		SELECT
+			d.device_id,
+			COALESCE(d.name, ''),
+			COALESCE(d.network_type, ''),
+			COALESCE(d.firmware_version, 0)::DOUBLE PRECISION,
+			d.happened_at,
+			d.keepalive_at,
+			d.settings_at,
+			COALESCE(s.events, 0),
+			COALESCE(ks.keepalives, 0),
+			COALESCE(r.days, 0),
+			kl.battery_percentage::INTEGER,
+			ks.min_battery::INTEGER,
+			kl.idle_voltage::INTEGER
+		FROM parking.devices d
+		LEFT JOIN k_stats ks ON ks.device_id = d.device_id
+		LEFT JOIN k_last kl ON kl.device_id = d.device_id
+		LEFT JOIN a_stats s ON s.device_id = d.device_id
+		LEFT JOIN reporting r ON r.device_id = d.device_id
+		WHERE d.deleted_at IS NULL %s
+		ORDER BY d.device_id
+	`, strings.Join(keepalives, " UNION ALL "), deviceFilter)
+
+	rows, err := dbSession.SQL().Query(query, args...)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to summarise device health: %w", err))
+	}
+	defer rows.Close()
+
+	var health []*DeviceHealth
+	for rows.Next() {
+		var h DeviceHealth
+		err := rows.Scan(&h.DeviceID, &h.Name, &h.NetworkType, &h.FirmwareVersion, &h.HappenedAt, &h.KeepaliveAt, &h.SettingsAt,
+			&h.ParkingEvents, &h.Keepalives, &h.DaysReporting, &h.BatteryPercentage, &h.MinBattery, &h.IdleVoltage)
+		if err != nil {
+			return nil, helpers.WrapError(fmt.Errorf("failed to scan device health: %w", err))
+		}
+		health = append(health, &h)
+	}
+
+	return health, rows.Err()
+}
+
+// GetLatestBatteries returns the battery level of the newest keepalive of each device since the given time.
+func (d *DeviceHealth) GetLatestBatteries(since time.Time) (map[string]int, error) {
+	keepalives := make([]string, len(keepaliveLogTables))
+	for i, table := range keepaliveLogTables {
+		keepalives[i] = fmt.Sprintf(`SELECT device_id, happened_at, battery_percentage FROM %s WHERE happened_at >= $1 AND battery_percentage IS NOT NULL`, table)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT DISTINCT ON (device_id) device_id, battery_percentage::INTEGER
+		FROM (%s) k
+		ORDER BY device_id, happened_at DESC
+	`, strings.Join(keepalives, " UNION ALL "))
+
+	rows, err := dbSession.SQL().Query(query, since.UTC())
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve battery levels: %w", err))
+	}
+	defer rows.Close()
+
+	batteries := make(map[string]int)
+	for rows.Next() {
+		var deviceID string
+		var battery int
+		if err := rows.Scan(&deviceID, &battery); err != nil {
+			return nil, helpers.WrapError(fmt.Errorf("failed to scan battery level: %w", err))
+		}
+		batteries[deviceID] = battery
+	}
+
+	return batteries, rows.Err()
+}
@@ -0,0 +1,285 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// Lifecycle states of a device.
+const (
+	StateProvisioned    = "provisioned"    // Created ahead of its installation, it becomes active with its first message
+	StateActive         = "active"         // In service
+	StateMaintenance    = "maintenance"    // Being serviced, its readings are not trusted
+	StateDecommissioned = "decommissioned" // Taken out of service, kept for its history
+	StateDeleted        = "deleted"        // Soft deleted, restorable until it is purged
+)
+
+// LifecycleState defines how a device in a state is handled.
+type LifecycleState struct {
+	Ingest    bool     // Its traffic is processed; otherwise it is ignored
+	Analytics bool     // It counts in the parking sessions, occupancy rollups, capacities and zone counts
+	Next      []string // States it can be moved to. Deleted devices are restored instead.
+}
+
+// LifecycleStates lists the lifecycle states with their ingest behaviour and transitions.
+var LifecycleStates = map[string]LifecycleState{
+	StateProvisioned:    {Ingest: true, Analytics: false, Next: []string{StateActive, StateMaintenance, StateDecommissioned, StateDeleted}},
+	StateActive:         {Ingest: true, Analytics: true, Next: []string{StateMaintenance, StateDecommissioned, StateDeleted}},
+	StateMaintenance:    {Ingest: true, Analytics: false, Next: []string{StateActive, StateDecommissioned, StateDeleted}},
+	StateDecommissioned: {Ingest: false, Analytics: false, Next: []string{StateProvisioned, StateActive, StateDeleted}},
+	StateDeleted:        {Ingest: false, Analytics: false},
+}
+
+// ErrInvalidTransition is returned when a device cannot be moved to the requested state.
+var ErrInvalidTransition = errors.New("invalid lifecycle transition")
+
+// DeviceState returns the lifecycle state of a device, active for devices cached before states were introduced.
+func DeviceState(state string) string {
+	if state == "" {
+		return StateActive
+	}
+	return state
+}
+
+// CanTransition reports whether a device can be moved from one state to another.
+func CanTransition(from, to string) bool {
+	return slices.Contains(LifecycleStates[DeviceState(from)].Next, to)
+}
+
+// AnalyticsStates returns the states of the devices counted in analytics.
+func AnalyticsStates() []string {
+	var states []string
+	for state, s := range LifecycleStates {
+		if s.Analytics {
+			states = append(states, state)
+		}
+	}
+	slices.Sort(states)
+	return states
+}
+
+// devicePurgeTables lists the raw data, log and settings tables of a device, all keyed by device_id.
+// The sessions, rollups, profiles and assignments are the history of the bays the device monitored
+// and outlive it, like they outlive a sensor swap.
+var devicePurgeTables = []string{
+	"parking.raw_data_logs",
+	"parking.activity_logs",
+	"parking.nbiot_keepalive_logs",
+	"parking.nbiot_setting_logs",
+	"parking.nbiot_device_settings",
+	"parking.lora_keepalive_logs",
+	"parking.lora_setting_logs",
+	"parking.lora_device_settings",
+	"parking.sigfox_keepalive_logs",
+	"parking.sigfox_setting_logs",
+	"parking.sigfox_device_settings",
+}
+
+// -----------------------------------------------------------------------------
+
+// GetByIDIncludingDeleted retrieves a single device by its ID, including soft-deleted records.
+func (d *Device) GetByIDIncludingDeleted(id string) (*Device, error) {
+	collection := dbSession.Collection(d.TableName())
+
+	var device Device
+	err := collection.Find(up.Cond{"device_id": id}).One(&device)
+	if err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, errors.New("device not found")
+		}
+		return nil, fmt.Errorf("failed to retrieve device: %w", err)
+	}
+
+	return &device, nil
+}
+
+// GetDeleted retrieves the soft-deleted devices, deleted before the given time when it is not zero,
+// the first deleted first.
+func (d *Device) GetDeleted(before time.Time) ([]*Device, error) {
+	var devices []*Device
+
+	cond := up.Cond{"deleted_at IS NOT": nil}
+	if !before.IsZero() {
+		cond["deleted_at <"] = before
+	}
+
+	collection := dbSession.Collection(d.TableName())
+	err := collection.Find(cond).OrderBy("deleted_at", "device_id").All(&devices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve deleted devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+// SetState moves a device to another lifecycle state, following the transitions of LifecycleStates.
+// Deleting a device soft deletes and blocks it, remembering the state and blocking it is restored to.
+func (d *Device) SetState(id, state string) (*Device, error) {
+	device, err := d.GetByIDIncludingDeleted(id)
+	if err != nil {
+		return nil, err
+	}
+
+	from := DeviceState(device.LifecycleState)
+	if from == state {
+		return device, nil
+	}
+	if !CanTransition(from, state) {
+		return nil, fmt.Errorf("%w: a %s device cannot be moved to %s", ErrInvalidTransition, from, state)
+	}
+
+	now := time.Now().UTC()
+	updatedFields := map[string]interface{}{
+		"lifecycle_state": state,
+		"updated_at":      now,
+	}
+	if state == StateDeleted {
+		updatedFields["deleted_at"] = now
+		updatedFields["is_blocked"] = true
+		updatedFields["previous_state"] = from
+		updatedFields["previous_blocked"] = device.IsBlocked
+	}
+
+	return d.updateState(id, updatedFields)
+}
+
+// Restore brings back a soft-deleted device in the state it was deleted from, blocked only when it was
+// blocked before it was deleted.
+func (d *Device) Restore(id string) (*Device, error) {
+	device, err := d.GetByIDIncludingDeleted(id)
+	if err != nil {
+		return nil, err
+	}
+	if DeviceState(device.LifecycleState) != StateDeleted {
+		return nil, fmt.Errorf("%w: device %s is not deleted", ErrInvalidTransition, id)
+	}
+
+	state := StateActive
+	if device.PreviousState != nil && *device.PreviousState != "" {
+		state = *device.PreviousState
+	}
+	isBlocked := false
+	if device.PreviousBlocked != nil {
+		isBlocked = *device.PreviousBlocked
+	}
+
+	restored, err := d.updateState(id, map[string]interface{}{
+		"lifecycle_state":  state,
+		"previous_state":   nil,
+		"previous_blocked": nil,
+		"deleted_at":       nil,
+		"is_blocked":       isBlocked,
+		"updated_at":       time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleted devices are left out when the cache is populated, so cache the whole device again.
+	if err := cacheDevice(restored); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// updateState saves the state fields of a device to the database and the cache.
+func (d *Device) updateState(id string, updatedFields map[string]interface{}) (*Device, error) {
+	collection := dbSession.Collection(d.TableName())
+
+	if err := collection.Find(up.Cond{"device_id": id}).Update(updatedFields); err != nil {
+		return nil, fmt.Errorf("error updating lifecycle state of device %s: %w", id, err)
+	}
+
+	if err := cache.AppCache.UpdateDeviceFields(id, updatedFields); err != nil {
+		return nil, fmt.Errorf("failed to update lifecycle state of device %s in cache: %w", id, err)
+	}
+
+	return d.GetByIDIncludingDeleted(id)
+}
+
+// Purge permanently deletes a device together with its raw data and logs, in a single transaction,
+// and removes it from the cache. Its bay assignment is closed, keeping the history of the bay;
+// audit logs are kept too. A tombstone records the purge, see DeletedSince.
+func (d *Device) Purge(id string) error {
+	device, err := d.GetByIDIncludingDeleted(id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err = dbSession.Tx(func(sess up.Session) error {
+		_, err := sess.SQL().Exec(`
+			UPDATE parking.bay_assignments SET assigned_to = $1
+			WHERE device_id = $2 AND assigned_to IS NULL
+		`, now, id)
+		if err != nil {
+			return fmt.Errorf("failed to close bay assignment: %w", err)
+		}
+
+		for _, table := range devicePurgeTables {
+			if _, err := sess.SQL().Exec(fmt.Sprintf("DELETE FROM %s WHERE device_id = $1", table), id); err != nil {
+				return fmt.Errorf("failed to purge %s: %w", table, err)
+			}
+		}
+		if _, err := sess.SQL().Exec("DELETE FROM parking.devices WHERE device_id = $1", id); err != nil {
+			return fmt.Errorf("failed to purge device: %w", err)
+		}
+
+		// Leave a tombstone for the incremental feeds.
+		_, err = sess.SQL().Exec(`
+			INSERT INTO parking.purged_devices (device_id, network_type, purged_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (device_id) DO UPDATE SET network_type = EXCLUDED.network_type, purged_at = EXCLUDED.purged_at
+		`, id, device.NetworkType, now)
+		if err != nil {
+			return fmt.Errorf("failed to record purged device: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to purge device %s: %w", id, err))
+	}
+
+	if err := cache.AppCache.DeleteDevice(id); err != nil {
+		return fmt.Errorf("failed to delete device data from cache: %w", err)
+	}
+
+	return nil
+}
+
+// DeletedSince returns the IDs of the devices deleted or purged at or after the given time, and not
+// registered again since, ordered by device ID.
+func (d *Device) DeletedSince(since time.Time) ([]string, error) {
+	rows, err := dbSession.SQL().Query(`
+		SELECT device_id FROM parking.devices WHERE deleted_at >= $1
+		UNION
+		SELECT p.device_id FROM parking.purged_devices p
+		WHERE p.purged_at >= $1
+			AND NOT EXISTS (SELECT 1 FROM parking.devices d WHERE d.device_id = p.device_id AND d.deleted_at IS NULL)
+		ORDER BY device_id
+	`, since)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve deleted devices: %w", err))
+	}
+	defer rows.Close()
+
+	var deviceIDs []string
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, helpers.WrapError(fmt.Errorf("failed to scan deleted device: %w", err))
+		}
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve deleted devices: %w", err))
+	}
+
+	return deviceIDs, nil
+}
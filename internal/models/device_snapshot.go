@@ -0,0 +1,145 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+)
+
+// DeviceSnapshot is the state of a device at a point in time, rebuilt from its logs.
+type DeviceSnapshot struct {
+	DeviceID        string     `json:"device_id"`
+	Name            string     `json:"name"`
+	NetworkType     string     `json:"network_type"`
+	FirmwareVersion float64    `json:"firmware_version"` // From the last parking event, or the device if there is none
+	Latitude        float64    `json:"latitude"`
+	Longitude       float64    `json:"longitude"`
+	IsHidden        bool       `json:"is_hidden"`
+	IsOccupied      *bool      `json:"is_occupied"`  // Nil if the device had not reported a parking event yet
+	HappenedAt      *time.Time `json:"happened_at"`  // Last parking event
+	KeepaliveAt     *time.Time `json:"keepalive_at"` // Last keepalive
+	SettingsAt      *time.Time `json:"settings_at"`  // Last settings report
+}
+
+// SnapshotChange is a single state change of a device, used to play the history back.
+type SnapshotChange struct {
+	Type       string    `json:"type"` // parking, keepalive or settings
+	DeviceID   string    `json:"device_id"`
+	HappenedAt time.Time `json:"happened_at"`
+	IsOccupied *bool     `json:"is_occupied,omitempty"` // Parking events only
+}
+
+// Keepalive and settings logs of every network type.
+var (
+	keepaliveLogTables = []string{"parking.nbiot_keepalive_logs", "parking.lora_keepalive_logs", "parking.sigfox_keepalive_logs"}
+	settingLogTables   = []string{"parking.nbiot_setting_logs", "parking.lora_setting_logs", "parking.sigfox_setting_logs"}
+)
+
+// -----------------------------------------------------------------------------
+
+// latestLogSQL returns a subquery with the latest happened_at of device d in the tables up to $1.
+func latestLogSQL(tables []string) string {
+	parts := make([]string, len(tables))
+	for i, table := range tables {
+		parts[i] = fmt.Sprintf(`SELECT MAX(happened_at) AS happened_at FROM %s WHERE device_id = d.device_id AND happened_at <= $1`, table)
+	}
+	return fmt.Sprintf(`SELECT MAX(happened_at) AS happened_at FROM (%s) l`, strings.Join(parts, " UNION ALL "))
+}
+
+// At rebuilds the state of the devices that existed at the given time. An empty deviceIDs returns every device.
+func (d *DeviceSnapshot) At(at time.Time, deviceIDs []string) ([]*DeviceSnapshot, error) {
+	args := []interface{}{at.UTC()}
+	conditions := []string{"d.created_at <= $1", "(d.deleted_at IS NULL OR d.deleted_at > $1)"}
+	if len(deviceIDs) > 0 {
+		args = append(args, deviceIDs)
+		conditions = append(conditions, "d.device_id = ANY($2)")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			d.device_id,
+			COALESCE(d.name, ''),
+			COALESCE(d.network_type, ''),
+			COALESCE(a.firmware_version, d.firmware_version, 0)::DOUBLE PRECISION,
+			COALESCE(d.latitude, 0)::DOUBLE PRECISION,
+			COALESCE(d.longitude, 0)::DOUBLE PRECISION,
+			COALESCE(d.is_hidden, FALSE),
+			a.is_occupied,
+			a.happened_at,
+			k.happened_at,
+			s.happened_at
+		FROM parking.devices d
+		LEFT JOIN LATERAL (
+			SELECT is_occupied, happened_at, firmware_version
+			FROM parking.activity_logs
+			WHERE device_id = d.device_id AND happened_at <= $1
+			ORDER BY happened_at DESC, id DESC
+			LIMIT 1
+		) a ON TRUE
+		LEFT JOIN LATERAL (%s) k ON TRUE
+		LEFT JOIN LATERAL (%s) s ON TRUE
+		WHERE %s
+		ORDER BY d.device_id
+	`, latestLogSQL(keepaliveLogTables), latestLogSQL(settingLogTables), strings.Join(conditions, " AND "))
+
+	rows, err := dbSession.SQL().Query(query, args...)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to rebuild device snapshot at %s: %w", at, err))
+	}
+	defer rows.Close()
+
+	snapshots := []*DeviceSnapshot{}
+	for rows.Next() {
+		var s DeviceSnapshot
+		err := rows.Scan(&s.DeviceID, &s.Name, &s.NetworkType, &s.FirmwareVersion, &s.Latitude, &s.Longitude,
+			&s.IsHidden, &s.IsOccupied, &s.HappenedAt, &s.KeepaliveAt, &s.SettingsAt)
+		if err != nil {
+			return nil, helpers.WrapError(fmt.Errorf("failed to scan device snapshot: %w", err))
+		}
+		snapshots = append(snapshots, &s)
+	}
+
+	return snapshots, rows.Err()
+}
+
+// ChangesBetween returns the parking events, keepalives and settings reports in (from, to], oldest first.
+// An empty deviceIDs returns the changes of every device.
+func (d *DeviceSnapshot) ChangesBetween(from, to time.Time, deviceIDs []string) ([]SnapshotChange, error) {
+	args := []interface{}{from.UTC(), to.UTC()}
+	filter := ""
+	if len(deviceIDs) > 0 {
+		args = append(args, deviceIDs)
+		filter = "AND device_id = ANY($3)"
+	}
+
+	parts := []string{
+		fmt.Sprintf(`SELECT 'parking' AS type, device_id, happened_at, is_occupied FROM parking.activity_logs WHERE happened_at > $1 AND happened_at <= $2 %s`, filter),
+	}
+	for _, table := range keepaliveLogTables {
+		parts = append(parts, fmt.Sprintf(`SELECT 'keepalive', device_id, happened_at, NULL::BOOLEAN FROM %s WHERE happened_at > $1 AND happened_at <= $2 %s`, table, filter))
+	}
+	for _, table := range settingLogTables {
+		parts = append(parts, fmt.Sprintf(`SELECT 'settings', device_id, happened_at, NULL::BOOLEAN FROM %s WHERE happened_at > $1 AND happened_at <= $2 %s`, table, filter))
+	}
+
+	query := strings.Join(parts, " UNION ALL ") + " ORDER BY 3, 2"
+
+	rows, err := dbSession.SQL().Query(query, args...)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to load device changes from %s to %s: %w", from, to, err))
+	}
+	defer rows.Close()
+
+	var changes []SnapshotChange
+	for rows.Next() {
+		var c SnapshotChange
+		if err := rows.Scan(&c.Type, &c.DeviceID, &c.HappenedAt, &c.IsOccupied); err != nil {
+			return nil, helpers.WrapError(fmt.Errorf("failed to scan device change: %w", err))
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
@@ -0,0 +1,323 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// DeviceTags are the custom key/value metadata of a device, such as its installer or asset number,
+// stored as a JSONB object. Values are strings, numbers or booleans.
+type DeviceTags map[string]any
+
+// Scan implements the sql.Scanner interface for DeviceTags.
+func (t *DeviceTags) Scan(src interface{}) error {
+	*t = DeviceTags{}
+	if src == nil {
+		return nil
+	}
+
+	b, ok := src.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, (*map[string]any)(t))
+}
+
+// Value implements the driver.Valuer interface for DeviceTags.
+func (t DeviceTags) Value() (driver.Value, error) {
+	if t == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]any(t))
+	return string(b), err
+}
+
+// MarshalJSON encodes missing tags as an empty object.
+func (t DeviceTags) MarshalJSON() ([]byte, error) {
+	if t == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]any(t))
+}
+
+// -----------------------------------------------------------------------------
+
+// TagKeyPattern is the format of tag keys, which also appear in query parameters as tag.<key>.
+var TagKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// TagTypes are the value types a tag definition can require.
+var TagTypes = map[string]bool{"string": true, "number": true, "boolean": true, "date": true}
+
+// TagDefinition is the schema of a device tag, defined by admins. Tags without a definition are
+// accepted as they are.
+type TagDefinition struct {
+	Key           string     `db:"key" json:"key"`
+	Description   string     `db:"description" json:"description"`
+	Type          string     `db:"type" json:"type"`                     // string, number, boolean or date (YYYY-MM-DD)
+	AllowedValues StringList `db:"allowed_values" json:"allowed_values"` // Empty for any value of the type
+	Required      bool       `db:"required" json:"required"`             // Every device must have the tag when its tags are edited
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// StringList is a list of strings stored as a JSONB array.
+type StringList []string
+
+// Scan implements the sql.Scanner interface for StringList.
+func (l *StringList) Scan(src interface{}) error {
+	*l = StringList{}
+	if src == nil {
+		return nil
+	}
+
+	b, ok := src.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(l))
+}
+
+// Value implements the driver.Valuer interface for StringList.
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+// TableName returns the full table name for the TagDefinition model in PostgreSQL.
+func (t *TagDefinition) TableName() string {
+	return "parking.tag_definitions"
+}
+
+// -----------------------------------------------------------------------------
+
+// Validate checks the definition's key, type and allowed values.
+func (t *TagDefinition) Validate() error {
+	if !TagKeyPattern.MatchString(t.Key) {
+		return errors.New("key must start with a lowercase letter and contain only lowercase letters, digits and underscores, up to 50 characters")
+	}
+	if !TagTypes[t.Type] {
+		return fmt.Errorf("invalid type '%s', must be string, number, boolean or date", t.Type)
+	}
+	if t.Type == "boolean" && len(t.AllowedValues) > 0 {
+		return errors.New("boolean tags cannot have allowed values")
+	}
+	for _, value := range t.AllowedValues {
+		if _, err := t.parse(value); err != nil {
+			return fmt.Errorf("invalid allowed value: %w", err)
+		}
+	}
+	return nil
+}
+
+// parse checks that a value is of the definition's type, returning it as it is compared with
+// the allowed values.
+func (t *TagDefinition) parse(value any) (string, error) {
+	switch t.Type {
+	case "number":
+		switch v := value.(type) {
+		case float64:
+			return fmt.Sprint(v), nil
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return fmt.Sprint(f), nil
+			}
+		}
+		return "", fmt.Errorf("'%v' is not a number", value)
+	case "boolean":
+		if v, ok := value.(bool); ok {
+			return fmt.Sprint(v), nil
+		}
+		return "", fmt.Errorf("'%v' is not true or false", value)
+	case "date":
+		if v, ok := value.(string); ok {
+			if _, err := time.Parse("2006-01-02", v); err == nil {
+				return v, nil
+			}
+		}
+		return "", fmt.Errorf("'%v' is not a date in YYYY-MM-DD format", value)
+	default:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("'%v' is not a string", value)
+	}
+}
+
+// ValidateTagValue checks a tag value against its definition, which may be nil for an undefined tag.
+// Values of undefined tags must be strings, numbers or booleans.
+func ValidateTagValue(def *TagDefinition, key string, value any) error {
+	if !TagKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid tag key '%s'", key)
+	}
+
+	if def == nil {
+		switch value.(type) {
+		case string, float64, bool:
+			return nil
+		}
+		return fmt.Errorf("tag '%s' must be a string, number or boolean", key)
+	}
+
+	parsed, err := def.parse(value)
+	if err != nil {
+		return fmt.Errorf("tag '%s': %w", key, err)
+	}
+	if len(def.AllowedValues) > 0 {
+		for _, allowed := range def.AllowedValues {
+			if normalized, _ := def.parse(allowed); normalized == parsed {
+				return nil
+			}
+		}
+		return fmt.Errorf("tag '%s' must be one of %s", key, strings.Join(def.AllowedValues, ", "))
+	}
+	return nil
+}
+
+// ValidateDeviceTags checks a device's complete set of tags against the definitions, keyed by tag key,
+// returning one message per invalid or missing tag, sorted.
+func ValidateDeviceTags(defs map[string]*TagDefinition, tags DeviceTags) []string {
+	var problems []string
+	for key, value := range tags {
+		if err := ValidateTagValue(defs[key], key, value); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for key, def := range defs {
+		if _, ok := tags[key]; def.Required && !ok {
+			problems = append(problems, fmt.Sprintf("tag '%s' is required", key))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// -----------------------------------------------------------------------------
+
+// GetAll retrieves all tag definitions from the database.
+func (t *TagDefinition) GetAll() ([]*TagDefinition, error) {
+	var defs []*TagDefinition
+
+	collection := dbSession.Collection(t.TableName())
+	err := collection.Find().OrderBy("key").All(&defs)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve tag definitions from database: %w", err))
+	}
+
+	return defs, nil
+}
+
+// GetMap retrieves all tag definitions keyed by tag key.
+func (t *TagDefinition) GetMap() (map[string]*TagDefinition, error) {
+	defs, err := t.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]*TagDefinition, len(defs))
+	for _, def := range defs {
+		byKey[def.Key] = def
+	}
+	return byKey, nil
+}
+
+// GetByKey retrieves a single tag definition by its key.
+func (t *TagDefinition) GetByKey(key string) (*TagDefinition, error) {
+	collection := dbSession.Collection(t.TableName())
+
+	var def TagDefinition
+	err := collection.Find(up.Cond{"key": key}).One(&def)
+	if err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, errors.New("tag definition not found")
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve tag definition: %w", err))
+	}
+
+	return &def, nil
+}
+
+// Create inserts a new tag definition into the database and returns it.
+func (t *TagDefinition) Create(def *TagDefinition) (*TagDefinition, error) {
+	if _, err := t.GetByKey(def.Key); err == nil {
+		return nil, fmt.Errorf("tag '%s' is already defined", def.Key)
+	}
+
+	collection := dbSession.Collection(t.TableName())
+
+	now := time.Now().UTC()
+	def.CreatedAt = now
+	def.UpdatedAt = now
+
+	if _, err := collection.Insert(def); err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to create tag definition: %w", err))
+	}
+
+	return def, nil
+}
+
+// Update saves all fields of an existing tag definition but its key.
+// The tags already set on devices are not revalidated.
+func (t *TagDefinition) Update(def *TagDefinition) (*TagDefinition, error) {
+	collection := dbSession.Collection(t.TableName())
+
+	res := collection.Find(up.Cond{"key": def.Key})
+	count, err := res.Count()
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking tag definition existence: %w", err))
+	}
+	if count == 0 {
+		return nil, errors.New("tag definition not found")
+	}
+
+	err = res.Update(map[string]interface{}{
+		"description":    def.Description,
+		"type":           def.Type,
+		"allowed_values": def.AllowedValues,
+		"required":       def.Required,
+		"updated_at":     time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error updating tag definition: %w", err))
+	}
+
+	return t.GetByKey(def.Key)
+}
+
+// DeleteByKey deletes a tag definition. The tags set on devices are kept, without a schema.
+func (t *TagDefinition) DeleteByKey(key string) error {
+	collection := dbSession.Collection(t.TableName())
+
+	res := collection.Find(up.Cond{"key": key})
+	count, err := res.Count()
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("error checking tag definition existence: %w", err))
+	}
+	if count == 0 {
+		return errors.New("tag definition not found")
+	}
+
+	if err := res.Delete(); err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to delete tag definition: %w", err))
+	}
+
+	return nil
+}
@@ -15,19 +15,34 @@ var dbSession up.Session
 type Models struct {
 	ActivityLog          ActivityLog
 	AuditLog             AuditLog
+	Bay                  Bay
+	BayAssignment        BayAssignment
+	ClaimCode            ClaimCode
 	Device               Device
+	DeviceHealth         DeviceHealth
+	DeviceSnapshot       DeviceSnapshot
+	OccupancyProfile     OccupancyProfile
+	OccupancyRollup      OccupancyRollup
 	LoraDeviceSettings   LoraDeviceSettings
 	LoraKeepaliveLog     LoraKeepaliveLog
 	LoraSettingLog       LoraSettingLog
 	NbiotDeviceSettings  NbiotDeviceSettings
 	NbiotKeepaliveLog    NbiotKeepaliveLog
 	NbiotSettingLog      NbiotSettingLog
+	ParkingSession       ParkingSession
+	PendingDevice        PendingDevice
 	RawDataLog           RawDataLog
+	Report               Report
+	ReportSchedule       ReportSchedule
 	Setting              Setting
+	StayRule             StayRule
+	TagDefinition        TagDefinition
 	SigfoxKeepaliveLog   SigfoxKeepaliveLog
 	SigfoxSettingLog     SigfoxSettingLog
 	SigfoxDeviceSettings SigfoxDeviceSettings
 	User                 User
+	Violation            Violation
+	Zone                 Zone
 }
 
 var AppModels Models
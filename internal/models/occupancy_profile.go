@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// OccupancyProfile is the average occupancy of a device in one weekday and hour.
+type OccupancyProfile struct {
+	DeviceID      string    `db:"device_id" json:"device_id"`
+	Weekday       int       `db:"weekday" json:"weekday"`               // ISO weekday in the profile timezone
+	Hour          int       `db:"hour" json:"hour"`                     // Hour of the day in the profile timezone
+	OccupancyRate float64   `db:"occupancy_rate" json:"occupancy_rate"` // Average share of the hour occupied
+	Samples       int       `db:"samples" json:"samples"`               // Hours the average was taken over
+	TrainedAt     time.Time `db:"trained_at" json:"trained_at"`
+}
+
+// TableName returns the full table name for the OccupancyProfile model in PostgreSQL.
+func (o *OccupancyProfile) TableName() string {
+	return "parking.occupancy_profiles"
+}
+
+// -----------------------------------------------------------------------------
+
+// Train replaces the profiles with the average occupancy per device, weekday and hour of the
+// hourly rollups in [from, to). Weekdays and hours are taken in the given timezone. Hours without
+// a rollup count as vacant, but only from the first rollup of each device, so new devices are not
+// averaged over time they did not exist. Returns the number of profiles stored.
+func (o *OccupancyProfile) Train(from, to time.Time, timezone string) (int, error) {
+	from = from.UTC().Truncate(time.Hour)
+	to = to.UTC().Truncate(time.Hour)
+	trainedAt := time.Now().UTC()
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (device_id, weekday, hour, occupancy_rate, samples, trained_at)
+		WITH first_seen AS (
+			SELECT device_id, MIN(bucket) AS since
+			FROM parking.occupancy_hourly
+			WHERE bucket >= $1 AND bucket < $2
+			GROUP BY device_id
+		), hours AS (
+			SELECT h AS bucket, (h AT TIME ZONE 'UTC' AT TIME ZONE $3) AS local
+			FROM generate_series($1::timestamp, $2::timestamp - INTERVAL '1 hour', INTERVAL '1 hour') AS h
+		)
+		SELECT
+			f.device_id,
+			EXTRACT(ISODOW FROM h.local)::SMALLINT,
+			EXTRACT(HOUR FROM h.local)::SMALLINT,
+			LEAST(1.0, COALESCE(SUM(r.occupied_seconds), 0)::DOUBLE PRECISION / (COUNT(*) * 3600)),
+			COUNT(*),
+			$4
+		FROM first_seen f
+		JOIN hours h ON h.bucket >= f.since
+		LEFT JOIN parking.occupancy_hourly r ON r.device_id = f.device_id AND r.bucket = h.bucket
+		GROUP BY 1, 2, 3
+	`, o.TableName())
+
+	var count int
+	err := dbSession.Tx(func(sess up.Session) error {
+		if _, err := sess.SQL().Exec(fmt.Sprintf(`DELETE FROM %s`, o.TableName())); err != nil {
+			return fmt.Errorf("failed to delete occupancy profiles: %w", err)
+		}
+
+		res, err := sess.SQL().Exec(query, from, to, timezone, trainedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert occupancy profiles: %w", err)
+		}
+
+		rows, _ := res.RowsAffected()
+		count = int(rows)
+		return nil
+	})
+	if err != nil {
+		return 0, helpers.WrapError(fmt.Errorf("failed to train occupancy profiles from %s to %s: %w", from, to, err))
+	}
+
+	return count, nil
+}
+
+// GetProfiles returns the profiles of the given devices, or of every device if deviceIDs is empty.
+func (o *OccupancyProfile) GetProfiles(deviceIDs []string) ([]*OccupancyProfile, error) {
+	conditions := up.Cond{}
+	if len(deviceIDs) > 0 {
+		conditions["device_id IN"] = deviceIDs
+	}
+
+	var profiles []*OccupancyProfile
+	err := dbSession.Collection(o.TableName()).Find(conditions).All(&profiles)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve occupancy profiles: %w", err))
+	}
+
+	return profiles, nil
+}
@@ -0,0 +1,279 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// OccupancyRollup is one hourly (or daily) bucket of a device's parking sessions.
+type OccupancyRollup struct {
+	DeviceID        string    `db:"device_id" json:"device_id"`
+	Bucket          time.Time `db:"bucket" json:"bucket"`                     // Start of the UTC hour or day
+	OccupiedSeconds int64     `db:"occupied_seconds" json:"occupied_seconds"` // Seconds occupied within the bucket
+	Arrivals        int       `db:"arrivals" json:"arrivals"`                 // Sessions started within the bucket
+	Departures      int       `db:"departures" json:"departures"`             // Sessions ended within the bucket
+	DwellSeconds    int64     `db:"dwell_seconds" json:"dwell_seconds"`       // Total duration of the sessions ended within the bucket
+	DwellCount      int       `db:"dwell_count" json:"dwell_count"`           // Number of sessions ended within the bucket
+	UpdatedAt       time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// RollupQuery selects and groups rollup buckets.
+type RollupQuery struct {
+	From        time.Time // Inclusive, UTC
+	To          time.Time // Exclusive, UTC
+	Interval    string    // hour, day, week or month
+	Timezone    string    // IANA name the periods are aligned to
+	GroupBy     string    // device, network_type, bay or all
+	DeviceIDs   []string
+	NetworkType string
+	BayIDs      []int // Bays, across the devices assigned to them over time
+}
+
+// ByBay reports whether the query follows bays rather than devices.
+func (q RollupQuery) ByBay() bool {
+	return q.GroupBy == "bay" || len(q.BayIDs) > 0
+}
+
+// RollupAggregate is the sum of the buckets of one group and period.
+type RollupAggregate struct {
+	Group           string
+	Period          time.Time // Wall clock start of the period in the query timezone, labelled UTC
+	OccupiedSeconds int64
+	Arrivals        int64
+	Departures      int64
+	DwellSeconds    int64
+	DwellCount      int64
+}
+
+// Group expressions of the rollup queries, for the rollup (r), device (d) and bay (b) tables.
+var rollupGroupColumns = map[string]string{
+	"device":       "r.device_id",
+	"network_type": "COALESCE(d.network_type, '')",
+	"bay":          "b.id::TEXT",
+	"all":          "'all'",
+}
+
+// RollupIntervals lists the supported period sizes.
+var RollupIntervals = map[string]bool{"hour": true, "day": true, "week": true, "month": true}
+
+// RollupGroups lists the supported groupings.
+var RollupGroups = map[string]bool{"device": true, "network_type": true, "bay": true, "all": true}
+
+// TableName returns the full table name of the hourly rollups in PostgreSQL.
+func (o *OccupancyRollup) TableName() string {
+	return "parking.occupancy_hourly"
+}
+
+// DailyTableName returns the full table name of the daily rollups in PostgreSQL.
+func (o *OccupancyRollup) DailyTableName() string {
+	return "parking.occupancy_daily"
+}
+
+// -----------------------------------------------------------------------------
+
+// Recompute rebuilds the hourly buckets in [from, to) and the daily buckets of the days they touch
+// from the parking sessions. An empty deviceIDs recomputes every device.
+func (o *OccupancyRollup) Recompute(deviceIDs []string, from, to time.Time) error {
+	from = from.UTC().Truncate(time.Hour)
+	to = to.UTC().Truncate(time.Hour)
+	if !to.After(from) {
+		to = from.Add(time.Hour)
+	}
+
+	dayFrom := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	dayTo := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	if dayTo.Before(to) {
+		dayTo = dayTo.AddDate(0, 0, 1)
+	}
+
+	// Restrict to the given devices, if any. The device list is the last argument of each statement.
+	deleteFilter, insertFilter := "", ""
+	deleteArgs := []interface{}{from, to}
+	insertArgs := []interface{}{from, to, time.Now().UTC()}
+	dailyArgs := []interface{}{dayFrom, dayTo}
+	if len(deviceIDs) > 0 {
+		deleteFilter = "AND device_id = ANY($3)"
+		insertFilter = "AND s.device_id = ANY($4)"
+		deleteArgs = append(deleteArgs, deviceIDs)
+		insertArgs = append(insertArgs, deviceIDs)
+		dailyArgs = append(dailyArgs, deviceIDs)
+	}
+
+	hourlyDelete := fmt.Sprintf(`DELETE FROM %s WHERE bucket >= $1 AND bucket < $2 %s`, o.TableName(), deleteFilter)
+
+	// Sum the part of every session that overlaps each hour; sessions still open count until now ($3).
+	hourlyInsert := fmt.Sprintf(`
+		INSERT INTO %s (device_id, bucket, occupied_seconds, arrivals, departures, dwell_seconds, dwell_count, updated_at)
+		SELECT
+			s.device_id,
+			b.bucket,
+			SUM(GREATEST(0, EXTRACT(EPOCH FROM LEAST(COALESCE(s.ended_at, $3), b.bucket + INTERVAL '1 hour') - GREATEST(s.started_at, b.bucket))))::BIGINT,
+			COUNT(*) FILTER (WHERE s.started_at >= b.bucket),
+			COUNT(*) FILTER (WHERE s.ended_at < b.bucket + INTERVAL '1 hour'),
+			COALESCE(SUM(s.duration_seconds) FILTER (WHERE s.ended_at < b.bucket + INTERVAL '1 hour'), 0),
+			COUNT(*) FILTER (WHERE s.ended_at < b.bucket + INTERVAL '1 hour'),
+			NOW()
+		FROM generate_series($1::timestamp, $2::timestamp - INTERVAL '1 hour', INTERVAL '1 hour') AS b(bucket)
+		JOIN parking.parking_sessions s
+			ON s.started_at < b.bucket + INTERVAL '1 hour' AND (s.ended_at IS NULL OR s.ended_at >= b.bucket)
+		WHERE TRUE %s
+		GROUP BY s.device_id, b.bucket
+	`, o.TableName(), insertFilter)
+
+	dailyDelete := fmt.Sprintf(`DELETE FROM %s WHERE bucket >= $1 AND bucket < $2 %s`, o.DailyTableName(), deleteFilter)
+
+	dailyInsert := fmt.Sprintf(`
+		INSERT INTO %s (device_id, bucket, occupied_seconds, arrivals, departures, dwell_seconds, dwell_count, updated_at)
+		SELECT device_id, date_trunc('day', bucket), SUM(occupied_seconds), SUM(arrivals), SUM(departures), SUM(dwell_seconds), SUM(dwell_count), NOW()
+		FROM %s
+		WHERE bucket >= $1 AND bucket < $2 %s
+		GROUP BY device_id, date_trunc('day', bucket)
+	`, o.DailyTableName(), o.TableName(), deleteFilter)
+
+	err := dbSession.Tx(func(sess up.Session) error {
+		if _, err := sess.SQL().Exec(hourlyDelete, deleteArgs...); err != nil {
+			return fmt.Errorf("failed to delete hourly rollups: %w", err)
+		}
+		if _, err := sess.SQL().Exec(hourlyInsert, insertArgs...); err != nil {
+			return fmt.Errorf("failed to insert hourly rollups: %w", err)
+		}
+		if _, err := sess.SQL().Exec(dailyDelete, dailyArgs...); err != nil {
+			return fmt.Errorf("failed to delete daily rollups: %w", err)
+		}
+		if _, err := sess.SQL().Exec(dailyInsert, dailyArgs...); err != nil {
+			return fmt.Errorf("failed to insert daily rollups: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to recompute occupancy rollups from %s to %s: %w", from, to, err))
+	}
+
+	return nil
+}
+
+// Aggregate sums the rollup buckets per group and period. Periods are aligned to the query
+// timezone; the daily table is used when its UTC days line up with the requested periods.
+func (o *OccupancyRollup) Aggregate(q RollupQuery) ([]RollupAggregate, error) {
+	groupColumn, ok := rollupGroupColumns[q.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported group_by '%s'", q.GroupBy)
+	}
+	if !RollupIntervals[q.Interval] {
+		return nil, fmt.Errorf("unsupported interval '%s'", q.Interval)
+	}
+
+	table, bucketSize := o.TableName(), "hour"
+	if q.Interval != "hour" && q.Timezone == "UTC" {
+		table, bucketSize = o.DailyTableName(), "day"
+	}
+
+	args := []interface{}{q.From.UTC(), q.To.UTC(), q.Timezone}
+	conditions := []string{"r.bucket >= $1", "r.bucket < $2"}
+
+	// Attribute the buckets to the bay the device monitored at the time.
+	bayJoin := ""
+	if q.ByBay() {
+		bayJoin = fmt.Sprintf(`
+			JOIN parking.bay_assignments ba ON ba.device_id = r.device_id
+				AND r.bucket >= date_trunc('%s', ba.assigned_from)
+				AND (ba.assigned_to IS NULL OR r.bucket < ba.assigned_to)
+			JOIN parking.bays b ON b.id = ba.bay_id`, bucketSize)
+	}
+	if len(q.BayIDs) > 0 {
+		args = append(args, q.BayIDs)
+		conditions = append(conditions, fmt.Sprintf("b.id = ANY($%d)", len(args)))
+	}
+	if len(q.DeviceIDs) > 0 {
+		args = append(args, q.DeviceIDs)
+		conditions = append(conditions, fmt.Sprintf("r.device_id = ANY($%d)", len(args)))
+	}
+	if q.NetworkType != "" {
+		args = append(args, strings.ToLower(q.NetworkType))
+		conditions = append(conditions, fmt.Sprintf("LOWER(d.network_type) = $%d", len(args)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			%s AS group_key,
+			date_trunc('%s', r.bucket AT TIME ZONE 'UTC' AT TIME ZONE $3) AS period,
+			SUM(r.occupied_seconds), SUM(r.arrivals), SUM(r.departures), SUM(r.dwell_seconds), SUM(r.dwell_count)
+		FROM %s r
+		LEFT JOIN parking.devices d ON d.device_id = r.device_id%s
+		WHERE %s
+		GROUP BY 1, 2
+		ORDER BY 2, 1
+	`, groupColumn, q.Interval, table, bayJoin, strings.Join(conditions, " AND "))
+
+	rows, err := dbSession.SQL().Query(query, args...)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to aggregate occupancy rollups: %w", err))
+	}
+	defer rows.Close()
+
+	var aggregates []RollupAggregate
+	for rows.Next() {
+		var a RollupAggregate
+		if err := rows.Scan(&a.Group, &a.Period, &a.OccupiedSeconds, &a.Arrivals, &a.Departures, &a.DwellSeconds, &a.DwellCount); err != nil {
+			return nil, helpers.WrapError(fmt.Errorf("failed to scan occupancy rollup: %w", err))
+		}
+		aggregates = append(aggregates, a)
+	}
+
+	return aggregates, rows.Err()
+}
+
+// CountDevices returns the number of active devices (bays) per group, used as the capacity of the group.
+// Queries that follow bays count the bays instead.
+func (o *OccupancyRollup) CountDevices(q RollupQuery) (map[string]int, error) {
+	groupColumn, ok := rollupGroupColumns[q.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported group_by '%s'", q.GroupBy)
+	}
+	groupColumn = strings.Replace(groupColumn, "r.", "d.", 1)
+
+	args := []interface{}{}
+	var query string
+	if q.ByBay() {
+		conditions := []string{"TRUE"}
+		if len(q.BayIDs) > 0 {
+			args = append(args, q.BayIDs)
+			conditions = append(conditions, fmt.Sprintf("b.id = ANY($%d)", len(args)))
+		}
+		query = fmt.Sprintf(`SELECT %s, COUNT(*) FROM parking.bays b WHERE %s GROUP BY 1`, groupColumn, strings.Join(conditions, " AND "))
+	} else {
+		args = append(args, AnalyticsStates())
+		conditions := []string{"d.deleted_at IS NULL", fmt.Sprintf("d.lifecycle_state = ANY($%d)", len(args))}
+		if len(q.DeviceIDs) > 0 {
+			args = append(args, q.DeviceIDs)
+			conditions = append(conditions, fmt.Sprintf("d.device_id = ANY($%d)", len(args)))
+		}
+		if q.NetworkType != "" {
+			args = append(args, strings.ToLower(q.NetworkType))
+			conditions = append(conditions, fmt.Sprintf("LOWER(d.network_type) = $%d", len(args)))
+		}
+		query = fmt.Sprintf(`SELECT %s, COUNT(*) FROM parking.devices d WHERE %s GROUP BY 1`, groupColumn, strings.Join(conditions, " AND "))
+	}
+
+	rows, err := dbSession.SQL().Query(query, args...)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to count devices: %w", err))
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var group string
+		var count int
+		if err := rows.Scan(&group, &count); err != nil {
+			return nil, helpers.WrapError(fmt.Errorf("failed to scan device count: %w", err))
+		}
+		counts[group] = count
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/google/uuid"
+	up "github.com/upper/db/v4"
+)
+
+// ParkingSession is a single stay of a vehicle on a device, from arrival to departure.
+type ParkingSession struct {
+	ID              int64        `db:"id,omitempty" json:"id"`
+	DeviceID        string       `db:"device_id" json:"device_id"`
+	NetworkType     string       `db:"network_type" json:"network_type"`
+	StartedAt       time.Time    `db:"started_at" json:"started_at"`
+	EndedAt         *time.Time   `db:"ended_at" json:"ended_at"`                 // Nil while the vehicle is parked
+	DurationSeconds *int64       `db:"duration_seconds" json:"duration_seconds"` // Nil while the vehicle is parked
+	ArrivalRawID    uuid.UUID    `db:"arrival_raw_id" json:"arrival_raw_id"`
+	DepartureRawID  *uuid.UUID   `db:"departure_raw_id" json:"departure_raw_id"`
+	EventsAmount    int          `db:"events_amount" json:"events_amount"`
+	Interruptions   int          `db:"interruptions" json:"interruptions"` // Repeated arrivals or short vacant gaps, e.g. sensor reboots
+	BeaconsAmount   int          `db:"beacons_amount" json:"beacons_amount"`
+	Beacons         *BeaconSlice `db:"beacons" json:"beacons"` // Distinct beacons seen during the session
+	CreatedAt       time.Time    `db:"created_at,omitempty" json:"created_at"`
+	UpdatedAt       time.Time    `db:"updated_at,omitempty" json:"updated_at"`
+}
+
+// ParkingSessionFilter holds the optional filters of a parking session query.
+type ParkingSessionFilter struct {
+	DeviceIDs   []string
+	NetworkType string
+	BayIDs      []int     // Sessions that started while their device was assigned to one of the bays
+	From        time.Time // Sessions still active at or after From
+	To          time.Time // Sessions started at or before To
+	Status      string    // "open", "closed" or empty for both
+	MinDuration int64     // Seconds, 0 for no minimum
+	MaxDuration int64     // Seconds, 0 for no maximum
+	Limit       int
+	Offset      int
+	NewestFirst bool
+}
+
+// TableName returns the full table name for the ParkingSession model in PostgreSQL.
+func (p *ParkingSession) TableName() string {
+	return "parking.parking_sessions"
+}
+
+// -----------------------------------------------------------------------------
+
+// DeriveParkingSessions pairs time-ordered activity logs of one device into sessions.
+//
+//   - An occupied event opens a session, a vacant event closes it.
+//   - An occupied event while a session is open belongs to that session; sensors re-report their
+//     state after a reboot, so it is counted as an interruption instead of a new arrival.
+//   - An occupied event within mergeGap of the previous departure reopens that session; this is the
+//     short vacant flicker of a sensor recalibrating after a reboot.
+//   - A vacant event without an open session is a repeated departure and is ignored.
+func DeriveParkingSessions(logs []*ActivityLog, mergeGap time.Duration) []*ParkingSession {
+	var sessions []*ParkingSession
+	var open *ParkingSession
+	var beacons map[Beacon]bool
+
+	for _, log := range logs {
+		switch {
+		case log.IsOccupied && open != nil:
+			open.Interruptions++
+
+		case log.IsOccupied && len(sessions) > 0 && sessions[len(sessions)-1].EndedAt != nil &&
+			log.HappenedAt.Sub(*sessions[len(sessions)-1].EndedAt) <= mergeGap:
+			// Reopen the previous session.
+			open = sessions[len(sessions)-1]
+			open.EndedAt = nil
+			open.DurationSeconds = nil
+			open.DepartureRawID = nil
+			open.Interruptions++
+
+		case log.IsOccupied:
+			open = &ParkingSession{
+				DeviceID:     log.DeviceID,
+				NetworkType:  log.NetworkType,
+				StartedAt:    log.HappenedAt,
+				ArrivalRawID: log.RawID,
+				Beacons:      &BeaconSlice{},
+			}
+			beacons = make(map[Beacon]bool)
+			sessions = append(sessions, open)
+
+		case open != nil:
+			endedAt := log.HappenedAt
+			duration := int64(endedAt.Sub(open.StartedAt).Seconds())
+			rawID := log.RawID
+
+			open.EndedAt = &endedAt
+			open.DurationSeconds = &duration
+			open.DepartureRawID = &rawID
+			open.EventsAmount++
+			open = nil
+			continue
+
+		default:
+			continue
+		}
+
+		open.EventsAmount++
+
+		// Keep each beacon once, identified by major and minor.
+		if log.Beacons != nil {
+			for _, b := range *log.Beacons {
+				key := Beacon{Major: b.Major, Minor: b.Minor}
+				if !beacons[key] {
+					beacons[key] = true
+					*open.Beacons = append(*open.Beacons, b)
+				}
+			}
+		}
+		open.BeaconsAmount = len(*open.Beacons)
+	}
+
+	return sessions
+}
+
+// Rebuild re-derives the sessions of a device from the activity logs starting at since.
+//
+// Derivation restarts at the session that was open at since, so late events from NB-IoT batches
+// are slotted into the right place and the sessions after them are corrected. It returns the
+// number of sessions written and the time derivation restarted at; sessions from then on may have changed.
+func (p *ParkingSession) Rebuild(deviceID string, since time.Time, mergeGap time.Duration) (int, time.Time, error) {
+	written := 0
+
+	// Find where to restart: the start of the session that was open at since, if any.
+	anchor := since.Add(-mergeGap)
+
+	err := dbSession.Tx(func(sess up.Session) error {
+
+		var previous ParkingSession
+		err := sess.Collection(p.TableName()).
+			Find("device_id = ? AND started_at <= ?", deviceID, since).
+			OrderBy("-started_at").
+			One(&previous)
+		if err != nil && err != up.ErrNoMoreRows {
+			return fmt.Errorf("failed to find the session open at %s: %w", since, err)
+		}
+		if err == nil && (previous.EndedAt == nil || !previous.EndedAt.Add(mergeGap).Before(since)) {
+			anchor = previous.StartedAt
+		}
+
+		// Reload the activity logs from the anchor onwards.
+		var logs []*ActivityLog
+		err = sess.Collection((&ActivityLog{}).TableName()).
+			Find("device_id = ? AND happened_at >= ?", deviceID, anchor).
+			OrderBy("happened_at", "id").
+			All(&logs)
+		if err != nil {
+			return fmt.Errorf("failed to load activity logs: %w", err)
+		}
+
+		// Replace the sessions from the anchor onwards.
+		if err := sess.Collection(p.TableName()).Find("device_id = ? AND started_at >= ?", deviceID, anchor).Delete(); err != nil {
+			return fmt.Errorf("failed to delete sessions: %w", err)
+		}
+
+		sessions := DeriveParkingSessions(logs, mergeGap)
+		for _, s := range sessions {
+			s.CreatedAt = time.Now().UTC()
+			s.UpdatedAt = s.CreatedAt
+			if _, err := sess.Collection(p.TableName()).Insert(s); err != nil {
+				return fmt.Errorf("failed to insert session started at %s: %w", s.StartedAt, err)
+			}
+		}
+
+		written = len(sessions)
+		return nil
+	})
+	if err != nil {
+		return 0, anchor, helpers.WrapError(fmt.Errorf("failed to rebuild parking sessions of device %s: %w", deviceID, err))
+	}
+
+	return written, anchor, nil
+}
+
+// GetParkingSessions returns the sessions that match the filter.
+func (p *ParkingSession) GetParkingSessions(filter ParkingSessionFilter) ([]*ParkingSession, error) {
+	conditions := []up.LogicalExpr{}
+
+	if len(filter.DeviceIDs) > 0 {
+		conditions = append(conditions, up.Cond{"device_id IN": filter.DeviceIDs})
+	}
+	if filter.NetworkType != "" {
+		conditions = append(conditions, up.Raw("LOWER(network_type) = ?", strings.ToLower(filter.NetworkType)))
+	}
+	if len(filter.BayIDs) > 0 {
+		placeholders := make([]string, len(filter.BayIDs))
+		args := make([]interface{}, len(filter.BayIDs))
+		for i, bayID := range filter.BayIDs {
+			placeholders[i] = "?"
+			args[i] = bayID
+		}
+		conditions = append(conditions, up.Raw(fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM parking.bay_assignments ba
+			WHERE ba.device_id = parking_sessions.device_id AND ba.bay_id IN (%s)
+				AND parking_sessions.started_at >= ba.assigned_from
+				AND (ba.assigned_to IS NULL OR parking_sessions.started_at < ba.assigned_to)
+		)`, strings.Join(placeholders, ", ")), args...))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, up.Or(up.Cond{"ended_at IS": nil}, up.Cond{"ended_at >=": filter.From}))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, up.Cond{"started_at <=": filter.To})
+	}
+
+	switch filter.Status {
+	case "open":
+		conditions = append(conditions, up.Cond{"ended_at IS": nil})
+	case "closed":
+		conditions = append(conditions, up.Cond{"ended_at IS NOT": nil})
+	}
+
+	if filter.MinDuration > 0 {
+		conditions = append(conditions, up.Cond{"duration_seconds >=": filter.MinDuration})
+	}
+	if filter.MaxDuration > 0 {
+		conditions = append(conditions, up.Cond{"duration_seconds <=": filter.MaxDuration})
+	}
+
+	orderBy := "started_at"
+	if filter.NewestFirst {
+		orderBy = "-started_at"
+	}
+
+	var sessions []*ParkingSession
+	err := dbSession.Collection(p.TableName()).
+		Find(up.And(conditions...)).
+		OrderBy(orderBy, "id").
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		All(&sessions)
+	if err != nil {
+		if err == up.ErrNoMoreRows {
+			return nil, nil
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to fetch parking sessions: %w", err))
+	}
+
+	return sessions, nil
+}
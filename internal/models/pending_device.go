@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// Statuses of a pending device.
+const (
+	PendingStatusPending  = "pending"
+	PendingStatusRejected = "rejected"
+)
+
+// PendingDevice is an unknown device that sent traffic while the registration policy requires
+// devices to be approved or claimed. It becomes a Device when it is approved.
+type PendingDevice struct {
+	DeviceID        string    `db:"device_id" json:"device_id"`
+	NetworkType     string    `db:"network_type" json:"network_type"`
+	FirmwareVersion float64   `db:"firmware_version" json:"firmware_version"`
+	Name            string    `db:"name" json:"name"`           // Name to register the device with, "" for a generated one
+	Latitude        float64   `db:"latitude" json:"latitude"`   // Location to register the device at, 0,0 for the default
+	Longitude       float64   `db:"longitude" json:"longitude"` // location
+	Status          string    `db:"status" json:"status"`       // pending or rejected
+	FirstSeenAt     time.Time `db:"first_seen_at" json:"first_seen_at"`
+	ReviewedBy      *int      `db:"reviewed_by" json:"reviewed_by"`
+	CreatedAt       time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// TableName returns the full table name for the PendingDevice model in PostgreSQL.
+func (p *PendingDevice) TableName() string {
+	return "parking.pending_devices"
+}
+
+// -----------------------------------------------------------------------------
+
+// GetAll retrieves the pending devices with the given status, or all of them when status is empty,
+// the first seen first.
+func (p *PendingDevice) GetAll(status string) ([]*PendingDevice, error) {
+	var devices []*PendingDevice
+
+	cond := up.Cond{}
+	if status != "" {
+		cond["status"] = status
+	}
+
+	collection := dbSession.Collection(p.TableName())
+	err := collection.Find(cond).OrderBy("first_seen_at", "device_id").All(&devices)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve pending devices from database: %w", err))
+	}
+
+	return devices, nil
+}
+
+// GetByID retrieves a single pending device by its device ID.
+func (p *PendingDevice) GetByID(deviceID string) (*PendingDevice, error) {
+	collection := dbSession.Collection(p.TableName())
+
+	var device PendingDevice
+	err := collection.Find(up.Cond{"device_id": deviceID}).One(&device)
+	if err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, errors.New("pending device not found")
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve pending device: %w", err))
+	}
+
+	return &device, nil
+}
+
+// Seen records traffic of an unknown device, adding it as pending the first time.
+// The network type and firmware version of a known pending device are refreshed; its status is kept.
+func (p *PendingDevice) Seen(deviceID, networkType string, firmwareVersion float64) error {
+	_, err := dbSession.SQL().Exec(`
+		INSERT INTO parking.pending_devices (device_id, network_type, firmware_version, status, first_seen_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5, $5)
+		ON CONFLICT (device_id) DO UPDATE SET
+			network_type = EXCLUDED.network_type,
+			firmware_version = EXCLUDED.firmware_version
+	`, deviceID, networkType, firmwareVersion, PendingStatusPending, time.Now().UTC())
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to record pending device %s: %w", deviceID, err))
+	}
+	return nil
+}
+
+// Update saves the name, location, status and reviewer of a pending device.
+func (p *PendingDevice) Update(device *PendingDevice) (*PendingDevice, error) {
+	collection := dbSession.Collection(p.TableName())
+
+	res := collection.Find(up.Cond{"device_id": device.DeviceID})
+	count, err := res.Count()
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking pending device existence: %w", err))
+	}
+	if count == 0 {
+		return nil, errors.New("pending device not found")
+	}
+
+	err = res.Update(map[string]interface{}{
+		"name":        device.Name,
+		"latitude":    device.Latitude,
+		"longitude":   device.Longitude,
+		"status":      device.Status,
+		"reviewed_by": device.ReviewedBy,
+		"updated_at":  time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error updating pending device: %w", err))
+	}
+
+	return p.GetByID(device.DeviceID)
+}
+
+// DeleteByID removes a pending device, once it has been registered.
+func (p *PendingDevice) DeleteByID(deviceID string) error {
+	collection := dbSession.Collection(p.TableName())
+
+	if err := collection.Find(up.Cond{"device_id": deviceID}).Delete(); err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to delete pending device %s: %w", deviceID, err))
+	}
+	return nil
+}
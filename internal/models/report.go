@@ -0,0 +1,136 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// ErrReportFileNotFound is returned when a report has no stored file.
+var ErrReportFileNotFound = errors.New("report file not found")
+
+// Report is a report file generated on demand or by a schedule.
+type Report struct {
+	ID          int        `db:"id,omitempty" json:"id"`
+	ReportType  string     `db:"report_type" json:"report_type"`
+	Format      string     `db:"format" json:"format"`
+	FromDate    time.Time  `db:"from_date" json:"from_date"`
+	ToDate      time.Time  `db:"to_date" json:"to_date"`
+	DeviceIDs   string     `db:"device_ids" json:"device_ids"` // Comma separated, "" for every device
+	Status      string     `db:"status" json:"status"`         // pending, ready or failed
+	FileName    string     `db:"file_name" json:"file_name"`
+	FileSize    int64      `db:"file_size" json:"file_size"`
+	RowCount    int        `db:"row_count" json:"row_count"`
+	Error       string     `db:"error" json:"error"`
+	UserID      *int       `db:"user_id" json:"user_id"`         // Nil for scheduled reports
+	ScheduleID  *int       `db:"schedule_id" json:"schedule_id"` // Nil for reports requested via the API
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	CompletedAt *time.Time `db:"completed_at" json:"completed_at"`
+}
+
+// TableName returns the full table name for the Report model in PostgreSQL.
+func (r *Report) TableName() string {
+	return "app.reports"
+}
+
+// -----------------------------------------------------------------------------
+
+// Create inserts a new report and returns it with its ID.
+func (r *Report) Create(report *Report) (*Report, error) {
+	report.CreatedAt = time.Now().UTC()
+
+	err := dbSession.Collection(r.TableName()).InsertReturning(report)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to create report: %w", err))
+	}
+
+	return report, nil
+}
+
+// Complete stores the outcome of the generation of a report, with the content of its file when
+// it is ready. The file is kept in the database so every gateway instance can serve it.
+func (r *Report) Complete(report *Report, content []byte) error {
+	completedAt := time.Now().UTC()
+	report.CompletedAt = &completedAt
+
+	err := dbSession.Tx(func(sess up.Session) error {
+		if content != nil {
+			_, err := sess.SQL().Exec(`
+				INSERT INTO app.report_files (report_id, content) VALUES ($1, $2)
+				ON CONFLICT (report_id) DO UPDATE SET content = EXCLUDED.content
+			`, report.ID, content)
+			if err != nil {
+				return err
+			}
+		}
+
+		return sess.Collection(r.TableName()).Find(up.Cond{"id": report.ID}).Update(map[string]interface{}{
+			"status":       report.Status,
+			"file_name":    report.FileName,
+			"file_size":    report.FileSize,
+			"row_count":    report.RowCount,
+			"error":        report.Error,
+			"completed_at": completedAt,
+		})
+	})
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to complete report %d: %w", report.ID, err))
+	}
+
+	return nil
+}
+
+// GetFile returns the content of the file of a report.
+func (r *Report) GetFile(id int) ([]byte, error) {
+	row, err := dbSession.SQL().QueryRow(`SELECT content FROM app.report_files WHERE report_id = $1`, id)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve file of report %d: %w", id, err))
+	}
+
+	var content []byte
+	if err := row.Scan(&content); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrReportFileNotFound
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve file of report %d: %w", id, err))
+	}
+
+	return content, nil
+}
+
+// GetByID retrieves a single report by its ID.
+func (r *Report) GetByID(id int) (*Report, error) {
+	var report Report
+	err := dbSession.Collection(r.TableName()).Find(up.Cond{"id": id}).One(&report)
+	if err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, errors.New("report not found")
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve report: %w", err))
+	}
+
+	return &report, nil
+}
+
+// GetRecent returns the latest reports, newest first.
+func (r *Report) GetRecent(limit, offset int) ([]*Report, error) {
+	var reports []*Report
+	err := dbSession.Collection(r.TableName()).Find().OrderBy("-id").Limit(limit).Offset(offset).All(&reports)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve reports: %w", err))
+	}
+
+	return reports, nil
+}
+
+// DeleteByID deletes a report record, and its file, by its ID.
+func (r *Report) DeleteByID(id int) error {
+	if err := dbSession.Collection(r.TableName()).Find(up.Cond{"id": id}).Delete(); err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to delete report %d: %w", id, err))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// ReportSchedule generates a report of the previous period on a cron expression.
+type ReportSchedule struct {
+	ID         int       `db:"id,omitempty" json:"id"`
+	Name       string    `db:"name" json:"name"`
+	ReportType string    `db:"report_type" json:"report_type"`
+	Format     string    `db:"format" json:"format"`
+	CronSpec   string    `db:"cron_spec" json:"cron_spec"`   // With seconds, e.g. "0 0 6 1 * *"
+	Period     string    `db:"period" json:"period"`         // previous_day, previous_week or previous_month
+	DeviceIDs  string    `db:"device_ids" json:"device_ids"` // Comma separated, "" for every device
+	IsEnabled  bool      `db:"is_enabled" json:"is_enabled"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// ReportPeriods lists the periods a schedule can report on.
+var ReportPeriods = map[string]bool{"previous_day": true, "previous_week": true, "previous_month": true}
+
+// TableName returns the full table name for the ReportSchedule model in PostgreSQL.
+func (r *ReportSchedule) TableName() string {
+	return "app.report_schedules"
+}
+
+// PeriodRange returns the UTC range [from, to) of the schedule's period before now.
+// Weeks start on Monday.
+func (r *ReportSchedule) PeriodRange(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch r.Period {
+	case "previous_week":
+		weekStart := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return weekStart.AddDate(0, 0, -7), weekStart
+	case "previous_month":
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return monthStart.AddDate(0, -1, 0), monthStart
+	default:
+		return today.AddDate(0, 0, -1), today
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// GetAll retrieves all report schedules from the database.
+func (r *ReportSchedule) GetAll() ([]*ReportSchedule, error) {
+	var schedules []*ReportSchedule
+
+	err := dbSession.Collection(r.TableName()).Find().OrderBy("id").All(&schedules)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve report schedules from database: %w", err))
+	}
+
+	return schedules, nil
+}
+
+// GetByID retrieves a single report schedule by its ID.
+func (r *ReportSchedule) GetByID(id int) (*ReportSchedule, error) {
+	var schedule ReportSchedule
+	err := dbSession.Collection(r.TableName()).Find(up.Cond{"id": id}).One(&schedule)
+	if err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, errors.New("report schedule not found")
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve report schedule: %w", err))
+	}
+
+	return &schedule, nil
+}
+
+// Create inserts a new report schedule into the database and returns it.
+func (r *ReportSchedule) Create(schedule *ReportSchedule) (*ReportSchedule, error) {
+	now := time.Now().UTC()
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+
+	err := dbSession.Collection(r.TableName()).InsertReturning(schedule)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to create report schedule: %w", err))
+	}
+
+	return schedule, nil
+}
+
+// Update saves all fields of an existing report schedule.
+func (r *ReportSchedule) Update(schedule *ReportSchedule) (*ReportSchedule, error) {
+	res := dbSession.Collection(r.TableName()).Find(up.Cond{"id": schedule.ID})
+	count, err := res.Count()
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking report schedule existence: %w", err))
+	}
+	if count == 0 {
+		return nil, errors.New("report schedule not found")
+	}
+
+	err = res.Update(map[string]interface{}{
+		"name":        schedule.Name,
+		"report_type": schedule.ReportType,
+		"format":      schedule.Format,
+		"cron_spec":   schedule.CronSpec,
+		"period":      schedule.Period,
+		"device_ids":  schedule.DeviceIDs,
+		"is_enabled":  schedule.IsEnabled,
+		"updated_at":  time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error updating report schedule: %w", err))
+	}
+
+	return r.GetByID(schedule.ID)
+}
+
+// DeleteByID deletes a report schedule by its ID.
+func (r *ReportSchedule) DeleteByID(id int) error {
+	res := dbSession.Collection(r.TableName()).Find(up.Cond{"id": id})
+	count, err := res.Count()
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("error checking report schedule existence: %w", err))
+	}
+	if count == 0 {
+		return errors.New("report schedule not found")
+	}
+
+	if err := res.Delete(); err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to delete report schedule: %w", err))
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// StayRule limits how long a vehicle may occupy a bay.
+// A device takes its own rules, else the rules of the innermost zone of its bay that has rules,
+// else the rules without a device or zone.
+type StayRule struct {
+	ID             int       `db:"id,omitempty" json:"id"`
+	Name           string    `db:"name" json:"name"`
+	DeviceID       *string   `db:"device_id" json:"device_id"`               // Nil for a zone or default rule
+	ZoneID         *int      `db:"zone_id" json:"zone_id"`                   // Lot, level or zone the rule applies to, nil for a device or default rule
+	MaxStayMinutes int       `db:"max_stay_minutes" json:"max_stay_minutes"` // Longest allowed stay
+	ActiveFrom     string    `db:"active_from" json:"active_from"`           // "HH:MM" local time, "" for all day
+	ActiveTo       string    `db:"active_to" json:"active_to"`               // "HH:MM" local time, "" for all day
+	DaysOfWeek     string    `db:"days_of_week" json:"days_of_week"`         // ISO weekdays, e.g. "1,2,3,4,5", "" for every day
+	Timezone       string    `db:"timezone" json:"timezone"`                 // IANA timezone of the active hours and days
+	IsEnabled      bool      `db:"is_enabled" json:"is_enabled"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// TableName returns the full table name for the StayRule model in PostgreSQL.
+func (s *StayRule) TableName() string {
+	return "parking.stay_rules"
+}
+
+// -----------------------------------------------------------------------------
+
+// Validate checks the rule's scope, limit, active hours, days and timezone.
+func (s *StayRule) Validate() error {
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("name cannot be empty")
+	}
+	if s.DeviceID != nil && s.ZoneID != nil {
+		return errors.New("a rule applies to a device or a zone, not both")
+	}
+	if s.MaxStayMinutes <= 0 {
+		return errors.New("max_stay_minutes must be greater than zero")
+	}
+	if (s.ActiveFrom == "") != (s.ActiveTo == "") {
+		return errors.New("active_from and active_to must be set together")
+	}
+	if s.ActiveFrom != "" {
+		if _, err := parseClock(s.ActiveFrom); err != nil {
+			return fmt.Errorf("invalid active_from: %w", err)
+		}
+		if _, err := parseClock(s.ActiveTo); err != nil {
+			return fmt.Errorf("invalid active_to: %w", err)
+		}
+	}
+	if _, err := parseWeekdays(s.DaysOfWeek); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone '%s'", s.Timezone)
+	}
+	return nil
+}
+
+// WindowStart reports whether the rule applies at now and, if so, when the current active window
+// started. Rules without active hours apply all day on their days; their window starts at midnight.
+// Windows that end before they start (e.g. 22:00 to 06:00) run overnight.
+func (s *StayRule) WindowStart(now time.Time) (time.Time, bool) {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	days, _ := parseWeekdays(s.DaysOfWeek)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	// A window is only open if it started on one of the rule's days.
+	onDay := func(day time.Time) bool {
+		return len(days) == 0 || days[isoWeekday(day)]
+	}
+
+	if s.ActiveFrom == "" {
+		return today, onDay(today)
+	}
+
+	from, _ := parseClock(s.ActiveFrom)
+	to, _ := parseClock(s.ActiveTo)
+	start := today.Add(from)
+
+	if from < to {
+		return start, onDay(today) && !local.Before(start) && local.Before(today.Add(to))
+	}
+
+	// Overnight window: started today, or yesterday and not yet ended.
+	if !local.Before(start) {
+		return start, onDay(today)
+	}
+	yesterday := today.AddDate(0, 0, -1)
+	return yesterday.Add(from), onDay(yesterday) && local.Before(today.Add(to))
+}
+
+// parseClock parses "HH:MM" into the duration since midnight.
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not in HH:MM format", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseWeekdays parses a comma separated list of ISO weekdays (1 = Monday ... 7 = Sunday).
+func parseWeekdays(value string) (map[int]bool, error) {
+	days := make(map[int]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		day, err := strconv.Atoi(item)
+		if err != nil || day < 1 || day > 7 {
+			return nil, fmt.Errorf("invalid day of week '%s', must be 1 (Monday) to 7 (Sunday)", item)
+		}
+		days[day] = true
+	}
+	return days, nil
+}
+
+// isoWeekday returns the ISO weekday of t, 1 = Monday ... 7 = Sunday.
+func isoWeekday(t time.Time) int {
+	if t.Weekday() == time.Sunday {
+		return 7
+	}
+	return int(t.Weekday())
+}
+
+// -----------------------------------------------------------------------------
+
+// GetAll retrieves all stay rules from the database.
+func (s *StayRule) GetAll() ([]*StayRule, error) {
+	var rules []*StayRule
+
+	collection := dbSession.Collection(s.TableName())
+	err := collection.Find().OrderBy("id").All(&rules)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve stay rules from database: %w", err))
+	}
+
+	return rules, nil
+}
+
+// GetEnabled retrieves the enabled stay rules from the database.
+func (s *StayRule) GetEnabled() ([]*StayRule, error) {
+	var rules []*StayRule
+
+	collection := dbSession.Collection(s.TableName())
+	err := collection.Find(up.Cond{"is_enabled": true}).OrderBy("id").All(&rules)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve enabled stay rules from database: %w", err))
+	}
+
+	return rules, nil
+}
+
+// GetByID retrieves a single stay rule by its ID.
+func (s *StayRule) GetByID(id int) (*StayRule, error) {
+	collection := dbSession.Collection(s.TableName())
+
+	var rule StayRule
+	err := collection.Find(up.Cond{"id": id}).One(&rule)
+	if err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, errors.New("stay rule not found")
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve stay rule: %w", err))
+	}
+
+	return &rule, nil
+}
+
+// Create inserts a new stay rule into the database and returns it.
+func (s *StayRule) Create(newRule *StayRule) (*StayRule, error) {
+	collection := dbSession.Collection(s.TableName())
+
+	now := time.Now().UTC()
+	newRule.CreatedAt = now
+	newRule.UpdatedAt = now
+
+	err := collection.InsertReturning(newRule)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to create stay rule: %w", err))
+	}
+
+	return newRule, nil
+}
+
+// Update saves all fields of an existing stay rule.
+func (s *StayRule) Update(rule *StayRule) (*StayRule, error) {
+	collection := dbSession.Collection(s.TableName())
+
+	res := collection.Find(up.Cond{"id": rule.ID})
+	count, err := res.Count()
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking stay rule existence: %w", err))
+	}
+	if count == 0 {
+		return nil, errors.New("stay rule not found")
+	}
+
+	rule.UpdatedAt = time.Now().UTC()
+	err = res.Update(map[string]interface{}{
+		"name":             rule.Name,
+		"device_id":        rule.DeviceID,
+		"zone_id":          rule.ZoneID,
+		"max_stay_minutes": rule.MaxStayMinutes,
+		"active_from":      rule.ActiveFrom,
+		"active_to":        rule.ActiveTo,
+		"days_of_week":     rule.DaysOfWeek,
+		"timezone":         rule.Timezone,
+		"is_enabled":       rule.IsEnabled,
+		"updated_at":       rule.UpdatedAt,
+	})
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error updating stay rule: %w", err))
+	}
+
+	return s.GetByID(rule.ID)
+}
+
+// DeleteByID deletes a stay rule by its ID.
+func (s *StayRule) DeleteByID(id int) error {
+	collection := dbSession.Collection(s.TableName())
+
+	res := collection.Find(up.Cond{"id": id})
+	count, err := res.Count()
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("error checking stay rule existence: %w", err))
+	}
+	if count == 0 {
+		return errors.New("stay rule not found")
+	}
+
+	if err := res.Delete(); err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to delete stay rule: %w", err))
+	}
+
+	return nil
+}
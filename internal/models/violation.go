@@ -0,0 +1,114 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// Violation is raised when a vehicle stays longer than a stay rule allows and cleared when it leaves.
+type Violation struct {
+	ID             int64      `db:"id,omitempty" json:"id"`
+	RuleID         int        `db:"rule_id" json:"rule_id"`
+	DeviceID       string     `db:"device_id" json:"device_id"`
+	OccupiedSince  time.Time  `db:"occupied_since" json:"occupied_since"`     // Arrival of the vehicle
+	ViolatedAt     time.Time  `db:"violated_at" json:"violated_at"`           // When the limit was exceeded
+	MaxStayMinutes int        `db:"max_stay_minutes" json:"max_stay_minutes"` // Limit of the rule when raised
+	ClearedAt      *time.Time `db:"cleared_at" json:"cleared_at"`             // Nil while the violation is active
+	CreatedAt      time.Time  `db:"created_at,omitempty" json:"created_at"`
+}
+
+// ViolationFilter holds the optional filters of a violation query.
+type ViolationFilter struct {
+	DeviceIDs []string
+	RuleID    int
+	Status    string    // "active", "cleared" or empty for both
+	From      time.Time // Violated at or after From
+	To        time.Time // Violated at or before To
+	Limit     int
+	Offset    int
+}
+
+// TableName returns the full table name for the Violation model in PostgreSQL.
+func (v *Violation) TableName() string {
+	return "parking.violations"
+}
+
+// -----------------------------------------------------------------------------
+
+// Create inserts a new violation and returns it with its ID.
+func (v *Violation) Create(violation *Violation) (*Violation, error) {
+	violation.CreatedAt = time.Now().UTC()
+
+	err := dbSession.Collection(v.TableName()).InsertReturning(violation)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to create violation: %w", err))
+	}
+
+	return violation, nil
+}
+
+// GetActive retrieves the violations that have not been cleared yet.
+func (v *Violation) GetActive() ([]*Violation, error) {
+	var violations []*Violation
+
+	err := dbSession.Collection(v.TableName()).Find(up.Cond{"cleared_at IS": nil}).OrderBy("id").All(&violations)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve active violations: %w", err))
+	}
+
+	return violations, nil
+}
+
+// Clear marks a violation as cleared at the given time.
+func (v *Violation) Clear(violation *Violation, clearedAt time.Time) error {
+	err := dbSession.Collection(v.TableName()).
+		Find(up.Cond{"id": violation.ID}).
+		Update(map[string]interface{}{"cleared_at": clearedAt})
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to clear violation %d: %w", violation.ID, err))
+	}
+
+	violation.ClearedAt = &clearedAt
+	return nil
+}
+
+// GetViolations returns the violations that match the filter, newest first.
+func (v *Violation) GetViolations(filter ViolationFilter) ([]*Violation, error) {
+	conditions := []up.LogicalExpr{}
+
+	if len(filter.DeviceIDs) > 0 {
+		conditions = append(conditions, up.Cond{"device_id IN": filter.DeviceIDs})
+	}
+	if filter.RuleID > 0 {
+		conditions = append(conditions, up.Cond{"rule_id": filter.RuleID})
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, up.Cond{"violated_at >=": filter.From})
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, up.Cond{"violated_at <=": filter.To})
+	}
+
+	switch filter.Status {
+	case "active":
+		conditions = append(conditions, up.Cond{"cleared_at IS": nil})
+	case "cleared":
+		conditions = append(conditions, up.Cond{"cleared_at IS NOT": nil})
+	}
+
+	var violations []*Violation
+	err := dbSession.Collection(v.TableName()).
+		Find(up.And(conditions...)).
+		OrderBy("-violated_at", "-id").
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		All(&violations)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to fetch violations: %w", err))
+	}
+
+	return violations, nil
+}
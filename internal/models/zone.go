@@ -0,0 +1,271 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	up "github.com/upper/db/v4"
+)
+
+// Zone kinds. A lot contains levels, lots and levels contain zones.
+const (
+	ZoneKindLot   = "lot"
+	ZoneKindLevel = "level"
+	ZoneKindZone  = "zone"
+)
+
+// zoneParentKinds lists the kinds each zone kind may be nested in; "" is the top level.
+var zoneParentKinds = map[string]map[string]bool{
+	ZoneKindLot:   {"": true},
+	ZoneKindLevel: {ZoneKindLot: true},
+	ZoneKindZone:  {"": true, ZoneKindLot: true, ZoneKindLevel: true},
+}
+
+// Zone is a parking lot, a level of a lot or a zone of bays, outlined by a polygon or a bounding box.
+type Zone struct {
+	ID           int            `db:"id,omitempty" json:"id"`
+	ParentID     *int           `db:"parent_id" json:"parent_id"` // Nil at the top level
+	Kind         string         `db:"kind" json:"kind"`           // lot, level or zone
+	Name         string         `db:"name" json:"name"`
+	Capacity     int            `db:"capacity" json:"capacity"` // Number of spaces, 0 to count the assigned bays
+	PolygonJSON  sql.NullString `db:"polygon" json:"-"`
+	Polygon      [][2]float64   `db:"-" json:"polygon"` // [[longitude, latitude], ...]
+	MinLatitude  *float64       `db:"min_latitude" json:"min_latitude"`
+	MinLongitude *float64       `db:"min_longitude" json:"min_longitude"`
+	MaxLatitude  *float64       `db:"max_latitude" json:"max_latitude"`
+	MaxLongitude *float64       `db:"max_longitude" json:"max_longitude"`
+	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time      `db:"updated_at" json:"updated_at"`
+}
+
+// TableName returns the full table name for the Zone model in PostgreSQL.
+func (z *Zone) TableName() string {
+	return "parking.zones"
+}
+
+// -----------------------------------------------------------------------------
+
+// Validate checks the zone's kind, name, capacity and geometry, and derives the bounding box
+// from the polygon when there is one.
+func (z *Zone) Validate() error {
+	if _, ok := zoneParentKinds[z.Kind]; !ok {
+		return fmt.Errorf("invalid kind '%s', must be lot, level or zone", z.Kind)
+	}
+	if strings.TrimSpace(z.Name) == "" {
+		return errors.New("name cannot be empty")
+	}
+	if z.Capacity < 0 {
+		return errors.New("capacity cannot be negative")
+	}
+
+	if len(z.Polygon) > 0 {
+		if len(z.Polygon) < 3 {
+			return errors.New("polygon must have at least 3 points")
+		}
+		minLat, minLng, maxLat, maxLng := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for _, point := range z.Polygon {
+			lng, lat := point[0], point[1]
+			if !validCoordinates(lat, lng) {
+				return fmt.Errorf("polygon point [%f, %f] is out of range", lng, lat)
+			}
+			minLat, maxLat = math.Min(minLat, lat), math.Max(maxLat, lat)
+			minLng, maxLng = math.Min(minLng, lng), math.Max(maxLng, lng)
+		}
+		z.MinLatitude, z.MinLongitude, z.MaxLatitude, z.MaxLongitude = &minLat, &minLng, &maxLat, &maxLng
+
+		polygonJSON, err := json.Marshal(z.Polygon)
+		if err != nil {
+			return fmt.Errorf("failed to encode polygon: %w", err)
+		}
+		z.PolygonJSON = sql.NullString{String: string(polygonJSON), Valid: true}
+		return nil
+	}
+
+	z.PolygonJSON = sql.NullString{}
+	bbox := []*float64{z.MinLatitude, z.MinLongitude, z.MaxLatitude, z.MaxLongitude}
+	set := 0
+	for _, v := range bbox {
+		if v != nil {
+			set++
+		}
+	}
+	switch {
+	case set == 0:
+		return nil
+	case set < len(bbox):
+		return errors.New("min_latitude, min_longitude, max_latitude and max_longitude must be set together")
+	case !validCoordinates(*z.MinLatitude, *z.MinLongitude) || !validCoordinates(*z.MaxLatitude, *z.MaxLongitude):
+		return errors.New("bounding box is out of range")
+	case *z.MinLatitude > *z.MaxLatitude || *z.MinLongitude > *z.MaxLongitude:
+		return errors.New("bounding box minimum must not exceed its maximum")
+	}
+	return nil
+}
+
+// validCoordinates reports whether a latitude and longitude are in range.
+func validCoordinates(lat, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// ValidateParent checks that the zone may be nested in parent, which is nil at the top level.
+func (z *Zone) ValidateParent(parent *Zone) error {
+	parentKind := ""
+	if parent != nil {
+		parentKind = parent.Kind
+	}
+	if !zoneParentKinds[z.Kind][parentKind] {
+		if parent == nil {
+			return fmt.Errorf("a %s must have a parent", z.Kind)
+		}
+		return fmt.Errorf("a %s cannot be nested in a %s", z.Kind, parent.Kind)
+	}
+	return nil
+}
+
+// parsePolygonJSON parses the PolygonJSON field into the Polygon field.
+func (z *Zone) parsePolygonJSON() error {
+	z.Polygon = nil
+	if !z.PolygonJSON.Valid {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(z.PolygonJSON.String), &z.Polygon); err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to decode polygon of zone %d: %w", z.ID, err))
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// GetAll retrieves all zones.
+func (z *Zone) GetAll() ([]*Zone, error) {
+	var zones []*Zone
+
+	err := dbSession.Collection(z.TableName()).Find().OrderBy("id").All(&zones)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve zones from database: %w", err))
+	}
+
+	for _, zone := range zones {
+		if err := zone.parsePolygonJSON(); err != nil {
+			return nil, err
+		}
+	}
+
+	return zones, nil
+}
+
+// GetByID retrieves a single zone by its ID.
+func (z *Zone) GetByID(id int) (*Zone, error) {
+	var zone Zone
+	err := dbSession.Collection(z.TableName()).Find(up.Cond{"id": id}).One(&zone)
+	if err != nil {
+		if errors.Is(err, up.ErrNoMoreRows) {
+			return nil, errors.New("zone not found")
+		}
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve zone: %w", err))
+	}
+
+	if err := zone.parsePolygonJSON(); err != nil {
+		return nil, err
+	}
+
+	return &zone, nil
+}
+
+// Create inserts a new zone into the database and returns it.
+func (z *Zone) Create(zone *Zone) (*Zone, error) {
+	now := time.Now().UTC()
+	zone.CreatedAt = now
+	zone.UpdatedAt = now
+
+	err := dbSession.Collection(z.TableName()).InsertReturning(zone)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to create zone: %w", err))
+	}
+
+	return zone, zone.parsePolygonJSON()
+}
+
+// Update saves the name, parent, capacity and geometry of an existing zone. The kind cannot change.
+func (z *Zone) Update(zone *Zone) (*Zone, error) {
+	res := dbSession.Collection(z.TableName()).Find(up.Cond{"id": zone.ID})
+	count, err := res.Count()
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error checking zone existence: %w", err))
+	}
+	if count == 0 {
+		return nil, errors.New("zone not found")
+	}
+
+	err = res.Update(map[string]interface{}{
+		"parent_id":     zone.ParentID,
+		"name":          zone.Name,
+		"capacity":      zone.Capacity,
+		"polygon":       zone.PolygonJSON,
+		"min_latitude":  zone.MinLatitude,
+		"min_longitude": zone.MinLongitude,
+		"max_latitude":  zone.MaxLatitude,
+		"max_longitude": zone.MaxLongitude,
+		"updated_at":    time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("error updating zone: %w", err))
+	}
+
+	return z.GetByID(zone.ID)
+}
+
+// DeleteByID deletes a zone with its nested zones. Their bays are kept without a zone.
+func (z *Zone) DeleteByID(id int) error {
+	res := dbSession.Collection(z.TableName()).Find(up.Cond{"id": id})
+	count, err := res.Count()
+	if err != nil {
+		return helpers.WrapError(fmt.Errorf("error checking zone existence: %w", err))
+	}
+	if count == 0 {
+		return errors.New("zone not found")
+	}
+
+	if err := res.Delete(); err != nil {
+		return helpers.WrapError(fmt.Errorf("failed to delete zone: %w", err))
+	}
+
+	return nil
+}
+
+// DeviceIDs returns the devices currently assigned to bays of the zone and of the zones nested in it.
+func (z *Zone) DeviceIDs(id int) ([]string, error) {
+	rows, err := dbSession.SQL().Query(`
+		WITH RECURSIVE tree AS (
+			SELECT id FROM parking.zones WHERE id = $1
+			UNION ALL
+			SELECT c.id FROM parking.zones c JOIN tree t ON c.parent_id = t.id
+		)
+		SELECT a.device_id
+		FROM parking.bays b
+		JOIN tree t ON b.zone_id = t.id
+		JOIN parking.bay_assignments a ON a.bay_id = b.id AND a.assigned_to IS NULL
+		ORDER BY a.device_id
+	`, id)
+	if err != nil {
+		return nil, helpers.WrapError(fmt.Errorf("failed to retrieve devices of zone %d: %w", id, err))
+	}
+	defer rows.Close()
+
+	deviceIDs := []string{}
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, helpers.WrapError(fmt.Errorf("failed to scan device of zone %d: %w", id, err))
+		}
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+
+	return deviceIDs, rows.Err()
+}
@@ -0,0 +1,164 @@
+package mq
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/streadway/amqp"
+)
+
+// DeliveryHandler processes a single message consumed from a queue.
+// Returning an error nacks the message so that RabbitMQ can redeliver it.
+type DeliveryHandler func(delivery amqp.Delivery) error
+
+// RabbitMQConsumer manages the connection and consumption of a single RabbitMQ queue.
+type RabbitMQConsumer struct {
+	config     RabbitConfig
+	queue      string
+	handler    DeliveryHandler
+	connection *amqp.Connection
+	channel    *amqp.Channel
+	closing    atomic.Bool // Set by Close, read by the Run goroutine
+}
+
+// NewRabbitMQConsumer creates a new consumer for the given queue.
+func NewRabbitMQConsumer(config RabbitConfig, queue string, handler DeliveryHandler) *RabbitMQConsumer {
+	return &RabbitMQConsumer{
+		config:  config,
+		queue:   queue,
+		handler: handler,
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// Run connects to RabbitMQ and consumes the queue, reconnecting whenever the connection drops.
+func (c *RabbitMQConsumer) Run() {
+	for !c.closing.Load() {
+		deliveries, err := c.connect()
+		if err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to start consumer for queue '%s'", c.queue))
+			helpers.LogInfo("Retrying consumer for queue '%s' in %s...", c.queue, c.config.ReconnectDelay)
+			time.Sleep(c.config.ReconnectDelay)
+			continue
+		}
+
+		helpers.LogInfo("Consuming RabbitMQ queue '%s'", c.queue)
+
+		// The deliveries channel is closed by the library when the connection or channel goes away.
+		for delivery := range deliveries {
+			if err := c.handler(delivery); err != nil {
+				helpers.LogError(err, fmt.Sprintf("Failed to process message from queue '%s'", c.queue))
+				delivery.Nack(false, false)
+				continue
+			}
+			delivery.Ack(false)
+		}
+
+		if !c.closing.Load() {
+			helpers.LogInfo("Consumer for queue '%s' disconnected, reconnecting in %s...", c.queue, c.config.ReconnectDelay)
+			time.Sleep(c.config.ReconnectDelay)
+		}
+	}
+}
+
+// connect opens the connection and channel, declares the topology and starts consuming.
+func (c *RabbitMQConsumer) connect() (<-chan amqp.Delivery, error) {
+	var err error
+	c.connection, err = amqp.Dial(c.config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	c.channel, err = c.connection.Channel()
+	if err != nil {
+		c.connection.Close()
+		return nil, fmt.Errorf("failed to open a channel: %w", err)
+	}
+
+	queue, ok := c.config.Queues[c.queue]
+	if !ok {
+		c.disconnect()
+		return nil, fmt.Errorf("queue '%s' is not configured", c.queue)
+	}
+
+	if _, err := c.channel.QueueDeclare(queue.Name, queue.Durable, false, false, false, nil); err != nil {
+		c.disconnect()
+		return nil, fmt.Errorf("failed to declare queue '%s': %w", queue.Name, err)
+	}
+
+	// Bind the queue to any exchange configured for it.
+	for routingKey, bind := range c.config.RoutingKey {
+		if bind.Queue != queue.Name {
+			continue
+		}
+		exchange, ok := c.config.Exchanges[bind.Exchange]
+		if !ok {
+			continue
+		}
+		if err := c.channel.ExchangeDeclare(exchange.Name, exchange.Type, exchange.Durable, false, false, false, nil); err != nil {
+			c.disconnect()
+			return nil, fmt.Errorf("failed to declare exchange '%s': %w", exchange.Name, err)
+		}
+		if err := c.channel.QueueBind(queue.Name, routingKey, exchange.Name, false, nil); err != nil {
+			c.disconnect()
+			return nil, fmt.Errorf("failed to bind queue '%s': %w", queue.Name, err)
+		}
+	}
+
+	// Process one message at a time so a slow handler does not buffer the whole queue in memory.
+	if err := c.channel.Qos(1, 0, false); err != nil {
+		c.disconnect()
+		return nil, fmt.Errorf("failed to set QoS: %w", err)
+	}
+
+	deliveries, err := c.channel.Consume(queue.Name, "", false, false, false, false, nil)
+	if err != nil {
+		c.disconnect()
+		return nil, fmt.Errorf("failed to consume queue '%s': %w", queue.Name, err)
+	}
+
+	return deliveries, nil
+}
+
+// Reply publishes a response to the queue named in the delivery's reply-to property,
+// carrying over its correlation ID. Deliveries without a reply-to are ignored.
+func (c *RabbitMQConsumer) Reply(delivery amqp.Delivery, body []byte) error {
+	if delivery.ReplyTo == "" {
+		return nil
+	}
+
+	if c.channel == nil {
+		return fmt.Errorf("channel not open")
+	}
+
+	// Publish through the default exchange, which routes directly to the named queue.
+	err := c.channel.Publish("", delivery.ReplyTo, false, false, amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: delivery.CorrelationId,
+		Body:          body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish reply to '%s': %w", delivery.ReplyTo, err)
+	}
+
+	return nil
+}
+
+// Close stops consuming and closes the channel and connection.
+func (c *RabbitMQConsumer) Close() {
+	c.closing.Store(true)
+	c.disconnect()
+}
+
+// disconnect closes the channel and connection without stopping the consumer.
+func (c *RabbitMQConsumer) disconnect() {
+	if c.channel != nil {
+		c.channel.Close()
+	}
+	if c.connection != nil {
+		c.connection.Close()
+	}
+}
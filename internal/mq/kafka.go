@@ -0,0 +1,127 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// KafkaConfig holds the Kafka connection settings.
+type KafkaConfig struct {
+	Brokers        []string
+	TopicPrefix    string // Prepended to exchange names to build topic names, e.g. "parking." + "event_logs"
+	ClientID       string
+	ReconnectDelay time.Duration
+}
+
+// SetupKafkaConfig builds the Kafka configuration from environment variables.
+func SetupKafkaConfig() KafkaConfig {
+	clientID := os.Getenv("KAFKA_CLIENT_ID")
+	if clientID == "" {
+		clientID = "iot-parking-gateway"
+	}
+
+	return KafkaConfig{
+		Brokers:        strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
+		TopicPrefix:    os.Getenv("KAFKA_TOPIC_PREFIX"),
+		ClientID:       clientID,
+		ReconnectDelay: 10 * time.Second,
+	}
+}
+
+// KafkaProducer publishes messages to Kafka using an idempotent producer.
+// Records are keyed by device ID, so all messages of a device land on the same partition in order.
+//
+// The client is created up front and connects by itself: records published while the brokers are
+// unreachable are buffered and produced once they are back.
+type KafkaProducer struct {
+	config KafkaConfig
+	client *kgo.Client
+}
+
+// NewKafkaProducer creates a new Kafka producer instance.
+func NewKafkaProducer(config KafkaConfig) *KafkaProducer {
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.ClientID(config.ClientID),
+		// Idempotent writes require acknowledgement from all in-sync replicas.
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		// Hash the record key so a device always maps to the same partition.
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+		kgo.AllowAutoTopicCreation(),
+	)
+	if err != nil {
+		helpers.LogFatal(err, "Failed to create Kafka client")
+	}
+
+	return &KafkaProducer{
+		config: config,
+		client: client,
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// Run waits until the brokers are reachable, logging the connection.
+// Messages published meanwhile are buffered by the client.
+func (p *KafkaProducer) Run() {
+	for {
+		if p.ping() {
+			helpers.LogInfo("Successfully connected to Kafka")
+			break
+		}
+
+		// Wait before retrying the connection
+		helpers.LogInfo(fmt.Sprintf("Retrying connection to Kafka in %s...", p.config.ReconnectDelay))
+		time.Sleep(p.config.ReconnectDelay)
+	}
+}
+
+// ping checks that the brokers are reachable.
+func (p *KafkaProducer) ping() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := p.client.Ping(ctx); err != nil {
+		helpers.LogError(err, "Failed to connect to Kafka")
+		return false
+	}
+	return true
+}
+
+// Publish produces a message to the topic derived from the exchange name, keyed by device ID.
+func (p *KafkaProducer) Publish(exchangeName, key, message string) {
+	topic := p.config.TopicPrefix + exchangeName
+
+	record := &kgo.Record{
+		Topic: topic,
+		Key:   []byte(key),
+		Value: []byte(message),
+		Headers: []kgo.RecordHeader{
+			{Key: "message_id", Value: []byte(uuid.NewString())},
+		},
+	}
+
+	p.client.Produce(context.Background(), record, func(r *kgo.Record, err error) {
+		if err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to publish message to topic '%s'", r.Topic))
+		}
+	})
+}
+
+// Close flushes buffered records and closes the client.
+func (p *KafkaProducer) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := p.client.Flush(ctx); err != nil {
+		helpers.LogError(err, "Failed to flush Kafka producer")
+	}
+	p.client.Close()
+}
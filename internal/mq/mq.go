@@ -66,6 +66,14 @@ var Queues = map[string]Queue{
 		Name:    "thingsboard_event_logs",
 		Durable: true,
 	},
+	"gateway_commands": {
+		Name:    "gateway_commands",
+		Durable: true,
+	},
+	"violations": {
+		Name:    "violations",
+		Durable: true,
+	},
 }
 
 var Excanges = map[string]Exchange{
@@ -74,6 +82,11 @@ var Excanges = map[string]Exchange{
 		Type:    "fanout",
 		Durable: true,
 	},
+	"violations": {
+		Name:    "violations",
+		Type:    "fanout",
+		Durable: true,
+	},
 }
 
 func SetupRabbitMQConfig() RabbitConfig {
@@ -103,6 +116,31 @@ func SetupRabbitMQConfig() RabbitConfig {
 				Exchange: "event_logs",
 				Queue:    "thingsboard_event_logs",
 			},
+			"violations": {
+				Exchange: "violations",
+				Queue:    "violations",
+			},
 		},
 	}
 }
+
+// WithQueue returns a copy of the configuration that also declares the queue, bound to the exchange.
+// Queues of optional sinks are added this way to their consumer's configuration only, so that they are
+// not declared, and left to grow, when the sink is disabled.
+func (r RabbitConfig) WithQueue(queue Queue, exchange string) RabbitConfig {
+	queues := make(map[string]Queue, len(r.Queues)+1)
+	for name, q := range r.Queues {
+		queues[name] = q
+	}
+	queues[queue.Name] = queue
+
+	routingKeys := make(map[string]Bind, len(r.RoutingKey)+1)
+	for key, bind := range r.RoutingKey {
+		routingKeys[key] = bind
+	}
+	routingKeys[queue.Name] = Bind{Exchange: exchange, Queue: queue.Name}
+
+	r.Queues = queues
+	r.RoutingKey = routingKeys
+	return r
+}
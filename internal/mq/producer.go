@@ -119,6 +119,12 @@ func (p *RabbitMQProducer) monitorConnection() {
 	}()
 }
 
+// Publish sends a message to the given exchange. RabbitMQ exchanges are fanout, so the key
+// is not used for routing.
+func (p *RabbitMQProducer) Publish(exchangeName, key, message string) {
+	p.SendMessageToExchange(exchangeName, message)
+}
+
 func (p *RabbitMQProducer) SendMessageToExchange(exchangeName, message string) {
 	exchange, ok := p.config.Exchanges[exchangeName]
 	if !ok {
@@ -0,0 +1,38 @@
+package mq
+
+import "os"
+
+// Publisher is the message bus used by the ingest paths to publish decoded packages.
+// It is implemented by RabbitMQProducer and KafkaProducer.
+type Publisher interface {
+	// Run connects to the broker, retrying until it succeeds.
+	Run()
+
+	// Publish sends a message to the named exchange (RabbitMQ) or topic (Kafka).
+	// The key identifies the device; Kafka uses it as the partition key so that
+	// messages from the same device stay in order.
+	Publish(exchangeName, key, message string)
+
+	// Close flushes pending messages and closes the connection.
+	Close()
+}
+
+// AppPublisher is the publisher shared by the HTTP ingest handlers.
+var AppPublisher Publisher
+
+// NewPublisher creates the publisher selected by the MQ_DRIVER environment variable.
+// Supported drivers are "rabbitmq" (default) and "kafka".
+func NewPublisher(rabbitConfig RabbitConfig) Publisher {
+	switch os.Getenv("MQ_DRIVER") {
+	case "kafka":
+		AppPublisher = NewKafkaProducer(SetupKafkaConfig())
+	default:
+		AppPublisher = NewRabbitMQProducer(rabbitConfig)
+	}
+	return AppPublisher
+}
+
+// UsesRabbitMQ reports whether RabbitMQ is the configured message bus.
+func UsesRabbitMQ() bool {
+	return os.Getenv("MQ_DRIVER") != "kafka"
+}
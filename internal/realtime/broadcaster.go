@@ -0,0 +1,261 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/google/uuid"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// channel is the Redis pub/sub channel shared by all gateway instances.
+const channel = "socketio:broadcast"
+
+// resubscribeDelay is how long to wait before re-subscribing after a Redis error.
+const resubscribeDelay = 5 * time.Second
+
+// Event is a single broadcast. It is the envelope published to Redis, stored in the replay
+// ring and delivered to local listeners such as the SSE stream.
+type Event struct {
+	Origin    string          `json:"origin"`
+	Namespace string          `json:"namespace"`
+	Room      string          `json:"room,omitempty"`
+	DeviceID  string          `json:"device_id,omitempty"`
+	Hidden    bool            `json:"hidden,omitempty"`
+	Name      string          `json:"event"`
+	Seq       int64           `json:"seq"`
+	Payload   json.RawMessage `json:"payload"`
+
+	// FavoritesOf marks a notice that the favorites of the user changed, rather than a broadcast.
+	FavoritesOf string `json:"favorites_of,omitempty"`
+}
+
+// Broadcaster emits Socket.IO events to the clients of this instance and relays them
+// through Redis pub/sub to every other gateway instance, so that clients receive all
+// events regardless of which replica they are connected to.
+//
+// Every broadcast is given a sequence number and kept in a bounded Redis ring so that
+// reconnecting clients can replay the events they missed.
+type Broadcaster struct {
+	cache      *cache.RedisCache
+	instanceID string
+	replaySize int
+	server     *socketio.Server
+	mu         sync.RWMutex
+	stop       chan struct{}
+	favorites  *favoritesCache
+
+	listeners   map[chan Event]struct{}
+	listenersMu sync.Mutex
+}
+
+// NewBroadcaster creates a broadcaster using the given Redis cache for pub/sub.
+// The replay ring size is read from SOCKET_REPLAY_BUFFER_SIZE (default 1000).
+func NewBroadcaster(c *cache.RedisCache) *Broadcaster {
+	replaySize, err := strconv.Atoi(os.Getenv("SOCKET_REPLAY_BUFFER_SIZE"))
+	if err != nil || replaySize <= 0 {
+		replaySize = 1000
+	}
+
+	return &Broadcaster{
+		cache:      c,
+		instanceID: uuid.NewString(),
+		replaySize: replaySize,
+		stop:       make(chan struct{}),
+		favorites:  newFavoritesCache(c),
+		listeners:  make(map[chan Event]struct{}),
+	}
+}
+
+// SetServer sets the local Socket.IO server that events are emitted on.
+func (b *Broadcaster) SetServer(server *socketio.Server) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.server = server
+}
+
+// ---------------------------------------------------------------------
+
+// BroadcastToNamespace emits an event to every client in the namespace, on all instances.
+func (b *Broadcaster) BroadcastToNamespace(namespace, event string, payload any) {
+	b.broadcast(namespace, "", event, payload)
+}
+
+// BroadcastToRoom emits an event to every client in the room, on all instances.
+func (b *Broadcaster) BroadcastToRoom(namespace, room, event string, payload any) {
+	b.broadcast(namespace, room, event, payload)
+}
+
+// BroadcastDeviceEvent emits a device event, on all instances, to the connections entitled
+// to see the device: its group rooms, the device room and the favorites rooms listing it.
+func (b *Broadcaster) BroadcastDeviceEvent(deviceID, event string, payload any) {
+	hidden := IsDeviceHidden(b.cache, deviceID)
+
+	msg := Event{Namespace: "/", DeviceID: deviceID, Hidden: hidden, Name: event}
+	payload = b.record(&msg, payload)
+
+	b.emitDeviceLocal(deviceID, hidden, event, payload)
+	b.publish(msg)
+}
+
+func (b *Broadcaster) broadcast(namespace, room, event string, payload any) {
+	msg := Event{Namespace: namespace, Room: room, Name: event}
+	payload = b.record(&msg, payload)
+
+	b.emitLocal(namespace, room, event, payload)
+	b.publish(msg)
+}
+
+// record assigns the next sequence number to the broadcast, adds it to the payload as `seq`
+// and stores the message in the replay ring. It returns the payload to emit.
+//
+// The message is stored without its sequence number, which Redis assigns and stores with it in
+// a single step; Since adds it back when reading the ring.
+func (b *Broadcaster) record(msg *Event, payload any) any {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to serialize '%s' payload for Redis", msg.Name))
+		return payload
+	}
+
+	msg.Origin = b.instanceID
+	msg.Payload = data
+
+	seq, err := b.cache.ZAppend(seqKey, ringKey, msg, b.replaySize)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to store '%s' in the replay ring", msg.Name))
+		return payload
+	}
+
+	payload = withSeq(payload, seq)
+	if data, err = json.Marshal(payload); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to serialize '%s' payload for Redis", msg.Name))
+		return payload
+	}
+
+	msg.Seq = seq
+	msg.Payload = data
+	return payload
+}
+
+// publish delivers the broadcast to local listeners and relays it to the other instances.
+func (b *Broadcaster) publish(msg Event) {
+	if msg.Payload == nil {
+		return
+	}
+
+	b.notify(msg)
+
+	if err := b.cache.Publish(channel, msg); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to relay '%s' to other instances", msg.Name))
+	}
+}
+
+// emitLocal emits the event on this instance's Socket.IO server only.
+func (b *Broadcaster) emitLocal(namespace, room, event string, payload any) {
+	b.mu.RLock()
+	server := b.server
+	b.mu.RUnlock()
+
+	if server == nil {
+		return
+	}
+
+	if room == "" {
+		server.BroadcastToNamespace(namespace, event, payload)
+	} else {
+		server.BroadcastToRoom(namespace, room, event, payload)
+	}
+}
+
+// emitDeviceLocal emits a device event on this instance's Socket.IO server only.
+func (b *Broadcaster) emitDeviceLocal(deviceID string, hidden bool, event string, payload any) {
+	b.mu.RLock()
+	server := b.server
+	b.mu.RUnlock()
+
+	if server == nil {
+		return
+	}
+
+	emitDeviceEvent(server, b.favorites, deviceID, hidden, event, payload)
+}
+
+// FavoritesChanged drops the cached favorites of a user on every instance, after they were updated.
+func (b *Broadcaster) FavoritesChanged(userID int) {
+	msg := Event{Origin: b.instanceID, FavoritesOf: strconv.Itoa(userID)}
+	b.favorites.invalidate(msg.FavoritesOf)
+
+	if err := b.cache.Publish(channel, msg); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to relay favorites change of user %d to other instances", userID))
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// Run subscribes to the Redis channel and re-emits events published by other instances.
+// It re-subscribes after Redis errors until Close is called.
+func (b *Broadcaster) Run() {
+	for {
+		// Favorites changes published while not subscribed were missed.
+		b.favorites.reset()
+
+		err := b.cache.Subscribe(channel, b.stop, b.handleMessage)
+
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+
+		if err != nil {
+			helpers.LogError(err, "Socket.IO Redis subscription lost")
+		}
+		helpers.LogInfo("Re-subscribing to Socket.IO Redis channel in %s...", resubscribeDelay)
+		time.Sleep(resubscribeDelay)
+	}
+}
+
+func (b *Broadcaster) handleMessage(data []byte) {
+	var msg Event
+	if err := json.Unmarshal(data, &msg); err != nil {
+		helpers.LogError(err, "Failed to decode Socket.IO broadcast from Redis")
+		return
+	}
+
+	// Events from this instance were already emitted locally.
+	if msg.Origin == b.instanceID {
+		return
+	}
+
+	if msg.FavoritesOf != "" {
+		b.favorites.invalidate(msg.FavoritesOf)
+		return
+	}
+
+	b.notify(msg)
+
+	var payload any
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to decode '%s' payload from Redis", msg.Name))
+		return
+	}
+
+	if msg.DeviceID != "" {
+		b.emitDeviceLocal(msg.DeviceID, msg.Hidden, msg.Name, payload)
+		return
+	}
+
+	b.emitLocal(msg.Namespace, msg.Room, msg.Name, payload)
+}
+
+// Close stops the Redis subscription.
+func (b *Broadcaster) Close() {
+	close(b.stop)
+}
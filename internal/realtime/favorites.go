@@ -0,0 +1,76 @@
+package realtime
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+)
+
+// favoritesCache keeps the favorite device IDs of the users with a favorites room in memory, so that
+// device events are not matched against Redis for every room. Entries are loaded on first use and
+// dropped when the user's favorites change, on this or any other instance.
+type favoritesCache struct {
+	cache *cache.RedisCache
+	mu    sync.RWMutex
+	users map[string]map[string]bool
+}
+
+func newFavoritesCache(c *cache.RedisCache) *favoritesCache {
+	return &favoritesCache{cache: c, users: make(map[string]map[string]bool)}
+}
+
+// get returns the favorite device IDs of a user, loading them from Redis when they are not cached.
+func (f *favoritesCache) get(userID string) map[string]bool {
+	f.mu.RLock()
+	favorites, ok := f.users[userID]
+	f.mu.RUnlock()
+	if ok {
+		return favorites
+	}
+
+	favorites, err := loadFavorites(f.cache, userID)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to load favorites of user %s", userID))
+		return favorites // Not cached, so that the next event tries again
+	}
+
+	f.mu.Lock()
+	f.users[userID] = favorites
+	f.mu.Unlock()
+	return favorites
+}
+
+// invalidate drops the cached favorites of a user.
+func (f *favoritesCache) invalidate(userID string) {
+	f.mu.Lock()
+	delete(f.users, userID)
+	f.mu.Unlock()
+}
+
+// reset drops every cached entry, e.g. when invalidations may have been missed.
+func (f *favoritesCache) reset() {
+	f.mu.Lock()
+	f.users = make(map[string]map[string]bool)
+	f.mu.Unlock()
+}
+
+// loadFavorites loads the favorite device IDs of a user from the cache.
+func loadFavorites(c *cache.RedisCache, userID string) (map[string]bool, error) {
+	favorites := make(map[string]bool)
+
+	value, err := c.HGet("app:user:favorites", userID)
+	if err != nil {
+		return favorites, err
+	}
+
+	if ids, ok := value.([]any); ok {
+		for _, id := range ids {
+			if s, ok := id.(string); ok {
+				favorites[s] = true
+			}
+		}
+	}
+	return favorites, nil
+}
@@ -0,0 +1,46 @@
+package realtime
+
+import "github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+
+// listenerBuffer is how many events a slow listener may fall behind before it is disconnected.
+const listenerBuffer = 256
+
+// Listen registers a listener that receives every broadcast, from this and other instances,
+// as it is emitted. A listener that falls more than listenerBuffer events behind is unregistered
+// and its channel closed rather than silently missing events, so it can reconnect and catch up
+// from the replay ring. The returned function unregisters the listener and closes the channel.
+func (b *Broadcaster) Listen() (<-chan Event, func()) {
+	ch := make(chan Event, listenerBuffer)
+
+	b.listenersMu.Lock()
+	b.listeners[ch] = struct{}{}
+	b.listenersMu.Unlock()
+
+	cancel := func() {
+		b.listenersMu.Lock()
+		defer b.listenersMu.Unlock()
+		if _, ok := b.listeners[ch]; ok {
+			delete(b.listeners, ch)
+			close(ch)
+		}
+	}
+
+	return ch, cancel
+}
+
+// notify delivers the event to every listener without blocking the broadcast, disconnecting
+// listeners whose buffer is full.
+func (b *Broadcaster) notify(event Event) {
+	b.listenersMu.Lock()
+	defer b.listenersMu.Unlock()
+
+	for ch := range b.listeners {
+		select {
+		case ch <- event:
+		default:
+			delete(b.listeners, ch)
+			close(ch)
+			helpers.LogInfo("Disconnected a slow listener at '%s' event #%d", event.Name, event.Seq)
+		}
+	}
+}
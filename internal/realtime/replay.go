@@ -0,0 +1,185 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// Redis keys of the replay ring.
+const (
+	seqKey  = "realtime:seq"    // Last sequence number assigned
+	ringKey = "realtime:events" // Sorted set of recent broadcasts, scored by sequence number
+)
+
+// Replay statuses.
+const (
+	ReplayOK             = "ok"
+	ReplayResyncRequired = "resync_required"
+)
+
+// EventResyncRequired is emitted when the missed events are no longer in the ring and
+// the client must reload its state.
+const EventResyncRequired = "resync-required"
+
+// ReplayResult is returned to the client as the ack of a replay request.
+type ReplayResult struct {
+	Status     string `json:"status"`
+	Replayed   int    `json:"replayed"`
+	CurrentSeq int64  `json:"current_seq"`
+}
+
+// withSeq returns the payload with its sequence number added. Only map payloads can
+// carry the sequence; other payloads are returned unchanged.
+func withSeq(payload any, seq int64) any {
+	m, ok := payload.(map[string]any)
+	if !ok {
+		return payload
+	}
+
+	sequenced := make(map[string]any, len(m)+1)
+	for k, v := range m {
+		sequenced[k] = v
+	}
+	sequenced["seq"] = seq
+	return sequenced
+}
+
+// decodeRingItem decodes an event of the replay ring and gives it, and its payload, the sequence
+// number it was stored with.
+func decodeRingItem(item cache.SequencedItem) (Event, error) {
+	var event Event
+	if err := json.Unmarshal(item.Data, &event); err != nil {
+		return event, err
+	}
+
+	var payload any
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return event, err
+	}
+	data, err := json.Marshal(withSeq(payload, item.Seq))
+	if err != nil {
+		return event, err
+	}
+
+	event.Seq = item.Seq
+	event.Payload = data
+	return event, nil
+}
+
+// ---------------------------------------------------------------------
+
+// Since returns the events broadcast after lastSeq, in order, together with the current
+// sequence number. It returns false when the events can no longer be replayed, either
+// because the gap is larger than the ring or because the sequence was reset.
+func (b *Broadcaster) Since(lastSeq int64) ([]Event, int64, bool) {
+	current, err := b.currentSeq()
+	if err != nil {
+		helpers.LogError(err, "Failed to read the current broadcast sequence")
+		return nil, 0, false
+	}
+
+	// A client ahead of the server means the sequence was reset.
+	if lastSeq > current {
+		return nil, current, false
+	}
+
+	if lastSeq == current {
+		return nil, current, true
+	}
+
+	oldest, ok, err := b.cache.ZMinScore(ringKey)
+	if err != nil {
+		helpers.LogError(err, "Failed to read the replay ring")
+		return nil, current, false
+	}
+
+	// The gap is larger than the ring.
+	if !ok || lastSeq+1 < oldest {
+		return nil, current, false
+	}
+
+	items, err := b.cache.ZRangeSequenced(ringKey, lastSeq+1, current)
+	if err != nil {
+		helpers.LogError(err, "Failed to read the replay ring")
+		return nil, current, false
+	}
+
+	events := make([]Event, 0, len(items))
+	for _, item := range items {
+		event, err := decodeRingItem(item)
+		if err != nil {
+			helpers.LogError(err, "Failed to decode event from the replay ring")
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, current, true
+}
+
+// Replay emits to the connection every event after lastSeq that it would have received.
+// If those events have already left the ring, a "resync-required" event is emitted instead.
+func (b *Broadcaster) Replay(conn socketio.Conn, lastSeq int64) ReplayResult {
+	events, current, ok := b.Since(lastSeq)
+	if !ok {
+		return b.resync(conn, current)
+	}
+
+	replayed := 0
+	for i := range events {
+		if !b.connWantsMessage(conn, &events[i]) {
+			continue
+		}
+
+		var payload any
+		if err := json.Unmarshal(events[i].Payload, &payload); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to decode '%s' payload from the replay ring", events[i].Name))
+			continue
+		}
+
+		conn.Emit(events[i].Name, payload)
+		replayed++
+	}
+
+	return ReplayResult{Status: ReplayOK, Replayed: replayed, CurrentSeq: current}
+}
+
+// resync tells the connection it must reload its state.
+func (b *Broadcaster) resync(conn socketio.Conn, current int64) ReplayResult {
+	result := ReplayResult{Status: ReplayResyncRequired, CurrentSeq: current}
+	conn.Emit(EventResyncRequired, result)
+	return result
+}
+
+// currentSeq returns the last sequence number assigned, or 0 if none.
+func (b *Broadcaster) currentSeq() (int64, error) {
+	value, err := b.cache.Get(seqKey)
+	if err != nil {
+		return 0, err
+	}
+
+	seq, _ := value.(float64)
+	return int64(seq), nil
+}
+
+// connWantsMessage reports whether the connection would have received the message live.
+func (b *Broadcaster) connWantsMessage(conn socketio.Conn, msg *Event) bool {
+	if msg.DeviceID != "" {
+		return connWantsDeviceEvent(conn, b.favorites, msg.DeviceID, msg.Hidden)
+	}
+
+	if msg.Room == "" {
+		return true
+	}
+
+	for _, room := range conn.Rooms() {
+		if room == msg.Room {
+			return true
+		}
+	}
+	return false
+}
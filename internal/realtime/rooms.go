@@ -0,0 +1,139 @@
+package realtime
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/apptypes"
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// Room names. Every authenticated connection joins one group room on connect and may
+// subscribe to individual device rooms or to its favorites room instead.
+const (
+	RoomGroupAdmin = "group:admin" // Users allowed to see hidden devices
+	RoomGroupUser  = "group:user"  // Users limited to visible devices
+
+	deviceRoomPrefix    = "device:"
+	favoritesRoomPrefix = "favorites:"
+)
+
+// hiddenDevicesMaxAccessLevel is the highest access level allowed to see hidden devices,
+// matching the level required to edit devices.
+const hiddenDevicesMaxAccessLevel = 2
+
+// Session is stored as the context of every authenticated Socket.IO connection.
+type Session struct {
+	Claims *apptypes.UserClaims
+}
+
+// CanSeeHiddenDevices reports whether the session's user may receive events of hidden devices.
+func (s *Session) CanSeeHiddenDevices() bool {
+	return s.Claims.AccessLevel <= hiddenDevicesMaxAccessLevel
+}
+
+// GroupRoom returns the group room the session joins on connect.
+func (s *Session) GroupRoom() string {
+	if s.CanSeeHiddenDevices() {
+		return RoomGroupAdmin
+	}
+	return RoomGroupUser
+}
+
+// FavoritesRoom returns the room for the session user's favorites list.
+func (s *Session) FavoritesRoom() string {
+	return fmt.Sprintf("%s%d", favoritesRoomPrefix, s.Claims.UserID)
+}
+
+// DeviceRoom returns the room name for a single device.
+func DeviceRoom(deviceID string) string {
+	return deviceRoomPrefix + deviceID
+}
+
+// SessionFromConn returns the session stored on the connection, or nil if not authenticated.
+func SessionFromConn(conn socketio.Conn) *Session {
+	session, _ := conn.Context().(*Session)
+	return session
+}
+
+// ---------------------------------------------------------------------
+
+// IsDeviceHidden reports whether the cached device is flagged hidden.
+func IsDeviceHidden(c *cache.RedisCache, deviceID string) bool {
+	device, err := c.GetDevice(deviceID)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to load device %s from cache", deviceID))
+		return false
+	}
+	hidden, _ := device["is_hidden"].(bool)
+	return hidden
+}
+
+// CanAccessDevice reports whether the session may subscribe to the device's room.
+func CanAccessDevice(c *cache.RedisCache, session *Session, deviceID string) bool {
+	device, err := c.GetDevice(deviceID)
+	if err != nil || device == nil {
+		return false
+	}
+	if hidden, _ := device["is_hidden"].(bool); hidden {
+		return session.CanSeeHiddenDevices()
+	}
+	return true
+}
+
+// connWantsDeviceEvent reports whether the connection is entitled to the device event through
+// any of the rooms it joined.
+func connWantsDeviceEvent(conn socketio.Conn, favorites *favoritesCache, deviceID string, hidden bool) bool {
+	session := SessionFromConn(conn)
+	if session == nil || (hidden && !session.CanSeeHiddenDevices()) {
+		return false
+	}
+
+	for _, room := range conn.Rooms() {
+		switch room {
+		case RoomGroupAdmin, RoomGroupUser, DeviceRoom(deviceID):
+			return true
+		case session.FavoritesRoom():
+			if favorites.get(fmt.Sprintf("%d", session.Claims.UserID))[deviceID] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ---------------------------------------------------------------------
+
+// emitDeviceEvent emits a device event once to every local connection entitled to it,
+// whether it joined the group room, the device room or its favorites room.
+func emitDeviceEvent(server *socketio.Server, favorites *favoritesCache, deviceID string, hidden bool, event string, payload any) {
+	emitted := make(map[string]bool)
+
+	emit := func(conn socketio.Conn) {
+		if emitted[conn.ID()] {
+			return
+		}
+		session := SessionFromConn(conn)
+		if session == nil || (hidden && !session.CanSeeHiddenDevices()) {
+			return
+		}
+		emitted[conn.ID()] = true
+		conn.Emit(event, payload)
+	}
+
+	server.ForEach("/", RoomGroupAdmin, emit)
+	if !hidden {
+		server.ForEach("/", RoomGroupUser, emit)
+	}
+	server.ForEach("/", DeviceRoom(deviceID), emit)
+
+	for _, room := range server.Rooms("/") {
+		userID, ok := strings.CutPrefix(room, favoritesRoomPrefix)
+		if !ok || !favorites.get(userID)[deviceID] {
+			continue
+		}
+		server.ForEach("/", room, emit)
+	}
+}
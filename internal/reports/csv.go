@@ -0,0 +1,32 @@
+package reports
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// RenderCSV writes the table as CSV, with the columns as the header row.
+func RenderCSV(w io.Writer, t *Table) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(t.Columns); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i := range record {
+			record[i] = ""
+			if i < len(row) {
+				record[i] = formatCell(row[i])
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
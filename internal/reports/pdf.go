@@ -0,0 +1,140 @@
+package reports
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Layout of the PDF reports, in millimetres.
+const (
+	pdfMargin       = 10.0
+	pdfRowHeight    = 6.0
+	pdfMinColWidth  = 15.0
+	pdfSampleRows   = 200 // Rows measured to size the columns
+	pdfCellPaddings = 2.0
+)
+
+// RenderPDF writes the table as a landscape A4 PDF, repeating the column header on every page.
+func RenderPDF(w io.Writer, t *Table) error {
+	pdf := fpdf.New("L", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(false, pdfMargin)
+	pdf.AliasNbPages("")
+
+	// The core fonts are not UTF-8; translate to their code page.
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	generated := fmt.Sprintf("Generated %s UTC", t.GeneratedAt.UTC().Format("2006-01-02 15:04"))
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 5, tr(generated), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	tableWidth := pageWidth - 2*pdfMargin
+	widths := pdfColumnWidths(pdf, t, tableWidth)
+
+	drawHeader := func() {
+		pdf.SetFont("Helvetica", "B", 8)
+		pdf.SetFillColor(221, 235, 247)
+		for i, column := range t.Columns {
+			pdf.CellFormat(widths[i], pdfRowHeight, tr(pdfFit(pdf, column, widths[i])), "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 8)
+	}
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 8, tr(t.Title), "", 1, "L", false, 0, "")
+	if t.Subtitle != "" {
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(0, 6, tr(t.Subtitle), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(2)
+	drawHeader()
+
+	// Leave room for the footer at the bottom of each page.
+	bottom := pageHeight - 2*pdfMargin
+
+	if len(t.Rows) == 0 {
+		pdf.CellFormat(tableWidth, pdfRowHeight, "No data for the selected period.", "1", 1, "C", false, 0, "")
+	}
+
+	for _, row := range t.Rows {
+		if pdf.GetY()+pdfRowHeight > bottom {
+			pdf.AddPage()
+			drawHeader()
+		}
+		for i := range t.Columns {
+			text := ""
+			if i < len(row) {
+				text = formatCell(row[i])
+			}
+			pdf.CellFormat(widths[i], pdfRowHeight, tr(pdfFit(pdf, text, widths[i])), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("failed to render PDF: %w", err)
+	}
+	return pdf.Output(w)
+}
+
+// pdfColumnWidths sizes the columns to their widest cell among the header and the first rows,
+// scaled to fill the table width.
+func pdfColumnWidths(pdf *fpdf.Fpdf, t *Table, tableWidth float64) []float64 {
+	widths := make([]float64, len(t.Columns))
+	if len(widths) == 0 {
+		return widths
+	}
+
+	pdf.SetFont("Helvetica", "B", 8)
+	for i, column := range t.Columns {
+		widths[i] = pdf.GetStringWidth(column) + pdfCellPaddings
+	}
+
+	pdf.SetFont("Helvetica", "", 8)
+	for r, row := range t.Rows {
+		if r >= pdfSampleRows {
+			break
+		}
+		for i := range widths {
+			if i < len(row) {
+				if width := pdf.GetStringWidth(formatCell(row[i])) + pdfCellPaddings; width > widths[i] {
+					widths[i] = width
+				}
+			}
+		}
+	}
+
+	total := 0.0
+	for i := range widths {
+		if widths[i] < pdfMinColWidth {
+			widths[i] = pdfMinColWidth
+		}
+		total += widths[i]
+	}
+	for i := range widths {
+		widths[i] *= tableWidth / total
+	}
+
+	return widths
+}
+
+// pdfFit shortens text with an ellipsis until it fits the column width.
+func pdfFit(pdf *fpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text)+pdfCellPaddings <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.GetStringWidth(string(runes)+"...")+pdfCellPaddings > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
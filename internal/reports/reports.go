@@ -0,0 +1,77 @@
+// Package reports renders tabular reports to CSV, XLSX and PDF files.
+package reports
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Table is a rendered report: a title and a list of rows under named columns.
+type Table struct {
+	Title       string
+	Subtitle    string // e.g. the period and filters of the report
+	GeneratedAt time.Time
+	Columns     []string
+	Rows        [][]any // Cells are strings, numbers, bools, time.Time or *time.Time
+}
+
+// Formats lists the supported file formats with their file extensions.
+var Formats = map[string]string{
+	"csv":  ".csv",
+	"xlsx": ".xlsx",
+	"pdf":  ".pdf",
+}
+
+// ContentTypes lists the MIME type of each format.
+var ContentTypes = map[string]string{
+	"csv":  "text/csv",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"pdf":  "application/pdf",
+}
+
+// Render writes the table to w in the given format.
+func Render(w io.Writer, format string, t *Table) error {
+	switch format {
+	case "csv":
+		return RenderCSV(w, t)
+	case "xlsx":
+		return RenderXLSX(w, t)
+	case "pdf":
+		return RenderPDF(w, t)
+	default:
+		return fmt.Errorf("unsupported report format '%s'", format)
+	}
+}
+
+// formatCell formats a cell for the text based formats.
+func formatCell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format("2006-01-02 15:04:05")
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return formatCell(*v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', 2, 64)
+	case bool:
+		if v {
+			return "Yes"
+		}
+		return "No"
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,112 @@
+package reports
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxSheet is the name of the worksheet holding the report.
+const xlsxSheet = "Report"
+
+// xlsxHeaderRow is the row of the column names; the title and subtitle are above it.
+const xlsxHeaderRow = 4
+
+// RenderXLSX writes the table as an Excel workbook with a single worksheet.
+// Rows are streamed, so large reports do not have to be held as cells in memory.
+func RenderXLSX(w io.Writer, t *Table) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	if err := f.SetSheetName("Sheet1", xlsxSheet); err != nil {
+		return fmt.Errorf("failed to name worksheet: %w", err)
+	}
+
+	titleStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}})
+	if err != nil {
+		return fmt.Errorf("failed to create title style: %w", err)
+	}
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"DDEBF7"}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create header style: %w", err)
+	}
+
+	sw, err := f.NewStreamWriter(xlsxSheet)
+	if err != nil {
+		return fmt.Errorf("failed to create worksheet writer: %w", err)
+	}
+
+	// Column widths and panes have to be set before the first row.
+	if len(t.Columns) > 0 {
+		if err := sw.SetColWidth(1, len(t.Columns), 20); err != nil {
+			return fmt.Errorf("failed to set column widths: %w", err)
+		}
+	}
+	err = sw.SetPanes(&excelize.Panes{
+		Freeze:      true,
+		YSplit:      xlsxHeaderRow,
+		TopLeftCell: fmt.Sprintf("A%d", xlsxHeaderRow+1),
+		ActivePane:  "bottomLeft",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to freeze header row: %w", err)
+	}
+
+	subtitle := fmt.Sprintf("Generated %s UTC", t.GeneratedAt.UTC().Format("2006-01-02 15:04"))
+	if t.Subtitle != "" {
+		subtitle = t.Subtitle + " - " + subtitle
+	}
+	if err := sw.SetRow("A1", []interface{}{excelize.Cell{StyleID: titleStyle, Value: t.Title}}); err != nil {
+		return fmt.Errorf("failed to write title: %w", err)
+	}
+	if err := sw.SetRow("A2", []interface{}{subtitle}); err != nil {
+		return fmt.Errorf("failed to write subtitle: %w", err)
+	}
+
+	header := make([]interface{}, len(t.Columns))
+	for i, column := range t.Columns {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: column}
+	}
+	if err := sw.SetRow(fmt.Sprintf("A%d", xlsxHeaderRow), header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+
+	for i, row := range t.Rows {
+		values := make([]interface{}, len(row))
+		for j, value := range row {
+			values[j] = xlsxValue(value)
+		}
+		if err := sw.SetRow(fmt.Sprintf("A%d", xlsxHeaderRow+1+i), values); err != nil {
+			return fmt.Errorf("failed to write row %d: %w", i+1, err)
+		}
+	}
+
+	if err := sw.Flush(); err != nil {
+		return fmt.Errorf("failed to flush worksheet: %w", err)
+	}
+
+	return f.Write(w)
+}
+
+// xlsxValue converts a cell to a value the worksheet writer understands; times are written as dates.
+func xlsxValue(value any) any {
+	switch v := value.(type) {
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return v.UTC()
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		return v.UTC()
+	default:
+		return v
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// maxAnalyticsPoints bounds the number of group/period points a single report may return.
+const maxAnalyticsPoints = 50000
+
+// ErrAnalyticsRangeTooLarge is returned when a report would exceed maxAnalyticsPoints.
+var ErrAnalyticsRangeTooLarge = errors.New("the requested range is too large for the interval and grouping")
+
+// OccupancyPoint is the occupancy of one group during one period.
+type OccupancyPoint struct {
+	Group           string  `json:"group"`
+	PeriodStart     string  `json:"period_start"`     // RFC 3339 in the requested timezone
+	PeriodSeconds   int64   `json:"period_seconds"`   // Part of the period within the requested range
+	Devices         int     `json:"devices"`          // Bays in the group
+	OccupiedSeconds int64   `json:"occupied_seconds"` // Summed over the bays
+	OccupancyRate   float64 `json:"occupancy_rate"`   // occupied_seconds / (devices * period_seconds)
+}
+
+// TurnoverPoint is the turnover and dwell time of one group during one period.
+type TurnoverPoint struct {
+	Group           string  `json:"group"`
+	PeriodStart     string  `json:"period_start"`
+	Devices         int     `json:"devices"`
+	Arrivals        int64   `json:"arrivals"`
+	Departures      int64   `json:"departures"`
+	TurnoverRate    float64 `json:"turnover_rate"`     // Arrivals per bay
+	AvgDwellSeconds float64 `json:"avg_dwell_seconds"` // Average duration of the sessions that ended in the period
+}
+
+// analyticsPeriod is one period of a report, with its part inside the requested range.
+type analyticsPeriod struct {
+	start   time.Time
+	seconds int64
+}
+
+// -----------------------------------------------------------------------------
+
+// RecomputeOccupancyRollups rebuilds the hourly and daily rollups of the devices from since until now.
+func (s *Service) RecomputeOccupancyRollups(deviceIDs []string, since time.Time) {
+	if err := s.models.OccupancyRollup.Recompute(deviceIDs, since, time.Now().UTC().Add(time.Hour)); err != nil {
+		helpers.LogError(err, "Failed to recompute occupancy rollups")
+	}
+}
+
+// RefreshOccupancyRollups recomputes the previous and current hour of every device,
+// so bays that stay occupied accumulate occupancy without new events.
+func (s *Service) RefreshOccupancyRollups() {
+	s.RecomputeOccupancyRollups(nil, time.Now().UTC().Add(-time.Hour))
+}
+
+// BackfillOccupancyRollups recomputes the rollups of every device in [from, to), one day at a time.
+func (s *Service) BackfillOccupancyRollups(from, to time.Time) {
+	for dayStart := from.UTC(); dayStart.Before(to); dayStart = dayStart.AddDate(0, 0, 1) {
+		dayEnd := dayStart.AddDate(0, 0, 1)
+		if dayEnd.After(to) {
+			dayEnd = to
+		}
+
+		if err := s.models.OccupancyRollup.Recompute(nil, dayStart, dayEnd); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to backfill occupancy rollups of %s", dayStart.Format("2006-01-02")))
+		}
+	}
+
+	helpers.LogInfo("Backfilled occupancy rollups from %s to %s", from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+}
+
+// -----------------------------------------------------------------------------
+
+// OccupancyReport returns the occupancy rate per group and period.
+func (s *Service) OccupancyReport(q models.RollupQuery) ([]OccupancyPoint, error) {
+	periods, devices, aggregates, err := s.analyticsData(&q)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := sortedGroups(devices)
+
+	points := []OccupancyPoint{}
+	for _, period := range periods {
+		for _, group := range groups {
+			count := devices[group]
+			a := aggregates[analyticsKey(group, period.start)]
+
+			point := OccupancyPoint{
+				Group:           group,
+				PeriodStart:     period.start.Format(time.RFC3339),
+				PeriodSeconds:   period.seconds,
+				Devices:         count,
+				OccupiedSeconds: a.OccupiedSeconds,
+			}
+			if capacity := int64(count) * period.seconds; capacity > 0 {
+				point.OccupancyRate = float64(a.OccupiedSeconds) / float64(capacity)
+			}
+			points = append(points, point)
+		}
+	}
+
+	return points, nil
+}
+
+// TurnoverReport returns the arrivals per bay and the average dwell time per group and period.
+func (s *Service) TurnoverReport(q models.RollupQuery) ([]TurnoverPoint, error) {
+	periods, devices, aggregates, err := s.analyticsData(&q)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := sortedGroups(devices)
+
+	points := []TurnoverPoint{}
+	for _, period := range periods {
+		for _, group := range groups {
+			count := devices[group]
+			a := aggregates[analyticsKey(group, period.start)]
+
+			point := TurnoverPoint{
+				Group:       group,
+				PeriodStart: period.start.Format(time.RFC3339),
+				Devices:     count,
+				Arrivals:    a.Arrivals,
+				Departures:  a.Departures,
+			}
+			if count > 0 {
+				point.TurnoverRate = float64(a.Arrivals) / float64(count)
+			}
+			if a.DwellCount > 0 {
+				point.AvgDwellSeconds = float64(a.DwellSeconds) / float64(a.DwellCount)
+			}
+			points = append(points, point)
+		}
+	}
+
+	return points, nil
+}
+
+// analyticsData loads what a report needs: the periods of the range in the query timezone,
+// the number of bays per group and the summed rollups keyed by group and period.
+// The query range is widened to whole periods.
+func (s *Service) analyticsData(q *models.RollupQuery) ([]analyticsPeriod, map[string]int, map[string]models.RollupAggregate, error) {
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid timezone '%s'", q.Timezone)
+	}
+
+	// Split the range into periods, clipping the last one to the range and to now.
+	rangeEnd := q.To
+	if now := time.Now().UTC(); now.Before(rangeEnd) {
+		rangeEnd = now
+	}
+
+	var periods []analyticsPeriod
+	for start := truncatePeriod(q.From.In(loc), q.Interval); start.Before(rangeEnd); start = nextPeriod(start, q.Interval) {
+		end := nextPeriod(start, q.Interval)
+		if end.After(rangeEnd) {
+			end = rangeEnd
+		}
+		periods = append(periods, analyticsPeriod{start: start, seconds: int64(end.Sub(start).Seconds())})
+	}
+	if len(periods) > 0 {
+		q.From = periods[0].start.UTC()
+	}
+
+	devices, err := s.models.OccupancyRollup.CountDevices(*q)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if q.GroupBy == "device" {
+		for group := range devices {
+			devices[group] = 1
+		}
+	}
+
+	if len(periods)*len(devices) > maxAnalyticsPoints {
+		return nil, nil, nil, ErrAnalyticsRangeTooLarge
+	}
+
+	rows, err := s.models.OccupancyRollup.Aggregate(*q)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	aggregates := make(map[string]models.RollupAggregate, len(rows))
+	for _, row := range rows {
+		// The period is the wall clock time in the query timezone.
+		start := time.Date(row.Period.Year(), row.Period.Month(), row.Period.Day(), row.Period.Hour(), 0, 0, 0, loc)
+		aggregates[analyticsKey(row.Group, start)] = row
+	}
+
+	return periods, devices, aggregates, nil
+}
+
+// sortedGroups returns the group names in order.
+func sortedGroups(devices map[string]int) []string {
+	groups := make([]string, 0, len(devices))
+	for group := range devices {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+func analyticsKey(group string, start time.Time) string {
+	return fmt.Sprintf("%s|%d", group, start.Unix())
+}
+
+// truncatePeriod returns the start of the period that contains t, in t's location.
+func truncatePeriod(t time.Time, interval string) time.Time {
+	switch interval {
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case "week":
+		// Weeks start on Monday, as in PostgreSQL's date_trunc.
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
+
+// nextPeriod returns the start of the period after the one starting at start.
+func nextPeriod(start time.Time, interval string) time.Time {
+	switch interval {
+	case "hour":
+		return start.Add(time.Hour)
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
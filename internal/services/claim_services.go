@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// Claim errors.
+var (
+	ErrClaimNotFound = errors.New("claim code not found")
+	ErrClaimRedeemed = errors.New("claim code already redeemed")
+	ErrBayNotFound   = errors.New("bay not found")
+)
+
+// ErrInvalidNetworkType is returned when generating claim codes for an unknown network type.
+var ErrInvalidNetworkType = errors.New("invalid network_type, must be LoRa, SigFox or NB-IoT")
+
+// Claim is what an installer records when redeeming a claim code on site.
+type Claim struct {
+	Code      string
+	Name      string // Optional, the device keeps its name or gets a generated one
+	Latitude  float64
+	Longitude float64
+	BayID     *int // Optional bay the device monitors
+}
+
+// ClaimResult is the outcome of a redeemed claim code.
+type ClaimResult struct {
+	Claim      *models.ClaimCode     `json:"claim"`
+	Device     *models.Device        `json:"device"`
+	Assignment *models.BayAssignment `json:"assignment"` // Nil when no bay was given
+	Registered bool                  `json:"registered"` // The device was pending, or not seen yet, and is now registered
+}
+
+// GenerateClaimCodes creates a claim code for each device of a batch, to be printed on their labels.
+func (s *Service) GenerateClaimCodes(batch, networkType string, deviceIDs []string) ([]*models.ClaimCode, error) {
+	networkType, ok := networkTypes[strings.ToLower(networkType)]
+	if !ok {
+		return nil, ErrInvalidNetworkType
+	}
+
+	codes := make([]*models.ClaimCode, 0, len(deviceIDs))
+	seen := make(map[string]bool, len(deviceIDs))
+
+	for _, deviceID := range deviceIDs {
+		if seen[deviceID] {
+			continue
+		}
+		seen[deviceID] = true
+
+		code, err := models.NewClaimCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, &models.ClaimCode{
+			Code:        code,
+			DeviceID:    deviceID,
+			NetworkType: networkType,
+			Batch:       batch,
+		})
+	}
+
+	if err := s.models.ClaimCode.CreateBatch(codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RedeemClaim registers the device of a claim code at the installer's location, assigning it to a bay
+// when one is given. A pending device is approved and its held traffic released; a device that has not
+// sent traffic yet is registered straight away; a registered device is moved to the new location.
+func (s *Service) RedeemClaim(claim Claim, userID int) (*ClaimResult, error) {
+	code, err := s.models.ClaimCode.GetByCode(models.NormalizeClaimCode(claim.Code))
+	if err != nil {
+		if err.Error() == "claim code not found" {
+			return nil, ErrClaimNotFound
+		}
+		return nil, err
+	}
+	if code.ClaimedAt != nil {
+		return nil, ErrClaimRedeemed
+	}
+
+	// Check the bay before claiming, so that a busy bay does not use up the code.
+	var bay *models.Bay
+	if claim.BayID != nil {
+		if bay, err = s.models.Bay.GetByID(*claim.BayID); err != nil {
+			if err.Error() == "bay not found" {
+				return nil, ErrBayNotFound
+			}
+			return nil, err
+		}
+		if bay.DeviceID != nil && *bay.DeviceID != code.DeviceID {
+			return nil, models.ErrBayHasDevice
+		}
+	}
+
+	if code, err = s.models.ClaimCode.Claim(code.Code, userID); err != nil {
+		if err.Error() == "claim code already redeemed" {
+			return nil, ErrClaimRedeemed
+		}
+		return nil, err
+	}
+
+	result := &ClaimResult{Claim: code}
+	result.Device, result.Registered, err = s.registerClaimedDevice(code, claim)
+	if err != nil {
+		if err := s.models.ClaimCode.Release(code.Code); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to release claim code of device %s", code.DeviceID))
+		}
+		return nil, err
+	}
+
+	if bay != nil && bay.DeviceID == nil {
+		result.Assignment, err = s.models.BayAssignment.Assign(bay.ID, code.DeviceID, time.Now().UTC())
+		if err != nil {
+			return result, err
+		}
+		s.RebuildZoneCounts()
+	}
+	s.RebuildSpatialIndex()
+
+	return result, nil
+}
+
+// registerClaimedDevice registers the device of a redeemed code, or moves it when it is already registered.
+func (s *Service) registerClaimedDevice(code *models.ClaimCode, claim Claim) (*models.Device, bool, error) {
+	device, err := s.models.Device.GetByID(code.DeviceID)
+	if err == nil {
+		fields := map[string]interface{}{"latitude": claim.Latitude, "longitude": claim.Longitude}
+		if claim.Name != "" {
+			fields["name"] = claim.Name
+		}
+		device, err = s.models.Device.UpdateByID(device.DeviceID, fields)
+		return device, false, err
+	}
+	if !strings.HasPrefix(err.Error(), "device not found") {
+		return nil, false, err
+	}
+
+	// List a device that has not sent traffic yet, so that it is approved like a pending one.
+	if _, err := s.models.PendingDevice.GetByID(code.DeviceID); err != nil {
+		if err.Error() != "pending device not found" {
+			return nil, false, err
+		}
+		if err := s.models.PendingDevice.Seen(code.DeviceID, code.NetworkType, 0); err != nil {
+			return nil, false, err
+		}
+	}
+
+	device, err = s.ApproveDevice(code.DeviceID, claim.Name, &claim.Latitude, &claim.Longitude)
+	return device, err == nil, err
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/devicefile"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// networkTypes maps the lower-cased network types to how they are stored.
+var networkTypes = map[string]string{"lora": "LoRa", "sigfox": "SigFox", "nb-iot": "NB-IoT"}
+
+// ErrImportInvalid is returned when an import is applied while its file has issues.
+var ErrImportInvalid = errors.New("the file has issues, no devices were imported")
+
+// ImportIssue is a problem with a row of an imported file.
+type ImportIssue struct {
+	Row      int    `json:"row"`
+	DeviceID string `json:"device_id"`
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+}
+
+// ImportReport is the outcome of validating, and when not a dry run applying, a device import.
+type ImportReport struct {
+	DryRun   bool          `json:"dry_run"`
+	Applied  bool          `json:"applied"`
+	Rows     int           `json:"rows"`
+	Created  int           `json:"created"`  // New devices
+	Updated  int           `json:"updated"`  // Existing devices
+	Restored int           `json:"restored"` // Soft deleted devices, restored by the import
+	Issues   []ImportIssue `json:"issues"`
+}
+
+// validateImport checks the records of an import, normalising their network types, and counts
+// the devices they would create, update and restore.
+func (s *Service) validateImport(records []*devicefile.Record) (*ImportReport, error) {
+	existing, err := s.models.Device.GetAllIncludingDeleted()
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[string]bool, len(existing))
+	for _, d := range existing {
+		deleted[d.DeviceID] = !d.DeletedAt.IsZero()
+	}
+
+	report := &ImportReport{Rows: len(records), Issues: []ImportIssue{}}
+	firstRow := make(map[string]int, len(records))
+
+	for _, record := range records {
+		d := &record.Device
+		issue := func(field, message string) {
+			report.Issues = append(report.Issues, ImportIssue{Row: record.Row, DeviceID: d.DeviceID, Field: field, Message: message})
+		}
+
+		fields := make([]string, 0, len(record.Errors))
+		for field := range record.Errors {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			issue(field, record.Errors[field])
+		}
+
+		if d.DeviceID != "" && len(d.DeviceID) < 3 {
+			issue("device_id", "device_id must be at least 3 characters long")
+		}
+		if d.Name != "" && len(d.Name) < 3 {
+			issue("name", "name must be at least 3 characters long")
+		}
+
+		if networkType, ok := networkTypes[strings.ToLower(d.NetworkType)]; ok {
+			d.NetworkType = networkType
+		} else if d.NetworkType != "" {
+			issue("network_type", fmt.Sprintf("invalid network_type '%s', must be LoRa, SigFox or NB-IoT", d.NetworkType))
+		}
+
+		if _, ok := record.Errors["latitude"]; !ok && (math.IsNaN(d.Latitude) || d.Latitude < -90 || d.Latitude > 90) {
+			issue("latitude", fmt.Sprintf("latitude %v is out of range", d.Latitude))
+		}
+		if _, ok := record.Errors["longitude"]; !ok && (math.IsNaN(d.Longitude) || d.Longitude < -180 || d.Longitude > 180) {
+			issue("longitude", fmt.Sprintf("longitude %v is out of range", d.Longitude))
+		}
+
+		if d.DeviceID == "" {
+			continue
+		}
+		if row, ok := firstRow[d.DeviceID]; ok {
+			issue("device_id", fmt.Sprintf("duplicate device_id, first seen on row %d", row))
+			continue
+		}
+		firstRow[d.DeviceID] = record.Row
+
+		isDeleted, exists := deleted[d.DeviceID]
+		switch {
+		case !exists:
+			report.Created++
+		case isDeleted:
+			report.Restored++
+		default:
+			report.Updated++
+		}
+	}
+
+	return report, nil
+}
+
+// ImportDevices validates the records of a device import and, unless it is a dry run, upserts them
+// in a single transaction, then refreshes the device cache, the Bloom filter and the indexes.
+// Nothing is imported while the file has issues; the report lists them.
+func (s *Service) ImportDevices(records []*devicefile.Record, dryRun bool) (*ImportReport, error) {
+	report, err := s.validateImport(records)
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = dryRun
+	if dryRun {
+		return report, nil
+	}
+	if len(report.Issues) > 0 {
+		return report, ErrImportInvalid
+	}
+
+	// Existing devices only take the values given in the file, so optional columns left out
+	// do not reset their flags or firmware version.
+	devices := make([]*models.Device, len(records))
+	columns := make([][]string, len(records))
+	for i, record := range records {
+		devices[i] = &record.Device
+		for _, column := range record.Columns {
+			if slices.Contains(models.UpsertColumns, column) {
+				columns[i] = append(columns[i], column)
+			}
+		}
+	}
+
+	// Upsert also saves the devices to the cache.
+	devices, err = s.models.Device.UpsertMany(devices, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(devices))
+	for i, d := range devices {
+		keys[i] = fmt.Sprintf("%s %s", d.NetworkType, d.DeviceID)
+	}
+	if _, err := s.cache.AddItemsToBloomFilter("registered-devices", keys); err != nil {
+		helpers.LogError(err, "Failed to add imported devices to the Bloom Filter")
+	}
+
+	s.RebuildZoneCounts()
+	s.RebuildSpatialIndex()
+
+	report.Applied = true
+	return report, nil
+}
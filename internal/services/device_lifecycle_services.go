@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// defaultDeviceRetention is how long a deleted device can be restored before it is purged.
+const defaultDeviceRetention = 30 * 24 * time.Hour
+
+// Purge errors.
+var (
+	ErrDeviceNotDeleted    = errors.New("device is not deleted")
+	ErrRetentionNotElapsed = errors.New("retention period has not elapsed")
+)
+
+// DeletedDevice is a soft-deleted device with the time it is purged at.
+type DeletedDevice struct {
+	*models.Device
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+// DeviceRetention returns the retention grace period of deleted devices, from the device_retention_days setting.
+func (s *Service) DeviceRetention() time.Duration {
+	value, err := s.cache.HGet("app:settings", "device_retention_days")
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve 'device_retention_days' from application settings.")
+	}
+	if days, _ := value.(string); days != "" {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour
+		}
+	}
+	return defaultDeviceRetention
+}
+
+// ChangeDeviceState moves a device to another lifecycle state and rebuilds the zone counts and the
+// spatial index, which only count the devices in service.
+func (s *Service) ChangeDeviceState(deviceID, state string) (*models.Device, error) {
+	device, err := s.models.Device.SetState(deviceID, state)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RebuildZoneCounts()
+	s.RebuildSpatialIndex()
+	return device, nil
+}
+
+// RestoreDevice brings back a soft-deleted device in the state it was deleted from.
+func (s *Service) RestoreDevice(deviceID string) (*models.Device, error) {
+	device, err := s.models.Device.Restore(deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RebuildZoneCounts()
+	s.RebuildSpatialIndex()
+	return device, nil
+}
+
+// PurgeDevice permanently deletes a soft-deleted device and its logs. Unless forced, the device must
+// have been deleted for longer than the retention period.
+func (s *Service) PurgeDevice(deviceID string, force bool) error {
+	device, err := s.models.Device.GetByIDIncludingDeleted(deviceID)
+	if err != nil {
+		return err
+	}
+	if models.DeviceState(device.LifecycleState) != models.StateDeleted {
+		return ErrDeviceNotDeleted
+	}
+	if !force && time.Since(device.DeletedAt) < s.DeviceRetention() {
+		return fmt.Errorf("%w: device %s can be purged after %s", ErrRetentionNotElapsed,
+			deviceID, device.DeletedAt.Add(s.DeviceRetention()).Format(time.RFC3339))
+	}
+
+	if err := s.models.Device.Purge(deviceID); err != nil {
+		return err
+	}
+
+	// The device stays in the Bloom filter, which cannot remove items. Its next message finds it
+	// missing and announces it again, see AdmitTraffic.
+	if err := s.cache.SetRegistrationStatus(deviceID, ""); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to clear registration status of device %s", deviceID))
+	}
+	if err := s.cache.DropHeldMessages(deviceID); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to drop held messages of device %s", deviceID))
+	}
+
+	s.RebuildZoneCounts()
+	s.RebuildSpatialIndex()
+	return nil
+}
+
+// PurgeExpiredDevices purges the devices deleted for longer than the retention period.
+func (s *Service) PurgeExpiredDevices() {
+	devices, err := s.models.Device.GetDeleted(time.Now().UTC().Add(-s.DeviceRetention()))
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve expired deleted devices")
+		return
+	}
+
+	purged := 0
+	for _, device := range devices {
+		if err := s.PurgeDevice(device.DeviceID, false); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to purge device %s", device.DeviceID))
+			continue
+		}
+		purged++
+	}
+	if purged > 0 {
+		helpers.LogInfo("Purged %d deleted devices", purged)
+	}
+}
+
+// DeletedDevices retrieves the soft-deleted devices with the time each one is purged at.
+func (s *Service) DeletedDevices() ([]DeletedDevice, error) {
+	devices, err := s.models.Device.GetDeleted(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	retention := s.DeviceRetention()
+	views := make([]DeletedDevice, len(devices))
+	for i, d := range devices {
+		views[i] = DeletedDevice{Device: d, PurgeAfter: d.DeletedAt.Add(retention)}
+	}
+	return views, nil
+}
+
+// AdmitTraffic decides whether the traffic of a registered device is processed, following the ingest
+// behaviour of its lifecycle state. A provisioned device becomes active with its first message.
+// It returns the state of the device and whether its message should be processed.
+//
+// Devices missing from the cache are looked up in the database: deleted devices are not cached,
+// and purged devices are announced again so that they are registered anew.
+func (s *Service) AdmitTraffic(networkType, deviceID string, firmwareVersion float64, deviceData map[string]any) (string, bool) {
+	state, _ := deviceData["lifecycle_state"].(string)
+
+	if deviceData == nil {
+		device, err := s.models.Device.GetByIDIncludingDeleted(deviceID)
+		switch {
+		case err == nil:
+			state = device.LifecycleState
+		case err.Error() == "device not found":
+			if s.bus != nil {
+				s.bus.Publish(events.DeviceRegistered{DeviceID: deviceID, NetworkType: networkType, FirmwareVersion: firmwareVersion})
+			}
+			return "", true
+		default:
+			helpers.LogError(err, fmt.Sprintf("Failed to retrieve device %s", deviceID))
+			return "", true
+		}
+	}
+
+	state = models.DeviceState(state)
+	if !models.LifecycleStates[state].Ingest {
+		helpers.LogInfo("Device %s is %s. Request ignored.", deviceID, state)
+		return state, false
+	}
+
+	if state == models.StateProvisioned {
+		if _, err := s.ChangeDeviceState(deviceID, models.StateActive); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to activate provisioned device %s", deviceID))
+		} else {
+			state = models.StateActive
+		}
+	}
+	return state, true
+}
+
+// countsInAnalytics reports whether a cached device is in service, counting in the sessions, rollups,
+// zone counts and spatial queries.
+func countsInAnalytics(device map[string]any) bool {
+	state, _ := device["lifecycle_state"].(string)
+	return models.LifecycleStates[models.DeviceState(state)].Analytics
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// ErrTagsInvalid is returned when tags do not match the tag definitions; nothing is saved.
+var ErrTagsInvalid = errors.New("the tags do not match the tag definitions")
+
+// TagIssue is a problem with the tags of a device, or with the edit itself when DeviceID is empty.
+type TagIssue struct {
+	DeviceID string `json:"device_id,omitempty"`
+	Message  string `json:"message"`
+}
+
+// ValidateTags checks a device's complete set of tags against the tag definitions,
+// returning ErrTagsInvalid with the problems found.
+func (s *Service) ValidateTags(tags models.DeviceTags) ([]string, error) {
+	defs, err := s.models.TagDefinition.GetMap()
+	if err != nil {
+		return nil, err
+	}
+
+	if problems := models.ValidateDeviceTags(defs, tags); len(problems) > 0 {
+		return problems, ErrTagsInvalid
+	}
+	return nil, nil
+}
+
+// BulkUpdateTags sets and removes tags on several devices at once. The resulting tags of every device
+// are validated first; when any device has issues none is updated and ErrTagsInvalid is returned.
+// It returns the number of devices updated.
+func (s *Service) BulkUpdateTags(deviceIDs []string, set models.DeviceTags, remove []string) (int, []TagIssue, error) {
+	defs, err := s.models.TagDefinition.GetMap()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	issues := []TagIssue{}
+
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := models.ValidateTagValue(defs[key], key, set[key]); err != nil {
+			issues = append(issues, TagIssue{Message: err.Error()})
+		}
+	}
+	for _, key := range remove {
+		if _, ok := set[key]; ok {
+			issues = append(issues, TagIssue{Message: fmt.Sprintf("tag '%s' cannot be both set and removed", key)})
+		}
+	}
+	if len(issues) > 0 {
+		return 0, issues, ErrTagsInvalid
+	}
+
+	devices, _, err := s.models.Device.Search(models.DeviceFilter{DeviceIDs: deviceIDs})
+	if err != nil {
+		return 0, nil, err
+	}
+	found := make(map[string]*models.Device, len(devices))
+	for _, device := range devices {
+		found[device.DeviceID] = device
+	}
+
+	tagsByDevice := make(map[string]models.DeviceTags, len(devices))
+	for _, deviceID := range deviceIDs {
+		device, ok := found[deviceID]
+		if !ok {
+			issues = append(issues, TagIssue{DeviceID: deviceID, Message: "device not found or has been deleted"})
+			continue
+		}
+
+		tags := models.DeviceTags{}
+		for key, value := range device.Tags {
+			tags[key] = value
+		}
+		for key, value := range set {
+			tags[key] = value
+		}
+		for _, key := range remove {
+			delete(tags, key)
+		}
+
+		// The tags the device already had must match the definitions too, and required tags must remain.
+		for _, problem := range models.ValidateDeviceTags(defs, tags) {
+			issues = append(issues, TagIssue{DeviceID: deviceID, Message: problem})
+		}
+		tagsByDevice[deviceID] = tags
+	}
+	if len(issues) > 0 {
+		return 0, issues, ErrTagsInvalid
+	}
+
+	if err := s.models.Device.SetTags(tagsByDevice); err != nil {
+		return 0, nil, err
+	}
+	return len(tagsByDevice), issues, nil
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+const (
+	// defaultForecastHistoryWeeks is how much history the occupancy profiles are learned from.
+	defaultForecastHistoryWeeks = 8
+
+	// forecastTrendHours is the number of completed hours compared against the profiles
+	// to correct the forecast for today's trend.
+	forecastTrendHours = 3
+
+	// forecastTrendDecay is how fast the trend correction fades with the horizon.
+	forecastTrendDecay = 3 * time.Hour
+
+	// forecastStateDecay is how fast the current state of a bay stops predicting its future state.
+	forecastStateDecay = 30 * time.Minute
+)
+
+// ErrUnknownZone is returned when a query selects a zone that does not exist.
+var ErrUnknownZone = errors.New("unknown zone")
+
+// ForecastQuery selects the bays and the horizon of a forecast.
+type ForecastQuery struct {
+	Zone      string // Zone ID, "all" for every bay
+	DeviceIDs []string
+	Horizon   time.Duration
+}
+
+// DeviceForecast is the forecast state of one bay.
+type DeviceForecast struct {
+	DeviceID            string  `json:"device_id"`
+	IsOccupied          bool    `json:"is_occupied"`          // Current state
+	HasProfile          bool    `json:"has_profile"`          // False until the bay has history to learn from
+	SeasonalRate        float64 `json:"seasonal_rate"`        // Average occupancy of the forecast weekday and hour
+	TrendCorrection     float64 `json:"trend_correction"`     // Recent deviation from the profile, applied to the forecast
+	OccupiedProbability float64 `json:"occupied_probability"` // Probability the bay is occupied at the forecast time
+	FreeProbability     float64 `json:"free_probability"`
+}
+
+// ZoneForecast is the forecast availability of the bays of a zone.
+type ZoneForecast struct {
+	Zone               string           `json:"zone"`
+	HorizonMinutes     float64          `json:"horizon_minutes"`
+	ForecastAt         string           `json:"forecast_at"` // RFC 3339, UTC
+	TrainedAt          *time.Time       `json:"trained_at"`  // Nil until the profiles are trained
+	Capacity           int              `json:"capacity"`
+	CurrentlyFree      int              `json:"currently_free"`
+	ExpectedFree       float64          `json:"expected_free"`        // Sum of the free probabilities
+	ProbabilityAnyFree float64          `json:"probability_any_free"` // Probability that at least one bay is free
+	Devices            []DeviceForecast `json:"devices"`
+}
+
+// forecastTimezone returns the timezone the weekday/hour profiles are learned in, from FORECAST_TIMEZONE.
+func forecastTimezone() *time.Location {
+	if loc, err := time.LoadLocation(os.Getenv("FORECAST_TIMEZONE")); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// forecastHistoryWeeks returns the number of weeks learned from, from FORECAST_HISTORY_WEEKS, or the default.
+func forecastHistoryWeeks() int {
+	weeks, err := strconv.Atoi(os.Getenv("FORECAST_HISTORY_WEEKS"))
+	if err != nil || weeks <= 0 {
+		return defaultForecastHistoryWeeks
+	}
+	return weeks
+}
+
+// slotKey returns the ISO weekday and hour of t in loc, the key of the profiles.
+func slotKey(t time.Time, loc *time.Location) [2]int {
+	local := t.In(loc)
+	weekday := int(local.Weekday())
+	if weekday == 0 {
+		weekday = 7
+	}
+	return [2]int{weekday, local.Hour()}
+}
+
+// -----------------------------------------------------------------------------
+
+// TrainForecastProfiles relearns the weekday/hour occupancy profiles of every device from the hourly rollups.
+func (s *Service) TrainForecastProfiles() {
+	to := time.Now().UTC().Truncate(time.Hour)
+	from := to.AddDate(0, 0, -7*forecastHistoryWeeks())
+
+	count, err := s.models.OccupancyProfile.Train(from, to, forecastTimezone().String())
+	if err != nil {
+		helpers.LogError(err, "Failed to train occupancy profiles")
+		return
+	}
+
+	helpers.LogInfo("Trained %d occupancy profiles from %s to %s", count, from.Format(time.RFC3339), to.Format(time.RFC3339))
+}
+
+// selectDevices resolves a zone and an optional list of devices to the devices they have in common.
+// It returns all as true when neither restricts the devices.
+func (s *Service) selectDevices(zone string, deviceIDs []string) (selected map[string]bool, all bool, err error) {
+	zoneDeviceIDs, err := s.zoneDeviceIDs(zone)
+	if err != nil {
+		return nil, false, err
+	}
+	if zoneDeviceIDs == nil && len(deviceIDs) == 0 {
+		return nil, true, nil
+	}
+
+	inZone := make(map[string]bool, len(zoneDeviceIDs))
+	for _, deviceID := range zoneDeviceIDs {
+		inZone[deviceID] = true
+	}
+
+	selected = make(map[string]bool)
+	if len(deviceIDs) == 0 {
+		return inZone, false, nil
+	}
+	for _, deviceID := range deviceIDs {
+		if zoneDeviceIDs == nil || inZone[deviceID] {
+			selected[deviceID] = true
+		}
+	}
+	return selected, false, nil
+}
+
+// Forecast predicts the availability of the bays of a zone after the horizon. Each bay's forecast
+// starts from its seasonal profile, corrected by how far the last hours deviated from the profile,
+// and is blended with its current state, which dominates for short horizons.
+func (s *Service) Forecast(q ForecastQuery) (*ZoneForecast, error) {
+	selected, all, err := s.selectDevices(q.Zone, q.DeviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := s.cache.GetAllDevices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve devices from cache: %w", err)
+	}
+
+	// Only the devices in service count as capacity.
+	var deviceIDs []string
+	for deviceID, device := range devices {
+		if isDeletedDevice(device) || !countsInAnalytics(device) {
+			continue
+		}
+		if all || selected[deviceID] {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+	sort.Strings(deviceIDs)
+
+	now := time.Now().UTC()
+	target := now.Add(q.Horizon)
+	loc := forecastTimezone()
+
+	result := &ZoneForecast{
+		Zone:           q.Zone,
+		HorizonMinutes: q.Horizon.Minutes(),
+		ForecastAt:     target.Format(time.RFC3339),
+		Capacity:       len(deviceIDs),
+		Devices:        []DeviceForecast{},
+	}
+	if len(deviceIDs) == 0 {
+		return result, nil
+	}
+
+	// Index the profiles by device, weekday and hour.
+	profiles, err := s.models.OccupancyProfile.GetProfiles(deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	rates := make(map[string]map[[2]int]float64)
+	for _, p := range profiles {
+		if rates[p.DeviceID] == nil {
+			rates[p.DeviceID] = make(map[[2]int]float64)
+		}
+		rates[p.DeviceID][[2]int{p.Weekday, p.Hour}] = p.OccupancyRate
+		if result.TrainedAt == nil || p.TrainedAt.After(*result.TrainedAt) {
+			trainedAt := p.TrainedAt
+			result.TrainedAt = &trainedAt
+		}
+	}
+
+	// Load the occupancy of the last completed hours for the trend correction.
+	trendTo := now.Truncate(time.Hour)
+	trendFrom := trendTo.Add(-forecastTrendHours * time.Hour)
+	aggregates, err := s.models.OccupancyRollup.Aggregate(models.RollupQuery{
+		From:      trendFrom,
+		To:        trendTo,
+		Interval:  "hour",
+		Timezone:  "UTC",
+		GroupBy:   "device",
+		DeviceIDs: deviceIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	recent := make(map[string]int64)
+	for _, a := range aggregates {
+		recent[analyticsKey(a.Group, a.Period)] = a.OccupiedSeconds
+	}
+
+	stateWeight := math.Exp(-q.Horizon.Seconds() / forecastStateDecay.Seconds())
+	trendWeight := math.Exp(-q.Horizon.Seconds() / forecastTrendDecay.Seconds())
+	probabilityAllOccupied := 1.0
+
+	for _, deviceID := range deviceIDs {
+		f := DeviceForecast{
+			DeviceID:   deviceID,
+			IsOccupied: cache.IsOccupiedValue(devices[deviceID]["is_occupied"]),
+		}
+
+		state := 0.0
+		if f.IsOccupied {
+			state = 1
+		} else {
+			result.CurrentlyFree++
+		}
+
+		deviceRates, hasProfile := rates[deviceID]
+		f.HasProfile = hasProfile
+
+		if hasProfile {
+			f.SeasonalRate = deviceRates[slotKey(target, loc)]
+
+			// Average deviation of the last completed hours from their profile.
+			var deviation float64
+			for hour := trendFrom; hour.Before(trendTo); hour = hour.Add(time.Hour) {
+				actual := float64(recent[analyticsKey(deviceID, hour)]) / 3600
+				deviation += actual - deviceRates[slotKey(hour, loc)]
+			}
+			f.TrendCorrection = trendWeight * deviation / forecastTrendHours
+
+			seasonal := clamp01(f.SeasonalRate + f.TrendCorrection)
+			f.OccupiedProbability = stateWeight*state + (1-stateWeight)*seasonal
+		} else {
+			// Without history the current state is the best guess.
+			f.OccupiedProbability = state
+		}
+
+		f.OccupiedProbability = clamp01(f.OccupiedProbability)
+		f.FreeProbability = 1 - f.OccupiedProbability
+
+		result.ExpectedFree += f.FreeProbability
+		probabilityAllOccupied *= f.OccupiedProbability
+		result.Devices = append(result.Devices, f)
+	}
+
+	result.ProbabilityAnyFree = 1 - probabilityAllOccupied
+
+	return result, nil
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+)
+
+// GeoJSONQuery selects the devices of a GeoJSON feed.
+type GeoJSONQuery struct {
+	Zone       string    // Zone ID, "all" for every device
+	Since      time.Time // Only devices updated at or after this time, zero for every device
+	ShowHidden bool
+}
+
+// FeatureCollection is a GeoJSON feed of devices. Deleted and UpdatedAt are foreign members
+// for incremental clients: the IDs of the devices deleted since the query's since, and the time
+// to pass as since on the next query.
+type FeatureCollection struct {
+	Type      string     `json:"type"`
+	Features  []Feature  `json:"features"`
+	Deleted   []string   `json:"deleted,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at"` // Latest update of the features, nil when there are none
+}
+
+// Feature is a device in a GeoJSON feed.
+type Feature struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id"`
+	Geometry   Point          `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// Point is a GeoJSON point.
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // [longitude, latitude]
+}
+
+// DeviceFeatures returns the devices of a zone as a GeoJSON FeatureCollection ordered by device ID.
+// Devices are placed at their bay when it has a location, and left out when they have none.
+func (s *Service) DeviceFeatures(q GeoJSONQuery) (*FeatureCollection, error) {
+	zoneDeviceIDs, err := s.zoneDeviceIDs(q.Zone)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices map[string]map[string]any
+	if zoneDeviceIDs != nil {
+		devices, err = s.cache.GetDevices(zoneDeviceIDs)
+	} else {
+		devices, err = s.cache.GetAllDevices()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve devices from cache: %w", err)
+	}
+
+	deviceIDs := make([]string, 0, len(devices))
+	for deviceID := range devices {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+
+	bays := s.currentSpatialIndex().bays
+	threshold := staleAfter()
+	now := time.Now().UTC()
+
+	collection := &FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	for _, deviceID := range deviceIDs {
+		device := devices[deviceID]
+		if hidden, _ := device["is_hidden"].(bool); hidden && !q.ShowHidden {
+			continue
+		}
+
+		updatedAt := cachedTime(device, "updated_at")
+		if !q.Since.IsZero() && (updatedAt == nil || updatedAt.Before(q.Since)) {
+			continue
+		}
+		if isDeletedDevice(device) {
+			continue
+		}
+
+		lat, _ := device["latitude"].(float64)
+		lng, _ := device["longitude"].(float64)
+		bay := bays[deviceID]
+		if bay != nil && (bay.Latitude != 0 || bay.Longitude != 0) {
+			lat, lng = bay.Latitude, bay.Longitude
+		}
+		if lat == 0 && lng == 0 {
+			continue
+		}
+
+		lastSeen := lastSeenAt(device)
+		properties := map[string]any{
+			"device_id":          deviceID,
+			"name":               device["name"],
+			"network_type":       device["network_type"],
+			"firmware_version":   device["firmware_version"],
+			"is_occupied":        cache.IsOccupiedValue(device["is_occupied"]),
+			"occupied_since":     cachedTime(device, "occupied_since"),
+			"last_seen_at":       lastSeen,
+			"is_stale":           lastSeen == nil || now.Sub(*lastSeen) > threshold,
+			"battery_percentage": device["battery_percentage"],
+			"bay_id":             nil,
+			"bay_code":           nil,
+			"bay_type":           nil,
+			"updated_at":         updatedAt,
+		}
+		if bay != nil {
+			properties["bay_id"], properties["bay_code"], properties["bay_type"] = bay.ID, bay.Code, bay.BayType
+		}
+
+		collection.Features = append(collection.Features, Feature{
+			Type:       "Feature",
+			ID:         deviceID,
+			Geometry:   Point{Type: "Point", Coordinates: [2]float64{lng, lat}},
+			Properties: properties,
+		})
+
+		if updatedAt != nil && (collection.UpdatedAt == nil || updatedAt.After(*collection.UpdatedAt)) {
+			collection.UpdatedAt = updatedAt
+		}
+	}
+
+	// Deleted devices are taken from the database, as they leave the cache when it is populated or
+	// when they are purged. They are not limited to the zone, whose bays no longer list purged devices.
+	if !q.Since.IsZero() {
+		if collection.Deleted, err = s.models.Device.DeletedSince(q.Since); err != nil {
+			return nil, err
+		}
+	}
+
+	return collection, nil
+}
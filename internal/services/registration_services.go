@@ -0,0 +1,329 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/go-faker/faker/v4"
+)
+
+// Registration policies, set by the device_registration_policy setting.
+const (
+	RegistrationAuto      = "auto"       // Unknown devices are registered when they first send traffic
+	RegistrationApproval  = "approval"   // Unknown devices wait for an admin to approve them
+	RegistrationClaimCode = "claim_code" // Unknown devices wait to be claimed with the code on their label, or approved
+)
+
+// RegistrationPolicies lists the valid values of the device_registration_policy setting.
+var RegistrationPolicies = []string{RegistrationAuto, RegistrationApproval, RegistrationClaimCode}
+
+// maxHeldMessages is the number of messages kept for a pending device, the oldest being dropped first.
+const maxHeldMessages = 500
+
+// ErrDeviceNotPending is returned when approving or rejecting a device that is not waiting for registration.
+var ErrDeviceNotPending = errors.New("pending device not found")
+
+// PendingDeviceView is a pending device with the number of messages held for it.
+type PendingDeviceView struct {
+	*models.PendingDevice
+	HeldMessages int `json:"held_messages"`
+}
+
+// RegistrationPolicy returns the current device registration policy, auto when it is not set.
+func (s *Service) RegistrationPolicy() string {
+	value, err := s.cache.HGet("app:settings", "device_registration_policy")
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve 'device_registration_policy' from application settings.")
+	}
+	if policy, ok := value.(string); ok && policy != "" {
+		return policy
+	}
+	return RegistrationAuto
+}
+
+// HoldTraffic decides whether the traffic of a device that is not registered yet must be held.
+// Messages of pending devices are kept until the device is approved, those of rejected devices are dropped.
+// It returns the registration status of the device, pending or rejected, or "" when the message
+// should be processed as usual.
+func (s *Service) HoldTraffic(networkType, deviceID string, firmwareVersion float64, msg events.HeldMessage) string {
+	status, err := s.cache.GetRegistrationStatus(deviceID)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to retrieve registration status of device %s", deviceID))
+	}
+
+	switch {
+	case status == models.PendingStatusRejected:
+		helpers.LogInfo("Device %s was rejected. Request ignored.", deviceID)
+		return status
+	case status == "" && s.RegistrationPolicy() == RegistrationAuto:
+		return ""
+	case status == "":
+		// First message of the device, list it for review straight away.
+		if err := s.models.PendingDevice.Seen(deviceID, networkType, firmwareVersion); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to record pending device %s", deviceID))
+		}
+		if err := s.cache.SetRegistrationStatus(deviceID, models.PendingStatusPending); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to set registration status of device %s", deviceID))
+		}
+	}
+
+	if err := s.cache.HoldMessage(deviceID, msg, maxHeldMessages); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to hold message of device %s", deviceID))
+	}
+	return models.PendingStatusPending
+}
+
+// queueForRegistration lists a newly seen device for review, unless it is registered automatically.
+// It returns true when the device must not be created.
+func (s *Service) queueForRegistration(networkType, deviceID string, firmwareVersion float64) bool {
+	status, err := s.cache.GetRegistrationStatus(deviceID)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to retrieve registration status of device %s", deviceID))
+	}
+	if status == models.PendingStatusRejected {
+		return true
+	}
+	if status == "" && s.RegistrationPolicy() == RegistrationAuto {
+		return false
+	}
+
+	if err := s.models.PendingDevice.Seen(deviceID, networkType, firmwareVersion); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to record pending device %s", deviceID))
+		return true
+	}
+	if status == "" {
+		if err := s.cache.SetRegistrationStatus(deviceID, models.PendingStatusPending); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to set registration status of device %s", deviceID))
+		}
+	}
+	return true
+}
+
+// PendingDevices retrieves the devices waiting for registration with the given status, or all of
+// them when status is empty, with the number of messages held for each.
+func (s *Service) PendingDevices(status string) ([]PendingDeviceView, error) {
+	devices, err := s.models.PendingDevice.GetAll(status)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceIDs := make([]string, len(devices))
+	for i, d := range devices {
+		deviceIDs[i] = d.DeviceID
+	}
+	counts, err := s.cache.CountHeldMessages(deviceIDs)
+	if err != nil {
+		helpers.LogError(err, "Failed to count held messages")
+	}
+
+	views := make([]PendingDeviceView, len(devices))
+	for i, d := range devices {
+		views[i] = PendingDeviceView{PendingDevice: d, HeldMessages: counts[d.DeviceID]}
+	}
+	return views, nil
+}
+
+// ApproveDevice registers a pending or rejected device with the given name and location, falling back
+// to those set on the pending device and then to a generated name and the default location.
+// The messages held for the device are released to be processed.
+func (s *Service) ApproveDevice(deviceID, name string, latitude, longitude *float64) (*models.Device, error) {
+	pending, err := s.models.PendingDevice.GetByID(deviceID)
+	if err != nil {
+		if err.Error() == "pending device not found" {
+			return nil, ErrDeviceNotPending
+		}
+		return nil, err
+	}
+
+	if name == "" {
+		name = pending.Name
+	}
+	if name == "" {
+		name = "__" + faker.Username()
+	}
+
+	lat, lng := pending.Latitude, pending.Longitude
+	if lat == 0 && lng == 0 {
+		lat, lng = defaultLocation()
+	}
+	if latitude != nil {
+		lat = *latitude
+	}
+	if longitude != nil {
+		lng = *longitude
+	}
+
+	if err := s.createDevice(pending.NetworkType, deviceID, pending.FirmwareVersion, name, lat, lng); err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s %s", pending.NetworkType, deviceID)
+	if _, err := s.cache.AddItemToBloomFilter("registered-devices", key); err != nil {
+		helpers.LogError(err, "Failed to add device ID to the 'registered-devices' Bloom Filter")
+	}
+
+	if err := s.models.PendingDevice.DeleteByID(deviceID); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to remove pending device %s", deviceID))
+	}
+	if err := s.cache.SetRegistrationStatus(deviceID, ""); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to clear registration status of device %s", deviceID))
+	}
+
+	s.releaseHeldTraffic(pending.NetworkType, deviceID)
+	s.RebuildSpatialIndex()
+
+	return s.models.Device.GetByID(deviceID)
+}
+
+// RejectDevice marks a pending device as rejected, dropping its held messages and any further traffic.
+func (s *Service) RejectDevice(deviceID string, reviewedBy int) (*models.PendingDevice, error) {
+	pending, err := s.models.PendingDevice.GetByID(deviceID)
+	if err != nil {
+		if err.Error() == "pending device not found" {
+			return nil, ErrDeviceNotPending
+		}
+		return nil, err
+	}
+
+	pending.Status = models.PendingStatusRejected
+	pending.ReviewedBy = &reviewedBy
+	if pending, err = s.models.PendingDevice.Update(pending); err != nil {
+		return nil, err
+	}
+
+	if err := s.cache.SetRegistrationStatus(deviceID, models.PendingStatusRejected); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to set registration status of device %s", deviceID))
+	}
+	if err := s.cache.DropHeldMessages(deviceID); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to drop held messages of device %s", deviceID))
+	}
+
+	return pending, nil
+}
+
+// PopulateRegistrationStatuses loads the statuses of the devices waiting for registration into the cache.
+func (s *Service) PopulateRegistrationStatuses() {
+	devices, err := s.models.PendingDevice.GetAll("")
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve pending devices from database")
+		return
+	}
+
+	statuses := make(map[string]string, len(devices))
+	for _, d := range devices {
+		statuses[d.DeviceID] = d.Status
+	}
+	if err := s.cache.ReplaceRegistrationStatuses(statuses); err != nil {
+		helpers.LogError(err, "Failed to cache registration statuses")
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// releaseHeldTraffic publishes the messages held for a newly registered device so they are processed.
+// The messages stay held until the replay subscribers are done with them, see HeldTrafficReplayed.
+func (s *Service) releaseHeldTraffic(networkType, deviceID string) {
+	if s.bus == nil {
+		helpers.LogInfo("No event bus to release the held messages of device %s, keeping them held", deviceID)
+		return
+	}
+
+	values, err := s.cache.GetHeldMessages(deviceID)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to retrieve held messages of device %s", deviceID))
+		return
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	messages := make([]events.HeldMessage, 0, len(values))
+	for _, value := range values {
+		var msg events.HeldMessage
+		if err := json.Unmarshal(value, &msg); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to decode held message of device %s", deviceID))
+			continue
+		}
+		messages = append(messages, msg)
+	}
+
+	s.bus.Publish(events.TrafficReleased{DeviceID: deviceID, NetworkType: networkType, Messages: messages, Held: len(values)})
+	helpers.LogInfo("Released %d held messages of device %s", len(messages), deviceID)
+}
+
+// HeldTrafficReplayed discards the released messages of a device once they were replayed.
+func (s *Service) HeldTrafficReplayed(e events.TrafficReleased) {
+	if err := s.cache.RemoveHeldMessages(e.DeviceID, e.Held); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to remove replayed messages of device %s", e.DeviceID))
+	}
+}
+
+// StaleHeldMessage reports whether a held message was overtaken by newer traffic of the device, processed
+// since it was approved. Replaying it would overwrite the device state with an older one.
+func (s *Service) StaleHeldMessage(deviceID string, msg events.HeldMessage) bool {
+	deviceData, err := s.cache.GetDevice(deviceID)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to retrieve device %s from cache", deviceID))
+		return false
+	}
+
+	happenedAt, _ := deviceData["happened_at"].(string)
+	lastEvent, err := time.Parse(time.RFC3339, happenedAt)
+	if err != nil {
+		return false
+	}
+	return msg.ReceivedAt.Before(lastEvent)
+}
+
+// createDevice creates a device together with the settings record of its network.
+func (s *Service) createDevice(networkType, deviceID string, firmwareVersion float64, name string, latitude, longitude float64) error {
+	newDevice := models.Device{
+		DeviceID:        deviceID,
+		Name:            name,
+		NetworkType:     networkType,
+		Latitude:        latitude,
+		Longitude:       longitude,
+		FirmwareVersion: firmwareVersion,
+	}
+
+	// Attempt to create a new device record in the database.
+	if _, err := s.models.Device.Create(&newDevice); err != nil {
+		return fmt.Errorf("failed to create a new device record for ID %s: %w", deviceID, err)
+	}
+
+	// Attempts to create a new device settings record in the database.
+	var err error
+	switch networkType {
+	case "NB-IoT":
+		_, err = s.models.NbiotDeviceSettings.Create(&models.NbiotDeviceSettings{DeviceID: deviceID, NetworkType: networkType})
+	case "LoRa":
+		_, err = s.models.LoraDeviceSettings.Create(&models.LoraDeviceSettings{DeviceID: deviceID, NetworkType: networkType})
+	case "SigFox":
+		_, err = s.models.SigfoxDeviceSettings.Create(&models.SigfoxDeviceSettings{DeviceID: deviceID, NetworkType: networkType})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create a new device settings record for ID %s: %w", deviceID, err)
+	}
+
+	return nil
+}
+
+// defaultLocation returns the location new devices are placed at until they are moved.
+func defaultLocation() (float64, float64) {
+	latitude, err := strconv.ParseFloat(os.Getenv("DEFAULT_LATITUDE"), 64)
+	if err != nil {
+		latitude = 0
+	}
+	longitude, err := strconv.ParseFloat(os.Getenv("DEFAULT_LONGITUDE"), 64)
+	if err != nil {
+		longitude = 0
+	}
+	return latitude, longitude
+}
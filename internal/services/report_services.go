@@ -0,0 +1,473 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+	"github.com/foxcodenine/iot-parking-gateway/internal/reports"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// maxReportRows bounds the rows of a single report.
+	maxReportRows = 100000
+
+	// maxReportRange bounds the period of a single report.
+	maxReportRange = 366 * 24 * time.Hour
+)
+
+// ReportTypes lists the report types with their titles.
+var ReportTypes = map[string]string{
+	"occupancy_summary": "Occupancy summary",
+	"device_health":     "Device health",
+	"sessions":          "Parking sessions",
+	"audit_trail":       "Audit trail",
+}
+
+// reportCronParser parses schedules the same way as the application's scheduler, with seconds.
+var reportCronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// reportScheduler keeps the cron entries of the report schedules.
+type reportScheduler struct {
+	mu      sync.Mutex
+	cron    *cron.Cron
+	entries map[int]cron.EntryID
+	loaded  map[int]time.Time // updated_at of the schedule each entry was registered from
+}
+
+// ValidateReport checks the type, format and period of a report and the devices it filters on.
+func ValidateReport(report *models.Report) error {
+	if _, ok := ReportTypes[report.ReportType]; !ok {
+		return fmt.Errorf("invalid report type '%s', must be one of %s", report.ReportType, strings.Join(sortedKeys(ReportTypes), ", "))
+	}
+	if _, ok := reports.Formats[report.Format]; !ok {
+		return fmt.Errorf("invalid format '%s', must be one of %s", report.Format, strings.Join(sortedKeys(reports.Formats), ", "))
+	}
+	if !report.FromDate.Before(report.ToDate) {
+		return errors.New("from_date must be less than to_date")
+	}
+	if report.ToDate.Sub(report.FromDate) > maxReportRange {
+		return fmt.Errorf("the report period cannot exceed %.0f days", maxReportRange.Hours()/24)
+	}
+	return nil
+}
+
+// ValidateReportSchedule checks a schedule's report, cron expression and period.
+func ValidateReportSchedule(schedule *models.ReportSchedule) error {
+	if strings.TrimSpace(schedule.Name) == "" {
+		return errors.New("name cannot be empty")
+	}
+	if _, ok := ReportTypes[schedule.ReportType]; !ok {
+		return fmt.Errorf("invalid report type '%s', must be one of %s", schedule.ReportType, strings.Join(sortedKeys(ReportTypes), ", "))
+	}
+	if _, ok := reports.Formats[schedule.Format]; !ok {
+		return fmt.Errorf("invalid format '%s', must be one of %s", schedule.Format, strings.Join(sortedKeys(reports.Formats), ", "))
+	}
+	if _, err := reportCronParser.Parse(schedule.CronSpec); err != nil {
+		return fmt.Errorf("invalid cron_spec '%s': %v", schedule.CronSpec, err)
+	}
+	if !models.ReportPeriods[schedule.Period] {
+		return errors.New("invalid period, must be previous_day, previous_week or previous_month")
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// RequestReport stores a pending report and generates it in the background.
+func (s *Service) RequestReport(report *models.Report) (*models.Report, error) {
+	if err := ValidateReport(report); err != nil {
+		return nil, err
+	}
+
+	report.Status = "pending"
+	report, err := s.models.Report.Create(report)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.GenerateReport(report)
+
+	return report, nil
+}
+
+// GenerateReport builds the report's table, renders its file and stores the outcome.
+func (s *Service) GenerateReport(report *models.Report) {
+	content, err := s.renderReport(report)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to generate report %d", report.ID))
+		report.Status = "failed"
+		report.Error = err.Error()
+	} else {
+		report.Status = "ready"
+		helpers.LogInfo("Generated %s report %d with %d rows", report.ReportType, report.ID, report.RowCount)
+	}
+
+	if err := s.models.Report.Complete(report, content); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to store the outcome of report %d", report.ID))
+	}
+}
+
+// renderReport renders the report's file and returns its content.
+func (s *Service) renderReport(report *models.Report) ([]byte, error) {
+	table, err := s.buildReportTable(report)
+	if err != nil {
+		return nil, err
+	}
+	report.RowCount = len(table.Rows)
+
+	// The random part keeps the file names of reports of the same period apart.
+	report.FileName = fmt.Sprintf("%s-%s-%s-%s%s",
+		strings.ReplaceAll(report.ReportType, "_", "-"),
+		report.FromDate.Format("20060102"),
+		report.ToDate.Format("20060102"),
+		uuid.NewString()[:8],
+		reports.Formats[report.Format],
+	)
+
+	var file bytes.Buffer
+	if err := reports.Render(&file, report.Format, table); err != nil {
+		return nil, err
+	}
+
+	report.FileSize = int64(file.Len())
+	return file.Bytes(), nil
+}
+
+// DeleteReport deletes a report and its file.
+func (s *Service) DeleteReport(report *models.Report) error {
+	return s.models.Report.DeleteByID(report.ID)
+}
+
+// buildReportTable queries the data of a report.
+func (s *Service) buildReportTable(report *models.Report) (*reports.Table, error) {
+	var deviceIDs []string
+	for _, deviceID := range strings.Split(report.DeviceIDs, ",") {
+		if deviceID = strings.TrimSpace(deviceID); deviceID != "" {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+
+	table := &reports.Table{
+		Title:       ReportTypes[report.ReportType],
+		Subtitle:    fmt.Sprintf("%s to %s UTC", report.FromDate.UTC().Format("2006-01-02 15:04"), report.ToDate.UTC().Format("2006-01-02 15:04")),
+		GeneratedAt: time.Now().UTC(),
+	}
+	if len(deviceIDs) > 0 {
+		table.Subtitle += fmt.Sprintf(", %d devices", len(deviceIDs))
+	}
+
+	var err error
+	switch report.ReportType {
+	case "occupancy_summary":
+		err = s.occupancySummaryRows(table, report, deviceIDs)
+	case "device_health":
+		err = s.deviceHealthRows(table, report, deviceIDs)
+	case "sessions":
+		err = s.sessionRows(table, report, deviceIDs)
+	case "audit_trail":
+		err = s.auditTrailRows(table, report)
+	default:
+		err = fmt.Errorf("unsupported report type '%s'", report.ReportType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(table.Rows) > maxReportRows {
+		return nil, fmt.Errorf("the report has more than %d rows, select a shorter period or fewer devices", maxReportRows)
+	}
+	return table, nil
+}
+
+// occupancySummaryRows adds the occupancy, arrivals and dwell time of every device over the period.
+func (s *Service) occupancySummaryRows(table *reports.Table, report *models.Report, deviceIDs []string) error {
+	table.Columns = []string{"Device ID", "Name", "Network", "Occupied hours", "Occupancy (%)", "Arrivals", "Departures", "Avg dwell (min)"}
+
+	devices, err := s.models.Device.GetAll()
+	if err != nil {
+		return err
+	}
+
+	aggregates, err := s.models.OccupancyRollup.Aggregate(models.RollupQuery{
+		From:      report.FromDate,
+		To:        report.ToDate,
+		Interval:  "day",
+		Timezone:  "UTC",
+		GroupBy:   "device",
+		DeviceIDs: deviceIDs,
+	})
+	if err != nil {
+		return err
+	}
+
+	totals := make(map[string]*models.RollupAggregate)
+	for i := range aggregates {
+		a := &aggregates[i]
+		total, ok := totals[a.Group]
+		if !ok {
+			total = &models.RollupAggregate{Group: a.Group}
+			totals[a.Group] = total
+		}
+		total.OccupiedSeconds += a.OccupiedSeconds
+		total.Arrivals += a.Arrivals
+		total.Departures += a.Departures
+		total.DwellSeconds += a.DwellSeconds
+		total.DwellCount += a.DwellCount
+	}
+
+	selected := make(map[string]bool)
+	for _, deviceID := range deviceIDs {
+		selected[deviceID] = true
+	}
+
+	periodSeconds := report.ToDate.Sub(report.FromDate).Seconds()
+	for _, device := range devices {
+		if len(selected) > 0 && !selected[device.DeviceID] {
+			continue
+		}
+
+		total := totals[device.DeviceID]
+		if total == nil {
+			total = &models.RollupAggregate{}
+		}
+
+		avgDwell := 0.0
+		if total.DwellCount > 0 {
+			avgDwell = float64(total.DwellSeconds) / float64(total.DwellCount) / 60
+		}
+
+		table.Rows = append(table.Rows, []any{
+			device.DeviceID,
+			device.Name,
+			device.NetworkType,
+			float64(total.OccupiedSeconds) / 3600,
+			100 * float64(total.OccupiedSeconds) / periodSeconds,
+			total.Arrivals,
+			total.Departures,
+			avgDwell,
+		})
+	}
+
+	return nil
+}
+
+// deviceHealthRows adds the reporting, uptime and battery of every device over the period.
+// Uptime is the share of days in the period on which the device reported.
+func (s *Service) deviceHealthRows(table *reports.Table, report *models.Report, deviceIDs []string) error {
+	table.Columns = []string{
+		"Device ID", "Name", "Network", "Firmware", "Last parking event", "Last keepalive", "Last settings",
+		"Parking events", "Keepalives", "Days reporting", "Uptime (%)", "Battery (%)", "Min battery (%)", "Idle voltage",
+	}
+
+	health, err := s.models.DeviceHealth.GetDeviceHealth(report.FromDate, report.ToDate, deviceIDs)
+	if err != nil {
+		return err
+	}
+
+	days := report.ToDate.Sub(report.FromDate).Hours() / 24
+	for _, h := range health {
+		uptime := 0.0
+		if days > 0 {
+			uptime = 100 * float64(h.DaysReporting) / days
+			if uptime > 100 {
+				uptime = 100
+			}
+		}
+
+		table.Rows = append(table.Rows, []any{
+			h.DeviceID, h.Name, h.NetworkType, h.FirmwareVersion, h.HappenedAt, h.KeepaliveAt, h.SettingsAt,
+			h.ParkingEvents, h.Keepalives, h.DaysReporting, uptime,
+			optionalInt(h.BatteryPercentage), optionalInt(h.MinBattery), optionalInt(h.IdleVoltage),
+		})
+	}
+
+	return nil
+}
+
+// sessionRows adds the parking sessions that overlap the period.
+func (s *Service) sessionRows(table *reports.Table, report *models.Report, deviceIDs []string) error {
+	table.Columns = []string{"Session ID", "Device ID", "Network", "Started at", "Ended at", "Duration (min)", "Events", "Interruptions"}
+
+	sessions, err := s.models.ParkingSession.GetParkingSessions(models.ParkingSessionFilter{
+		DeviceIDs: deviceIDs,
+		From:      report.FromDate,
+		To:        report.ToDate.Add(-time.Second),
+		Limit:     maxReportRows + 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		var duration any
+		if session.DurationSeconds != nil {
+			duration = float64(*session.DurationSeconds) / 60
+		}
+
+		table.Rows = append(table.Rows, []any{
+			session.ID, session.DeviceID, session.NetworkType, session.StartedAt, session.EndedAt,
+			duration, session.EventsAmount, session.Interruptions,
+		})
+	}
+
+	return nil
+}
+
+// auditTrailRows adds the audit log entries of the period.
+func (s *Service) auditTrailRows(table *reports.Table, report *models.Report) error {
+	table.Columns = []string{"Happened at", "User", "Access level", "Action", "Entity", "Entity ID", "IP address", "Details"}
+
+	logs, err := s.models.AuditLog.GetBetween(report.FromDate, report.ToDate, maxReportRows+1)
+	if err != nil {
+		return err
+	}
+
+	for _, log := range logs {
+		table.Rows = append(table.Rows, []any{
+			log.HappenedAt, log.Email, log.AccessLevel, log.Action, log.Entity, log.EntityID, log.IPAddress, log.Details,
+		})
+	}
+
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// StartReportSchedules registers the enabled report schedules on the scheduler.
+func (s *Service) StartReportSchedules(c *cron.Cron) {
+	s.reportScheduler = &reportScheduler{
+		cron:    c,
+		entries: make(map[int]cron.EntryID),
+		loaded:  make(map[int]time.Time),
+	}
+	s.SyncReportSchedules()
+}
+
+// SyncReportSchedules brings the scheduler in line with the report schedules in the database,
+// so changes made through another gateway instance are picked up.
+func (s *Service) SyncReportSchedules() {
+	rs := s.reportScheduler
+	if rs == nil {
+		return
+	}
+
+	schedules, err := s.models.ReportSchedule.GetAll()
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve report schedules")
+		return
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	current := make(map[int]bool)
+	for _, schedule := range schedules {
+		if !schedule.IsEnabled {
+			continue
+		}
+		current[schedule.ID] = true
+
+		// Skip schedules that have not changed since they were registered.
+		if _, ok := rs.entries[schedule.ID]; ok && rs.loaded[schedule.ID].Equal(schedule.UpdatedAt) {
+			continue
+		}
+		rs.remove(schedule.ID)
+
+		schedule := schedule
+		entryID, err := rs.cron.AddFunc(schedule.CronSpec, func() {
+			s.runReportSchedule(schedule.ID)
+		})
+		if err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to schedule report '%s'", schedule.Name))
+			continue
+		}
+		rs.entries[schedule.ID] = entryID
+		rs.loaded[schedule.ID] = schedule.UpdatedAt
+	}
+
+	// Remove the schedules that were deleted or disabled.
+	for scheduleID := range rs.entries {
+		if !current[scheduleID] {
+			rs.remove(scheduleID)
+		}
+	}
+}
+
+// remove unregisters a schedule; the caller holds the lock.
+func (rs *reportScheduler) remove(scheduleID int) {
+	if entryID, ok := rs.entries[scheduleID]; ok {
+		rs.cron.Remove(entryID)
+		delete(rs.entries, scheduleID)
+		delete(rs.loaded, scheduleID)
+	}
+}
+
+// runReportSchedule generates the report of a schedule for its previous period.
+func (s *Service) runReportSchedule(scheduleID int) {
+	now := time.Now().UTC()
+
+	// Every instance runs the schedule; only the first one generates the report.
+	lockKey := fmt.Sprintf("reports:schedule:%d:%d", scheduleID, now.Truncate(time.Minute).Unix())
+	locked, err := s.cache.SetNX(lockKey, true, 120)
+	if err != nil {
+		helpers.LogError(err, "Failed to acquire the report schedule lock")
+		return
+	}
+	if !locked {
+		return
+	}
+
+	schedule, err := s.models.ReportSchedule.GetByID(scheduleID)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to retrieve report schedule %d", scheduleID))
+		return
+	}
+	if !schedule.IsEnabled {
+		return
+	}
+
+	from, to := schedule.PeriodRange(now)
+	report := &models.Report{
+		ReportType: schedule.ReportType,
+		Format:     schedule.Format,
+		FromDate:   from,
+		ToDate:     to,
+		DeviceIDs:  schedule.DeviceIDs,
+		Status:     "pending",
+		ScheduleID: &schedule.ID,
+	}
+
+	report, err = s.models.Report.Create(report)
+	if err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to create the report of schedule '%s'", schedule.Name))
+		return
+	}
+
+	s.GenerateReport(report)
+}
+
+// optionalInt returns the value of a nullable integer, or nil.
+func optionalInt(value *int) any {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
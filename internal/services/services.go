@@ -3,11 +3,13 @@ package services
 import (
 	"fmt"
 	"log"
-	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
 	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
 	"github.com/foxcodenine/iot-parking-gateway/internal/models"
 	"github.com/go-faker/faker/v4"
@@ -16,7 +18,11 @@ import (
 type Service struct {
 	models  models.Models
 	cache   *cache.RedisCache
+	bus     *events.Bus
 	infoLog *log.Logger
+
+	reportScheduler *reportScheduler
+	spatial         atomic.Pointer[spatialIndex]
 }
 
 func NewService(m models.Models, rc *cache.RedisCache) *Service {
@@ -36,6 +42,7 @@ func (s *Service) RegisterNewDevices() {
 	}
 
 	// Process each device entry to create new device records.
+	registered := 0
 	for _, entry := range deviceEntries {
 
 		// Convert interface to string, ensuring it represents a device ID correctly.
@@ -64,66 +71,23 @@ func (s *Service) RegisterNewDevices() {
 			return // or handle the error appropriately
 		}
 
-		latitude, err := strconv.ParseFloat(os.Getenv("DEFAULT_LATITUDE"), 64)
-		if err != nil {
-			latitude = 0
-		}
-		longitude, err := strconv.ParseFloat(os.Getenv("DEFAULT_LONGITUDE"), 64)
-		if err != nil {
-			longitude = 0
-		}
-
-		name := faker.Username()
-		// Define a new device model instance.
-		newDevice := models.Device{
-			DeviceID:        deviceID,
-			Name:            "__" + name,
-			NetworkType:     networkType,
-			Latitude:        latitude,
-			Longitude:       longitude,
-			FirmwareVersion: firmwareVersion,
-		}
-
-		// Attempt to create a new device record in the database.
-		_, err = s.models.Device.Create(&newDevice)
-		if err != nil {
-			helpers.LogError(err, fmt.Sprintf("Failed to create a new device record for ID %s", deviceID))
-			continue // Proceed to the next ID instead of stopping.
-		}
-
-		// Attempts to create a new device settings record in the database.
-		switch networkType {
-		case "NB-IoT":
-			newDeviceSetting := models.NbiotDeviceSettings{
-				DeviceID:    deviceID,
-				NetworkType: networkType,
-			}
-			_, err = s.models.NbiotDeviceSettings.Create(&newDeviceSetting)
-
-		case "LoRa":
-			newDeviceSetting := models.LoraDeviceSettings{
-				DeviceID:    deviceID,
-				NetworkType: networkType,
-			}
-			_, err = s.models.LoraDeviceSettings.Create(&newDeviceSetting)
-		case "SigFox":
-			newDeviceSetting := models.SigfoxDeviceSettings{
-				DeviceID:    deviceID,
-				NetworkType: networkType,
-			}
-			_, err = s.models.SigfoxDeviceSettings.Create(&newDeviceSetting)
+		// Devices waiting for registration, and any new device unless the policy registers them
+		// automatically, are queued for review instead.
+		if s.queueForRegistration(networkType, deviceID, firmwareVersion) {
+			continue
 		}
 
-		if err != nil {
-			helpers.LogError(err, fmt.Sprintf("Failed to create a new device settings record for ID %s", deviceID))
+		latitude, longitude := defaultLocation()
+		if err := s.createDevice(networkType, deviceID, firmwareVersion, "__"+faker.Username(), latitude, longitude); err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to register device %s", deviceID))
 			continue // Proceed to the next ID instead of stopping.
 		}
-
+		registered++
 	}
-	if len(deviceEntries) == 1 {
+	if registered == 1 {
 		helpers.LogInfo("Successfully added 1 device to PostgreSQL")
-	} else if len(deviceEntries) > 1 {
-		helpers.LogInfo("Successfully added %d devices to PostgreSQL", len(deviceEntries))
+	} else if registered > 1 {
+		helpers.LogInfo("Successfully added %d devices to PostgreSQL", registered)
 	}
 }
 
@@ -173,6 +137,17 @@ func (s *Service) PopulateDeviceCache() {
 		return
 	}
 
+	// Restore the battery levels, which are otherwise only cached as keepalives arrive.
+	batteries, err := s.models.DeviceHealth.GetLatestBatteries(time.Now().UTC().AddDate(0, 0, -7))
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve battery levels")
+	}
+	for _, device := range deviceMaps {
+		if battery, ok := batteries[fmt.Sprint(device["device_id"])]; ok {
+			device["battery_percentage"] = battery
+		}
+	}
+
 	// Step 4: Save all devices to the cache
 	if err := s.cache.SaveMultipleDevices(deviceMaps); err != nil {
 		helpers.LogError(err, "Failed to save devices to cache")
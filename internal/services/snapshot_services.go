@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+const (
+	// playbackChunk is the length of history loaded at a time while playing back.
+	playbackChunk = time.Hour
+
+	// playbackHeartbeatInterval is how often the sink is told the playback is still running
+	// while it waits for the next change.
+	playbackHeartbeatInterval = 15 * time.Second
+)
+
+// ErrPlaybackStopped is returned by Playback when the context is cancelled.
+var ErrPlaybackStopped = errors.New("playback stopped")
+
+// SnapshotQuery selects the devices and the time of a snapshot or playback.
+type SnapshotQuery struct {
+	Zone      string // Zone ID, "all" for every device
+	DeviceIDs []string
+	From      time.Time // Time of the snapshot, or start of the playback
+	To        time.Time // End of the playback
+	Speed     float64   // Playback seconds per real second
+}
+
+// PlaybackSink receives the output of a playback.
+type PlaybackSink struct {
+	Snapshot  func([]*models.DeviceSnapshot) error // State at the start of the playback
+	Change    func(models.SnapshotChange) error    // Each change, when its time comes
+	Heartbeat func() error                         // Called while waiting for the next change
+}
+
+// snapshotDeviceIDs returns the devices to rebuild, or nil for every device.
+// The second value is false when the selection matches no device.
+func (s *Service) snapshotDeviceIDs(q SnapshotQuery) ([]string, bool, error) {
+	selected, all, err := s.selectDevices(q.Zone, q.DeviceIDs)
+	if err != nil || all {
+		return nil, err == nil, err
+	}
+
+	deviceIDs := make([]string, 0, len(selected))
+	for deviceID := range selected {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+
+	return deviceIDs, len(deviceIDs) > 0, nil
+}
+
+// Snapshot rebuilds the state of the devices at q.From from their parking events, keepalives and settings reports.
+func (s *Service) Snapshot(q SnapshotQuery) ([]*models.DeviceSnapshot, error) {
+	deviceIDs, ok, err := s.snapshotDeviceIDs(q)
+	if err != nil || !ok {
+		return []*models.DeviceSnapshot{}, err
+	}
+
+	return s.models.DeviceSnapshot.At(q.From, deviceIDs)
+}
+
+// Playback sends the state of the devices at q.From and then every change until q.To to the sink,
+// paced at q.Speed times real time. Changes are loaded an hour of history at a time.
+func (s *Service) Playback(ctx context.Context, q SnapshotQuery, sink PlaybackSink) error {
+	deviceIDs, ok, err := s.snapshotDeviceIDs(q)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return sink.Snapshot([]*models.DeviceSnapshot{})
+	}
+
+	snapshots, err := s.models.DeviceSnapshot.At(q.From, deviceIDs)
+	if err != nil {
+		return err
+	}
+	if err := sink.Snapshot(snapshots); err != nil {
+		return err
+	}
+
+	// The playback clock maps history time onto real time.
+	started := time.Now()
+	timeAt := func(t time.Time) time.Time {
+		return started.Add(time.Duration(float64(t.Sub(q.From)) / q.Speed))
+	}
+
+	heartbeat := time.NewTicker(playbackHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for chunkStart := q.From; chunkStart.Before(q.To); chunkStart = chunkStart.Add(playbackChunk) {
+		chunkEnd := chunkStart.Add(playbackChunk)
+		if chunkEnd.After(q.To) {
+			chunkEnd = q.To
+		}
+
+		changes, err := s.models.DeviceSnapshot.ChangesBetween(chunkStart, chunkEnd, deviceIDs)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			timer := time.NewTimer(time.Until(timeAt(change.HappenedAt)))
+		wait:
+			for {
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ErrPlaybackStopped
+				case <-heartbeat.C:
+					if err := sink.Heartbeat(); err != nil {
+						timer.Stop()
+						return err
+					}
+				case <-timer.C:
+					break wait
+				}
+			}
+
+			if err := sink.Change(change); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
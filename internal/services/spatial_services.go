@@ -0,0 +1,265 @@
+package services
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/geo"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+const (
+	// defaultStaleAfter is how long a device may stay silent before it is considered stale.
+	defaultStaleAfter = 24 * time.Hour
+
+	// spatialBatchSize is the number of nearest candidates whose live state is read from the cache at once.
+	spatialBatchSize = 100
+)
+
+// spatialIndex is the in-memory index of the device locations. A device assigned to a bay
+// is indexed at the bay's location, otherwise at its own.
+type spatialIndex struct {
+	tree *geo.RTree
+	bays map[string]*models.Bay // Device ID to the bay it monitors
+}
+
+// SpatialFilter holds the optional filters of a spatial query. Occupancy and staleness are
+// read from the device cache, so they are live even though the index is rebuilt periodically.
+type SpatialFilter struct {
+	Occupied   *bool
+	BayTypes   map[string]bool // Empty for any; devices without a bay only match when empty
+	Stale      *bool
+	StaleAfter time.Duration // Silence after which a device is stale, 0 for the default
+	ShowHidden bool
+}
+
+// SpatialDevice is a device found by a spatial query.
+type SpatialDevice struct {
+	DeviceID    string     `json:"device_id"`
+	Name        string     `json:"name"`
+	NetworkType string     `json:"network_type"`
+	Latitude    float64    `json:"latitude"`
+	Longitude   float64    `json:"longitude"`
+	Distance    *float64   `json:"distance_meters,omitempty"` // Set by the nearest query
+	IsOccupied  bool       `json:"is_occupied"`
+	LastSeenAt  *time.Time `json:"last_seen_at"` // Latest event, keepalive or settings, nil if never
+	IsStale     bool       `json:"is_stale"`
+	BayID       *int       `json:"bay_id"`
+	BayCode     *string    `json:"bay_code"`
+	BayType     *string    `json:"bay_type"`
+}
+
+// staleAfter returns the silence after which a device is stale, from DEVICE_STALE_MINUTES, or the default.
+func staleAfter() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("DEVICE_STALE_MINUTES"))
+	if err != nil || minutes <= 0 {
+		return defaultStaleAfter
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// cachedTime parses a timestamp field of a cached device, returning nil when it is missing or unset.
+func cachedTime(device map[string]any, field string) *time.Time {
+	value, ok := device[field].(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || t.Year() <= 1 {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// lastSeenAt returns the latest traffic time of a cached device, or nil if it never reported.
+func lastSeenAt(device map[string]any) *time.Time {
+	var latest *time.Time
+	for _, field := range []string{"happened_at", "keepalive_at", "settings_at"} {
+		if t := cachedTime(device, field); t != nil && (latest == nil || t.After(*latest)) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// isDeletedDevice reports whether a cached device was soft deleted. Soft deleted devices stay
+// cached with their deleted_at until the cache is next populated.
+func isDeletedDevice(device map[string]any) bool {
+	deletedAt, _ := device["deleted_at"].(string)
+	return deletedAt != "" && !strings.HasPrefix(deletedAt, "0001-01-01")
+}
+
+// -----------------------------------------------------------------------------
+
+// RebuildSpatialIndex rebuilds the spatial index from the device cache and the bays.
+// Devices without a location, at 0,0, and devices that are not in service are left out.
+func (s *Service) RebuildSpatialIndex() {
+	devices, err := s.cache.GetAllDevices()
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve devices from cache")
+		return
+	}
+	bays, err := s.models.Bay.GetAll(models.BayFilter{})
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve bays")
+		return
+	}
+
+	index := &spatialIndex{bays: make(map[string]*models.Bay)}
+	for _, bay := range bays {
+		if bay.DeviceID != nil {
+			index.bays[*bay.DeviceID] = bay
+		}
+	}
+
+	items := make([]geo.Item, 0, len(devices))
+	for deviceID, device := range devices {
+		if isDeletedDevice(device) || !countsInAnalytics(device) {
+			continue
+		}
+		lat, _ := device["latitude"].(float64)
+		lng, _ := device["longitude"].(float64)
+		if bay := index.bays[deviceID]; bay != nil && (bay.Latitude != 0 || bay.Longitude != 0) {
+			lat, lng = bay.Latitude, bay.Longitude
+		}
+		if lat == 0 && lng == 0 {
+			continue
+		}
+		items = append(items, geo.Item{ID: deviceID, Latitude: lat, Longitude: lng})
+	}
+	index.tree = geo.NewRTree(items)
+
+	s.spatial.Store(index)
+}
+
+// currentSpatialIndex returns the current index, building it on first use.
+func (s *Service) currentSpatialIndex() *spatialIndex {
+	if index := s.spatial.Load(); index != nil {
+		return index
+	}
+	s.RebuildSpatialIndex()
+	if index := s.spatial.Load(); index != nil {
+		return index
+	}
+	return &spatialIndex{tree: geo.NewRTree(nil)}
+}
+
+// resolveSpatial reads the live state of the candidates from the cache and returns those that pass the filter,
+// in the order of the candidates.
+func (s *Service) resolveSpatial(index *spatialIndex, candidates []geo.Item, distances []float64, filter SpatialFilter) ([]*SpatialDevice, error) {
+	deviceIDs := make([]string, len(candidates))
+	for i, item := range candidates {
+		deviceIDs[i] = item.ID
+	}
+	devices, err := s.cache.GetDevices(deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := filter.StaleAfter
+	if threshold <= 0 {
+		threshold = staleAfter()
+	}
+	now := time.Now().UTC()
+
+	var result []*SpatialDevice
+	for i, item := range candidates {
+		device, ok := devices[item.ID]
+		if !ok || isDeletedDevice(device) || !countsInAnalytics(device) {
+			continue // Deleted, or taken out of service, since the index was built
+		}
+		if hidden, _ := device["is_hidden"].(bool); hidden && !filter.ShowHidden {
+			continue
+		}
+
+		bay := index.bays[item.ID]
+		if len(filter.BayTypes) > 0 && (bay == nil || !filter.BayTypes[bay.BayType]) {
+			continue
+		}
+
+		d := &SpatialDevice{
+			DeviceID:   item.ID,
+			Latitude:   item.Latitude,
+			Longitude:  item.Longitude,
+			IsOccupied: cache.IsOccupiedValue(device["is_occupied"]),
+			LastSeenAt: lastSeenAt(device),
+		}
+		d.IsStale = d.LastSeenAt == nil || now.Sub(*d.LastSeenAt) > threshold
+		if filter.Occupied != nil && d.IsOccupied != *filter.Occupied {
+			continue
+		}
+		if filter.Stale != nil && d.IsStale != *filter.Stale {
+			continue
+		}
+
+		d.Name, _ = device["name"].(string)
+		d.NetworkType, _ = device["network_type"].(string)
+		if distances != nil {
+			distance := distances[i]
+			d.Distance = &distance
+		}
+		if bay != nil {
+			d.BayID, d.BayCode, d.BayType = &bay.ID, &bay.Code, &bay.BayType
+		}
+		result = append(result, d)
+	}
+
+	return result, nil
+}
+
+// NearestDevices returns up to limit devices within radius meters of the point that pass the filter, nearest first.
+func (s *Service) NearestDevices(lat, lng, radius float64, limit int, filter SpatialFilter) ([]*SpatialDevice, error) {
+	index := s.currentSpatialIndex()
+
+	var candidates []geo.Item
+	var distances []float64
+	index.tree.Nearest(lat, lng, radius, func(item geo.Item, meters float64) bool {
+		candidates = append(candidates, item)
+		distances = append(distances, meters)
+		return true
+	})
+
+	// Read the live state in batches, stopping once enough devices pass the filter.
+	result := []*SpatialDevice{}
+	for start := 0; start < len(candidates) && len(result) < limit; start += spatialBatchSize {
+		end := min(start+spatialBatchSize, len(candidates))
+		found, err := s.resolveSpatial(index, candidates[start:end], distances[start:end], filter)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, found...)
+	}
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+// DevicesInBox returns the devices inside the bounding box that pass the filter, ordered by device ID.
+func (s *Service) DevicesInBox(box geo.Rect, filter SpatialFilter) ([]*SpatialDevice, error) {
+	index := s.currentSpatialIndex()
+
+	var candidates []geo.Item
+	index.tree.Search(box, func(item geo.Item) bool {
+		candidates = append(candidates, item)
+		return true
+	})
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+
+	result, err := s.resolveSpatial(index, candidates, nil, filter)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = []*SpatialDevice{}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// stayRulesLockKey makes sure a single gateway instance checks the stay rules at a time.
+const stayRulesLockKey = "stay-rules:check:lock"
+
+// SetBus sets the event bus the scheduler publishes violations on.
+func (s *Service) SetBus(bus *events.Bus) {
+	s.bus = bus
+}
+
+// CheckStayRules compares the occupied devices in the cache against the enabled stay rules.
+// It raises a violation when a vehicle stays longer than a rule allows and clears it when the vehicle leaves.
+func (s *Service) CheckStayRules() {
+	// Skip the run if another instance is already checking.
+	locked, err := s.cache.SetNX(stayRulesLockKey, true, 10)
+	if err != nil {
+		helpers.LogError(err, "Failed to acquire the stay rules lock")
+		return
+	}
+	if !locked {
+		return
+	}
+	defer s.cache.Delete(stayRulesLockKey)
+
+	rules, err := s.models.StayRule.GetEnabled()
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve stay rules")
+		return
+	}
+
+	devices, err := s.cache.GetAllDevices()
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve devices from cache")
+		return
+	}
+
+	activeViolations, err := s.models.Violation.GetActive()
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve active violations")
+		return
+	}
+
+	now := time.Now().UTC()
+	ruleNames := make(map[int]string, len(rules))
+	for _, rule := range rules {
+		ruleNames[rule.ID] = rule.Name
+	}
+
+	// Clear the violations of vehicles that have left, and of devices taken out of service, whose
+	// last state no longer changes.
+	active := make(map[string]bool, len(activeViolations))
+	for _, violation := range activeViolations {
+		device := devices[violation.DeviceID]
+		occupiedSince, occupied := deviceOccupiedSince(device)
+
+		// A different arrival time means the vehicle left and another one arrived.
+		if occupied && inService(device) && occupiedSince.Equal(violation.OccupiedSince) {
+			active[violationKey(violation.RuleID, violation.DeviceID)] = true
+			continue
+		}
+
+		if err := s.models.Violation.Clear(violation, now); err != nil {
+			helpers.LogError(err, "Failed to clear violation")
+			continue
+		}
+		s.publish(events.ViolationCleared{Violation: *violation, RuleName: ruleNames[violation.RuleID]})
+	}
+
+	// Device rules replace the rules of the device's zones, which replace the rules that apply to every device.
+	deviceRules := make(map[string][]*models.StayRule)
+	zoneRules := make(map[int][]*models.StayRule)
+	var defaultRules []*models.StayRule
+	for _, rule := range rules {
+		switch {
+		case rule.DeviceID != nil:
+			deviceRules[*rule.DeviceID] = append(deviceRules[*rule.DeviceID], rule)
+		case rule.ZoneID != nil:
+			zoneRules[*rule.ZoneID] = append(zoneRules[*rule.ZoneID], rule)
+		default:
+			defaultRules = append(defaultRules, rule)
+		}
+	}
+
+	deviceZones, err := s.cache.GetAllDeviceZones()
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve device zones from cache")
+		return
+	}
+
+	// Raise violations for vehicles over their limit.
+	for deviceID, device := range devices {
+		occupiedSince, occupied := deviceOccupiedSince(device)
+		if !occupied || !inService(device) {
+			continue
+		}
+
+		applicable := applicableStayRules(deviceRules[deviceID], deviceZones[deviceID], zoneRules, defaultRules)
+
+		for _, rule := range applicable {
+			if active[violationKey(rule.ID, deviceID)] {
+				continue
+			}
+
+			// The stay is counted from the arrival or from the start of the rule's active window.
+			windowStart, inWindow := rule.WindowStart(now)
+			if !inWindow {
+				continue
+			}
+			countedFrom := occupiedSince
+			if windowStart.After(countedFrom) {
+				countedFrom = windowStart
+			}
+
+			limit := time.Duration(rule.MaxStayMinutes) * time.Minute
+			if now.Sub(countedFrom) < limit {
+				continue
+			}
+
+			violation, err := s.models.Violation.Create(&models.Violation{
+				RuleID:         rule.ID,
+				DeviceID:       deviceID,
+				OccupiedSince:  occupiedSince,
+				ViolatedAt:     countedFrom.Add(limit).UTC(),
+				MaxStayMinutes: rule.MaxStayMinutes,
+			})
+			if err != nil {
+				helpers.LogError(err, fmt.Sprintf("Failed to raise violation of rule %d for device %s", rule.ID, deviceID))
+				continue
+			}
+
+			active[violationKey(rule.ID, deviceID)] = true
+			s.publish(events.ViolationRaised{Violation: *violation, RuleName: rule.Name})
+			helpers.LogInfo("Raised violation of stay rule '%s' for device %s", rule.Name, deviceID)
+		}
+	}
+}
+
+// publish publishes the event if the bus is set.
+func (s *Service) publish(event events.Event) {
+	if s.bus != nil {
+		s.bus.Publish(event)
+	}
+}
+
+// applicableStayRules returns the rules of a device: its own, else those of the innermost of its zones
+// that has rules, else the default rules.
+func applicableStayRules(own []*models.StayRule, zoneIDs []int, zoneRules map[int][]*models.StayRule, defaultRules []*models.StayRule) []*models.StayRule {
+	if len(own) > 0 {
+		return own
+	}
+	for _, zoneID := range zoneIDs {
+		if rules, ok := zoneRules[zoneID]; ok {
+			return rules
+		}
+	}
+	return defaultRules
+}
+
+// deviceOccupiedSince returns when the vehicle on a cached device arrived, and false if the device is vacant.
+// Devices loaded from PostgreSQL have no occupied_since yet; their last parking event is used instead.
+func deviceOccupiedSince(device map[string]any) (time.Time, bool) {
+	if device == nil || !cache.IsOccupiedValue(device["is_occupied"]) {
+		return time.Time{}, false
+	}
+
+	for _, field := range []string{"occupied_since", "happened_at"} {
+		value, _ := device[field].(string)
+		if value == "" {
+			continue
+		}
+		if t, err := time.Parse("2006-01-02T15:04:05Z", value); err == nil && !t.IsZero() && t.Year() > 1 {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func violationKey(ruleID int, deviceID string) string {
+	return fmt.Sprintf("%d|%s", ruleID, deviceID)
+}
+
+// inService reports whether a cached device is checked against the stay rules: only the devices
+// counting in the analytics are, leaving out deleted devices and those in maintenance or decommissioned.
+func inService(device map[string]any) bool {
+	return !isDeletedDevice(device) && countsInAnalytics(device)
+}
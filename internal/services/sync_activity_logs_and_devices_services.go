@@ -61,6 +61,9 @@ func (s *Service) SyncActivityLogs() {
 
 		// Log successful insertion and update.
 		helpers.LogInfo("Successfully inserted %d activity logs records into PostgreSQL", len(activityLogs))
+
+		// Pair the new arrival and departure events into parking sessions.
+		s.SyncParkingSessions(activityLogs)
 	}
 
 }
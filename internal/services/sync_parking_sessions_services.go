@@ -0,0 +1,71 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// defaultSessionMergeGap is how long a sensor may report vacant before a new arrival counts
+// as a new parking session instead of the continuation of the previous one.
+const defaultSessionMergeGap = 2 * time.Minute
+
+// sessionMergeGap returns the merge gap from SESSION_MERGE_GAP_SECONDS, or the default.
+func sessionMergeGap() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("SESSION_MERGE_GAP_SECONDS"))
+	if err != nil || seconds < 0 {
+		return defaultSessionMergeGap
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// SyncParkingSessions re-derives the parking sessions of every device in the given activity logs,
+// starting at each device's earliest log so that late packages are placed correctly.
+func (s *Service) SyncParkingSessions(activityLogs []models.ActivityLog) {
+
+	// Find the earliest event of every device in the batch.
+	earliest := make(map[string]time.Time)
+	for _, log := range activityLogs {
+		if since, ok := earliest[log.DeviceID]; !ok || log.HappenedAt.Before(since) {
+			earliest[log.DeviceID] = log.HappenedAt
+		}
+	}
+
+	// Devices that are not in service, such as those under maintenance, are left out of the analytics.
+	deviceIDs := make([]string, 0, len(earliest))
+	for deviceID := range earliest {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	devices, err := s.cache.GetDevices(deviceIDs)
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve devices from cache")
+	}
+
+	mergeGap := sessionMergeGap()
+	sessionsCount := 0
+
+	for deviceID, since := range earliest {
+		if device, ok := devices[deviceID]; ok && !countsInAnalytics(device) {
+			delete(earliest, deviceID)
+			continue
+		}
+
+		written, anchor, err := s.models.ParkingSession.Rebuild(deviceID, since, mergeGap)
+		if err != nil {
+			helpers.LogError(err, fmt.Sprintf("Failed to derive parking sessions for device %s", deviceID))
+			continue
+		}
+		sessionsCount += written
+
+		// Backfill the occupancy buckets the rebuilt sessions touch.
+		s.RecomputeOccupancyRollups([]string{deviceID}, anchor)
+	}
+
+	if sessionsCount > 0 {
+		helpers.LogInfo("Successfully derived %d parking sessions for %d devices", sessionsCount, len(earliest))
+	}
+}
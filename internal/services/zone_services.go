@@ -0,0 +1,152 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/foxcodenine/iot-parking-gateway/internal/models"
+)
+
+// ZoneOccupancy is a zone with the live counts of the bays in it and in the zones nested in it.
+type ZoneOccupancy struct {
+	*models.Zone
+	Bays     int `json:"bays"`     // Devices assigned to bays
+	Occupied int `json:"occupied"` // Bays whose device reports a vehicle
+	Free     int `json:"free"`
+}
+
+// zoneSummary is the part of a zone cached with its counts and sent in `zone-occupancy-event`.
+func zoneSummary(zone *models.Zone) map[string]any {
+	return map[string]any{
+		"id":        zone.ID,
+		"parent_id": zone.ParentID,
+		"kind":      zone.Kind,
+		"name":      zone.Name,
+		"capacity":  zone.Capacity,
+	}
+}
+
+// ValidateZone checks a zone and that its parent exists and may contain it.
+func (s *Service) ValidateZone(zone *models.Zone) error {
+	if err := zone.Validate(); err != nil {
+		return err
+	}
+
+	var parent *models.Zone
+	if zone.ParentID != nil {
+		if *zone.ParentID == zone.ID {
+			return fmt.Errorf("a zone cannot be nested in itself")
+		}
+		var err error
+		parent, err = s.models.Zone.GetByID(*zone.ParentID)
+		if err != nil {
+			if err.Error() == "zone not found" {
+				return fmt.Errorf("parent zone %d not found", *zone.ParentID)
+			}
+			return err
+		}
+	}
+
+	return zone.ValidateParent(parent)
+}
+
+// RebuildZoneCounts recomputes the bays and occupancy of every zone from the devices currently
+// assigned to bays and the device cache. The counts are then kept live by the zone subscriber; rebuilding
+// picks up zone and bay changes and corrects any drift.
+func (s *Service) RebuildZoneCounts() {
+	zones, err := s.models.Zone.GetAll()
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve zones")
+		return
+	}
+	bays, err := s.models.Bay.GetAll(models.BayFilter{})
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve bays")
+		return
+	}
+	devices, err := s.cache.GetAllDevices()
+	if err != nil {
+		helpers.LogError(err, "Failed to retrieve devices from cache")
+		return
+	}
+
+	summaries := make(map[int]any, len(zones))
+	parents := make(map[int]*int, len(zones))
+	for _, zone := range zones {
+		summaries[zone.ID] = zoneSummary(zone)
+		parents[zone.ID] = zone.ParentID
+	}
+
+	zoneBays := make(map[int][]string)
+	zoneOccupied := make(map[int][]string)
+	deviceZones := make(map[string][]int)
+
+	for _, bay := range bays {
+		if bay.ZoneID == nil || bay.DeviceID == nil {
+			continue
+		}
+		deviceID := *bay.DeviceID
+		device, ok := devices[deviceID]
+		if !ok || !countsInAnalytics(device) {
+			continue // Deleted devices, and those not in service, no longer count
+		}
+		isOccupied := cache.IsOccupiedValue(device["is_occupied"])
+
+		// A bay counts towards its zone and every zone the zone is nested in.
+		// The depth bound only guards against a parent cycle edited into the database.
+		for zoneID, depth := bay.ZoneID, 0; zoneID != nil && depth < len(zones); zoneID, depth = parents[*zoneID], depth+1 {
+			deviceZones[deviceID] = append(deviceZones[deviceID], *zoneID)
+			zoneBays[*zoneID] = append(zoneBays[*zoneID], deviceID)
+			if isOccupied {
+				zoneOccupied[*zoneID] = append(zoneOccupied[*zoneID], deviceID)
+			}
+		}
+	}
+
+	if err := s.cache.ReplaceZones(summaries, zoneBays, zoneOccupied, deviceZones); err != nil {
+		helpers.LogError(err, "Failed to cache zone counts")
+	}
+}
+
+// ZoneOccupancies adds the live counts to the zones.
+func (s *Service) ZoneOccupancies(zones []*models.Zone) ([]*ZoneOccupancy, error) {
+	zoneIDs := make([]int, len(zones))
+	for i, zone := range zones {
+		zoneIDs[i] = zone.ID
+	}
+
+	counts, err := s.cache.GetZoneCounts(zoneIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*ZoneOccupancy, len(zones))
+	for i, zone := range zones {
+		c := counts[zone.ID]
+		result[i] = &ZoneOccupancy{Zone: zone, Bays: c.Bays, Occupied: c.Occupied, Free: max(c.Bays-c.Occupied, 0)}
+	}
+
+	return result, nil
+}
+
+// zoneDeviceIDs returns the devices of a zone, given by ID; nil means every device.
+func (s *Service) zoneDeviceIDs(zone string) ([]string, error) {
+	if zone == "" || zone == "all" {
+		return nil, nil
+	}
+
+	zoneID, err := strconv.Atoi(zone)
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownZone, zone)
+	}
+	if _, err := s.models.Zone.GetByID(zoneID); err != nil {
+		if err.Error() == "zone not found" {
+			return nil, fmt.Errorf("%w '%s'", ErrUnknownZone, zone)
+		}
+		return nil, err
+	}
+
+	return s.models.Zone.DeviceIDs(zoneID)
+}
@@ -0,0 +1,164 @@
+package thingsboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+)
+
+// ThingsBoard Gateway MQTT API topics.
+const (
+	connectTopic    = "v1/gateway/connect"
+	telemetryTopic  = "v1/gateway/telemetry"
+	attributesTopic = "v1/gateway/attributes"
+)
+
+// Config holds the connection settings for the ThingsBoard MQTT broker.
+type Config struct {
+	Enabled     bool
+	BrokerURL   string
+	AccessToken string // Access token of the gateway device in ThingsBoard, used as the MQTT username
+	ClientID    string
+	QoS         byte
+}
+
+// SetupThingsBoardConfig builds the ThingsBoard configuration from environment variables.
+func SetupThingsBoardConfig() Config {
+	port := os.Getenv("THINGSBOARD_MQTT_PORT")
+	if port == "" {
+		port = "1883"
+	}
+
+	clientID := os.Getenv("THINGSBOARD_CLIENT_ID")
+	if clientID == "" {
+		clientID = "iot-parking-gateway"
+	}
+
+	return Config{
+		Enabled:     os.Getenv("THINGSBOARD_ENABLED") == "true",
+		BrokerURL:   fmt.Sprintf("tcp://%s:%s", os.Getenv("THINGSBOARD_MQTT_HOST"), port),
+		AccessToken: os.Getenv("THINGSBOARD_ACCESS_TOKEN"),
+		ClientID:    clientID,
+		QoS:         1,
+	}
+}
+
+// Gateway publishes device data to ThingsBoard using the Gateway MQTT API,
+// so every parking device appears as its own device in ThingsBoard.
+type Gateway struct {
+	config    Config
+	client    mqtt.Client
+	connected map[string]bool // Devices already announced on v1/gateway/connect
+	mu        sync.Mutex
+}
+
+// NewGateway creates a new ThingsBoard gateway client.
+func NewGateway(config Config) *Gateway {
+	g := &Gateway{
+		config:    config,
+		connected: make(map[string]bool),
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.BrokerURL).
+		SetClientID(config.ClientID).
+		SetUsername(config.AccessToken).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetOnConnectHandler(func(mqtt.Client) {
+			// ThingsBoard forgets gateway sessions on reconnect, so devices must be announced again.
+			g.mu.Lock()
+			g.connected = make(map[string]bool)
+			g.mu.Unlock()
+			helpers.LogInfo("Successfully connected to ThingsBoard on %s", config.BrokerURL)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			helpers.LogError(err, "Connection to ThingsBoard lost")
+		})
+
+	g.client = mqtt.NewClient(opts)
+
+	return g
+}
+
+// Connect starts the MQTT connection. Retries happen in the background until the broker is reachable.
+func (g *Gateway) Connect() {
+	g.client.Connect()
+}
+
+// Close disconnects from the broker, allowing in-flight messages 250ms to complete.
+func (g *Gateway) Close() {
+	g.client.Disconnect(250)
+}
+
+// ConnectDevice announces a device to ThingsBoard. It is a no-op if the device was already announced.
+func (g *Gateway) ConnectDevice(deviceName, deviceType string) (bool, error) {
+	g.mu.Lock()
+	if g.connected[deviceName] {
+		g.mu.Unlock()
+		return false, nil
+	}
+	g.mu.Unlock()
+
+	payload := map[string]string{
+		"device": deviceName,
+		"type":   deviceType,
+	}
+
+	if err := g.publish(connectTopic, payload); err != nil {
+		return false, err
+	}
+
+	g.mu.Lock()
+	g.connected[deviceName] = true
+	g.mu.Unlock()
+
+	return true, nil
+}
+
+// Telemetry publishes time-series values for a device. The timestamp is sent in milliseconds.
+func (g *Gateway) Telemetry(deviceName string, ts time.Time, values map[string]any) error {
+	payload := map[string][]map[string]any{
+		deviceName: {
+			{
+				"ts":     ts.UnixMilli(),
+				"values": values,
+			},
+		},
+	}
+
+	return g.publish(telemetryTopic, payload)
+}
+
+// Attributes publishes client-side attributes for a device.
+func (g *Gateway) Attributes(deviceName string, attributes map[string]any) error {
+	payload := map[string]map[string]any{
+		deviceName: attributes,
+	}
+
+	return g.publish(attributesTopic, payload)
+}
+
+// publish serializes the payload and waits for the broker to acknowledge it.
+func (g *Gateway) publish(topic string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ThingsBoard payload: %w", err)
+	}
+
+	token := g.client.Publish(topic, g.config.QoS, false, data)
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("timed out publishing to ThingsBoard topic '%s'", topic)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to publish to ThingsBoard topic '%s': %w", topic, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package thingsboard
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// message is a message published through fakeClient.
+type message struct {
+	topic   string
+	qos     byte
+	payload string
+}
+
+// fakeClient is an MQTT client that records the published messages instead of sending them.
+type fakeClient struct {
+	mqtt.Client
+	published []message
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	c.published = append(c.published, message{topic: topic, qos: qos, payload: string(payload.([]byte))})
+	return doneToken{}
+}
+
+// doneToken is a token of a message the broker acknowledged.
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{}          { ch := make(chan struct{}); close(ch); return ch }
+func (doneToken) Error() error                   { return nil }
+
+func newTestGateway() (*Gateway, *fakeClient) {
+	client := &fakeClient{}
+	return &Gateway{config: Config{QoS: 1}, client: client, connected: make(map[string]bool)}, client
+}
+
+func assertJSON(t *testing.T, got, want string) {
+	t.Helper()
+
+	var g, w any
+	if err := json.Unmarshal([]byte(got), &g); err != nil {
+		t.Fatalf("payload %s is not JSON: %v", got, err)
+	}
+	json.Unmarshal([]byte(want), &w)
+
+	gotJSON, _ := json.Marshal(g)
+	wantJSON, _ := json.Marshal(w)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("payload =\n%s\nwant\n%s", gotJSON, wantJSON)
+	}
+}
+
+func TestGatewayConnectDevice(t *testing.T) {
+	g, client := newTestGateway()
+
+	isNew, err := g.ConnectDevice("02DF9902", "LoRa")
+	if err != nil || !isNew {
+		t.Fatalf("ConnectDevice() = %t, %v, want true, nil", isNew, err)
+	}
+	isNew, err = g.ConnectDevice("02DF9902", "LoRa")
+	if err != nil || isNew {
+		t.Fatalf("second ConnectDevice() = %t, %v, want false, nil", isNew, err)
+	}
+
+	if len(client.published) != 1 {
+		t.Fatalf("published %d messages, want 1", len(client.published))
+	}
+	msg := client.published[0]
+	if msg.topic != "v1/gateway/connect" || msg.qos != 1 {
+		t.Errorf("published to %s with QoS %d, want v1/gateway/connect with QoS 1", msg.topic, msg.qos)
+	}
+	assertJSON(t, msg.payload, `{"device": "02DF9902", "type": "LoRa"}`)
+}
+
+func TestGatewayTelemetry(t *testing.T) {
+	g, client := newTestGateway()
+
+	ts := time.Unix(1700000000, 0)
+	if err := g.Telemetry("02DF9902", ts, map[string]any{"is_occupied": true, "beacons_amount": 2.0}); err != nil {
+		t.Fatalf("Telemetry() returned error: %v", err)
+	}
+
+	msg := client.published[0]
+	if msg.topic != "v1/gateway/telemetry" {
+		t.Errorf("published to %s, want v1/gateway/telemetry", msg.topic)
+	}
+	assertJSON(t, msg.payload, `{"02DF9902": [{"ts": 1700000000000, "values": {"is_occupied": true, "beacons_amount": 2}}]}`)
+}
+
+func TestGatewayAttributes(t *testing.T) {
+	g, client := newTestGateway()
+
+	if err := g.Attributes("02DF9902", map[string]any{"name": "Bay 12", "latitude": 35.9}); err != nil {
+		t.Fatalf("Attributes() returned error: %v", err)
+	}
+
+	msg := client.published[0]
+	if msg.topic != "v1/gateway/attributes" {
+		t.Errorf("published to %s, want v1/gateway/attributes", msg.topic)
+	}
+	assertJSON(t, msg.payload, `{"02DF9902": {"name": "Bay 12", "latitude": 35.9}}`)
+}
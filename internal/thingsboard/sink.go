@@ -0,0 +1,172 @@
+package thingsboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+	"github.com/streadway/amqp"
+)
+
+// Event IDs used in the event_logs messages.
+const (
+	keepaliveEventID = 6
+	settingsEventID  = 25
+	parkingEventID   = 26
+)
+
+// publisher is the part of the Gateway used by the sink.
+type publisher interface {
+	ConnectDevice(deviceName, deviceType string) (bool, error)
+	Telemetry(deviceName string, ts time.Time, values map[string]any) error
+	Attributes(deviceName string, attributes map[string]any) error
+}
+
+// deviceStore looks up cached devices.
+type deviceStore interface {
+	GetDevice(deviceID string) (map[string]any, error)
+}
+
+// Sink forwards decoded packages from the thingsboard_event_logs queue to ThingsBoard.
+type Sink struct {
+	gateway    publisher
+	cache      deviceStore
+	attributes map[string]string // Device ID to the JSON of the attributes last published
+	mu         sync.Mutex
+}
+
+// NewSink creates a new ThingsBoard sink.
+func NewSink(g *Gateway, c *cache.RedisCache) *Sink {
+	return newSink(g, c)
+}
+
+func newSink(g publisher, c deviceStore) *Sink {
+	return &Sink{
+		gateway:    g,
+		cache:      c,
+		attributes: make(map[string]string),
+	}
+}
+
+// HandleDelivery translates a single event_logs message into ThingsBoard Gateway API calls.
+func (s *Sink) HandleDelivery(delivery amqp.Delivery) error {
+	var pkg map[string]any
+	if err := json.Unmarshal(delivery.Body, &pkg); err != nil {
+		// A malformed message will never succeed, so log it and drop it instead of redelivering.
+		helpers.LogError(err, "Invalid message on thingsboard_event_logs queue")
+		return nil
+	}
+
+	return s.HandlePackage(pkg)
+}
+
+// HandlePackage announces the device if needed and publishes its telemetry.
+func (s *Sink) HandlePackage(pkg map[string]any) error {
+	deviceID, ok := pkg["device_id"].(string)
+	if !ok || deviceID == "" {
+		return errors.New("missing or invalid device_id in package")
+	}
+
+	networkType, _ := pkg["network_type"].(string)
+
+	// Announce the device the first time it is seen on this connection, and push its attributes then
+	// and whenever they change, e.g. when the device is renamed or moved.
+	isNew, err := s.gateway.ConnectDevice(deviceID, networkType)
+	if err != nil {
+		return err
+	}
+	if err := s.publishAttributes(deviceID, isNew); err != nil {
+		helpers.LogError(err, fmt.Sprintf("Failed to publish ThingsBoard attributes for device %s", deviceID))
+	}
+
+	eventID, _ := pkg["event_id"].(float64)
+	timestamp, _ := pkg["timestamp"].(float64)
+
+	ts := time.Now().UTC()
+	if timestamp > 0 {
+		ts = time.Unix(int64(timestamp), 0).UTC()
+	}
+
+	values := map[string]any{}
+
+	switch int(eventID) {
+	case parkingEventID:
+		if isOccupied, ok := pkg["is_occupied"].(float64); ok {
+			values["is_occupied"] = isOccupied != 0
+		}
+		copyValues(values, pkg, "beacons_amount")
+
+	case keepaliveEventID:
+		copyValues(values, pkg, "battery_percentage", "idle_voltage", "rssi_average", "temperature_min", "temperature_max")
+
+	case settingsEventID:
+		// Settings changes are reflected as an attribute rather than telemetry.
+		return s.gateway.Attributes(deviceID, map[string]any{
+			"firmware_version": pkg["firmware_version"],
+			"settings_at":      ts.Format("2006-01-02T15:04:05Z"),
+		})
+
+	default:
+		return nil
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	return s.gateway.Telemetry(deviceID, ts, values)
+}
+
+// publishAttributes sends the device name, location and network details from the device cache when
+// they differ from those last published, or always when force is set.
+func (s *Sink) publishAttributes(deviceID string, force bool) error {
+	device, err := s.cache.GetDevice(deviceID)
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return nil
+	}
+
+	attributes := map[string]any{}
+	for _, key := range []string{"name", "latitude", "longitude", "network_type", "firmware_version"} {
+		if value, ok := device[key]; ok {
+			attributes[key] = value
+		}
+	}
+
+	// Maps are encoded with sorted keys, so equal attributes give the same JSON.
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	unchanged := s.attributes[deviceID] == string(data)
+	s.mu.Unlock()
+	if unchanged && !force {
+		return nil
+	}
+
+	if err := s.gateway.Attributes(deviceID, attributes); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.attributes[deviceID] = string(data)
+	s.mu.Unlock()
+	return nil
+}
+
+// copyValues copies the given keys from the package into values when present.
+func copyValues(values map[string]any, pkg map[string]any, keys ...string) {
+	for _, key := range keys {
+		if value, ok := pkg[key]; ok && value != nil {
+			values[key] = value
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package thingsboard
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
+)
+
+func TestMain(m *testing.M) {
+	helpers.ConfigLogger()
+	os.Exit(m.Run())
+}
+
+// call is a Gateway API call recorded by fakeGateway.
+type call struct {
+	kind   string // "connect", "telemetry" or "attributes"
+	device string
+	ts     time.Time
+	values map[string]any
+}
+
+// fakeGateway records the calls of the sink, announcing every device once like the Gateway.
+type fakeGateway struct {
+	calls     []call
+	connected map[string]bool
+}
+
+func newFakeGateway() *fakeGateway {
+	return &fakeGateway{connected: make(map[string]bool)}
+}
+
+func (g *fakeGateway) ConnectDevice(deviceName, deviceType string) (bool, error) {
+	if g.connected[deviceName] {
+		return false, nil
+	}
+	g.connected[deviceName] = true
+	g.calls = append(g.calls, call{kind: "connect", device: deviceName, values: map[string]any{"type": deviceType}})
+	return true, nil
+}
+
+func (g *fakeGateway) Telemetry(deviceName string, ts time.Time, values map[string]any) error {
+	g.calls = append(g.calls, call{kind: "telemetry", device: deviceName, ts: ts, values: values})
+	return nil
+}
+
+func (g *fakeGateway) Attributes(deviceName string, attributes map[string]any) error {
+	g.calls = append(g.calls, call{kind: "attributes", device: deviceName, values: attributes})
+	return nil
+}
+
+// reconnect forgets the announced devices, as the Gateway does when the MQTT connection is restored.
+func (g *fakeGateway) reconnect() {
+	g.connected = make(map[string]bool)
+}
+
+func (g *fakeGateway) take() []call {
+	calls := g.calls
+	g.calls = nil
+	return calls
+}
+
+// fakeDevices is a device cache.
+type fakeDevices map[string]map[string]any
+
+func (d fakeDevices) GetDevice(deviceID string) (map[string]any, error) {
+	return d[deviceID], nil
+}
+
+func newTestDevices() fakeDevices {
+	return fakeDevices{
+		"02DF9902": {
+			"name": "Bay 12", "latitude": 35.9, "longitude": 14.5, "network_type": "LoRa", "firmware_version": 5.8,
+			"is_occupied": true,
+		},
+	}
+}
+
+func parkingPackage(isOccupied float64) map[string]any {
+	return map[string]any{
+		"device_id": "02DF9902", "network_type": "LoRa", "event_id": 26.0, "timestamp": 1700000000.0,
+		"is_occupied": isOccupied, "beacons_amount": 2.0,
+	}
+}
+
+func TestHandlePackagePayloads(t *testing.T) {
+	ts := time.Unix(1700000000, 0).UTC()
+
+	tests := []struct {
+		name string
+		pkg  map[string]any
+		want []call
+	}{
+		{
+			name: "parking event",
+			pkg:  parkingPackage(1),
+			want: []call{{kind: "telemetry", device: "02DF9902", ts: ts, values: map[string]any{"is_occupied": true, "beacons_amount": 2.0}}},
+		},
+		{
+			name: "keepalive event",
+			pkg: map[string]any{
+				"device_id": "02DF9902", "network_type": "LoRa", "event_id": 6.0, "timestamp": 1700000000.0,
+				"battery_percentage": 87.0, "idle_voltage": 3.61, "rssi_average": -91.0, "temperature_min": 12.0, "temperature_max": nil,
+			},
+			want: []call{{kind: "telemetry", device: "02DF9902", ts: ts, values: map[string]any{
+				"battery_percentage": 87.0, "idle_voltage": 3.61, "rssi_average": -91.0, "temperature_min": 12.0,
+			}}},
+		},
+		{
+			name: "settings event",
+			pkg: map[string]any{
+				"device_id": "02DF9902", "network_type": "LoRa", "event_id": 25.0, "timestamp": 1700000000.0, "firmware_version": 5.9,
+			},
+			want: []call{{kind: "attributes", device: "02DF9902", values: map[string]any{
+				"firmware_version": 5.9, "settings_at": "2023-11-14T22:13:20Z",
+			}}},
+		},
+		{
+			name: "other event",
+			pkg:  map[string]any{"device_id": "02DF9902", "network_type": "LoRa", "event_id": 1.0, "timestamp": 1700000000.0},
+		},
+		{
+			name: "parking event without values",
+			pkg:  map[string]any{"device_id": "02DF9902", "network_type": "LoRa", "event_id": 26.0, "timestamp": 1700000000.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := newFakeGateway()
+			sink := newSink(gateway, newTestDevices())
+
+			// Announce the device first, so only the calls of the package itself are compared.
+			if err := sink.HandlePackage(map[string]any{"device_id": "02DF9902", "network_type": "LoRa"}); err != nil {
+				t.Fatalf("HandlePackage() returned error: %v", err)
+			}
+			gateway.take()
+
+			if err := sink.HandlePackage(tt.pkg); err != nil {
+				t.Fatalf("HandlePackage() returned error: %v", err)
+			}
+			if got := gateway.take(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HandlePackage() calls =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlePackageAnnouncesDevice(t *testing.T) {
+	gateway := newFakeGateway()
+	sink := newSink(gateway, newTestDevices())
+
+	if err := sink.HandlePackage(parkingPackage(1)); err != nil {
+		t.Fatalf("HandlePackage() returned error: %v", err)
+	}
+
+	calls := gateway.take()
+	if len(calls) != 3 {
+		t.Fatalf("got %d calls, want connect, attributes and telemetry: %+v", len(calls), calls)
+	}
+	if calls[0].kind != "connect" || calls[0].values["type"] != "LoRa" {
+		t.Errorf("first call is %+v, want a LoRa connect", calls[0])
+	}
+	wantAttributes := map[string]any{"name": "Bay 12", "latitude": 35.9, "longitude": 14.5, "network_type": "LoRa", "firmware_version": 5.8}
+	if calls[1].kind != "attributes" || !reflect.DeepEqual(calls[1].values, wantAttributes) {
+		t.Errorf("second call is %+v, want attributes %v", calls[1], wantAttributes)
+	}
+	if calls[2].kind != "telemetry" {
+		t.Errorf("third call is %+v, want telemetry", calls[2])
+	}
+}
+
+func TestHandlePackageResendsChangedAttributes(t *testing.T) {
+	gateway := newFakeGateway()
+	devices := newTestDevices()
+	sink := newSink(gateway, devices)
+
+	attributeCalls := func() []call {
+		var calls []call
+		for _, c := range gateway.take() {
+			if c.kind == "attributes" {
+				calls = append(calls, c)
+			}
+		}
+		return calls
+	}
+
+	sink.HandlePackage(parkingPackage(1))
+	if got := attributeCalls(); len(got) != 1 {
+		t.Fatalf("got %d attribute updates on the first package, want 1", len(got))
+	}
+
+	// Unchanged attributes are not sent again.
+	sink.HandlePackage(parkingPackage(0))
+	if got := attributeCalls(); len(got) != 0 {
+		t.Fatalf("got %d attribute updates while nothing changed, want 0", len(got))
+	}
+
+	// A rename or move is sent with the next package.
+	devices["02DF9902"]["name"] = "Bay 14"
+	devices["02DF9902"]["latitude"] = 35.91
+	sink.HandlePackage(parkingPackage(1))
+	got := attributeCalls()
+	if len(got) != 1 || got[0].values["name"] != "Bay 14" || got[0].values["latitude"] != 35.91 {
+		t.Fatalf("got attribute updates %+v after a rename and move, want the new name and location", got)
+	}
+
+	// ThingsBoard forgets the devices on reconnect, so the attributes are sent again.
+	gateway.reconnect()
+	sink.HandlePackage(parkingPackage(0))
+	if got := attributeCalls(); len(got) != 1 {
+		t.Fatalf("got %d attribute updates after a reconnect, want 1", len(got))
+	}
+}
+
+func TestHandlePackageUnknownDevice(t *testing.T) {
+	gateway := newFakeGateway()
+	sink := newSink(gateway, fakeDevices{})
+
+	pkg := parkingPackage(1)
+	pkg["device_id"] = "UNCACHED"
+	if err := sink.HandlePackage(pkg); err != nil {
+		t.Fatalf("HandlePackage() returned error: %v", err)
+	}
+
+	for _, c := range gateway.take() {
+		if c.kind == "attributes" {
+			t.Errorf("attributes published for a device missing from the cache: %+v", c)
+		}
+	}
+}
+
+func TestHandlePackageInvalidDevice(t *testing.T) {
+	sink := newSink(newFakeGateway(), newTestDevices())
+
+	if err := sink.HandlePackage(map[string]any{"event_id": 26.0}); err == nil {
+		t.Error("HandlePackage() returned no error for a package without device_id")
+	}
+}
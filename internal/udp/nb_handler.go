@@ -2,7 +2,6 @@ package udp
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -10,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
 	"github.com/foxcodenine/iot-parking-gateway/internal/firmware"
 	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
 
@@ -79,17 +79,23 @@ func (s *UDPServer) nbMessageHandler(conn *net.UDPConn, data []byte, addr *net.U
 
 	// If the device ID is not registered, track it for registration and prevent duplicates.
 	if !isDeviceRegistered {
-		// Add the device to a Redis set for tracking devices that need registration.
-		deviceDataKey := fmt.Sprintf("%s %f", deviceIdentifierKey, firmwareVersion)
-		if err := s.cache.SAdd("to-register-devices", deviceDataKey); err != nil {
-			helpers.LogError(err, "Failed to add device ID to the 'to-register-devices' set")
-		}
+		// Announce the device so it is tracked for registration.
+		s.bus.Publish(events.DeviceRegistered{
+			DeviceID:        strconv.Itoa(deviceID),
+			NetworkType:     "NB-IoT",
+			FirmwareVersion: firmwareVersion,
+		})
 
 		// Add the device ID to the Bloom Filter to prevent duplicate registrations in the future.
 		if _, err := s.cache.AddItemToBloomFilter("registered-devices", deviceIdentifierKey); err != nil {
 			helpers.LogError(err, "Failed to add device ID to the 'registered-devices' Bloom Filter")
 		}
 
+		// Hold the message when the device has to be approved or claimed first.
+		if s.holdUnregistered(deviceID, firmwareVersion, hexStr) {
+			sendResponse(conn, addr, reply)
+			return
+		}
 	}
 
 	// If the device ID is registered, check if it is soft delete, white listed or black listed.
@@ -103,6 +109,12 @@ func (s *UDPServer) nbMessageHandler(conn *net.UDPConn, data []byte, addr *net.U
 			return
 		}
 
+		// A device that is not registered yet may be waiting for approval.
+		if deviceData == nil && s.holdUnregistered(deviceID, firmwareVersion, hexStr) {
+			sendResponse(conn, addr, reply)
+			return
+		}
+
 		// Check if the device is soft deleted
 		if deletedAt, exists := deviceData["deleted_at"]; exists && deletedAt != nil && deletedAt != "0001-01-01T00:00:00Z" {
 			helpers.LogInfo("Device %d is marked as soft deleted. Request ignored.", deviceID)
@@ -110,6 +122,12 @@ func (s *UDPServer) nbMessageHandler(conn *net.UDPConn, data []byte, addr *net.U
 			return
 		}
 
+		// Check if the lifecycle state of the device lets its traffic in
+		if _, ok := s.services.AdmitTraffic("NB-IoT", strconv.Itoa(deviceID), firmwareVersion, deviceData); !ok {
+			sendResponse(conn, addr, reply)
+			return
+		}
+
 		// Retrieve application settings for device access mode
 		deviceAccessMode, err := s.cache.HGet("app:settings", "device_access_mode")
 		if err != nil {
@@ -185,105 +203,77 @@ func (s *UDPServer) nbMessageHandler(conn *net.UDPConn, data []byte, addr *net.U
 
 	// -----------------------------------------------------------------
 
-	// Attempt to update device cache and broadcast the changes.
-	err = s.updateDeviceCacheAndBroadcast(parsedData)
+	// Attempt to update the device state in the cache.
+	occupancyUpdate, err := s.updateDeviceCache(parsedData)
 
 	// Check for errors in the update process.
 	if err != nil {
 		// Log the error with additional context for better troubleshooting.
-		helpers.LogError(err, "Failed to update device cache and broadcast changes")
+		helpers.LogError(err, "Failed to update device cache")
 	}
 
-	// Attempt to update device keepalive_at in cache and broadcast the changes.
-	err = s.updateDeviceKeepaliveInCacheAndBroadcast(parsedData)
+	// Attempt to update device keepalive_at in cache.
+	keepaliveUpdate, err := s.updateDeviceKeepaliveInCache(parsedData)
 
 	// Check for errors in the update process.
 	if err != nil {
 		// Log the error with additional context for better troubleshooting.
-		helpers.LogError(err, "Failed to update device keepalive_at in cache and broadcast it")
+		helpers.LogError(err, "Failed to update device keepalive_at in cache")
 	}
 
-	// Attempt to update device settings_at in cache, check if device_settings should be updated and broadcast settings_at.
-	updateDeviceSettings, err := s.updateDeviceSettingsInCacheAndBroadcast(parsedData)
+	// Attempt to update device settings_at in cache and check if device_settings should be updated.
+	settingsUpdate, err := s.updateDeviceSettingsInCache(parsedData)
 
 	// Check for errors in the update process.
 	if err != nil {
 		// Log the error with additional context for better troubleshooting.
-		helpers.LogError(err, "Failed to update device settings_at in cache and broadcast it")
+		helpers.LogError(err, "Failed to update device settings_at in cache")
 	}
+	updateDeviceSettings := settingsUpdate != nil
 
-	// Push parsed parking data packages to Redis.
-	for _, i := range parsedData["parking_packages"].([]map[string]any) {
+	deviceIDStr := strconv.Itoa(deviceID)
 
+	// Add common fields to the parsed parking data packages.
+	parkingPackages := parsedData["parking_packages"].([]map[string]any)
+	for _, i := range parkingPackages {
 		i["firmware_version"] = parsedData["firmware_version"]
-		i["device_id"] = fmt.Sprintf("%d", parsedData["device_id"])
+		i["device_id"] = deviceIDStr
 		i["raw_id"] = rawUUID
 		i["event_id"] = 26
 		i["network_type"] = "NB-IoT"
-
-		err := s.cache.RPush("logs:activity-logs", i)
-		if err != nil {
-			helpers.LogError(err, "Failed to push parking package data log to Redis")
-		}
-
-		messageData, err := json.Marshal(i)
-		if err != nil {
-			helpers.LogError(err, "Failed to serialize parsedData to JSON")
-			continue
-		}
-		s.mqProducer.SendMessageToExchange("event_logs", string(messageData))
 	}
 
-	// Push parsed keepalive data to Redis.
-	for _, i := range parsedData["keep_alive_packages"].([]map[string]any) {
+	// Add common fields to the parsed keepalive packages.
+	keepalivePackages := parsedData["keep_alive_packages"].([]map[string]any)
+	for _, i := range keepalivePackages {
 		i["firmware_version"] = parsedData["firmware_version"]
-		i["device_id"] = fmt.Sprintf("%d", parsedData["device_id"])
+		i["device_id"] = deviceIDStr
 		i["raw_id"] = rawUUID
 		i["event_id"] = 6
 		i["network_type"] = "NB-IoT"
-
-		err := s.cache.RPush("logs:nb-keepalive-logs", i)
-		if err != nil {
-			helpers.LogError(err, "Failed to push keepalive package data log to Redis")
-		}
-
-		messageData, err := json.Marshal(i)
-		if err != nil {
-			helpers.LogError(err, "Failed to serialize parsedData to JSON")
-			continue
-		}
-		s.mqProducer.SendMessageToExchange("event_logs", string(messageData))
 	}
 
-	// Push parsed settings data to Redis.
-	for n, i := range parsedData["settings_packages"].([]map[string]any) {
+	// Add common fields to the parsed settings packages.
+	settingsPackages := parsedData["settings_packages"].([]map[string]any)
+	for n, i := range settingsPackages {
 		if n == 0 && updateDeviceSettings {
 			i["update_device_settings"] = true
 		} else {
 			i["update_device_settings"] = false
 		}
 
-		// Add common fields to each individual package
 		i["firmware_version"] = parsedData["firmware_version"]
-		i["device_id"] = fmt.Sprintf("%d", parsedData["device_id"])
+		i["device_id"] = deviceIDStr
 		i["raw_id"] = rawUUID
 		i["event_id"] = 25 // Assuming 25 is the event ID for setting logs
 		i["network_type"] = "NB-IoT"
-
-		// Push the package to Redis
-		err := s.cache.RPush("logs:nb-setting-logs", i)
-		if err != nil {
-			helpers.LogError(err, "Failed to push setting package data log to Redis")
-		}
-
-		messageData, err := json.Marshal(i)
-		if err != nil {
-			helpers.LogError(err, "Failed to serialize parsedData to JSON")
-			continue
-		}
-		s.mqProducer.SendMessageToExchange("event_logs", string(messageData))
 	}
 
+	// Hand the packages to the subscribers (Redis logs, RabbitMQ, Socket.IO, ...).
+	s.bus.Publish(events.OccupancyChanged{DeviceID: deviceIDStr, NetworkType: "NB-IoT", Packages: parkingPackages, Update: occupancyUpdate})
+	s.bus.Publish(events.KeepaliveReceived{DeviceID: deviceIDStr, NetworkType: "NB-IoT", Packages: keepalivePackages, Update: keepaliveUpdate})
+	s.bus.Publish(events.SettingsReported{DeviceID: deviceIDStr, NetworkType: "NB-IoT", Packages: settingsPackages, Update: settingsUpdate})
+
 	// time.Sleep(1 * time.Second)
 	// s.services.RegisterNewDevices()
 	// s.services.SyncActivityLogs()
@@ -296,6 +286,11 @@ func (s *UDPServer) nbMessageHandler(conn *net.UDPConn, data []byte, addr *net.U
 
 // sendResponse sends a structured reply back to the UDP client.
 func sendResponse(conn *net.UDPConn, addr *net.UDPAddr, reply []string) {
+	// Replayed messages have no client to answer.
+	if conn == nil {
+		return
+	}
+
 	response := []byte(strings.Join(reply, "") + "\n")
 	_, err := conn.WriteToUDP(response, addr)
 	if err != nil {
@@ -303,30 +298,37 @@ func sendResponse(conn *net.UDPConn, addr *net.UDPAddr, reply []string) {
 	}
 }
 
+// holdUnregistered holds the message of a device that is waiting for registration, or drops it when
+// the device was rejected. It returns false when the message should be processed.
+func (s *UDPServer) holdUnregistered(deviceID int, firmwareVersion float64, hexStr string) bool {
+	msg := events.HeldMessage{ReceivedAt: time.Now().UTC(), Payload: hexStr}
+	return s.services.HoldTraffic("NB-IoT", strconv.Itoa(deviceID), firmwareVersion, msg) != ""
+}
+
 // handleErrorSendResponse logs an error, sends a response to the client, and exits the handler.
 func handleErrorSendResponse(err error, message string, conn *net.UDPConn, addr *net.UDPAddr, reply []string) {
 	helpers.LogError(err, message, 3)
 	sendResponse(conn, addr, reply)
 }
 
-// updateDeviceKeepaliveInCacheAndBroadcast updates the keepalive timestamp for a device in the cache and broadcasts changes.
-// If the new keepalive timestamp is more recent than the cached one, the cache and relevant logs are updated.
-func (s *UDPServer) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[string]any) error {
+// updateDeviceKeepaliveInCache updates the keepalive timestamp for a device in the cache.
+// If the new keepalive timestamp is more recent than the cached one, the cache is updated and the change is returned.
+func (s *UDPServer) updateDeviceKeepaliveInCache(parsedData map[string]any) (map[string]any, error) {
 	// Extract the list of keepalive packages from the parsed data.
 	keepalivePackages, ok := parsedData["keep_alive_packages"].([]map[string]any)
 	if !ok {
-		return errors.New("invalid or missing keep_alive_packages data")
+		return nil, errors.New("invalid or missing keep_alive_packages data")
 	}
 
 	// Return early if there are no keepalive packages.
 	if len(keepalivePackages) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Retrieve the timestamp from the first keepalive package.
 	timestamp, ok := keepalivePackages[0]["timestamp"].(int)
 	if !ok {
-		return errors.New("timestamp missing or not an integer in keepalive package")
+		return nil, errors.New("timestamp missing or not an integer in keepalive package")
 	}
 
 	// Convert the timestamp to a UTC time string.
@@ -338,7 +340,7 @@ func (s *UDPServer) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[stri
 	cachedDevice, err := s.cache.GetDevice(deviceID)
 	if err != nil {
 		helpers.LogError(err, "Error retrieving device from cache")
-		return err
+		return nil, err
 	}
 
 	var happenedAt string
@@ -353,27 +355,27 @@ func (s *UDPServer) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[stri
 		}
 		happenedAt, ok = cachedDevice["happened_at"].(string)
 		if !ok {
-			return errors.New("cached happened_at is not a string")
+			return nil, errors.New("cached happened_at is not a string")
 		}
 		settingsAt, ok = cachedDevice["settings_at"].(string)
 		if !ok {
-			return errors.New("cached settings_at is not a string")
+			return nil, errors.New("cached settings_at is not a string")
 		}
 
 		cachedKeepaliveAt, err := time.Parse("2006-01-02T15:04:05Z", cachedKeepaliveAtStr)
 		if err != nil {
-			return fmt.Errorf("error parsing cached keepalive_at time: %v", err)
+			return nil, fmt.Errorf("error parsing cached keepalive_at time: %v", err)
 		}
 
 		newKeepaliveAt, err := time.Parse("2006-01-02T15:04:05Z", keepaliveAt)
 		if err != nil {
-			return fmt.Errorf("error parsing new keepalive_at time: %v", err)
+			return nil, fmt.Errorf("error parsing new keepalive_at time: %v", err)
 		}
 
 		// Update only if the new keepalive timestamp is more recent.
 		if !newKeepaliveAt.After(cachedKeepaliveAt) {
 			helpers.LogInfo("No update needed. Cached keepalive_at is newer or equal.")
-			return nil
+			return nil, nil
 		}
 
 	} else {
@@ -385,7 +387,7 @@ func (s *UDPServer) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[stri
 	err = s.cache.UpdateKeepaliveAt(deviceID, keepaliveAt, happenedAt, settingsAt)
 	if err != nil {
 		helpers.LogError(err, "Failed to update device keepalive timestamp in cache")
-		return err
+		return nil, err
 	}
 
 	// --- Log updates for PostgreSQL synchronization (e.g., logs:device-keepalive-at)
@@ -394,40 +396,29 @@ func (s *UDPServer) updateDeviceKeepaliveInCacheAndBroadcast(parsedData map[stri
 		"keepalive_at": keepaliveAt,
 	}
 
-	// Push the log entry to Redis for PostgreSQL update processing.
-	err = s.cache.RPush("logs:device-keepalive-at", logPayload)
-	if err != nil {
-		helpers.LogError(err, "Failed to push device keepalive_at to Redis")
-	}
-
-	// Broadcast the update to clients using Socket.IO.
-	s.SocketIO.BroadcastToNamespace("/", "keepalive-event", logPayload)
-	helpers.LogInfo("Broadcasted keepalive event for device %s", deviceID)
-	// TODO: implement "keepalive-event" in frontend
-
-	return nil
+	return logPayload, nil
 }
 
-// updateDeviceSettingsInCacheAndBroadcast updates the settings timestamp for a device in the cache and broadcasts changes.
-// If the new settings timestamp is more recent than the cached one, the cache and relevant logs are updated.
-func (s *UDPServer) updateDeviceSettingsInCacheAndBroadcast(parsedData map[string]any) (bool, error) {
+// updateDeviceSettingsInCache updates the settings timestamp for a device in the cache.
+// If the new settings timestamp is more recent than the cached one, the cache is updated and the change is returned.
+func (s *UDPServer) updateDeviceSettingsInCache(parsedData map[string]any) (map[string]any, error) {
 	// Extract the list of settings packages from the parsed data.
 	settingsPackages, ok := parsedData["settings_packages"].([]map[string]any)
 
 	if !ok {
-		return false, errors.New("invalid or missing settings_packages data")
+		return nil, errors.New("invalid or missing settings_packages data")
 	}
 
 	// Return early if there are no settings packages.
 	if len(settingsPackages) == 0 {
-		return false, nil
+		return nil, nil
 	}
 
 	// Retrieve the timestamp from the first settings package.
 	firstSettingsPakage := settingsPackages[0]
 	timestamp, ok := firstSettingsPakage["timestamp"].(int)
 	if !ok {
-		return false, errors.New("timestamp missing or not an integer in settings package")
+		return nil, errors.New("timestamp missing or not an integer in settings package")
 	}
 
 	// Convert the timestamp to a UTC time string.
@@ -439,7 +430,7 @@ func (s *UDPServer) updateDeviceSettingsInCacheAndBroadcast(parsedData map[strin
 	cachedDevice, err := s.cache.GetDevice(deviceID)
 	if err != nil {
 		helpers.LogError(err, "Error retrieving device from cache")
-		return false, err
+		return nil, err
 	}
 
 	var happenedAt string
@@ -455,27 +446,27 @@ func (s *UDPServer) updateDeviceSettingsInCacheAndBroadcast(parsedData map[strin
 		}
 		happenedAt, ok = cachedDevice["happened_at"].(string)
 		if !ok {
-			return false, errors.New("cached happened_at is not a string")
+			return nil, errors.New("cached happened_at is not a string")
 		}
 		keepaliveAt, ok = cachedDevice["keepalive_at"].(string)
 		if !ok {
-			return false, errors.New("cached keepalive_at is not a string")
+			return nil, errors.New("cached keepalive_at is not a string")
 		}
 
 		cachedSettingsAt, err := time.Parse("2006-01-02T15:04:05Z", cachedSettingsAtStr)
 		if err != nil {
-			return false, fmt.Errorf("error parsing cached settings_at time: %v", err)
+			return nil, fmt.Errorf("error parsing cached settings_at time: %v", err)
 		}
 
 		newSettingsAt, err := time.Parse("2006-01-02T15:04:05Z", settingsAt)
 		if err != nil {
-			return false, fmt.Errorf("error parsing new settings_at time: %v", err)
+			return nil, fmt.Errorf("error parsing new settings_at time: %v", err)
 		}
 
 		// Update only if the new settings timestamp is more recent.
 		if !newSettingsAt.After(cachedSettingsAt) {
 			helpers.LogInfo("No update needed. Cached settings_at is newer or equal.")
-			return false, nil
+			return nil, nil
 		}
 
 	} else {
@@ -487,7 +478,7 @@ func (s *UDPServer) updateDeviceSettingsInCacheAndBroadcast(parsedData map[strin
 	err = s.cache.UpdateSettingsAt(deviceID, settingsAt, happenedAt, keepaliveAt)
 	if err != nil {
 		helpers.LogError(err, "Failed to update device settings timestamp in cache")
-		return false, err
+		return nil, err
 	}
 
 	// --- Log updates for PostgreSQL synchronization (e.g., logs:device-settings-at)
@@ -496,38 +487,26 @@ func (s *UDPServer) updateDeviceSettingsInCacheAndBroadcast(parsedData map[strin
 		"settings_at": settingsAt,
 	}
 
-	// Push the log entry to Redis for PostgreSQL update processing.
-	err = s.cache.RPush("logs:device-settings-at", logPayload)
-	if err != nil {
-		helpers.LogError(err, "Failed to push device settings_at to Redis")
-	}
-
-	// Broadcast the update to clients using Socket.IO.
-	s.SocketIO.BroadcastToNamespace("/", "settings-event", logPayload)
-	helpers.LogInfo("Broadcasted settings event for device %s", deviceID)
-	// TODO: implement "settings-event" in frontend
-
-	return true, nil
+	return logPayload, nil
 }
 
-// updateDeviceCacheAndBroadcast updates the device data cache and broadcasts changes if the incoming data is newer than what's in the cache.
-// updateDeviceCacheAndBroadcast updates the device data cache and broadcasts changes if the incoming data is newer than what's in the cache.
-func (s *UDPServer) updateDeviceCacheAndBroadcast(parsedData map[string]any) error {
+// updateDeviceCache updates the device data cache if the incoming data is newer than what's in the cache and returns the change.
+func (s *UDPServer) updateDeviceCache(parsedData map[string]any) (map[string]any, error) {
 	// Extract the list of parking packages from the parsed data.
 	latestParkingPackage, ok := parsedData["parking_packages"].([]map[string]any)
 	if !ok {
-		return errors.New("invalid or missing parking_packages data")
+		return nil, errors.New("invalid or missing parking_packages data")
 	}
 
 	// Return early if there are no parking packages.
 	if len(latestParkingPackage) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Retrieve the timestamp from the first parking package.
 	timestamp, ok := latestParkingPackage[0]["timestamp"].(int)
 	if !ok {
-		return errors.New("timestamp missing or not an integer")
+		return nil, errors.New("timestamp missing or not an integer")
 	}
 
 	// Convert the timestamp to a UTC time string.
@@ -539,24 +518,24 @@ func (s *UDPServer) updateDeviceCacheAndBroadcast(parsedData map[string]any) err
 	cachedDevice, err := s.cache.GetDevice(deviceId)
 	if err != nil {
 		helpers.LogError(err, "Error retrieving device from cache")
-		return err
+		return nil, err
 	}
 
 	// Check if there is cached data and the new data is more recent.
 	if cachedDevice != nil {
 		cachedHappenedAtStr, ok := cachedDevice["happened_at"].(string)
 		if !ok {
-			return errors.New("cached happened_at is not a string")
+			return nil, errors.New("cached happened_at is not a string")
 		}
 
 		cachedHappenedAt, err := time.Parse("2006-01-02T15:04:05Z", cachedHappenedAtStr)
 		if err != nil {
-			return fmt.Errorf("error parsing cached happened_at time: %v", err)
+			return nil, fmt.Errorf("error parsing cached happened_at time: %v", err)
 		}
 
 		newHappenedAt, err := time.Parse("2006-01-02T15:04:05Z", happenedAt)
 		if err != nil {
-			return fmt.Errorf("error parsing new happened_at time: %v", err)
+			return nil, fmt.Errorf("error parsing new happened_at time: %v", err)
 		}
 
 		// Proceed with update if the new data is more recent.
@@ -565,19 +544,19 @@ func (s *UDPServer) updateDeviceCacheAndBroadcast(parsedData map[string]any) err
 		}
 
 		helpers.LogInfo("No update needed. Cached happened_at is newer or equal.")
-		return nil
+		return nil, nil
 	}
 
 	// If no cached data exists, process the event as a new entry.
 	return s.processParkingEvent(parsedData, happenedAt, latestParkingPackage)
 }
 
-// processParkingEvent processes a parking event by updating the cache, logging to Redis, and broadcasting changes.
-func (s *UDPServer) processParkingEvent(parsedData map[string]any, happenedAt string, latestParkingPackage []map[string]any) error {
+// processParkingEvent processes a parking event by updating the cache and returns the device update.
+func (s *UDPServer) processParkingEvent(parsedData map[string]any, happenedAt string, latestParkingPackage []map[string]any) (map[string]any, error) {
 	// Extract the firmware version as a float64.
 	firmwareVersionFloat, ok := parsedData["firmware_version"].(float64)
 	if !ok {
-		return errors.New("firmware_version missing or not a float64")
+		return nil, errors.New("firmware_version missing or not a float64")
 	}
 
 	// Format the firmware version as a string.
@@ -586,7 +565,7 @@ func (s *UDPServer) processParkingEvent(parsedData map[string]any, happenedAt st
 	// Extract the beacons data from the parking package.
 	beacons, ok := latestParkingPackage[0]["beacons"].([]map[string]any)
 	if !ok {
-		return errors.New("beacons missing or not in the expected format")
+		return nil, errors.New("beacons missing or not in the expected format")
 	}
 
 	// Determine if the parking spot is occupied.
@@ -596,7 +575,7 @@ func (s *UDPServer) processParkingEvent(parsedData map[string]any, happenedAt st
 	err := s.cache.ProcessParkingEventData(fmt.Sprintf("%d", parsedData["device_id"]), firmwareVersion, beacons, happenedAt, isOccupied)
 	if err != nil {
 		helpers.LogError(err, "Failed to update device cache")
-		return err
+		return nil, err
 	}
 
 	// --- Log updates for PostgreSQL synchronization (logs:device-update).
@@ -608,14 +587,5 @@ func (s *UDPServer) processParkingEvent(parsedData map[string]any, happenedAt st
 		"beacons":          beacons,
 	}
 
-	// Push the log entry to Redis for PostgreSQL update processing.
-	err = s.cache.RPush("logs:device-update", payload)
-	if err != nil {
-		helpers.LogError(err, "Failed to push to Redis logs:device-update")
-	}
-
-	// Broadcast the update to clients using Socket.IO.
-	s.SocketIO.BroadcastToNamespace("/", "parking-event", payload)
-
-	return nil
+	return payload, nil
 }
@@ -1,33 +1,34 @@
 package udp
 
 import (
+	"encoding/hex"
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/foxcodenine/iot-parking-gateway/internal/cache"
+	"github.com/foxcodenine/iot-parking-gateway/internal/events"
 	"github.com/foxcodenine/iot-parking-gateway/internal/helpers"
-	"github.com/foxcodenine/iot-parking-gateway/internal/mq"
 	"github.com/foxcodenine/iot-parking-gateway/internal/services"
-	socketio "github.com/googollee/go-socket.io"
 )
 
 // UDPServer represents a UDP server.
 type UDPServer struct {
 	Addr             string
 	Connection       *net.UDPConn
-	mqProducer       *mq.RabbitMQProducer
+	bus              *events.Bus
 	cache            *cache.RedisCache
 	services         *services.Service
 	shutdownCh       chan struct{} // Shutdown channel to signal the listening loop to stop
 	isShuttingDown   bool          // Flag to indicate the server is intentionally shutting down
 	deviceAccessMode *string
-	SocketIO         *socketio.Server
 }
 
 // NewUDPServer initializes a new UDP server.
-func NewUDPServer(addr string, mq *mq.RabbitMQProducer, c *cache.RedisCache, s *services.Service, dam *string) *UDPServer {
+func NewUDPServer(addr string, bus *events.Bus, c *cache.RedisCache, s *services.Service, dam *string) *UDPServer {
 	return &UDPServer{
 		Addr:             addr,
-		mqProducer:       mq,
+		bus:              bus,
 		cache:            c,
 		services:         s,
 		shutdownCh:       make(chan struct{}),
@@ -96,3 +97,29 @@ func (s *UDPServer) Stop() {
 	helpers.LogInfo("UDP connection closed.")
 
 }
+
+// ReplaySubscriber processes the NB-IoT messages held for a device once it is registered.
+// Replayed messages are handled like new ones, without a reply. Messages overtaken by newer
+// traffic of the device are skipped.
+func (s *UDPServer) ReplaySubscriber() events.Handler {
+	return func(event events.Event) {
+		e, ok := event.(events.TrafficReleased)
+		if !ok || e.NetworkType != "NB-IoT" {
+			return
+		}
+		defer s.services.HeldTrafficReplayed(e)
+
+		for _, msg := range e.Messages {
+			if s.services.StaleHeldMessage(e.DeviceID, msg) {
+				helpers.LogInfo("Skipped held message of device %s received at %s, newer traffic was processed", e.DeviceID, msg.ReceivedAt.Format(time.RFC3339))
+				continue
+			}
+			data, err := hex.DecodeString(msg.Payload)
+			if err != nil {
+				helpers.LogError(err, fmt.Sprintf("Failed to decode held message of device %s", e.DeviceID))
+				continue
+			}
+			s.nbMessageHandler(nil, data, nil)
+		}
+	}
+}